@@ -0,0 +1,409 @@
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/nodes"
+)
+
+func textPacket(from, id uint32, channel, text string) *meshtreampb.Packet {
+	return &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			Id:      id,
+			From:    from,
+			PortNum: pb.PortNum_TEXT_MESSAGE_APP,
+			Payload: &meshtreampb.Data_TextMessage{TextMessage: text},
+		},
+		Info: &meshtreampb.TopicInfo{Channel: channel},
+	}
+}
+
+func batteryPacket(from uint32, level uint32) *meshtreampb.Packet {
+	return &meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    from,
+		PortNum: pb.PortNum_TELEMETRY_APP,
+		Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+			Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(level)}},
+		}},
+	}}
+}
+
+func positionPacket(from uint32, lat, lon int32) *meshtreampb.Packet {
+	return &meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    from,
+		PortNum: pb.PortNum_POSITION_APP,
+		Payload: &meshtreampb.Data_Position{Position: &pb.Position{LatitudeI: proto.Int32(lat), LongitudeI: proto.Int32(lon)}},
+	}}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+cooldown: 30m
+notifiers:
+  - name: hook
+    type: webhook
+    url: http://example.com/hook
+rules:
+  - name: repeater-down
+    type: silent
+    nodes: ["!abcd1234"]
+    duration: 2h
+    notify: [hook]
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Cooldown != 30*time.Minute || config.Rules[0].Duration != 2*time.Hour {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	invalid := map[string]string{
+		"unknown key":      "rules:\n  - name: a\n    type: battery\n    below: 20\n    threshold: 5\n",
+		"unknown type":     "rules:\n  - name: a\n    type: bogus\n",
+		"unknown notifier": "rules:\n  - name: a\n    type: new_node\n    notify: [nope]\n",
+		"bad regex":        "rules:\n  - name: a\n    type: text\n    pattern: '('\n",
+		"silent no nodes":  "rules:\n  - name: a\n    type: silent\n    duration: 1h\n",
+		"bad node id":      "rules:\n  - name: a\n    type: battery\n    below: 20\n    nodes: [nope]\n",
+		"clear below":      "rules:\n  - name: a\n    type: battery\n    below: 20\n    clear_above: 10\n",
+	}
+	for name, doc := range invalid {
+		if _, err := ParseConfig([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSilentRule(t *testing.T) {
+	start := time.Now()
+	r, _ := newRule(RuleConfig{Type: RuleSilent, Nodes: []string{"!00000001"}, Duration: time.Hour}, nodes.NewDirectory(), start)
+
+	if events := r.tick(start.Add(59 * time.Minute)); len(events) != 0 {
+		t.Errorf("fired too early: %+v", events)
+	}
+	events := r.tick(start.Add(61 * time.Minute))
+	if len(events) != 1 || events[0].status != StatusFiring {
+		t.Fatalf("expected silent alert, got %+v", events)
+	}
+	if events := r.tick(start.Add(90 * time.Minute)); len(events) != 0 {
+		t.Errorf("firing alert should not repeat: %+v", events)
+	}
+
+	events = r.observe(textPacket(1, 1, "LongFast", "back"), start.Add(95*time.Minute), true)
+	if len(events) != 1 || events[0].status != StatusResolved {
+		t.Errorf("expected resolution when heard again, got %+v", events)
+	}
+}
+
+func TestBatteryRuleHysteresis(t *testing.T) {
+	r, _ := newRule(RuleConfig{Type: RuleBattery, Below: 20}, nodes.NewDirectory(), time.Now())
+	now := time.Now()
+
+	var statuses []Status
+	for _, level := range []uint32{50, 19, 18, 21, 19, 24, 25, 10} {
+		for _, ev := range r.observe(batteryPacket(1, level), now, true) {
+			statuses = append(statuses, ev.status)
+		}
+	}
+	want := []Status{StatusFiring, StatusResolved, StatusFiring}
+	if len(statuses) != len(want) {
+		t.Fatalf("want %v, got %v", want, statuses)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("want %v, got %v", want, statuses)
+		}
+	}
+}
+
+func TestBatteryRuleReplay(t *testing.T) {
+	r, _ := newRule(RuleConfig{Type: RuleBattery, Below: 20}, nodes.NewDirectory(), time.Now())
+	now := time.Now()
+
+	// Low levels in the replayed cache don't notify again after a restart,
+	// but are remembered, so a live recovery resolves the alert.
+	if events := r.observe(batteryPacket(1, 10), now, false); len(events) != 0 {
+		t.Errorf("expected no alert for a replayed level, got %+v", events)
+	}
+	if events := r.observe(batteryPacket(1, 12), now, true); len(events) != 0 {
+		t.Errorf("expected the alert to be firing already, got %+v", events)
+	}
+	if events := r.observe(batteryPacket(1, 80), now, true); len(events) != 1 || events[0].status != StatusResolved {
+		t.Errorf("expected a resolution, got %+v", events)
+	}
+}
+
+func TestNewNodeRule(t *testing.T) {
+	start := time.Now()
+	r, _ := newRule(RuleConfig{Type: RuleNewNode, Known: []string{"!00000002"}, Learn: time.Minute}, nodes.NewDirectory(), start)
+
+	if events := r.observe(textPacket(1, 1, "", ""), start, true); len(events) != 0 {
+		t.Errorf("nodes seen while learning should not alert: %+v", events)
+	}
+	later := start.Add(2 * time.Minute)
+	if events := r.observe(textPacket(1, 2, "", ""), later, true); len(events) != 0 {
+		t.Errorf("learned node should not alert: %+v", events)
+	}
+	if events := r.observe(textPacket(2, 3, "", ""), later, true); len(events) != 0 {
+		t.Errorf("known node should not alert: %+v", events)
+	}
+	if events := r.observe(textPacket(3, 4, "", ""), later, true); len(events) != 1 {
+		t.Errorf("expected new node alert, got %+v", events)
+	}
+}
+
+func TestPositionRuleWatchesRouters(t *testing.T) {
+	directory := nodes.NewDirectory()
+	directory.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    1,
+		PortNum: pb.PortNum_NODEINFO_APP,
+		Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{LongName: "Hilltop", Role: pb.Config_DeviceConfig_ROUTER}},
+	}})
+	r, err := newRule(RuleConfig{Type: RulePositionChanged}, directory, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	r.observe(positionPacket(1, 377749000, -1224194000), now, true)
+	// ~20m of GPS jitter stays under the default 100m threshold.
+	if events := r.observe(positionPacket(1, 377750800, -1224194000), now, true); len(events) != 0 {
+		t.Errorf("small move should not alert: %+v", events)
+	}
+	if events := r.observe(positionPacket(1, 377849000, -1224194000), now, true); len(events) != 1 {
+		t.Errorf("expected move alert, got %+v", events)
+	}
+
+	// Clients are not watched by default.
+	r.observe(positionPacket(2, 377749000, -1224194000), now, true)
+	if events := r.observe(positionPacket(2, 387749000, -1224194000), now, true); len(events) != 0 {
+		t.Errorf("client move should not alert: %+v", events)
+	}
+}
+
+// recorder is a stand-in HTTP endpoint that records requests.
+type recorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, string(body))
+	rec.mu.Unlock()
+}
+
+func (rec *recorder) wait(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		rec.mu.Lock()
+		got := len(rec.requests)
+		rec.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d requests", n)
+}
+
+func TestEngineWebhookWithDedup(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	config, err := ParseConfig([]byte(`
+notifiers:
+  - name: hook
+    type: webhook
+    url: ` + server.URL + `
+    headers:
+      X-Token: secret
+rules:
+  - name: sos
+    type: text
+    channel: LongFast
+    pattern: (?i)\bsos\b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mesh := testutil.NewMesh(t)
+	source := mesh.Source
+	engine, err := NewEngine(config, mesh.Broker, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source <- textPacket(1, 10, "LongFast", "SOS at the trailhead")
+	source <- textPacket(1, 10, "LongFast", "SOS at the trailhead") // same packet via another gateway
+	source <- textPacket(1, 11, "LongFast", "sos again")            // within cooldown
+	source <- textPacket(1, 12, "Other", "sos")                     // wrong channel
+	source <- textPacket(2, 13, "LongFast", "all good")
+
+	rec.wait(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	engine.Close()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.requests) != 1 {
+		t.Fatalf("expected exactly one notification, got %d: %v", len(rec.requests), rec.bodies)
+	}
+	if rec.requests[0].Header.Get("X-Token") != "secret" {
+		t.Error("custom header not sent")
+	}
+	var alert Alert
+	if err := json.Unmarshal([]byte(rec.bodies[0]), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.Rule != "sos" || alert.Node != "!00000001" || alert.Status != StatusFiring || !strings.Contains(alert.Message, "trailhead") {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+}
+
+func TestNtfyNotifier(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	n := newNotifier(NotifierConfig{Type: NotifierNtfy, URL: server.URL + "/mesh", Token: "tk", Priority: "high"}, http.DefaultClient)
+	err := n.Notify(context.Background(), Alert{Rule: "low-battery", Status: StatusFiring, NodeName: "Hill\ntop", Message: "battery is at 10%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := rec.requests[0]
+	if req.URL.Path != "/mesh" || rec.bodies[0] != "battery is at 10%" {
+		t.Errorf("unexpected request %s %q", req.URL, rec.bodies[0])
+	}
+	if req.Header.Get("Title") != "[firing] low-battery: Hill top" || req.Header.Get("Priority") != "high" || req.Header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+}
+
+func TestSMTPMessage(t *testing.T) {
+	n := newNotifier(NotifierConfig{Type: NotifierSMTP, Host: "mail.example.com", From: "mesh@example.com", To: []string{"a@example.com", "b@example.com"}}, nil).(*smtpNotifier)
+	if n.addr != "mail.example.com:587" {
+		t.Errorf("unexpected default address %s", n.addr)
+	}
+	msg := string(n.message(Alert{Rule: "repeater-down", Status: StatusResolved, Message: "heard again", Time: time.Now()}))
+	for _, want := range []string{"To: a@example.com, b@example.com\r\n", "Subject: [resolved] repeater-down\r\n", "\r\n\r\nheard again"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+// fakeSMTP serves one SMTP session and returns the message it received.
+func fakeSMTP(listener net.Listener) <-chan string {
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 mail.example.com ESMTP")
+		var data strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				reply("250 Queued")
+			case "QUIT":
+				reply("221 Bye")
+				received <- data.String()
+				return
+			default:
+				reply("502 Unsupported")
+			}
+		}
+	}()
+	return received
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	received := fakeSMTP(listener)
+
+	n := newNotifier(NotifierConfig{Type: NotifierSMTP, Host: "127.0.0.1", From: "mesh@example.com", To: []string{"a@example.com"}}, nil).(*smtpNotifier)
+	n.addr = listener.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := n.Notify(ctx, Alert{Rule: "sos", Status: StatusFiring, Message: "help"}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if msg := <-received; !strings.Contains(msg, "Subject: [firing] sos\r\n") || !strings.Contains(msg, "\r\nhelp\r\n") {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+}
+
+func TestSMTPNotifierTimesOut(t *testing.T) {
+	// A server that accepts connections and never replies.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	n := newNotifier(NotifierConfig{Type: NotifierSMTP, Host: "127.0.0.1", From: "mesh@example.com", To: []string{"a@example.com"}}, nil).(*smtpNotifier)
+	n.addr = listener.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := n.Notify(ctx, Alert{Rule: "sos", Status: StatusFiring}); err == nil {
+		t.Fatal("expected an error from a server that never replies")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Notify to give up at the deadline, took %s", elapsed)
+	}
+}
@@ -0,0 +1,184 @@
+package alerts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"meshstream/nodes"
+)
+
+// Rule types supported in the rules file.
+const (
+	RuleSilent          = "silent"           // Node not heard for longer than Duration
+	RuleBattery         = "battery"          // Battery level below Below
+	RuleText            = "text"             // Text message matching Pattern
+	RuleNewNode         = "new_node"         // Node not seen before
+	RulePositionChanged = "position_changed" // Router moved more than Distance meters
+)
+
+// Notifier types supported in the rules file.
+const (
+	NotifierWebhook = "webhook"
+	NotifierNtfy    = "ntfy"
+	NotifierSMTP    = "smtp"
+)
+
+// Config is the alerting rules file.
+type Config struct {
+	Cooldown  time.Duration    `yaml:"cooldown"` // Default minimum time between repeats of an event alert (default: 1h)
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	Rules     []RuleConfig     `yaml:"rules"`
+
+	SkipCache bool `yaml:"-"` // See mqtt.SubscriberConfig.SkipCache
+}
+
+// RuleConfig declares a single alerting rule. Which fields apply depends on
+// the rule type.
+type RuleConfig struct {
+	Name     string        `yaml:"name"`
+	Type     string        `yaml:"type"`
+	Nodes    []string      `yaml:"nodes"`    // Node IDs the rule applies to; empty means all nodes
+	Notify   []string      `yaml:"notify"`   // Notifier names; empty means all notifiers
+	Cooldown time.Duration `yaml:"cooldown"` // Overrides the default cooldown for event alerts
+
+	Duration time.Duration `yaml:"duration"` // silent: how long a node may go unheard
+
+	Below      float64 `yaml:"below"`       // battery: alert when the level drops below this percentage
+	ClearAbove float64 `yaml:"clear_above"` // battery: resolve once the level reaches this (default: below + 5)
+
+	Channel string `yaml:"channel"` // text: only match messages on this channel
+	Pattern string `yaml:"pattern"` // text: regular expression to match
+
+	Known []string      `yaml:"known"` // new_node: nodes that are never reported as new
+	Learn time.Duration `yaml:"learn"` // new_node: nodes seen within this long of startup are learned quietly (default: 10m)
+
+	Distance float64  `yaml:"distance"` // position_changed: meters a node must move (default: 100)
+	Roles    []string `yaml:"roles"`    // position_changed: device roles watched when nodes is empty (default: routers and repeaters)
+}
+
+// NotifierConfig declares a notification destination.
+type NotifierConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	URL     string            `yaml:"url"`     // webhook and ntfy: endpoint to POST to
+	Headers map[string]string `yaml:"headers"` // webhook: extra request headers
+	Token   string            `yaml:"token"`   // ntfy: access token
+
+	Priority string `yaml:"priority"` // ntfy: message priority, e.g. "high"
+
+	Host     string   `yaml:"host"` // smtp: server host
+	Port     int      `yaml:"port"` // smtp: server port (default: 587)
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// LoadConfig reads and validates a YAML rules file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a YAML rules document. Unknown keys are
+// rejected so that typos don't silently disable a rule.
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("invalid alerts config: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Validate checks the rules and notifiers, e.g. for a config embedded in
+// another file.
+func (c *Config) Validate() error {
+	notifiers := make(map[string]bool)
+	for i, n := range c.Notifiers {
+		if n.Name == "" {
+			return fmt.Errorf("notifier %d: name is required", i+1)
+		}
+		if notifiers[n.Name] {
+			return fmt.Errorf("notifier %q: duplicate name", n.Name)
+		}
+		notifiers[n.Name] = true
+
+		switch n.Type {
+		case NotifierWebhook, NotifierNtfy:
+			if n.URL == "" {
+				return fmt.Errorf("notifier %q: url is required", n.Name)
+			}
+		case NotifierSMTP:
+			if n.Host == "" || n.From == "" || len(n.To) == 0 {
+				return fmt.Errorf("notifier %q: host, from and to are required", n.Name)
+			}
+		default:
+			return fmt.Errorf("notifier %q: unknown type %q", n.Name, n.Type)
+		}
+	}
+
+	rules := make(map[string]bool)
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i+1)
+		}
+		if rules[r.Name] {
+			return fmt.Errorf("rule %q: duplicate name", r.Name)
+		}
+		rules[r.Name] = true
+
+		for _, name := range r.Notify {
+			if !notifiers[name] {
+				return fmt.Errorf("rule %q: unknown notifier %q", r.Name, name)
+			}
+		}
+		if _, err := nodes.ParseIDs(r.Nodes); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+
+		switch r.Type {
+		case RuleSilent:
+			if len(r.Nodes) == 0 || r.Duration <= 0 {
+				return fmt.Errorf("rule %q: silent rules need nodes and a duration", r.Name)
+			}
+		case RuleBattery:
+			if r.Below <= 0 || r.Below > 100 {
+				return fmt.Errorf("rule %q: below must be between 0 and 100", r.Name)
+			}
+			if r.ClearAbove != 0 && r.ClearAbove < r.Below {
+				return fmt.Errorf("rule %q: clear_above must not be less than below", r.Name)
+			}
+		case RuleText:
+			if r.Pattern == "" {
+				return fmt.Errorf("rule %q: pattern is required", r.Name)
+			}
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				return fmt.Errorf("rule %q: invalid pattern: %v", r.Name, err)
+			}
+		case RuleNewNode:
+			if _, err := nodes.ParseIDs(r.Known); err != nil {
+				return fmt.Errorf("rule %q: %v", r.Name, err)
+			}
+		case RulePositionChanged:
+			if r.Distance < 0 {
+				return fmt.Errorf("rule %q: distance must not be negative", r.Name)
+			}
+		default:
+			return fmt.Errorf("rule %q: unknown type %q", r.Name, r.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package alerts
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// Status is the state an alert notification reports.
+type Status string
+
+const (
+	StatusFiring   Status = "firing"
+	StatusResolved Status = "resolved"
+)
+
+// Alert is a notification raised by a rule.
+type Alert struct {
+	Rule     string    `json:"rule"`
+	Status   Status    `json:"status"`
+	Node     string    `json:"node,omitempty"`
+	NodeName string    `json:"nodeName,omitempty"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Title returns a one-line summary suitable for a subject or push title.
+func (a Alert) Title() string {
+	title := "[" + string(a.Status) + "] " + a.Rule
+	if a.NodeName != "" {
+		title += ": " + a.NodeName
+	}
+	// Node names come from the mesh; keep them from breaking header lines.
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, title)
+}
+
+const (
+	// tickInterval is how often time-based rules are evaluated.
+	tickInterval = time.Minute
+	// notifyTimeout bounds each delivery attempt.
+	notifyTimeout = 30 * time.Second
+)
+
+// compiledRule pairs a rule with its notification settings.
+type compiledRule struct {
+	name      string
+	rule      rule
+	notifiers []Notifier
+	cooldown  time.Duration
+}
+
+// dedupKey identifies an event alert for cooldown purposes.
+type dedupKey struct {
+	rule string
+	node uint32
+}
+
+// Engine evaluates alerting rules against the packet stream and dispatches
+// notifications.
+type Engine struct {
+	*mqtt.BaseSubscriber
+	rules     []*compiledRule
+	directory *nodes.Directory
+	start     time.Time
+	now       func() time.Time
+
+	mu       sync.Mutex
+	lastSent map[dedupKey]time.Time
+
+	queue  chan queuedAlert
+	done   chan struct{}
+	wg     sync.WaitGroup
+	health health.Tracker
+	logger logging.Logger
+}
+
+type queuedAlert struct {
+	alert     Alert
+	notifiers []Notifier
+}
+
+// NewEngine creates an alerting engine subscribed to the broker.
+func NewEngine(config Config, broker *mqtt.Broker, directory *nodes.Directory, logger logging.Logger) (*Engine, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = time.Hour
+	}
+
+	e := &Engine{
+		directory: directory,
+		start:     time.Now(),
+		now:       time.Now,
+		lastSent:  make(map[dedupKey]time.Time),
+		queue:     make(chan queuedAlert, 100),
+		done:      make(chan struct{}),
+		logger:    logger.Named("alerts"),
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	notifiers := make(map[string]Notifier, len(config.Notifiers))
+	var all []Notifier
+	for _, nc := range config.Notifiers {
+		n := newNotifier(nc, client)
+		notifiers[nc.Name] = n
+		all = append(all, n)
+	}
+
+	for _, rc := range config.Rules {
+		r, err := newRule(rc, directory, e.start)
+		if err != nil {
+			return nil, err
+		}
+		cr := &compiledRule{name: rc.Name, rule: r, notifiers: all, cooldown: config.Cooldown}
+		if len(rc.Notify) > 0 {
+			cr.notifiers = nil
+			for _, name := range rc.Notify {
+				cr.notifiers = append(cr.notifiers, notifiers[name])
+			}
+		}
+		if rc.Cooldown > 0 {
+			cr.cooldown = rc.Cooldown
+		}
+		e.rules = append(e.rules, cr)
+	}
+
+	e.BaseSubscriber = mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "Alerts",
+		Broker:     broker,
+		BufferSize: 100,
+		SkipCache:  config.SkipCache,
+		DropCopies: true,
+		Processor:  e.process,
+		StartHook: func() {
+			e.wg.Add(2)
+			go e.tickLoop()
+			go e.deliverLoop()
+		},
+		CloseHook: func() {
+			close(e.done)
+			e.wg.Wait()
+		},
+		Logger: logger,
+	})
+	e.Start()
+
+	e.logger.Infow("Alerting rules loaded", "rules", len(e.rules), "notifiers", len(all))
+	return e, nil
+}
+
+// process evaluates every rule against the first copy of a packet.
+func (e *Engine) process(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	if data == nil || data.GetFrom() == 0 {
+		return
+	}
+	now := e.now()
+	live := e.Live(packet)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, r := range e.rules {
+		e.dispatch(r, r.rule.observe(packet, now, live), now)
+	}
+}
+
+// tickLoop evaluates time-based rules.
+func (e *Engine) tickLoop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.tick()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *Engine) tick() {
+	now := e.now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, r := range e.rules {
+		e.dispatch(r, r.rule.tick(now), now)
+	}
+}
+
+// dispatch applies cooldowns and queues notifications. Callers must hold mu.
+func (e *Engine) dispatch(r *compiledRule, events []event, now time.Time) {
+	for _, ev := range events {
+		if !r.rule.stateful() {
+			key := dedupKey{rule: r.name, node: ev.node}
+			if last, ok := e.lastSent[key]; ok && now.Sub(last) < r.cooldown {
+				continue
+			}
+			e.lastSent[key] = now
+		}
+
+		alert := Alert{
+			Rule:    r.name,
+			Status:  ev.status,
+			Message: ev.message,
+			Time:    now,
+		}
+		if ev.node != 0 {
+			alert.Node = nodes.FormatID(ev.node)
+			alert.NodeName = e.directory.LongName(ev.node)
+		}
+		e.logger.Infow("Alert", "rule", alert.Rule, "status", alert.Status, "node", alert.Node, "message", alert.Message)
+
+		select {
+		case e.queue <- queuedAlert{alert: alert, notifiers: r.notifiers}:
+		default:
+			e.logger.Warnw("Alert queue full, dropping notification", "rule", alert.Rule, "node", alert.Node)
+		}
+	}
+}
+
+// deliverLoop sends queued alerts so slow destinations don't stall packet
+// processing. Pending alerts are delivered before shutdown.
+func (e *Engine) deliverLoop() {
+	defer e.wg.Done()
+
+	for {
+		select {
+		case qa := <-e.queue:
+			e.deliver(qa)
+		case <-e.done:
+			for {
+				select {
+				case qa := <-e.queue:
+					e.deliver(qa)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Engine) deliver(qa queuedAlert) {
+	for _, n := range qa.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := n.Notify(ctx, qa.alert); err != nil {
+			e.health.Failure()
+			e.logger.Errorw("Failed to send alert notification", "rule", qa.alert.Rule, "error", err)
+		} else {
+			e.health.Success()
+		}
+		cancel()
+	}
+}
+
+// Health reports whether sending notifications is failing.
+func (e *Engine) Health() health.Status {
+	return e.health.Status()
+}
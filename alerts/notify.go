@@ -0,0 +1,194 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier delivers alerts to an external destination.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// newNotifier builds the notifier described by a validated config.
+func newNotifier(config NotifierConfig, client *http.Client) Notifier {
+	switch config.Type {
+	case NotifierNtfy:
+		return &ntfyNotifier{url: config.URL, token: config.Token, priority: config.Priority, client: client}
+	case NotifierSMTP:
+		port := config.Port
+		if port == 0 {
+			port = 587
+		}
+		return &smtpNotifier{
+			addr:     net.JoinHostPort(config.Host, strconv.Itoa(port)),
+			host:     config.Host,
+			username: config.Username,
+			password: config.Password,
+			from:     config.From,
+			to:       config.To,
+		}
+	default:
+		return &webhookNotifier{url: config.URL, headers: config.Headers, client: client}
+	}
+}
+
+// webhookNotifier POSTs the alert as JSON.
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+	return doRequest(n.client, req)
+}
+
+// ntfyNotifier publishes the alert to an ntfy topic URL, using the message
+// as the body and the rule as the title.
+type ntfyNotifier struct {
+	url      string
+	token    string
+	priority string
+	client   *http.Client
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, alert Alert) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, strings.NewReader(alert.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", alert.Title())
+	if alert.Status == StatusResolved {
+		req.Header.Set("Tags", "white_check_mark")
+	} else {
+		req.Header.Set("Tags", "warning")
+		if n.priority != "" {
+			req.Header.Set("Priority", n.priority)
+		}
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return doRequest(n.client, req)
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// smtpNotifier sends the alert as a plain-text email. STARTTLS is used when
+// the server offers it.
+type smtpNotifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// Notify delivers the alert in one SMTP session. It follows smtp.SendMail,
+// which has no way to bound the exchange, and gives up when ctx is done so a
+// hung or tarpitting server can't stall delivery.
+func (n *smtpNotifier) Notify(ctx context.Context, alert Alert) error {
+	for _, addr := range append([]string{n.from}, n.to...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("invalid email address %q", addr)
+		}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Cancellation before the deadline interrupts blocked reads and writes.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+	if n.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s doesn't support authentication", n.host)
+		}
+		if err := c.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(alert)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (n *smtpNotifier) message(alert Alert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", alert.Title())
+	fmt.Fprintf(&b, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(alert.Message)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
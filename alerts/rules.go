@@ -0,0 +1,314 @@
+package alerts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
+)
+
+// event is a rule outcome before notification. Stateful rules (silent and
+// battery) report both firing and resolved events, and rely on hysteresis to
+// avoid repeats. Event rules only fire, and repeats for the same node are
+// suppressed by the engine's cooldown.
+type event struct {
+	node    uint32
+	status  Status
+	message string
+}
+
+// rule evaluates packets (and, for time-based rules, the passage of time).
+type rule interface {
+	// observe is called for every packet. live is false for packets replayed
+	// from the broker cache at startup, which should update state but not
+	// raise event alerts.
+	observe(packet *meshtreampb.Packet, now time.Time, live bool) []event
+	// tick is called periodically so rules can detect the absence of packets.
+	tick(now time.Time) []event
+	// stateful reports whether the rule produces firing/resolved pairs.
+	stateful() bool
+}
+
+// nodeFilter restricts a rule to a set of nodes. An empty filter matches all.
+type nodeFilter map[uint32]bool
+
+func newNodeFilter(ids []string) nodeFilter {
+	nums, _ := nodes.ParseIDs(ids)
+	f := make(nodeFilter, len(nums))
+	for _, n := range nums {
+		f[n] = true
+	}
+	return f
+}
+
+func (f nodeFilter) match(node uint32) bool {
+	return len(f) == 0 || f[node]
+}
+
+// newRule builds the rule described by a validated config.
+func newRule(config RuleConfig, directory *nodes.Directory, start time.Time) (rule, error) {
+	filter := newNodeFilter(config.Nodes)
+
+	switch config.Type {
+	case RuleSilent:
+		r := &silentRule{duration: config.Duration, lastHeard: make(map[uint32]time.Time), firing: make(map[uint32]bool), directory: directory}
+		// Nodes are given a full window after startup before they can fire.
+		for node := range filter {
+			r.lastHeard[node] = start
+		}
+		return r, nil
+
+	case RuleBattery:
+		clear := config.ClearAbove
+		if clear == 0 {
+			clear = min(config.Below+5, 100)
+		}
+		return &batteryRule{filter: filter, below: config.Below, clearAbove: clear, firing: make(map[uint32]bool), directory: directory}, nil
+
+	case RuleText:
+		return &textRule{filter: filter, channel: config.Channel, pattern: regexp.MustCompile(config.Pattern), directory: directory}, nil
+
+	case RuleNewNode:
+		learn := config.Learn
+		if learn == 0 {
+			learn = 10 * time.Minute
+		}
+		r := &newNodeRule{known: newNodeFilter(config.Known), learnUntil: start.Add(learn), directory: directory}
+		return r, nil
+
+	case RulePositionChanged:
+		distance := config.Distance
+		if distance == 0 {
+			distance = 100
+		}
+		roles := make(map[pb.Config_DeviceConfig_Role]bool)
+		names := config.Roles
+		if len(names) == 0 {
+			names = []string{"ROUTER", "ROUTER_LATE", "ROUTER_CLIENT", "REPEATER"}
+		}
+		for _, name := range names {
+			role, ok := pb.Config_DeviceConfig_Role_value[strings.ToUpper(name)]
+			if !ok {
+				return nil, fmt.Errorf("rule %q: unknown role %q", config.Name, name)
+			}
+			roles[pb.Config_DeviceConfig_Role(role)] = true
+		}
+		return &positionRule{filter: filter, roles: roles, distance: distance, baseline: make(map[uint32]*pb.Position), directory: directory}, nil
+	}
+	return nil, fmt.Errorf("rule %q: unknown type %q", config.Name, config.Type)
+}
+
+// packetTime returns when a packet was received, falling back to now.
+func packetTime(data *meshtreampb.Data, now time.Time) time.Time {
+	if data.GetRxTime() == 0 {
+		return now
+	}
+	return time.Unix(int64(data.GetRxTime()), 0)
+}
+
+// silentRule fires when a node has not been heard for longer than duration.
+type silentRule struct {
+	duration  time.Duration
+	lastHeard map[uint32]time.Time
+	firing    map[uint32]bool
+	directory *nodes.Directory
+}
+
+func (r *silentRule) stateful() bool { return true }
+
+func (r *silentRule) observe(packet *meshtreampb.Packet, now time.Time, live bool) []event {
+	node := packet.GetData().GetFrom()
+	last, ok := r.lastHeard[node]
+	if !ok {
+		return nil
+	}
+	heard := packetTime(packet.GetData(), now)
+	if heard.After(last) {
+		r.lastHeard[node] = heard
+	}
+	if r.firing[node] {
+		r.firing[node] = false
+		return []event{{node: node, status: StatusResolved, message: fmt.Sprintf("%s was heard again", r.directory.LongName(node))}}
+	}
+	return nil
+}
+
+func (r *silentRule) tick(now time.Time) []event {
+	var events []event
+	for node, last := range r.lastHeard {
+		if r.firing[node] || now.Sub(last) <= r.duration {
+			continue
+		}
+		r.firing[node] = true
+		events = append(events, event{
+			node:    node,
+			status:  StatusFiring,
+			message: fmt.Sprintf("%s has not been heard for %s", r.directory.LongName(node), now.Sub(last).Round(time.Minute)),
+		})
+	}
+	return events
+}
+
+// batteryRule fires when a node's battery drops below a threshold and
+// resolves once it recovers past a higher one, so a level hovering around the
+// threshold does not flap.
+type batteryRule struct {
+	filter     nodeFilter
+	below      float64
+	clearAbove float64
+	firing     map[uint32]bool
+	directory  *nodes.Directory
+}
+
+func (r *batteryRule) stateful() bool { return true }
+
+func (r *batteryRule) observe(packet *meshtreampb.Packet, now time.Time, live bool) []event {
+	data := packet.GetData()
+	metrics := data.GetTelemetry().GetDeviceMetrics()
+	if data.GetPortNum() != pb.PortNum_TELEMETRY_APP || metrics == nil || metrics.BatteryLevel == nil || !r.filter.match(data.GetFrom()) {
+		return nil
+	}
+
+	node := data.GetFrom()
+	level := float64(metrics.GetBatteryLevel())
+	// Levels above 100 mean the node is externally powered.
+	switch {
+	case !r.firing[node] && level < r.below:
+		r.firing[node] = true
+		// Replayed levels only restore the state, so a restart doesn't
+		// notify again about batteries that were already low.
+		if !live {
+			return nil
+		}
+		return []event{{node: node, status: StatusFiring, message: fmt.Sprintf("%s battery is at %.0f%%", r.directory.LongName(node), level)}}
+	case r.firing[node] && level >= r.clearAbove:
+		r.firing[node] = false
+		if !live {
+			return nil
+		}
+		return []event{{node: node, status: StatusResolved, message: fmt.Sprintf("%s battery recovered to %.0f%%", r.directory.LongName(node), min(level, 100))}}
+	}
+	return nil
+}
+
+func (r *batteryRule) tick(time.Time) []event { return nil }
+
+// textRule fires on text messages matching a pattern.
+type textRule struct {
+	filter    nodeFilter
+	channel   string
+	pattern   *regexp.Regexp
+	directory *nodes.Directory
+}
+
+func (r *textRule) stateful() bool { return false }
+
+func (r *textRule) observe(packet *meshtreampb.Packet, now time.Time, live bool) []event {
+	data := packet.GetData()
+	if !live || data.GetPortNum() != pb.PortNum_TEXT_MESSAGE_APP || !r.filter.match(data.GetFrom()) {
+		return nil
+	}
+	if r.channel != "" && packet.GetInfo().GetChannel() != r.channel {
+		return nil
+	}
+	if !r.pattern.MatchString(data.GetTextMessage()) {
+		return nil
+	}
+	return []event{{
+		node:    data.GetFrom(),
+		status:  StatusFiring,
+		message: fmt.Sprintf("%s on %s: %s", r.directory.LongName(data.GetFrom()), packet.GetInfo().GetChannel(), data.GetTextMessage()),
+	}}
+}
+
+func (r *textRule) tick(time.Time) []event { return nil }
+
+// newNodeRule fires the first time a node is seen. Nodes seen during the
+// learning window after startup (including the replayed cache) are treated as
+// already known.
+type newNodeRule struct {
+	known      nodeFilter
+	learnUntil time.Time
+	directory  *nodes.Directory
+}
+
+func (r *newNodeRule) stateful() bool { return false }
+
+func (r *newNodeRule) observe(packet *meshtreampb.Packet, now time.Time, live bool) []event {
+	node := packet.GetData().GetFrom()
+	if node == 0 || r.known[node] {
+		return nil
+	}
+	r.known[node] = true
+	if !live || now.Before(r.learnUntil) {
+		return nil
+	}
+	return []event{{node: node, status: StatusFiring, message: fmt.Sprintf("New node %s (%s) appeared", r.directory.LongName(node), nodes.FormatID(node))}}
+}
+
+func (r *newNodeRule) tick(time.Time) []event { return nil }
+
+// positionRule fires when a fixed node (by default, routers and repeaters)
+// reports a position further than distance from where it was first seen. The
+// reported positions' precision is added to the threshold so that reduced
+// precision positions do not trigger it.
+type positionRule struct {
+	filter    nodeFilter
+	roles     map[pb.Config_DeviceConfig_Role]bool
+	distance  float64
+	baseline  map[uint32]*pb.Position
+	directory *nodes.Directory
+}
+
+func (r *positionRule) stateful() bool { return false }
+
+func (r *positionRule) observe(packet *meshtreampb.Packet, now time.Time, live bool) []event {
+	data := packet.GetData()
+	pos := data.GetPosition()
+	if data.GetPortNum() != pb.PortNum_POSITION_APP || pos == nil {
+		return nil
+	}
+	node := data.GetFrom()
+	if len(r.filter) > 0 {
+		if !r.filter[node] {
+			return nil
+		}
+	} else {
+		user := r.directory.User(node)
+		if user == nil || !r.roles[user.GetRole()] {
+			return nil
+		}
+	}
+
+	lat, lon, ok := nodes.Coordinates(pos)
+	if !ok {
+		return nil
+	}
+	base, seen := r.baseline[node]
+	if !seen {
+		r.baseline[node] = pos
+		return nil
+	}
+	baseLat, baseLon, _ := nodes.Coordinates(base)
+	moved := nodes.DistanceMeters(baseLat, baseLon, lat, lon)
+	if moved <= r.distance+nodes.PrecisionMeters(base)+nodes.PrecisionMeters(pos) {
+		return nil
+	}
+
+	// Move the baseline so the alert is not repeated for the same move.
+	r.baseline[node] = pos
+	if !live {
+		return nil
+	}
+	return []event{{
+		node:    node,
+		status:  StatusFiring,
+		message: fmt.Sprintf("%s moved %.0fm to %.5f, %.5f", r.directory.LongName(node), moved, lat, lon),
+	}}
+}
+
+func (r *positionRule) tick(time.Time) []event { return nil }
@@ -0,0 +1,252 @@
+package aprs
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/nodes"
+)
+
+func TestPasscode(t *testing.T) {
+	tests := map[string]int{
+		"N0CALL":   13023,
+		"n0call-7": 13023,
+		"KE6ABC-9": 19621,
+	}
+	for call, want := range tests {
+		if got := Passcode(call); got != want {
+			t.Errorf("Passcode(%q) = %d, want %d", call, got, want)
+		}
+	}
+}
+
+func TestValidCallsign(t *testing.T) {
+	for _, call := range []string{"N0CALL", "N0CALL-7", "KE6ABC-15", "W1AW"} {
+		if !ValidCallsign(call) {
+			t.Errorf("%q should be valid", call)
+		}
+	}
+	for _, call := range []string{"", "n0call", "TOOLONG1", "N0CALL-", "N0CALL-123", "N0 CALL"} {
+		if ValidCallsign(call) {
+			t.Errorf("%q should be invalid", call)
+		}
+	}
+}
+
+func TestReportLine(t *testing.T) {
+	r := Report{
+		Source:   "N0CALL-7",
+		Lat:      37.774929,
+		Lon:      -122.419416,
+		Altitude: 100,
+		Symbol:   DefaultSymbol,
+		Comment:  "Base Camp\r\n",
+	}
+	want := `N0CALL-7>APZMSH,TCPIP*:!3746.50N\12225.16WM/A=000328Base Camp`
+	if got := r.Line(); got != want {
+		t.Errorf("Line() =\n  %s\nwant\n  %s", got, want)
+	}
+
+	r.Altitude = 0
+	r.Ambiguity = 3
+	want = `N0CALL-7>APZMSH,TCPIP*:!374 .  N\1222 .  WM Base Camp`
+	if got := r.Line(); got != want {
+		t.Errorf("Line() with ambiguity =\n  %s\nwant\n  %s", got, want)
+	}
+}
+
+func TestAmbiguity(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		want int
+	}{
+		{0, 0},
+		{32, 0},
+		{19, 1}, // ~46 m
+		{16, 2}, // ~365 m
+		{13, 3}, // ~2.9 km
+		{10, 4}, // ~23 km
+		{5, 4},
+	}
+	for _, tt := range tests {
+		pos := &pb.Position{PrecisionBits: tt.bits}
+		if got := Ambiguity(nodes.PrecisionMeters(pos)); got != tt.want {
+			t.Errorf("precision_bits %d: ambiguity %d, want %d", tt.bits, got, tt.want)
+		}
+	}
+}
+
+// server is a stand-in APRS-IS server that records the login and packets.
+type server struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	login string
+	lines []string
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &server{listener: l}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte("# aprsc 2.1.19\r\n"))
+
+	reader := bufio.NewReader(conn)
+	login, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.login = strings.TrimSpace(login)
+	s.mu.Unlock()
+
+	fields := strings.Fields(login)
+	status := "unverified"
+	if len(fields) >= 4 && fields[3] == "13023" {
+		status = "verified"
+	}
+	conn.Write([]byte("# logresp " + fields[1] + " " + status + ", server T2TEST\r\n"))
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.lines = append(s.lines, strings.TrimRight(line, "\r\n"))
+		s.mu.Unlock()
+	}
+}
+
+func (s *server) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		if len(s.lines) >= n {
+			lines := append([]string(nil), s.lines...)
+			s.mu.Unlock()
+			return lines
+		}
+		s.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d APRS packets", n)
+	return nil
+}
+
+func position(from uint32, lat, lon int32, bits uint32) *meshtreampb.Packet {
+	return &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			From:    from,
+			PortNum: pb.PortNum_POSITION_APP,
+			Payload: &meshtreampb.Data_Position{Position: &pb.Position{
+				LatitudeI:     proto.Int32(lat),
+				LongitudeI:    proto.Int32(lon),
+				PrecisionBits: bits,
+			}},
+		},
+	}
+}
+
+func TestGatewaySendsReports(t *testing.T) {
+	aprsServer := newServer(t)
+
+	mesh := testutil.NewMesh(t)
+	mesh.Name(1, "Base Camp")
+	source := mesh.Source
+
+	gateway, err := NewGateway(Config{
+		Server:   aprsServer.listener.Addr().String(),
+		Callsign: "n0call",
+		Stations: map[uint32]string{1: "N0CALL-7", 2: "N0CALL-8"},
+	}, mesh.Broker, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatalf("failed to create gateway: %v", err)
+	}
+	defer gateway.Close()
+
+	source <- position(1, 377749290, -1224194160, 32)
+	aprsServer.wait(t, 1)
+	source <- position(1, 377749290, -1224194160, 32) // rate limited
+	source <- position(2, 377749290, -1224194160, 13)
+	source <- position(3, 377749290, -1224194160, 32) // not mapped
+
+	lines := aprsServer.wait(t, 2)
+	time.Sleep(50 * time.Millisecond)
+
+	aprsServer.mu.Lock()
+	defer aprsServer.mu.Unlock()
+	if aprsServer.login != "user N0CALL pass 13023 vers meshstream 1.0" {
+		t.Errorf("unexpected login: %q", aprsServer.login)
+	}
+	if len(aprsServer.lines) != 2 {
+		t.Fatalf("expected 2 packets, got %v", aprsServer.lines)
+	}
+	if lines[0] != `N0CALL-7>APZMSH,TCPIP*:!3746.50N\12225.16WM Base Camp` {
+		t.Errorf("unexpected report: %s", lines[0])
+	}
+	if lines[1] != `N0CALL-8>APZMSH,TCPIP*:!374 .  N\1222 .  WM !00000002` {
+		t.Errorf("unexpected ambiguous report: %s", lines[1])
+	}
+}
+
+func TestGatewayConfigValidation(t *testing.T) {
+	logger := logging.NewDevLogger().Named("test")
+	configs := []Config{
+		{Callsign: "N0CALL"},
+		{Callsign: "not a call", Stations: map[uint32]string{1: "N0CALL-7"}},
+		{Callsign: "N0CALL", Stations: map[uint32]string{1: "bad call"}},
+		{Callsign: "N0CALL", Symbol: "xyz", Stations: map[uint32]string{1: "N0CALL-7"}},
+	}
+	for i, config := range configs {
+		if _, err := NewGateway(config, nil, nodes.NewDirectory(), logger); err == nil {
+			t.Errorf("config %d: expected an error", i)
+		}
+	}
+}
+
+func TestParseStations(t *testing.T) {
+	stations, err := ParseStations([]string{"!0000abcd=n0call-7", " 42 = KE6ABC-9 "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stations[0xabcd] != "N0CALL-7" || stations[42] != "KE6ABC-9" {
+		t.Errorf("unexpected stations: %v", stations)
+	}
+	if _, err := ParseStations([]string{"!0000abcd"}); err == nil {
+		t.Error("expected an error for a missing callsign")
+	}
+	if _, err := ParseStations([]string{"!0000abcd=NOT A CALL"}); err == nil {
+		t.Error("expected an error for an invalid callsign")
+	}
+}
@@ -0,0 +1,151 @@
+package aprs
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"meshstream/nodes"
+)
+
+// tocall identifies the software in the APRS destination field. The APZ
+// prefix is reserved for experimental software.
+const tocall = "APZMSH"
+
+// callsignPattern matches an APRS-IS station callsign with optional SSID.
+var callsignPattern = regexp.MustCompile(`^[A-Z0-9]{1,6}(-[A-Z0-9]{1,2})?$`)
+
+// ValidCallsign reports whether s is a valid callsign with optional SSID.
+func ValidCallsign(s string) bool {
+	return callsignPattern.MatchString(s)
+}
+
+// Passcode computes the APRS-IS login passcode for a callsign. Any SSID is
+// ignored.
+func Passcode(callsign string) int {
+	call, _, _ := strings.Cut(strings.ToUpper(callsign), "-")
+	hash := 0x73e2
+	for i := 0; i < len(call); i += 2 {
+		hash ^= int(call[i]) << 8
+		if i+1 < len(call) {
+			hash ^= int(call[i+1])
+		}
+	}
+	return hash & 0x7fff
+}
+
+// ambiguityMeters is the north-south extent of each APRS position ambiguity
+// level, from hundredths of a minute (none) up to whole degrees.
+var ambiguityMeters = []float64{18.52, 185.2, 1852, 18520, 111120}
+
+// Ambiguity returns the smallest APRS position ambiguity level (0-4) that
+// does not claim more accuracy than a position precise to within the given
+// number of meters.
+func Ambiguity(precisionMeters float64) int {
+	for level, m := range ambiguityMeters {
+		if m >= precisionMeters {
+			return level
+		}
+	}
+	return len(ambiguityMeters) - 1
+}
+
+// Report is an APRS position report.
+type Report struct {
+	Source    string  // Callsign-SSID of the station
+	Lat, Lon  float64 // Decimal degrees
+	Ambiguity int     // Position ambiguity level, 0-4
+	Altitude  float64 // Meters; 0 omits altitude
+	Symbol    string  // Two characters: symbol table and symbol code
+	Comment   string
+}
+
+// Line formats the report as an APRS-IS packet, without line terminator.
+func (r Report) Line() string {
+	symbol := r.Symbol
+	if len(symbol) != 2 {
+		symbol = DefaultSymbol
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s>%s,TCPIP*:!", r.Source, tocall)
+	sb.WriteString(formatCoord(r.Lat, 2, "N", "S", r.Ambiguity))
+	sb.WriteByte(symbol[0])
+	sb.WriteString(formatCoord(r.Lon, 3, "E", "W", r.Ambiguity))
+	sb.WriteByte(symbol[1])
+
+	if r.Altitude != 0 {
+		fmt.Fprintf(&sb, "/A=%06d", int(math.Round(r.Altitude*3.28084)))
+	}
+	if r.Comment != "" {
+		if r.Altitude == 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(sanitize(r.Comment))
+	}
+	return sb.String()
+}
+
+// formatCoord renders a coordinate as DDMM.mmH (or DDDMM.mmH), replacing the
+// least significant digits with spaces for ambiguous positions.
+func formatCoord(v float64, degDigits int, pos, neg string, ambiguity int) string {
+	hemi := pos
+	if v < 0 {
+		hemi = neg
+		v = -v
+	}
+	// Work in hundredths of a minute to avoid rounding 59.995 up to 60.00.
+	hundredths := int(math.Round(v * 6000))
+	deg := hundredths / 6000
+	minutes := hundredths % 6000
+
+	digits := []byte(fmt.Sprintf("%0*d%04d", degDigits, deg, minutes))
+	// Blank digits from the right: hundredths, tenths, minutes, tens of minutes.
+	for i := 0; i < ambiguity && i < 4; i++ {
+		digits[len(digits)-1-i] = ' '
+	}
+	d := string(digits)
+	return d[:len(d)-2] + "." + d[len(d)-2:] + hemi
+}
+
+// sanitize strips characters that would break an APRS-IS line and limits the
+// comment to the 43 characters the spec allows.
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > 43 {
+		s = s[:43]
+	}
+	return s
+}
+
+// ParseStations parses "node=CALLSIGN-SSID" entries, where node is any form
+// accepted by nodes.ParseID, into a map of node number to callsign.
+func ParseStations(entries []string) (map[uint32]string, error) {
+	stations := make(map[uint32]string, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, call, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid station mapping %q, expected node=CALLSIGN-SSID", entry)
+		}
+		num, err := nodes.ParseID(strings.TrimSpace(id))
+		if err != nil {
+			return nil, err
+		}
+		call = strings.ToUpper(strings.TrimSpace(call))
+		if !ValidCallsign(call) {
+			return nil, fmt.Errorf("invalid APRS callsign %q", call)
+		}
+		stations[num] = call
+	}
+	return stations, nil
+}
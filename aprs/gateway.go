@@ -0,0 +1,266 @@
+package aprs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/delivery"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// DefaultSymbol is the APRS symbol used when none is configured: the
+// alternate-table "M" overlay, commonly used for mesh nodes.
+const DefaultSymbol = `\M`
+
+// Config holds configuration for the APRS-IS gateway.
+type Config struct {
+	Server      string            // APRS-IS server host:port (default: rotate.aprs2.net:14580)
+	Callsign    string            // Login callsign
+	Passcode    int               // Login passcode; computed from Callsign when zero
+	Stations    map[uint32]string // Node number -> callsign-SSID to report it as
+	MinInterval time.Duration     // Minimum time between reports for a station (default: 10m)
+	Symbol      string            // Two-character APRS symbol (default: \M)
+	Comment     string            // Appended to each report after the node's name
+	SkipCache   bool              // See mqtt.SubscriberConfig.SkipCache
+}
+
+// Gateway reports the positions of allowlisted mesh nodes to APRS-IS.
+type Gateway struct {
+	*mqtt.BaseSubscriber
+	config    Config
+	directory *nodes.Directory
+
+	mu       sync.Mutex
+	lastSent map[uint32]time.Time
+
+	queue  chan string
+	done   chan struct{}
+	wg     sync.WaitGroup
+	health health.Tracker
+	logger logging.Logger
+}
+
+// NewGateway creates an APRS-IS gateway subscribed to the broker. The
+// connection is opened lazily and re-established after errors.
+func NewGateway(config Config, broker *mqtt.Broker, directory *nodes.Directory, logger logging.Logger) (*Gateway, error) {
+	if config.Server == "" {
+		config.Server = "rotate.aprs2.net:14580"
+	}
+	if config.MinInterval <= 0 {
+		config.MinInterval = 10 * time.Minute
+	}
+	if config.Symbol == "" {
+		config.Symbol = DefaultSymbol
+	}
+	config.Callsign = strings.ToUpper(config.Callsign)
+	if !ValidCallsign(config.Callsign) {
+		return nil, fmt.Errorf("invalid APRS login callsign %q", config.Callsign)
+	}
+	if config.Passcode == 0 {
+		config.Passcode = Passcode(config.Callsign)
+	}
+	if len(config.Symbol) != 2 {
+		return nil, fmt.Errorf("APRS symbol must be two characters, got %q", config.Symbol)
+	}
+	if len(config.Stations) == 0 {
+		return nil, fmt.Errorf("no nodes mapped to APRS callsigns")
+	}
+	for node, call := range config.Stations {
+		if !ValidCallsign(call) {
+			return nil, fmt.Errorf("invalid APRS callsign %q for node %s", call, nodes.FormatID(node))
+		}
+	}
+
+	g := &Gateway{
+		config:    config,
+		directory: directory,
+		lastSent:  make(map[uint32]time.Time),
+		queue:     make(chan string, 100),
+		done:      make(chan struct{}),
+		logger:    logger.Named("aprs"),
+	}
+
+	g.BaseSubscriber = mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "APRS",
+		Broker:     broker,
+		BufferSize: 100,
+		SkipCache:  config.SkipCache,
+		Processor:  g.process,
+		StartHook: func() {
+			g.wg.Add(1)
+			go g.sendLoop()
+		},
+		CloseHook: func() {
+			close(g.done)
+			g.wg.Wait()
+		},
+		Logger: logger,
+	})
+	g.Start()
+
+	return g, nil
+}
+
+// process converts positions from mapped nodes into APRS reports, at most
+// one per station every MinInterval.
+func (g *Gateway) process(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	if data.GetPortNum() != pb.PortNum_POSITION_APP {
+		return
+	}
+	call, ok := g.config.Stations[data.GetFrom()]
+	if !ok {
+		return
+	}
+	pos := data.GetPosition()
+	lat, lon, ok := nodes.Coordinates(pos)
+	if !ok {
+		return
+	}
+
+	// A restart shouldn't resend the cache's positions.
+	if !g.Live(packet) {
+		return
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	if last, sent := g.lastSent[data.GetFrom()]; sent && now.Sub(last) < g.config.MinInterval {
+		g.mu.Unlock()
+		return
+	}
+	g.lastSent[data.GetFrom()] = now
+	g.mu.Unlock()
+
+	comment := g.directory.LongName(data.GetFrom())
+	if g.config.Comment != "" {
+		comment += " " + g.config.Comment
+	}
+	report := Report{
+		Source:    call,
+		Lat:       lat,
+		Lon:       lon,
+		Ambiguity: Ambiguity(nodes.PrecisionMeters(pos)),
+		Altitude:  float64(pos.GetAltitude()),
+		Symbol:    g.config.Symbol,
+		Comment:   comment,
+	}
+
+	select {
+	case g.queue <- report.Line():
+	default:
+		g.logger.Warnw("APRS queue full, dropping report", "station", call)
+	}
+}
+
+// sendLoop writes queued reports, connecting and logging in as needed.
+func (g *Gateway) sendLoop() {
+	defer g.wg.Done()
+	delivery.Run(delivery.Config[string]{
+		Name:    "APRS-IS",
+		Address: g.config.Server,
+		Dial:    g.connect,
+	}, g.queue, g.done, &g.health, g.logger)
+}
+
+// connection is a logged-in APRS-IS session.
+type connection struct {
+	conn   net.Conn
+	dead   chan struct{}
+	once   sync.Once
+	logger logging.Logger
+}
+
+func (g *Gateway) connect() (delivery.Conn[string], error) {
+	conn, err := net.DialTimeout("tcp", g.config.Server, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	// The server greets with a "# software version" banner.
+	if _, err := reader.ReadString('\n'); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading banner: %v", err)
+	}
+
+	login := fmt.Sprintf("user %s pass %d vers meshstream 1.0\r\n", g.config.Callsign, g.config.Passcode)
+	if _, err := conn.Write([]byte(login)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("reading login response: %v", err)
+		}
+		if !strings.HasPrefix(line, "# logresp") {
+			continue
+		}
+		// "# logresp CALL verified, server T2EXAMPLE"
+		if !strings.Contains(line, " verified") || strings.Contains(line, "unverified") {
+			conn.Close()
+			return nil, fmt.Errorf("login rejected: %s", strings.TrimSpace(line))
+		}
+		break
+	}
+	conn.SetDeadline(time.Time{})
+	g.logger.Infow("Connected to APRS-IS", "server", g.config.Server, "callsign", g.config.Callsign)
+
+	c := &connection{conn: conn, dead: make(chan struct{}), logger: g.logger}
+	// Drain server keepalives so the connection doesn't stall, and notice
+	// when the server hangs up.
+	go func() {
+		defer c.Close()
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+func (c *connection) Send(line string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		return err
+	}
+	c.logger.Debugw("Sent APRS report", "packet", line)
+	return nil
+}
+
+func (c *connection) Closed() bool {
+	select {
+	case <-c.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *connection) Close() {
+	c.once.Do(func() {
+		close(c.dead)
+		c.conn.Close()
+	})
+}
+
+// Health reports whether connecting to APRS-IS or sending reports is
+// failing.
+func (g *Gateway) Health() health.Status {
+	return g.health.Status()
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/dpup/prefab/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnauthenticated is returned when a request has no valid credentials and
+// anonymous access isn't allowed.
+var ErrUnauthenticated = errors.New("authentication required")
+
+const (
+	// discoveryTimeout bounds fetching the OIDC provider's configuration.
+	discoveryTimeout = 10 * time.Second
+	// discoveryRetry is how long after a failed discovery before trying again.
+	discoveryRetry = 30 * time.Second
+)
+
+// Authenticator resolves request credentials to an identity.
+type Authenticator struct {
+	config Config
+	logger logging.Logger
+
+	tokens map[[sha256.Size]byte]*Identity
+	users  map[string]UserConfig
+
+	// verified caches successful basic auth checks, since bcrypt is slow by
+	// design and clients send credentials with every request.
+	verifiedMu sync.Mutex
+	verified   map[[sha256.Size]byte]bool
+
+	// The OIDC provider is discovered on first use and retried after a
+	// failure, so an unreachable issuer doesn't stop the server starting.
+	oidcMu      sync.Mutex
+	verifier    *oidc.IDTokenVerifier
+	discovering chan struct{} // Closed when the discovery in progress ends; nil when none is
+	oidcErr     error         // Why the last discovery failed
+	oidcRetryAt time.Time     // When to try again after a failure
+}
+
+// NewAuthenticator creates an authenticator for a validated config.
+func NewAuthenticator(config Config, logger logging.Logger) *Authenticator {
+	a := &Authenticator{
+		config:   config,
+		logger:   logger.Named("auth"),
+		tokens:   make(map[[sha256.Size]byte]*Identity),
+		users:    make(map[string]UserConfig),
+		verified: make(map[[sha256.Size]byte]bool),
+	}
+	for _, t := range config.Tokens {
+		policy := t.Policy
+		a.tokens[sha256.Sum256([]byte(t.Token))] = &Identity{Name: t.Name, Method: "token", Policy: &policy}
+	}
+	for _, u := range config.Users {
+		a.users[u.Name] = u
+	}
+	return a
+}
+
+// Authenticate resolves the credentials of an HTTP request. Browsers can't
+// set headers on EventSource and WebSocket connections, so a bearer token may
+// also be passed as the access_token query parameter.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			header = "Bearer " + token
+		}
+	}
+	return a.AuthenticateHeader(r.Context(), header)
+}
+
+// AuthenticateHeader resolves an Authorization header value, which may be
+// empty. It is used for gRPC metadata as well as HTTP requests.
+func (a *Authenticator) AuthenticateHeader(ctx context.Context, header string) (*Identity, error) {
+	scheme, credentials, _ := strings.Cut(header, " ")
+	credentials = strings.TrimSpace(credentials)
+
+	switch {
+	case header == "":
+		if a.config.Anonymous != nil {
+			return &Identity{Method: "anonymous", Policy: a.config.Anonymous}, nil
+		}
+		return nil, ErrUnauthenticated
+
+	case strings.EqualFold(scheme, "Bearer") && credentials != "":
+		if identity, ok := a.tokens[sha256.Sum256([]byte(credentials))]; ok {
+			return identity, nil
+		}
+		if a.config.OIDC != nil && strings.Count(credentials, ".") == 2 {
+			return a.authenticateOIDC(ctx, credentials)
+		}
+		return nil, fmt.Errorf("unknown token")
+
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("invalid basic credentials")
+		}
+		name, password, _ := strings.Cut(string(decoded), ":")
+		return a.authenticateBasic(name, password)
+	}
+	return nil, fmt.Errorf("unsupported authorization scheme %q", scheme)
+}
+
+// Challenge returns the WWW-Authenticate header for unauthenticated
+// requests. Basic is offered when users are configured so that browsers
+// prompt for a password.
+func (a *Authenticator) Challenge() string {
+	if len(a.config.Users) > 0 {
+		return `Basic realm="meshstream", charset="UTF-8"`
+	}
+	return `Bearer realm="meshstream"`
+}
+
+func (a *Authenticator) authenticateBasic(name, password string) (*Identity, error) {
+	user, ok := a.users[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown user %q", name)
+	}
+
+	key := sha256.Sum256([]byte(name + "\x00" + password + "\x00" + user.Password))
+	a.verifiedMu.Lock()
+	verified := a.verified[key]
+	a.verifiedMu.Unlock()
+
+	if !verified {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			return nil, fmt.Errorf("wrong password for user %q", name)
+		}
+		a.verifiedMu.Lock()
+		a.verified[key] = true
+		a.verifiedMu.Unlock()
+	}
+
+	policy := user.Policy
+	return &Identity{Name: name, Method: "basic", Policy: &policy}, nil
+}
+
+func (a *Authenticator) authenticateOIDC(ctx context.Context, raw string) (*Identity, error) {
+	verifier, err := a.oidcVerifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC token: %v", err)
+	}
+
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid OIDC claims: %v", err)
+	}
+	claim := a.config.OIDC.Claim
+	if claim == "" {
+		claim = "email"
+	}
+	name, _ := claims[claim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("OIDC token has no %q claim", claim)
+	}
+	// Some providers let users set an email address without proving they
+	// own it, which would let them claim a configured user's policy.
+	if claim == "email" && !emailVerified(claims) {
+		return nil, fmt.Errorf("OIDC email %q is not verified", name)
+	}
+
+	for _, u := range a.config.OIDC.Users {
+		if u.Name == name {
+			policy := u.Policy
+			return &Identity{Name: name, Method: "oidc", Policy: &policy}, nil
+		}
+	}
+	if a.config.OIDC.Default != nil {
+		return &Identity{Name: name, Method: "oidc", Policy: a.config.OIDC.Default}, nil
+	}
+	return nil, fmt.Errorf("OIDC user %q is not authorized", name)
+}
+
+// emailVerified reports whether the claims mark the email as verified. Some
+// providers send the flag as a string.
+func emailVerified(claims map[string]any) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// oidcVerifier returns the verifier of the discovered provider, starting
+// discovery if needed and waiting for it until ctx is done. After a failure,
+// requests fail with the same error until discoveryRetry has passed, rather
+// than each waiting on an unreachable issuer.
+func (a *Authenticator) oidcVerifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	a.oidcMu.Lock()
+	if a.verifier != nil || time.Now().Before(a.oidcRetryAt) {
+		verifier, err := a.verifier, a.oidcErr
+		a.oidcMu.Unlock()
+		return verifier, err
+	}
+	done := a.discovering
+	if done == nil {
+		done = make(chan struct{})
+		a.discovering = done
+		go a.discoverOIDC(done)
+	}
+	a.oidcMu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	a.oidcMu.Lock()
+	defer a.oidcMu.Unlock()
+	if a.verifier != nil {
+		return a.verifier, nil
+	}
+	return nil, a.oidcErr
+}
+
+// discoverOIDC fetches the provider's configuration and closes done.
+func (a *Authenticator) discoverOIDC(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, a.config.OIDC.Issuer)
+
+	a.oidcMu.Lock()
+	defer a.oidcMu.Unlock()
+	defer close(done)
+	a.discovering = nil
+	if err != nil {
+		a.logger.Warnw("OIDC discovery failed", "issuer", a.config.OIDC.Issuer, "error", err, "retryIn", discoveryRetry)
+		a.oidcErr = fmt.Errorf("OIDC provider unavailable: %v", err)
+		a.oidcRetryAt = time.Now().Add(discoveryRetry)
+		return
+	}
+	a.verifier = provider.Verifier(&oidc.Config{ClientID: a.config.OIDC.ClientID})
+	a.logger.Infow("OIDC provider discovered", "issuer", a.config.OIDC.Issuer)
+}
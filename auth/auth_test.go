@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	jose "github.com/go-jose/go-jose/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+func TestPolicy(t *testing.T) {
+	packet := func(channel, region string) *meshtreampb.Packet {
+		return &meshtreampb.Packet{Info: &meshtreampb.TopicInfo{Channel: channel, RegionPath: region}}
+	}
+	tests := []struct {
+		policy *Policy
+		packet *meshtreampb.Packet
+		want   bool
+	}{
+		{nil, packet("Private", "EU_868"), true},
+		{&Policy{}, packet("Private", "EU_868"), true},
+		{&Policy{Channels: []string{"*"}}, packet("Private", "EU_868"), true},
+		{&Policy{Channels: []string{"LongFast"}}, packet("LongFast", "US"), true},
+		{&Policy{Channels: []string{"LongFast"}}, packet("Private", "US"), false},
+		{&Policy{Channels: []string{"LongFast"}}, packet("", "US"), false},
+		{&Policy{Regions: []string{"US"}}, packet("LongFast", "US/bayarea"), true},
+		{&Policy{Regions: []string{"US/bayarea/"}}, packet("LongFast", "US/bayarea"), true},
+		{&Policy{Regions: []string{"US"}}, packet("LongFast", "USA"), false},
+		{&Policy{Channels: []string{"LongFast"}, Regions: []string{"US"}}, packet("LongFast", "EU_868"), false},
+	}
+	for i, tt := range tests {
+		if got := tt.policy.Allows(tt.packet); got != tt.want {
+			t.Errorf("%d: Allows(%v) = %v, want %v", i, tt.packet.Info, got, tt.want)
+		}
+	}
+
+	if (&Policy{Channels: []string{"LongFast"}}).Unrestricted() || !(&Policy{Regions: []string{"*"}}).Unrestricted() {
+		t.Error("unexpected Unrestricted result")
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"tokens: []", "no way to authenticate"},
+		{"tokens:\n  - name: a\n    token: short", "at least 16"},
+		{"users:\n  - name: alice\n    password: hunter2", "bcrypt hash"},
+		{"anonymous:\n  chanels: [LongFast]", "not found"},
+		{"oidc:\n  issuer: https://example.com", "client_id"},
+		{"oidc:\n  issuer: https://example.com\n  client_id: x", "users or a default"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseConfig([]byte(tt.config)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("config %q: expected error containing %q, got %v", tt.config, tt.want, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseConfig([]byte(`
+anonymous:
+  channels: [LongFast]
+tokens:
+  - name: grafana
+    token: 0123456789abcdef0123
+    regions: [US]
+users:
+  - name: alice
+    password: "` + string(hash) + `"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthenticator(config, logging.NewDevLogger())
+
+	request := func(header, query string) (*Identity, error) {
+		r := httptest.NewRequest(http.MethodGet, "/api/stream"+query, nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		return a.Authenticate(r)
+	}
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	if id, err := request("", ""); err != nil || id.Method != "anonymous" || id.Policy.Channels[0] != "LongFast" {
+		t.Errorf("expected anonymous identity, got %+v, %v", id, err)
+	}
+	if id, err := request("Bearer 0123456789abcdef0123", ""); err != nil || id.Name != "grafana" || id.Policy.Regions[0] != "US" {
+		t.Errorf("expected token identity, got %+v, %v", id, err)
+	}
+	if id, err := request("", "?access_token=0123456789abcdef0123"); err != nil || id.Name != "grafana" {
+		t.Errorf("expected token identity from query, got %+v, %v", id, err)
+	}
+	for i := 0; i < 2; i++ { // The second check is answered from the cache.
+		if id, err := request(basic("alice", "correct horse"), ""); err != nil || id.Name != "alice" || !id.Policy.Unrestricted() {
+			t.Errorf("expected basic identity, got %+v, %v", id, err)
+		}
+	}
+
+	for _, header := range []string{"Bearer wrong", basic("alice", "wrong"), basic("bob", "correct horse"), "Digest x"} {
+		if _, err := request(header, ""); err == nil {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+
+	config.Anonymous = nil
+	a = NewAuthenticator(config, logging.NewDevLogger())
+	if _, err := request("", ""); err != ErrUnauthenticated {
+		t.Errorf("expected ErrUnauthenticated without anonymous access, got %v", err)
+	}
+	if !strings.HasPrefix(a.Challenge(), "Basic") {
+		t.Errorf("expected a basic challenge, got %q", a.Challenge())
+	}
+}
+
+func TestAuthenticateOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	issuer = server.URL
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(audience, email string, verified any) string {
+		claims, _ := json.Marshal(map[string]any{
+			"iss": issuer, "aud": audience, "sub": "123", "email": email, "email_verified": verified,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+		})
+		jws, err := signer.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := jws.CompactSerialize()
+		return "Bearer " + raw
+	}
+
+	config, err := ParseConfig([]byte(`
+oidc:
+  issuer: ` + issuer + `
+  client_id: meshstream
+  users:
+    - name: alice@example.com
+  default:
+    channels: [LongFast]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthenticator(config, logging.NewDevLogger())
+	ctx := context.Background()
+
+	if id, err := a.AuthenticateHeader(ctx, token("meshstream", "alice@example.com", true)); err != nil || id.Method != "oidc" || !id.Policy.Unrestricted() {
+		t.Errorf("expected alice's identity, got %+v, %v", id, err)
+	}
+	if id, err := a.AuthenticateHeader(ctx, token("meshstream", "bob@example.com", "true")); err != nil || id.Policy.Channels[0] != "LongFast" {
+		t.Errorf("expected the default policy for bob, got %+v, %v", id, err)
+	}
+	if _, err := a.AuthenticateHeader(ctx, token("other-app", "alice@example.com", true)); err == nil {
+		t.Error("expected a token for another audience to be rejected")
+	}
+	for _, verified := range []any{false, nil} {
+		if _, err := a.AuthenticateHeader(ctx, token("meshstream", "alice@example.com", verified)); err == nil {
+			t.Errorf("expected an unverified email (%v) to be rejected", verified)
+		}
+	}
+}
+
+func TestOIDCDiscoveryBackoff(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	a := NewAuthenticator(Config{OIDC: &OIDCConfig{Issuer: server.URL, ClientID: "meshstream"}}, logging.NewDevLogger())
+	ctx := context.Background()
+	for range 3 {
+		if _, err := a.AuthenticateHeader(ctx, "Bearer a.b.c"); err == nil {
+			t.Fatal("expected an error while the issuer is down")
+		}
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("expected one discovery attempt until the retry interval passes, got %d", n)
+	}
+}
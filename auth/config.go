@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// minTokenLength is the shortest static API token accepted.
+const minTokenLength = 16
+
+// Config is the authentication file. Each identity has a policy limiting the
+// packets it may see.
+type Config struct {
+	Anonymous *Policy       `yaml:"anonymous"` // Policy for requests without credentials; unset requires authentication
+	Tokens    []TokenConfig `yaml:"tokens"`
+	Users     []UserConfig  `yaml:"users"`
+	OIDC      *OIDCConfig   `yaml:"oidc"`
+}
+
+// TokenConfig declares a static API token, sent as "Authorization: Bearer".
+type TokenConfig struct {
+	Name   string `yaml:"name"`
+	Token  string `yaml:"token"`
+	Policy `yaml:",inline"`
+}
+
+// UserConfig declares a user for HTTP basic authentication.
+type UserConfig struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"` // bcrypt hash, e.g. from htpasswd -nbB
+	Policy   `yaml:",inline"`
+}
+
+// OIDCConfig accepts ID tokens from an OpenID Connect provider as bearer
+// tokens.
+type OIDCConfig struct {
+	Issuer   string           `yaml:"issuer"`    // Issuer URL, used for discovery
+	ClientID string           `yaml:"client_id"` // Expected audience of the tokens
+	Claim    string           `yaml:"claim"`     // Claim that names the user (default: email, which must be verified)
+	Users    []OIDCUserConfig `yaml:"users"`
+	Default  *Policy          `yaml:"default"` // Policy for other users with a valid token; unset rejects them
+}
+
+// OIDCUserConfig gives a policy to the user whose claim matches Name.
+type OIDCUserConfig struct {
+	Name   string `yaml:"name"`
+	Policy `yaml:",inline"`
+}
+
+// LoadConfig reads and validates a YAML authentication file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a YAML authentication document. Unknown
+// keys are rejected so that a typo can't silently widen a policy.
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("invalid auth config: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Validate checks the identities and policies, e.g. for a config embedded in
+// another file.
+func (c *Config) Validate() error {
+	if c.Anonymous == nil && len(c.Tokens) == 0 && len(c.Users) == 0 && c.OIDC == nil {
+		return fmt.Errorf("auth config declares no way to authenticate")
+	}
+
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i, t := range c.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("token %d: name is required", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("token %q: duplicate name", t.Name)
+		}
+		names[t.Name] = true
+		if len(t.Token) < minTokenLength {
+			return fmt.Errorf("token %q: token must be at least %d characters", t.Name, minTokenLength)
+		}
+		if tokens[t.Token] {
+			return fmt.Errorf("token %q: duplicate token", t.Name)
+		}
+		tokens[t.Token] = true
+	}
+
+	for i, u := range c.Users {
+		if u.Name == "" {
+			return fmt.Errorf("user %d: name is required", i+1)
+		}
+		if names[u.Name] {
+			return fmt.Errorf("user %q: duplicate name", u.Name)
+		}
+		names[u.Name] = true
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+			return fmt.Errorf("user %q: password must be a bcrypt hash: %v", u.Name, err)
+		}
+	}
+
+	if o := c.OIDC; o != nil {
+		if o.Issuer == "" || o.ClientID == "" {
+			return fmt.Errorf("oidc: issuer and client_id are required")
+		}
+		users := make(map[string]bool)
+		for i, u := range o.Users {
+			if u.Name == "" {
+				return fmt.Errorf("oidc user %d: name is required", i+1)
+			}
+			if users[u.Name] {
+				return fmt.Errorf("oidc user %q: duplicate name", u.Name)
+			}
+			users[u.Name] = true
+		}
+		if len(o.Users) == 0 && o.Default == nil {
+			return fmt.Errorf("oidc: users or a default policy is required")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+// Policy lists the channels and regions an identity may see. An empty list,
+// or one containing "*", doesn't restrict. A packet must match both lists.
+type Policy struct {
+	Channels []string `yaml:"channels"` // Channel names, e.g. LongFast
+	Regions  []string `yaml:"regions"`  // Region paths from the MQTT topic, e.g. US/bayarea; include subregions
+}
+
+// Unrestricted reports whether the policy allows every packet. Endpoints that
+// serve data derived from all traffic, such as the topology, require it. A
+// nil policy, used when authentication is off, is unrestricted.
+func (p *Policy) Unrestricted() bool {
+	return p == nil || (allowsAll(p.Channels) && allowsAll(p.Regions))
+}
+
+// Allows reports whether the policy permits the packet.
+func (p *Policy) Allows(packet *meshtreampb.Packet) bool {
+	if p == nil {
+		return true
+	}
+	info := packet.GetInfo()
+	return p.AllowsChannel(info.GetChannel()) && p.AllowsRegion(info.GetRegionPath())
+}
+
+// AllowsChannel reports whether the policy permits packets on a channel.
+func (p *Policy) AllowsChannel(channel string) bool {
+	if p == nil || allowsAll(p.Channels) {
+		return true
+	}
+	for _, c := range p.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRegion reports whether the policy permits packets from a region path.
+func (p *Policy) AllowsRegion(region string) bool {
+	if p == nil || allowsAll(p.Regions) {
+		return true
+	}
+	for _, r := range p.Regions {
+		r = strings.Trim(r, "/")
+		if region == r || strings.HasPrefix(region, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func allowsAll(values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Name   string  // Token, user or OIDC claim name; empty for anonymous requests
+	Method string  // "token", "basic", "oidc" or "anonymous"
+	Policy *Policy // Never nil
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity added by WithIdentity, or nil.
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// PolicyFrom returns the policy of the context's identity, or nil, which
+// allows everything, when there is none because authentication is off.
+func PolicyFrom(ctx context.Context) *Policy {
+	if identity := FromContext(ctx); identity != nil {
+		return identity.Policy
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package chatbridge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// Config holds configuration for the chat bridge.
+type Config struct {
+	Channels       []string // Mesh channels to forward; required
+	DiscordWebhook string   // Discord webhook URL
+	SlackWebhook   string   // Slack incoming webhook URL
+	Matrix         *MatrixConfig
+	SkipCache      bool // See mqtt.SubscriberConfig.SkipCache
+}
+
+// MatrixConfig identifies a Matrix room to post to via the client-server API.
+type MatrixConfig struct {
+	Homeserver  string // Base URL, e.g. https://matrix.example.org
+	RoomID      string // Room ID, e.g. !abc123:example.org
+	AccessToken string // Access token of the bridge user
+}
+
+// Message is a mesh text message prepared for forwarding.
+type Message struct {
+	ID       uint32
+	From     uint32
+	Sender   string // Long name resolved from NODEINFO
+	Channel  string
+	Text     string
+	Time     time.Time
+	ReplyTo  uint32   // Mesh ID of the message this replies to, if any
+	Reaction bool     // Text is an emoji reaction to ReplyTo
+	Original *Message // The message replied to, when it was forwarded recently
+}
+
+// sink delivers messages to one chat service.
+type sink interface {
+	send(ctx context.Context, msg *Message) error
+	name() string
+}
+
+const (
+	// historySize bounds the recently forwarded messages kept for reply
+	// threading.
+	historySize = 1000
+	// sendTimeout bounds each delivery attempt.
+	sendTimeout = 30 * time.Second
+)
+
+// Bridge forwards channel text messages to chat services.
+type Bridge struct {
+	*mqtt.BaseSubscriber
+	channels  map[string]bool
+	sinks     []sink
+	directory *nodes.Directory
+
+	mu    sync.Mutex
+	byID  map[uint32]*Message
+	order []uint32
+
+	queue  chan *Message
+	done   chan struct{}
+	wg     sync.WaitGroup
+	health health.Tracker
+	logger logging.Logger
+}
+
+// NewBridge creates a chat bridge subscribed to the broker.
+func NewBridge(config Config, broker *mqtt.Broker, directory *nodes.Directory, logger logging.Logger) (*Bridge, error) {
+	if len(config.Channels) == 0 {
+		return nil, fmt.Errorf("no channels configured for the chat bridge")
+	}
+
+	client := &http.Client{Timeout: sendTimeout}
+	b := &Bridge{
+		channels:  make(map[string]bool, len(config.Channels)),
+		directory: directory,
+		byID:      make(map[uint32]*Message),
+		queue:     make(chan *Message, 100),
+		done:      make(chan struct{}),
+		logger:    logger.Named("chatbridge"),
+	}
+	for _, ch := range config.Channels {
+		b.channels[ch] = true
+	}
+
+	if config.DiscordWebhook != "" {
+		b.sinks = append(b.sinks, &discordSink{url: config.DiscordWebhook, client: client})
+	}
+	if config.SlackWebhook != "" {
+		b.sinks = append(b.sinks, &slackSink{url: config.SlackWebhook, client: client})
+	}
+	if m := config.Matrix; m != nil {
+		if m.Homeserver == "" || m.RoomID == "" || m.AccessToken == "" {
+			return nil, fmt.Errorf("matrix homeserver, room and access token are all required")
+		}
+		b.sinks = append(b.sinks, newMatrixSink(*m, client))
+	}
+	if len(b.sinks) == 0 {
+		return nil, fmt.Errorf("no chat webhooks configured")
+	}
+
+	b.BaseSubscriber = mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "ChatBridge",
+		Broker:     broker,
+		BufferSize: 100,
+		SkipCache:  config.SkipCache,
+		DropCopies: true,
+		Processor:  b.process,
+		StartHook: func() {
+			b.wg.Add(1)
+			go b.deliverLoop()
+		},
+		CloseHook: func() {
+			close(b.done)
+			b.wg.Wait()
+		},
+		Logger: logger,
+	})
+	b.Start()
+
+	return b, nil
+}
+
+// process queues live broadcast text messages on bridged channels.
+func (b *Bridge) process(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	if data.GetPortNum() != pb.PortNum_TEXT_MESSAGE_APP || data.GetTextMessage() == "" {
+		return
+	}
+	// Direct messages are never bridged.
+	if data.GetTo() != nodes.BroadcastID || !b.channels[packet.GetInfo().GetChannel()] {
+		return
+	}
+	// A restart shouldn't repost the cache.
+	if !b.Live(packet) {
+		return
+	}
+
+	received := time.Now()
+	if data.GetRxTime() != 0 {
+		received = time.Unix(int64(data.GetRxTime()), 0)
+	}
+
+	msg := &Message{
+		ID:       data.GetId(),
+		From:     data.GetFrom(),
+		Sender:   b.directory.LongName(data.GetFrom()),
+		Channel:  packet.GetInfo().GetChannel(),
+		Text:     data.GetTextMessage(),
+		Time:     received,
+		ReplyTo:  data.GetReplyId(),
+		Reaction: data.GetEmoji() != 0 && data.GetReplyId() != 0,
+	}
+
+	b.mu.Lock()
+	if msg.ReplyTo != 0 {
+		msg.Original = b.byID[msg.ReplyTo]
+	}
+	b.remember(msg)
+	b.mu.Unlock()
+
+	select {
+	case b.queue <- msg:
+	default:
+		b.logger.Warnw("Chat bridge queue full, dropping message", "from", nodes.FormatID(msg.From))
+	}
+}
+
+// remember records a forwarded message for replies to thread under,
+// evicting the oldest when full.
+// Callers must hold mu.
+func (b *Bridge) remember(msg *Message) {
+	if msg.ID == 0 {
+		return
+	}
+	if _, ok := b.byID[msg.ID]; !ok {
+		b.order = append(b.order, msg.ID)
+	}
+	b.byID[msg.ID] = msg
+	if len(b.order) > historySize {
+		delete(b.byID, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// deliverLoop sends queued messages in order so threads stay coherent.
+func (b *Bridge) deliverLoop() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case msg := <-b.queue:
+			b.deliver(msg)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Bridge) deliver(msg *Message) {
+	for _, s := range b.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := s.send(ctx, msg); err != nil {
+			b.health.Failure()
+			b.logger.Errorw("Failed to forward chat message", "destination", s.name(), "error", err)
+		} else {
+			b.health.Success()
+		}
+		cancel()
+	}
+}
+
+// Health reports whether forwarding messages is failing.
+func (b *Bridge) Health() health.Status {
+	return b.health.Status()
+}
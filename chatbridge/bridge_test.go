@@ -0,0 +1,198 @@
+package chatbridge
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/nodes"
+)
+
+// webhook is a stand-in chat service that records request bodies and paths.
+type webhook struct {
+	mu       sync.Mutex
+	paths    []string
+	bodies   []map[string]interface{}
+	response string
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var body map[string]interface{}
+	json.Unmarshal(data, &body)
+
+	w.mu.Lock()
+	w.paths = append(w.paths, r.Method+" "+r.URL.Path)
+	w.bodies = append(w.bodies, body)
+	n := len(w.bodies)
+	w.mu.Unlock()
+
+	if w.response != "" {
+		rw.Write([]byte(strings.ReplaceAll(w.response, "N", string(rune('0'+n)))))
+	}
+}
+
+func (w *webhook) wait(t *testing.T, n int) []map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		if len(w.bodies) >= n {
+			bodies := append([]map[string]interface{}(nil), w.bodies...)
+			w.mu.Unlock()
+			return bodies
+		}
+		w.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d webhook calls", n)
+	return nil
+}
+
+func text(id, from uint32, channel, msg string) *meshtreampb.Packet {
+	return &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			Id:      id,
+			From:    from,
+			To:      nodes.BroadcastID,
+			PortNum: pb.PortNum_TEXT_MESSAGE_APP,
+			Payload: &meshtreampb.Data_TextMessage{TextMessage: msg},
+		},
+		Info: &meshtreampb.TopicInfo{Channel: channel},
+	}
+}
+
+func newTestBridge(t *testing.T, config Config) chan *meshtreampb.Packet {
+	t.Helper()
+	mesh := testutil.NewMesh(t)
+	mesh.Name(1, "Base Camp")
+
+	bridge, err := NewBridge(config, mesh.Broker, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+	t.Cleanup(bridge.Close)
+	return mesh.Source
+}
+
+func TestDiscordAndSlack(t *testing.T) {
+	discord, slack := &webhook{}, &webhook{}
+	discordServer, slackServer := httptest.NewServer(discord), httptest.NewServer(slack)
+	defer discordServer.Close()
+	defer slackServer.Close()
+
+	source := newTestBridge(t, Config{
+		Channels:       []string{"LongFast"},
+		DiscordWebhook: discordServer.URL,
+		SlackWebhook:   slackServer.URL,
+	})
+
+	// The broker doesn't guarantee ordering between packets, so wait for each
+	// message before sending one that refers to it.
+	source <- text(100, 1, "LongFast", "Meet at <trailhead> @everyone")
+	source <- text(100, 1, "LongFast", "Meet at <trailhead> @everyone") // second gateway
+	source <- text(101, 2, "Private", "not bridged")
+	dm := text(102, 2, "LongFast", "direct message")
+	dm.Data.To = 1
+	source <- dm
+	discord.wait(t, 1)
+
+	reply := text(103, 2, "LongFast", "On my way")
+	reply.Data.ReplyId = 100
+	source <- reply
+	discord.wait(t, 2)
+
+	reaction := text(104, 2, "LongFast", "👍")
+	reaction.Data.ReplyId = 100
+	reaction.Data.Emoji = 1
+	source <- reaction
+
+	bodies := discord.wait(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	discord.mu.Lock()
+	defer discord.mu.Unlock()
+	if len(discord.bodies) != 3 {
+		t.Fatalf("expected 3 Discord posts, got %d: %v", len(discord.bodies), discord.bodies)
+	}
+	if bodies[0]["username"] != "Base Camp" || bodies[0]["content"] != "Meet at <trailhead> @everyone" {
+		t.Errorf("unexpected first post: %v", bodies[0])
+	}
+	if mentions := bodies[0]["allowed_mentions"].(map[string]interface{}); len(mentions["parse"].([]interface{})) != 0 {
+		t.Errorf("mentions should be disabled: %v", mentions)
+	}
+	if bodies[1]["username"] != "!00000002" || bodies[1]["content"] != "> Base Camp: Meet at <trailhead> @everyone\nOn my way" {
+		t.Errorf("unexpected reply post: %q", bodies[1]["content"])
+	}
+	if bodies[2]["content"] != "reacted 👍 to Base Camp: > Meet at <trailhead> @everyone" {
+		t.Errorf("unexpected reaction post: %q", bodies[2]["content"])
+	}
+
+	slackBodies := slack.wait(t, 3)
+	if slackBodies[0]["text"] != "*Base Camp*: Meet at &lt;trailhead&gt; @everyone" {
+		t.Errorf("unexpected Slack post: %q", slackBodies[0]["text"])
+	}
+}
+
+func TestMatrixThreading(t *testing.T) {
+	matrix := &webhook{response: `{"event_id":"$evN"}`}
+	server := httptest.NewServer(matrix)
+	defer server.Close()
+
+	source := newTestBridge(t, Config{
+		Channels: []string{"LongFast"},
+		Matrix:   &MatrixConfig{Homeserver: server.URL, RoomID: "!room:example.org", AccessToken: "tok"},
+	})
+
+	source <- text(200, 1, "LongFast", "Anyone on the ridge?")
+	matrix.wait(t, 1)
+	reply := text(201, 2, "LongFast", "Yes")
+	reply.Data.ReplyId = 200
+	source <- reply
+	matrix.wait(t, 2)
+	reaction := text(202, 1, "LongFast", "🎉")
+	reaction.Data.ReplyId = 201
+	reaction.Data.Emoji = 1
+	source <- reaction
+
+	bodies := matrix.wait(t, 3)
+
+	if !strings.HasPrefix(matrix.paths[0], "PUT /_matrix/client/v3/rooms/!room:example.org/send/m.room.message/") {
+		t.Errorf("unexpected Matrix path: %s", matrix.paths[0])
+	}
+	if bodies[0]["body"] != "Base Camp: Anyone on the ridge?" {
+		t.Errorf("unexpected Matrix message: %v", bodies[0])
+	}
+
+	relates := bodies[1]["m.relates_to"].(map[string]interface{})
+	if relates["m.in_reply_to"].(map[string]interface{})["event_id"] != "$ev1" {
+		t.Errorf("reply not threaded to the original event: %v", bodies[1])
+	}
+
+	if !strings.Contains(matrix.paths[2], "/send/m.reaction/") {
+		t.Errorf("expected reaction event, got %s", matrix.paths[2])
+	}
+	annotation := bodies[2]["m.relates_to"].(map[string]interface{})
+	if annotation["rel_type"] != "m.annotation" || annotation["event_id"] != "$ev2" || annotation["key"] != "🎉" {
+		t.Errorf("unexpected reaction: %v", annotation)
+	}
+}
+
+func TestBridgeRequiresChannelsAndTargets(t *testing.T) {
+	logger := logging.NewDevLogger().Named("test")
+	if _, err := NewBridge(Config{DiscordWebhook: "http://example.com"}, nil, nodes.NewDirectory(), logger); err == nil {
+		t.Error("expected an error without channels")
+	}
+	if _, err := NewBridge(Config{Channels: []string{"LongFast"}}, nil, nodes.NewDirectory(), logger); err == nil {
+		t.Error("expected an error without targets")
+	}
+}
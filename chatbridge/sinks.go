@@ -0,0 +1,207 @@
+package chatbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// quoteLimit truncates quoted originals in reply previews.
+const quoteLimit = 80
+
+// summary renders a message as plain text with any reply or reaction context,
+// for services without native threading.
+func summary(msg *Message, quote func(string) string) string {
+	switch {
+	case msg.Reaction && msg.Original != nil:
+		return fmt.Sprintf("reacted %s to %s: %s", msg.Text, msg.Original.Sender, quote(truncate(msg.Original.Text)))
+	case msg.Reaction:
+		return "reacted " + msg.Text
+	case msg.Original != nil:
+		return quote(msg.Original.Sender+": "+truncate(msg.Original.Text)) + "\n" + msg.Text
+	}
+	return msg.Text
+}
+
+func truncate(s string) string {
+	r := []rune(s)
+	if len(r) <= quoteLimit {
+		return s
+	}
+	return string(r[:quoteLimit]) + "…"
+}
+
+func postJSON(ctx context.Context, client *http.Client, method, endpoint string, headers map[string]string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// discordSink posts to a Discord webhook, using the sender's name as the
+// webhook username. Discord webhooks can't thread or react, so replies quote
+// the original instead.
+type discordSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *discordSink) name() string { return "discord" }
+
+func (s *discordSink) send(ctx context.Context, msg *Message) error {
+	body := map[string]interface{}{
+		"username": msg.Sender,
+		"content":  summary(msg, func(q string) string { return "> " + strings.ReplaceAll(q, "\n", "\n> ") }),
+		// Mesh text must never ping Discord users or roles.
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}
+	return postJSON(ctx, s.client, http.MethodPost, s.url, nil, body, nil)
+}
+
+// slackEscaper escapes the characters Slack treats as control sequences.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackSink posts to a Slack incoming webhook. Incoming webhooks can't
+// thread or react, so replies quote the original instead.
+type slackSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *slackSink) name() string { return "slack" }
+
+func (s *slackSink) send(ctx context.Context, msg *Message) error {
+	escaped := *msg
+	escaped.Text = slackEscaper.Replace(msg.Text)
+	if msg.Original != nil {
+		original := *msg.Original
+		original.Text = slackEscaper.Replace(original.Text)
+		original.Sender = slackEscaper.Replace(original.Sender)
+		escaped.Original = &original
+	}
+	text := "*" + slackEscaper.Replace(msg.Sender) + "*: " + summary(&escaped, func(q string) string { return "> " + strings.ReplaceAll(q, "\n", "\n> ") })
+	return postJSON(ctx, s.client, http.MethodPost, s.url, nil, map[string]string{"text": text}, nil)
+}
+
+// matrixSink posts to a Matrix room via the client-server API. Replies and
+// reactions to messages it forwarded are sent as native replies and
+// annotations.
+type matrixSink struct {
+	config    MatrixConfig
+	client    *http.Client
+	txnPrefix string
+	txn       atomic.Int64
+
+	mu     sync.Mutex
+	events map[uint32]string // Mesh message ID -> Matrix event ID
+	order  []uint32
+}
+
+func newMatrixSink(config MatrixConfig, client *http.Client) *matrixSink {
+	config.Homeserver = strings.TrimSuffix(config.Homeserver, "/")
+	return &matrixSink{
+		config: config,
+		client: client,
+		// Transaction IDs are scoped to the access token, so they must not
+		// repeat across restarts.
+		txnPrefix: "meshstream-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-",
+		events:    make(map[uint32]string),
+	}
+}
+
+func (s *matrixSink) name() string { return "matrix" }
+
+func (s *matrixSink) send(ctx context.Context, msg *Message) error {
+	var original string
+	if msg.ReplyTo != 0 {
+		s.mu.Lock()
+		original = s.events[msg.ReplyTo]
+		s.mu.Unlock()
+	}
+
+	if msg.Reaction && original != "" {
+		return s.put(ctx, "m.reaction", map[string]interface{}{
+			"m.relates_to": map[string]string{
+				"rel_type": "m.annotation",
+				"event_id": original,
+				"key":      msg.Text,
+			},
+		}, nil)
+	}
+
+	content := map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    msg.Sender + ": " + msg.Text,
+	}
+	if original != "" {
+		content["m.relates_to"] = map[string]interface{}{
+			"m.in_reply_to": map[string]string{"event_id": original},
+		}
+	} else if msg.ReplyTo != 0 {
+		content["body"] = msg.Sender + ": " + summary(msg, func(q string) string { return "> " + strings.ReplaceAll(q, "\n", "\n> ") })
+	}
+
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	if err := s.put(ctx, "m.room.message", content, &resp); err != nil {
+		return err
+	}
+	if msg.ID != 0 && resp.EventID != "" {
+		s.remember(msg.ID, resp.EventID)
+	}
+	return nil
+}
+
+func (s *matrixSink) put(ctx context.Context, eventType string, content interface{}, out interface{}) error {
+	txn := s.txnPrefix + strconv.FormatInt(s.txn.Add(1), 10)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/%s/%s",
+		s.config.Homeserver, url.PathEscape(s.config.RoomID), eventType, txn)
+	headers := map[string]string{"Authorization": "Bearer " + s.config.AccessToken}
+	return postJSON(ctx, s.client, http.MethodPut, endpoint, headers, content, out)
+}
+
+func (s *matrixSink) remember(id uint32, eventID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.events[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.events[id] = eventID
+	if len(s.order) > historySize {
+		delete(s.events, s.order[0])
+		s.order = s.order[1:]
+	}
+}
@@ -0,0 +1,193 @@
+// Package cluster lets meshstream replicas share one MQTT connection. A
+// follower streams the decoded packets from another replica, the leader,
+// over the gRPC API instead of connecting to MQTT itself, so any number of
+// replicas can serve clients from a single subscription.
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/health"
+)
+
+// Config holds configuration for a follower.
+type Config struct {
+	Leader           string        // host:port of the leader's API
+	Token            string        // Bearer token, if the leader requires authentication
+	UseTLS           bool          // Connect with TLS, e.g. through a TLS-terminating proxy
+	MaxReconnectTime time.Duration // Longest wait between reconnection attempts (default: 30s)
+}
+
+// Follower receives the packets of a leader replica: its snapshot, to warm
+// the local cache, and then live traffic. After a dropped connection it
+// resumes from the last packet received, if the leader still has the packets
+// after it, and otherwise takes the packets of a new snapshot it doesn't
+// already have.
+type Follower struct {
+	config   Config
+	conn     *grpc.ClientConn
+	client   meshtreampb.MeshstreamClient
+	messages chan *meshtreampb.Packet
+	lastSeq  uint64 // Leader sequence number of the latest packet; only used by run
+
+	connected atomic.Bool
+	health    health.Tracker // How long the leader has been unreachable
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	logger    logging.Logger
+}
+
+// NewFollower creates a follower of the configured leader. Nothing is sent
+// until Start.
+func NewFollower(config Config, logger logging.Logger) (*Follower, error) {
+	if _, _, err := net.SplitHostPort(config.Leader); err != nil {
+		return nil, fmt.Errorf("invalid cluster leader address %q: %v", config.Leader, err)
+	}
+	if config.MaxReconnectTime <= 0 {
+		config.MaxReconnectTime = 30 * time.Second
+	}
+
+	creds := insecure.NewCredentials()
+	if config.UseTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.NewClient(config.Leader, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("error creating cluster client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Follower{
+		config:   config,
+		conn:     conn,
+		client:   meshtreampb.NewMeshstreamClient(conn),
+		messages: make(chan *meshtreampb.Packet, 100),
+		ctx:      ctx,
+		cancel:   cancel,
+		logger:   logger.Named("cluster"),
+	}, nil
+}
+
+// Start follows the leader in the background, reconnecting until Close.
+func (f *Follower) Start() {
+	f.wg.Add(1)
+	go f.run()
+}
+
+// Messages returns the channel the leader's packets are delivered on.
+func (f *Follower) Messages() <-chan *meshtreampb.Packet {
+	return f.messages
+}
+
+// Connected reports whether the follower is currently streaming from the
+// leader.
+func (f *Follower) Connected() bool {
+	return f.connected.Load()
+}
+
+// Health reports how long the leader has been unreachable, if it is.
+func (f *Follower) Health() health.Status {
+	return f.health.Status()
+}
+
+// Close stops following and closes the connection to the leader.
+func (f *Follower) Close() {
+	f.cancel()
+	f.wg.Wait()
+	f.conn.Close()
+}
+
+// run follows the leader, waiting longer between attempts while it stays
+// unreachable.
+func (f *Follower) run() {
+	defer f.wg.Done()
+
+	attempt := 0
+	for {
+		received, err := f.follow()
+		if f.ctx.Err() != nil {
+			return
+		}
+		if received > 0 {
+			attempt = 0
+		}
+		delay := min(time.Second<<min(attempt, 16), f.config.MaxReconnectTime)
+		attempt++
+		f.logger.Warnw("Lost the cluster leader, reconnecting",
+			"error", err,
+			"leader", f.config.Leader,
+			"retryIn", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// follow streams from the leader until the stream fails, and returns how
+// many packets it received.
+func (f *Follower) follow() (int, error) {
+	defer f.connected.Store(false)
+
+	ctx := f.ctx
+	if f.config.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+f.config.Token)
+	}
+	stream, err := f.client.Replicate(ctx, &meshtreampb.ReplicateRequest{AfterSeq: f.lastSeq})
+	if err != nil {
+		f.health.Failure()
+		return 0, err
+	}
+	// The leader sends its headers as soon as it accepts the follower, before
+	// any packet.
+	if _, err := stream.Header(); err != nil {
+		f.health.Failure()
+		return 0, err
+	}
+	f.connected.Store(true)
+	f.health.Success()
+	f.logger.Infow("Following cluster leader", "leader", f.config.Leader, "afterSeq", f.lastSeq)
+
+	received := 0
+	for {
+		packet, err := stream.Recv()
+		if err != nil {
+			f.health.Failure()
+			return received, err
+		}
+		received++
+		// When the leader can't resume after lastSeq, because it restarted
+		// or no longer caches that packet, it sends its snapshot again. Skip
+		// the packets delivered before, so the local cache and clients don't
+		// get them twice. Leader sequence numbers start from the clock, so
+		// they keep growing across restarts.
+		if packet.GetSeq() <= f.lastSeq {
+			continue
+		}
+		// The local broker renumbers the packet, so note the leader's
+		// number first.
+		f.lastSeq = packet.GetSeq()
+
+		select {
+		case f.messages <- packet:
+		case <-f.ctx.Done():
+			return received, f.ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package cluster
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+// fakeLeader serves one replication stream per entry of streams, sending
+// packets with those sequence numbers. Every stream but the last then fails;
+// the last stays open.
+type fakeLeader struct {
+	meshtreampb.UnimplementedMeshstreamServer
+	streams [][]uint64
+
+	mu       sync.Mutex
+	requests []uint64
+	tokens   []string
+}
+
+func (l *fakeLeader) Replicate(req *meshtreampb.ReplicateRequest, stream meshtreampb.Meshstream_ReplicateServer) error {
+	l.mu.Lock()
+	l.requests = append(l.requests, req.GetAfterSeq())
+	l.tokens = append(l.tokens, metadata.ValueFromIncomingContext(stream.Context(), "authorization")...)
+	n := len(l.requests)
+	l.mu.Unlock()
+
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for _, seq := range l.streams[min(n, len(l.streams))-1] {
+		if err := stream.Send(&meshtreampb.Packet{Seq: seq, Data: &meshtreampb.Data{Id: uint32(seq)}}); err != nil {
+			return err
+		}
+	}
+	if n < len(l.streams) {
+		return status.Error(codes.Unavailable, "leader restarting")
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// followLeader starts a follower of the leader.
+func followLeader(t *testing.T, leader *fakeLeader) *Follower {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	meshtreampb.RegisterMeshstreamServer(grpcServer, leader)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	follower, err := NewFollower(Config{
+		Leader:           listener.Addr().String(),
+		Token:            "0123456789abcdef",
+		MaxReconnectTime: 10 * time.Millisecond,
+	}, logging.NewDevLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	follower.Start()
+	t.Cleanup(follower.Close)
+	return follower
+}
+
+// expectPackets waits for packets with the given IDs, and no others.
+func expectPackets(t *testing.T, follower *Follower, ids ...uint32) {
+	t.Helper()
+	for _, want := range ids {
+		select {
+		case packet := <-follower.Messages():
+			if packet.GetData().GetId() != want {
+				t.Fatalf("expected packet %d, got %v", want, packet)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for packet %d", want)
+		}
+	}
+	select {
+	case packet := <-follower.Messages():
+		t.Fatalf("unexpected packet %v", packet)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFollowerResumes(t *testing.T) {
+	leader := &fakeLeader{streams: [][]uint64{{5, 6}, {7}}}
+	follower := followLeader(t, leader)
+	expectPackets(t, follower, 5, 6, 7)
+	if !follower.Connected() {
+		t.Error("expected the follower to be connected")
+	}
+
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	if len(leader.requests) != 2 || leader.requests[0] != 0 || leader.requests[1] != 6 {
+		t.Errorf("expected a snapshot request and then one resuming after 6, got %v", leader.requests)
+	}
+	if len(leader.tokens) != 2 || leader.tokens[0] != "Bearer 0123456789abcdef" {
+		t.Errorf("expected the token on every request, got %v", leader.tokens)
+	}
+}
+
+func TestFollowerSkipsResentPackets(t *testing.T) {
+	// The leader restarts, or no longer has the packets after 6, and sends
+	// its snapshot again, including packets the follower already has.
+	leader := &fakeLeader{streams: [][]uint64{{5, 6}, {4, 6, 8, 9}}}
+	follower := followLeader(t, leader)
+	expectPackets(t, follower, 5, 6, 8, 9)
+}
+
+func TestNewFollowerValidatesLeader(t *testing.T) {
+	if _, err := NewFollower(Config{Leader: "leader.example.com"}, logging.NewDevLogger()); err == nil {
+		t.Error("expected an error for a leader address without a port")
+	}
+}
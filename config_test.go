@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigFile = `
+sources:
+  mqtt:
+    broker: mqtt.example.com
+    topic_prefix: msh/EU_868
+    connect_timeout: 45s
+  embedded_broker:
+    users:
+      - username: gateway
+        password: secret
+        topics: [msh/EU_868/#, msh/EU_433/#]
+channel_keys:
+  LongFast: AQ==
+  Private: c2VjcmV0c2VjcmV0c2VjcmV0
+server:
+  port: "8080"
+cache:
+  size: 20000
+  partition_sizes:
+    EU_868:LongFast: 2000
+  policy:
+    min_age: 30m
+    pinned_nodes: ["!abcd1234"]
+sinks:
+  chat_bridge:
+    channels: [LongFast, Ops]
+    matrix:
+      room: "!room:example.com"
+  aprs:
+    callsign: N0CALL
+    stations:
+      "!abcd1234": N0CALL-9
+    comment: Solar, 5W
+alerts:
+  notifiers:
+    - name: ops
+      type: ntfy
+      url: https://ntfy.sh/mesh
+  rules:
+    - name: quiet
+      type: silent
+      nodes: ["!abcd1234"]
+      duration: 2h
+auth:
+  tokens:
+    - name: dashboard
+      token: 0123456789abcdef0123
+`
+
+func TestParseConfigFile(t *testing.T) {
+	config, err := parseConfigFile([]byte(testConfigFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"MQTT_BROKER":           "mqtt.example.com",
+		"MQTT_TOPIC_PREFIX":     "msh/EU_868",
+		"MQTT_CONNECT_TIMEOUT":  "45s",
+		"EMBEDDED_BROKER_USERS": "gateway:secret:msh/EU_868/#|msh/EU_433/#",
+		"CHANNEL_KEYS":          "LongFast:AQ==,Private:c2VjcmV0c2VjcmV0c2VjcmV0",
+		"SERVER_PORT":           "8080",
+		"CACHE_SIZE":            "20000",
+		"CACHE_PARTITION_SIZES": "EU_868:LongFast=2000",
+		"BRIDGE_CHANNELS":       "LongFast,Ops",
+		"BRIDGE_MATRIX_ROOM":    "!room:example.com",
+		"APRS_CALLSIGN":         "N0CALL",
+		"APRS_STATIONS":         "!abcd1234=N0CALL-9",
+		"APRS_COMMENT":          "Solar, 5W",
+	}
+	if !reflect.DeepEqual(config.settings, expected) {
+		t.Errorf("unexpected settings:\n got %v\nwant %v", config.settings, expected)
+	}
+
+	if config.alerts == nil || len(config.alerts.Rules) != 1 || config.alerts.Rules[0].Name != "quiet" {
+		t.Errorf("expected the alerts section to be decoded, got %+v", config.alerts)
+	}
+	if config.auth == nil || len(config.auth.Tokens) != 1 || config.auth.Tokens[0].Name != "dashboard" {
+		t.Errorf("expected the auth section to be decoded, got %+v", config.auth)
+	}
+	if config.cachePolicy == nil || config.cachePolicy.MinAge != 30*time.Minute || !config.cachePolicy.PinnedNodes[0xabcd1234] {
+		t.Errorf("expected the cache policy to be compiled, got %+v", config.cachePolicy)
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	invalid := map[string]struct {
+		doc  string
+		want string
+	}{
+		"unknown setting":      {"server:\n  port: \"80\"\n  hots: x\n", "line 3: unknown setting server.hots"},
+		"unknown section":      {"sink:\n  tak: {}\n", "line 1: unknown setting sink"},
+		"not a number":         {"cache:\n  size: lots\n", `line 2: cache.size: "lots" is not a whole number`},
+		"not a duration":       {"cache:\n\n  retention: 3\n", `line 3: cache.retention: "3" is not a duration`},
+		"not a list":           {"sinks:\n  tak:\n    channels: LongFast\n", "line 3: sinks.tak.channels: should be a list"},
+		"invalid value":        {"sinks:\n  tak:\n    protocol: http\n", `line 3: sinks.tak.protocol: unsupported TAK protocol "http"`},
+		"invalid key":          {"channel_keys:\n  LongFast: '!!'\n", "line 2: channel_keys: key for channel LongFast is not valid base64"},
+		"invalid partitioning": {"cache:\n  partition_by: node\n", "line 2: cache.partition_by: unknown cache partitioning"},
+		"comma in list":        {"sinks:\n  tak:\n    channels: [\"a,b\"]\n", `line 3: sinks.tak.channels: "a,b" must not contain a comma`},
+		"section type":         {"server: localhost\n", "line 1: server should be a mapping"},
+		"unknown alerts key":   {"alerts:\n  rulez: []\n", "line 2: field rulez not found"},
+		"invalid rule":         {"verbose: true\nalerts:\n  rules:\n    - name: x\n      type: nope\n", "line 2: alerts:"},
+		"invalid policy":       {"cache:\n  policy:\n    quotas:\n      text: 0\n", "line 2: cache policy quotas"},
+	}
+	for name, tt := range invalid {
+		_, err := parseConfigFile([]byte(tt.doc))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %q", name, tt.want, err)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meshstream.yaml")
+	err := os.WriteFile(path, []byte(`
+sources:
+  mqtt:
+    broker: file.example.com
+    username: file-user
+    password: file-password
+server:
+  host: 0.0.0.0
+sinks:
+  tak:
+    channels: [LongFast]
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MESHSTREAM_MQTT_USERNAME", "env-user")
+	t.Setenv("MESHSTREAM_MQTT_PASSWORD", "env-password")
+
+	config, err := loadConfig([]string{"--config", path, "--mqtt-password", "flag-password"}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.ConfigFile != path {
+		t.Errorf("expected config file %s, got %s", path, config.ConfigFile)
+	}
+	if config.MQTTBroker != "file.example.com" {
+		t.Errorf("expected the broker from the file, got %s", config.MQTTBroker)
+	}
+	if config.MQTTUsername != "env-user" {
+		t.Errorf("expected the environment to override the file, got %s", config.MQTTUsername)
+	}
+	if config.MQTTPassword != "flag-password" {
+		t.Errorf("expected the flag to override the environment, got %s", config.MQTTPassword)
+	}
+	if config.ServerHost != "0.0.0.0" || config.ServerPort != "5446" {
+		t.Errorf("expected the host from the file and the default port, got %s:%s", config.ServerHost, config.ServerPort)
+	}
+	if !reflect.DeepEqual(config.TAKChannels, []string{"LongFast"}) {
+		t.Errorf("expected TAK channels from the file, got %v", config.TAKChannels)
+	}
+
+	reloaded, err := loadConfig([]string{"--config=" + path}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reloaded.MQTTClientID != config.MQTTClientID {
+		t.Errorf("expected the client ID to be stable across reloads, got %s and %s", config.MQTTClientID, reloaded.MQTTClientID)
+	}
+
+	if _, err := loadConfig([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}, flag.ContinueOnError); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestSessionExpiryNeedsClientID(t *testing.T) {
+	if _, err := loadConfig([]string{"--mqtt-session-expiry", "1h"}, flag.ContinueOnError); err == nil {
+		t.Error("expected an error for a session expiry with the default client ID")
+	}
+	config, err := loadConfig([]string{"--mqtt-session-expiry", "1h", "--mqtt-client-id", "replica-1"}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.MQTTClientID != "replica-1" {
+		t.Errorf("expected the client ID as given, got %s", config.MQTTClientID)
+	}
+}
+
+func TestRestartSettings(t *testing.T) {
+	old := &Config{MQTTBroker: "a", TAKAddress: "1.2.3.4:6969", ChannelKeys: []string{"LongFast:AQ=="}}
+
+	reloadable := *old
+	reloadable.TAKAddress = ""
+	reloadable.ChannelKeys = nil
+	if !reflect.DeepEqual(restartSettings(old), restartSettings(&reloadable)) {
+		t.Error("expected changes to sinks and keys not to need a restart")
+	}
+
+	restart := *old
+	restart.MQTTBroker = "b"
+	if reflect.DeepEqual(restartSettings(old), restartSettings(&restart)) {
+		t.Error("expected a change of MQTT broker to need a restart")
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"meshstream/alerts"
+	"meshstream/aprs"
+	"meshstream/auth"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// settingKind is how a config file value is checked and turned into the
+// string an environment variable would hold.
+type settingKind int
+
+const (
+	kindString   settingKind = iota
+	kindInt                  // Whole number
+	kindBool                 // true or false
+	kindDuration             // Go duration, e.g. 90s or 3h
+	kindList                 // Sequence of scalars, joined with commas
+	kindPairs                // Mapping, joined as key<sep>value pairs with commas
+	kindUsers                // Sequence of embedded broker accounts
+)
+
+// fileSetting maps a key of the config file to the MESHSTREAM_ environment
+// variable it stands in for.
+type fileSetting struct {
+	env   string // Variable name without the MESHSTREAM_ prefix
+	kind  settingKind
+	sep   string             // kindPairs: separator between key and value
+	check func(string) error // Optional check of the value, or of each list element or pair
+}
+
+// fileSettingsByPath lists the settings of the config file by their dotted
+// path. Everything settable by flag or environment variable can be set here,
+// grouped into sources, sinks and the server.
+var fileSettingsByPath = map[string]fileSetting{
+	"sources.mqtt.broker":               {env: "MQTT_BROKER"},
+	"sources.mqtt.username":             {env: "MQTT_USERNAME"},
+	"sources.mqtt.password":             {env: "MQTT_PASSWORD"},
+	"sources.mqtt.topic_prefix":         {env: "MQTT_TOPIC_PREFIX"},
+	"sources.mqtt.client_id":            {env: "MQTT_CLIENT_ID"},
+	"sources.mqtt.keepalive":            {env: "MQTT_KEEPALIVE", kind: kindInt},
+	"sources.mqtt.connect_timeout":      {env: "MQTT_CONNECT_TIMEOUT", kind: kindDuration},
+	"sources.mqtt.ping_timeout":         {env: "MQTT_PING_TIMEOUT", kind: kindDuration},
+	"sources.mqtt.max_reconnect":        {env: "MQTT_MAX_RECONNECT", kind: kindDuration},
+	"sources.mqtt.use_tls":              {env: "MQTT_USE_TLS", kind: kindBool},
+	"sources.mqtt.tls_port":             {env: "MQTT_TLS_PORT", kind: kindInt},
+	"sources.mqtt.transport":            {env: "MQTT_TRANSPORT", check: checkMQTTTransport},
+	"sources.mqtt.port":                 {env: "MQTT_PORT", kind: kindInt},
+	"sources.mqtt.websocket_path":       {env: "MQTT_WEBSOCKET_PATH"},
+	"sources.mqtt.ca_file":              {env: "MQTT_CA_FILE"},
+	"sources.mqtt.cert_file":            {env: "MQTT_CERT_FILE"},
+	"sources.mqtt.key_file":             {env: "MQTT_KEY_FILE"},
+	"sources.mqtt.server_name":          {env: "MQTT_SERVER_NAME"},
+	"sources.mqtt.insecure_skip_verify": {env: "MQTT_INSECURE_SKIP_VERIFY", kind: kindBool},
+	"sources.mqtt.protocol_version":     {env: "MQTT_PROTOCOL_VERSION", kind: kindInt},
+	"sources.mqtt.share_group":          {env: "MQTT_SHARE_GROUP"},
+	"sources.mqtt.session_expiry":       {env: "MQTT_SESSION_EXPIRY", kind: kindDuration},
+	"sources.mqtt.origin":               {env: "MQTT_ORIGIN"},
+
+	"sources.embedded_broker.enabled": {env: "EMBEDDED_BROKER", kind: kindBool},
+	"sources.embedded_broker.addr":    {env: "EMBEDDED_BROKER_ADDR"},
+	"sources.embedded_broker.users":   {env: "EMBEDDED_BROKER_USERS", kind: kindUsers},
+	"sources.embedded_broker.bridge":  {env: "EMBEDDED_BROKER_BRIDGE", kind: kindBool},
+
+	"sources.cluster.leader": {env: "CLUSTER_LEADER"},
+	"sources.cluster.token":  {env: "CLUSTER_TOKEN"},
+	"sources.cluster.tls":    {env: "CLUSTER_TLS", kind: kindBool},
+
+	"channel_keys": {env: "CHANNEL_KEYS", kind: kindPairs, sep: ":", check: checkChannelKey},
+
+	"server.host":       {env: "SERVER_HOST"},
+	"server.port":       {env: "SERVER_PORT"},
+	"server.static_dir": {env: "STATIC_DIR"},
+
+	"server.readiness.max_packet_age":   {env: "READY_MAX_PACKET_AGE", kind: kindDuration},
+	"server.readiness.max_backlog":      {env: "READY_MAX_BACKLOG", kind: kindInt},
+	"server.readiness.max_disconnected": {env: "READY_MAX_DISCONNECTED", kind: kindDuration},
+	"server.readiness.max_sink_failure": {env: "READY_MAX_SINK_FAILURE", kind: kindDuration},
+
+	"cache.size":                   {env: "CACHE_SIZE", kind: kindInt},
+	"cache.retention":              {env: "CACHE_RETENTION", kind: kindDuration},
+	"cache.partition_by":           {env: "CACHE_PARTITION_BY", check: checkPartitionBy},
+	"cache.partition_sizes":        {env: "CACHE_PARTITION_SIZES", kind: kindPairs, sep: "=", check: checkPartitionSize},
+	"cache.max_partitions":         {env: "CACHE_MAX_PARTITIONS", kind: kindInt},
+	"cache.slow_subscriber_policy": {env: "SLOW_SUBSCRIBER_POLICY", check: checkSlowSubscriberPolicy},
+	"cache.snapshot_chat_size":     {env: "SNAPSHOT_CHAT_SIZE", kind: kindInt},
+
+	"metrics.raw_retention":    {env: "METRICS_RAW_RETENTION", kind: kindDuration},
+	"metrics.rollup_interval":  {env: "METRICS_ROLLUP_INTERVAL", kind: kindDuration},
+	"metrics.rollup_retention": {env: "METRICS_ROLLUP_RETENTION", kind: kindDuration},
+	"metrics.file":             {env: "METRICS_FILE"},
+
+	"sinks.home_assistant.broker":           {env: "HA_BROKER"},
+	"sinks.home_assistant.username":         {env: "HA_USERNAME"},
+	"sinks.home_assistant.password":         {env: "HA_PASSWORD"},
+	"sinks.home_assistant.discovery_prefix": {env: "HA_DISCOVERY_PREFIX"},
+	"sinks.home_assistant.nodes":            {env: "HA_NODES", kind: kindList, check: checkNodeID},
+
+	"sinks.influx.url":            {env: "INFLUX_URL"},
+	"sinks.influx.org":            {env: "INFLUX_ORG"},
+	"sinks.influx.bucket":         {env: "INFLUX_BUCKET"},
+	"sinks.influx.token":          {env: "INFLUX_TOKEN"},
+	"sinks.influx.file":           {env: "INFLUX_FILE"},
+	"sinks.influx.batch_size":     {env: "INFLUX_BATCH_SIZE", kind: kindInt},
+	"sinks.influx.flush_interval": {env: "INFLUX_FLUSH_INTERVAL", kind: kindDuration},
+
+	"sinks.chat_bridge.channels":          {env: "BRIDGE_CHANNELS", kind: kindList},
+	"sinks.chat_bridge.discord_webhook":   {env: "BRIDGE_DISCORD_WEBHOOK"},
+	"sinks.chat_bridge.slack_webhook":     {env: "BRIDGE_SLACK_WEBHOOK"},
+	"sinks.chat_bridge.matrix.homeserver": {env: "BRIDGE_MATRIX_HOMESERVER"},
+	"sinks.chat_bridge.matrix.room":       {env: "BRIDGE_MATRIX_ROOM"},
+	"sinks.chat_bridge.matrix.token":      {env: "BRIDGE_MATRIX_TOKEN"},
+
+	"sinks.aprs.server":   {env: "APRS_SERVER"},
+	"sinks.aprs.callsign": {env: "APRS_CALLSIGN"},
+	"sinks.aprs.passcode": {env: "APRS_PASSCODE", kind: kindInt},
+	"sinks.aprs.stations": {env: "APRS_STATIONS", kind: kindPairs, sep: "=", check: checkAPRSStation},
+	"sinks.aprs.interval": {env: "APRS_INTERVAL", kind: kindDuration},
+	"sinks.aprs.comment":  {env: "APRS_COMMENT"},
+
+	"sinks.tak.address":  {env: "TAK_ADDRESS"},
+	"sinks.tak.protocol": {env: "TAK_PROTOCOL", check: checkTAKProtocol},
+	"sinks.tak.channels": {env: "TAK_CHANNELS", kind: kindList},
+
+	"verbose": {env: "VERBOSE_LOGGING", kind: kindBool},
+}
+
+// fileSections are the parts of the config file that embed another config
+// format whole: the alerting rules, access control and the cache policy.
+var fileSections = map[string]bool{"alerts": true, "auth": true, "cache.policy": true}
+
+// fileConfig is a parsed config file.
+type fileConfig struct {
+	settings    map[string]string // Environment variable name → value
+	alerts      *alerts.Config    // alerts section
+	auth        *auth.Config      // auth section
+	cachePolicy *mqtt.CachePolicy // cache.policy section
+}
+
+// embeddedSections decodes the sections of the config file that have their
+// own types. The inline maps take the other keys, which are checked against
+// fileSettingsByPath instead.
+type embeddedSections struct {
+	Alerts *alerts.Config `yaml:"alerts"`
+	Auth   *auth.Config   `yaml:"auth"`
+	Cache  struct {
+		Policy *mqtt.CachePolicyConfig `yaml:"policy"`
+		Other  map[string]any          `yaml:",inline"`
+	} `yaml:"cache"`
+	Other map[string]any `yaml:",inline"`
+}
+
+// loadConfigFile reads a YAML config file.
+func loadConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFile(data)
+}
+
+// parseConfigFile parses a YAML config document. Unknown keys, values of
+// the wrong type and invalid settings are reported with their line number.
+func parseConfigFile(data []byte) (*fileConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+	config := &fileConfig{settings: make(map[string]string)}
+	if len(root.Content) == 0 {
+		return config, nil
+	}
+	doc := root.Content[0]
+	if err := config.walk(doc, ""); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+
+	var sections embeddedSections
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sections); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+	if sections.Alerts != nil {
+		if err := sections.Alerts.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file: line %d: alerts: %v", sectionLine(doc, "alerts"), err)
+		}
+		config.alerts = sections.Alerts
+	}
+	if sections.Auth != nil {
+		if err := sections.Auth.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file: line %d: auth: %v", sectionLine(doc, "auth"), err)
+		}
+		config.auth = sections.Auth
+	}
+	if sections.Cache.Policy != nil {
+		policy, err := sections.Cache.Policy.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file: line %d: %v", sectionLine(doc, "cache.policy"), err)
+		}
+		config.cachePolicy = &policy
+	}
+	return config, nil
+}
+
+// walk checks the settings under a mapping node and records their values.
+func (c *fileConfig) walk(node *yaml.Node, prefix string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: %s should be a mapping", node.Line, describePath(prefix))
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+		if fileSections[path] {
+			continue
+		}
+		if setting, ok := fileSettingsByPath[path]; ok {
+			v, err := setting.value(value)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %v", value.Line, path, err)
+			}
+			c.settings[setting.env] = v
+			continue
+		}
+		if !isSection(path) {
+			return fmt.Errorf("line %d: unknown setting %s", key.Line, path)
+		}
+		if err := c.walk(value, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value checks a setting's node and returns it as an environment variable
+// would hold it.
+func (s fileSetting) value(node *yaml.Node) (string, error) {
+	var values []string
+	switch s.kind {
+	case kindList:
+		if node.Kind != yaml.SequenceNode {
+			return "", fmt.Errorf("should be a list")
+		}
+		for _, item := range node.Content {
+			v, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, v)
+		}
+
+	case kindPairs:
+		if node.Kind != yaml.MappingNode {
+			return "", fmt.Errorf("should be a mapping")
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v, err := scalar(node.Content[i+1])
+			if err != nil {
+				return "", err
+			}
+			values = append(values, node.Content[i].Value+s.sep+v)
+		}
+
+	case kindUsers:
+		if node.Kind != yaml.SequenceNode {
+			return "", fmt.Errorf("should be a list")
+		}
+		for _, item := range node.Content {
+			user, err := embeddedUser(item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, user)
+		}
+
+	default:
+		v, err := scalar(node)
+		if err != nil {
+			return "", err
+		}
+		if err := s.kind.check(v); err != nil {
+			return "", err
+		}
+		values = []string{v}
+	}
+
+	for _, v := range values {
+		if s.kind != kindString && strings.Contains(v, ",") {
+			return "", fmt.Errorf("%q must not contain a comma", v)
+		}
+		if s.check != nil {
+			if err := s.check(v); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strings.Join(values, ","), nil
+}
+
+// check reports whether a scalar can be parsed as the kind.
+func (k settingKind) check(v string) error {
+	var err error
+	switch k {
+	case kindInt:
+		_, err = strconv.Atoi(v)
+		if err != nil {
+			err = fmt.Errorf("%q is not a whole number", v)
+		}
+	case kindBool:
+		if _, err = strconv.ParseBool(v); err != nil {
+			err = fmt.Errorf("%q is not true or false", v)
+		}
+	case kindDuration:
+		if _, err = time.ParseDuration(v); err != nil {
+			err = fmt.Errorf("%q is not a duration, e.g. 90s or 3h", v)
+		}
+	}
+	return err
+}
+
+// embeddedUser converts an account mapping to the user:password[:topics]
+// form of MESHSTREAM_EMBEDDED_BROKER_USERS.
+func embeddedUser(node *yaml.Node) (string, error) {
+	if node.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("line %d: account should be a mapping of username, password and topics", node.Line)
+	}
+	var username, password string
+	var topics []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "username":
+			username, err = scalar(value)
+		case "password":
+			password, err = scalar(value)
+		case "topics":
+			if value.Kind != yaml.SequenceNode {
+				return "", fmt.Errorf("line %d: topics should be a list", value.Line)
+			}
+			for _, item := range value.Content {
+				topic, err := scalar(item)
+				if err != nil {
+					return "", err
+				}
+				topics = append(topics, topic)
+			}
+		default:
+			return "", fmt.Errorf("line %d: unknown account setting %s", key.Line, key.Value)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if username == "" || strings.ContainsAny(username, ":,") || strings.ContainsAny(password, ":,") {
+		return "", fmt.Errorf("line %d: account needs a username, and neither it nor the password may contain ':' or ','", node.Line)
+	}
+	user := username + ":" + password
+	if len(topics) > 0 {
+		user += ":" + strings.Join(topics, "|")
+	}
+	return user, nil
+}
+
+// scalar returns the value of a scalar node.
+func scalar(node *yaml.Node) (string, error) {
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("line %d: expected a single value", node.Line)
+	}
+	return node.Value, nil
+}
+
+// isSection reports whether a path has settings below it.
+func isSection(path string) bool {
+	for p := range fileSettingsByPath {
+		if strings.HasPrefix(p, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// sectionLine returns the line of the key at a dotted path, or 0.
+func sectionLine(node *yaml.Node, path string) int {
+	name, rest, nested := strings.Cut(path, ".")
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != name {
+			continue
+		}
+		if !nested {
+			return node.Content[i].Line
+		}
+		return sectionLine(node.Content[i+1], rest)
+	}
+	return 0
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the config file"
+	}
+	return path
+}
+
+func checkChannelKey(pair string) error {
+	channel, key, _ := strings.Cut(pair, ":")
+	if _, err := base64.StdEncoding.DecodeString(key); err != nil {
+		return fmt.Errorf("key for channel %s is not valid base64", channel)
+	}
+	return nil
+}
+
+func checkPartitionBy(v string) error {
+	_, err := mqtt.ParsePartitionBy(v)
+	return err
+}
+
+func checkPartitionSize(pair string) error {
+	_, err := parsePartitionSizes([]string{pair})
+	return err
+}
+
+func checkSlowSubscriberPolicy(v string) error {
+	_, err := mqtt.ParseSlowSubscriberPolicy(v)
+	return err
+}
+
+func checkNodeID(v string) error {
+	_, err := nodes.ParseID(v)
+	return err
+}
+
+func checkAPRSStation(pair string) error {
+	_, err := aprs.ParseStations([]string{pair})
+	return err
+}
+
+func checkMQTTTransport(v string) error {
+	if v != mqtt.TransportTCP && v != mqtt.TransportWebSocket {
+		return fmt.Errorf("unsupported MQTT transport %q, expected tcp or websocket", v)
+	}
+	return nil
+}
+
+func checkTAKProtocol(v string) error {
+	if v != "udp" && v != "tcp" {
+		return fmt.Errorf("unsupported TAK protocol %q, expected udp or tcp", v)
+	}
+	return nil
+}
@@ -285,6 +285,12 @@ func decodeDataPayload(data *meshtreampb.Data, pbData *pb.Data) {
 			}
 		}
 
+	case pb.PortNum_ATAK_PLUGIN:
+		// TAK packet - store the raw bytes, decoded by the TAK output
+		data.Payload = &meshtreampb.Data_AtakPlugin{
+			AtakPlugin: payload,
+		}
+
 	default:
 		// For other types, just store the raw bytes
 		data.Payload = &meshtreampb.Data_BinaryData{
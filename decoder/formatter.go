@@ -76,6 +76,9 @@ func FormatTopicAndPacket(topic *meshtreampb.TopicInfo, data *meshtreampb.Data)
 	case *meshtreampb.Data_MapReport:
 		sb.WriteString(fmt.Sprintf("Type: Map Report\n"))
 
+	case *meshtreampb.Data_AtakPlugin:
+		sb.WriteString(fmt.Sprintf("Type: ATAK Plugin\nLength: %d bytes\n", len(data.GetAtakPlugin())))
+
 	case *meshtreampb.Data_BinaryData:
 		sb.WriteString(fmt.Sprintf("Type: Binary Data\nLength: %d bytes\n", len(data.GetBinaryData())))
 
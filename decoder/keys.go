@@ -44,6 +44,25 @@ func GetChannelKey(channelId string) []byte {
 	return PadKey(defaultKey)
 }
 
+// SetChannelKeys replaces all channel keys with the given channel → base64
+// key pairs. If any key is invalid, the current keys are kept.
+func SetChannelKeys(base64Keys map[string]string) error {
+	keys := make(map[string][]byte, len(base64Keys))
+	for channelId, base64Key := range base64Keys {
+		key, err := base64.StdEncoding.DecodeString(base64Key)
+		if err != nil {
+			return fmt.Errorf("invalid base64 key for channel %s: %v", channelId, err)
+		}
+		keys[channelId] = PadKey(key)
+	}
+
+	channelKeysMutex.Lock()
+	defer channelKeysMutex.Unlock()
+
+	channelKeys = keys
+	return nil
+}
+
 // ClearChannelKeys removes all channel keys
 func ClearChannelKeys() {
 	channelKeysMutex.Lock()
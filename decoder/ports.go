@@ -0,0 +1,28 @@
+package decoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "meshstream/generated/meshtastic"
+)
+
+// ParsePort accepts a port number or name, case-insensitively and with or
+// without the _APP suffix. "text" is short for TEXT_MESSAGE_APP.
+func ParsePort(v string) (pb.PortNum, error) {
+	if n, err := strconv.ParseInt(v, 10, 32); err == nil {
+		return pb.PortNum(n), nil
+	}
+	name := strings.ToUpper(v)
+	if name == "TEXT" {
+		return pb.PortNum_TEXT_MESSAGE_APP, nil
+	}
+	if n, ok := pb.PortNum_value[name]; ok {
+		return pb.PortNum(n), nil
+	}
+	if n, ok := pb.PortNum_value[name+"_APP"]; ok {
+		return pb.PortNum(n), nil
+	}
+	return 0, fmt.Errorf("unknown port %q", v)
+}
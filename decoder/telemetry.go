@@ -0,0 +1,73 @@
+package decoder
+
+import (
+	"math"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "meshstream/generated/meshtastic"
+)
+
+// TelemetryMetrics flattens whichever metrics variant a Telemetry message
+// carries into numeric fields keyed by their proto field name. The returned
+// kind names the variant with any "_metrics" suffix removed, e.g. "device",
+// "environment", "air_quality", "power", "local_stats" or "health".
+//
+// Only populated scalar fields are returned, so optional sensor readings a
+// node does not report are absent rather than zero. Booleans are returned as
+// 0 or 1; strings, bytes and nested messages are skipped, and so are NaN and
+// infinite readings, which JSON and line protocol can't carry. Variants added to
+// the Meshtastic protos later are picked up without changes here.
+func TelemetryMetrics(telemetry *pb.Telemetry) (string, map[string]float64) {
+	if telemetry == nil {
+		return "", nil
+	}
+
+	msg := telemetry.ProtoReflect()
+	variant := msg.Descriptor().Oneofs().ByName("variant")
+	if variant == nil {
+		return "", nil
+	}
+	field := msg.WhichOneof(variant)
+	if field == nil || field.Kind() != protoreflect.MessageKind {
+		return "", nil
+	}
+
+	kind := strings.TrimSuffix(string(field.Name()), "_metrics")
+	fields := make(map[string]float64)
+
+	msg.Get(field).Message().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.IsList() || fd.IsMap() {
+			return true
+		}
+		if f, ok := numericValue(fd, v); ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			fields[string(fd.Name())] = f
+		}
+		return true
+	})
+
+	return kind, fields
+}
+
+// numericValue converts a scalar protobuf value to a float64.
+func numericValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (float64, bool) {
+	switch fd.Kind() {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float(), true
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(v.Int()), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(v.Uint()), true
+	case protoreflect.EnumKind:
+		return float64(v.Enum()), true
+	case protoreflect.BoolKind:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,121 @@
+package decoder
+
+import (
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "meshstream/generated/meshtastic"
+)
+
+func TestTelemetryMetricsDevice(t *testing.T) {
+	kind, fields := TelemetryMetrics(&pb.Telemetry{
+		Variant: &pb.Telemetry_DeviceMetrics{
+			DeviceMetrics: &pb.DeviceMetrics{
+				BatteryLevel:       proto.Uint32(87),
+				Voltage:            proto.Float32(4.1),
+				ChannelUtilization: proto.Float32(12.5),
+			},
+		},
+	})
+
+	if kind != "device" {
+		t.Errorf("want kind device, got %q", kind)
+	}
+	if fields["battery_level"] != 87 {
+		t.Errorf("want battery_level 87, got %v", fields["battery_level"])
+	}
+	if fields["channel_utilization"] != 12.5 {
+		t.Errorf("want channel_utilization 12.5, got %v", fields["channel_utilization"])
+	}
+	if _, ok := fields["air_util_tx"]; ok {
+		t.Error("unpopulated optional fields should be omitted")
+	}
+}
+
+func TestTelemetryMetricsVariants(t *testing.T) {
+	testCases := []struct {
+		name      string
+		telemetry *pb.Telemetry
+		wantKind  string
+		wantField string
+	}{
+		{
+			name: "environment",
+			telemetry: &pb.Telemetry{Variant: &pb.Telemetry_EnvironmentMetrics{
+				EnvironmentMetrics: &pb.EnvironmentMetrics{Temperature: proto.Float32(21.5)},
+			}},
+			wantKind:  "environment",
+			wantField: "temperature",
+		},
+		{
+			name: "air quality",
+			telemetry: &pb.Telemetry{Variant: &pb.Telemetry_AirQualityMetrics{
+				AirQualityMetrics: &pb.AirQualityMetrics{Pm25Standard: proto.Uint32(9)},
+			}},
+			wantKind:  "air_quality",
+			wantField: "pm25_standard",
+		},
+		{
+			name: "power",
+			telemetry: &pb.Telemetry{Variant: &pb.Telemetry_PowerMetrics{
+				PowerMetrics: &pb.PowerMetrics{Ch1Voltage: proto.Float32(12.6)},
+			}},
+			wantKind:  "power",
+			wantField: "ch1_voltage",
+		},
+		{
+			name: "local stats",
+			telemetry: &pb.Telemetry{Variant: &pb.Telemetry_LocalStats{
+				LocalStats: &pb.LocalStats{NumOnlineNodes: 14},
+			}},
+			wantKind:  "local_stats",
+			wantField: "num_online_nodes",
+		},
+		{
+			name: "health",
+			telemetry: &pb.Telemetry{Variant: &pb.Telemetry_HealthMetrics{
+				HealthMetrics: &pb.HealthMetrics{HeartBpm: proto.Uint32(60)},
+			}},
+			wantKind:  "health",
+			wantField: "heart_bpm",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kind, fields := TelemetryMetrics(tc.telemetry)
+			if kind != tc.wantKind {
+				t.Errorf("want kind %q, got %q", tc.wantKind, kind)
+			}
+			if _, ok := fields[tc.wantField]; !ok {
+				t.Errorf("expected field %q in %v", tc.wantField, fields)
+			}
+		})
+	}
+}
+
+func TestTelemetryMetricsSkipsNonFinite(t *testing.T) {
+	_, fields := TelemetryMetrics(&pb.Telemetry{
+		Variant: &pb.Telemetry_EnvironmentMetrics{
+			EnvironmentMetrics: &pb.EnvironmentMetrics{
+				Temperature:        proto.Float32(float32(math.NaN())),
+				RelativeHumidity:   proto.Float32(float32(math.Inf(1))),
+				BarometricPressure: proto.Float32(1013),
+			},
+		},
+	})
+	if len(fields) != 1 || fields["barometric_pressure"] != 1013 {
+		t.Errorf("expected only barometric_pressure, got %v", fields)
+	}
+}
+
+func TestTelemetryMetricsEmpty(t *testing.T) {
+	if kind, fields := TelemetryMetrics(nil); kind != "" || fields != nil {
+		t.Errorf("expected nothing for nil telemetry, got %q %v", kind, fields)
+	}
+	if kind, _ := TelemetryMetrics(&pb.Telemetry{Time: 1}); kind != "" {
+		t.Errorf("expected no kind for telemetry without a variant, got %q", kind)
+	}
+}
@@ -0,0 +1,104 @@
+// Package delivery sends queued messages to a network endpoint over a
+// connection that is re-established after errors. While the endpoint keeps
+// failing, attempts are spaced out with exponential backoff so that a server
+// that accepts connections but rejects writes isn't hammered with reconnects.
+package delivery
+
+import (
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/health"
+)
+
+// Conn is an open connection to an endpoint.
+type Conn[T any] interface {
+	Send(msg T) error
+	Closed() bool // Whether the endpoint hung up
+	Close()
+}
+
+// Config holds configuration for a delivery loop.
+type Config[T any] struct {
+	Name       string                  // Endpoint name for log messages, e.g. "APRS-IS"
+	Address    string                  // Endpoint address for log messages
+	Dial       func() (Conn[T], error) // Opens a connection
+	MinBackoff time.Duration           // Wait after the first failure (default: 1s)
+	MaxBackoff time.Duration           // Longest wait between attempts (default: 5m)
+}
+
+// Run sends messages from the queue until done is closed, connecting as
+// needed. A message is retried until it is sent. Every failure to connect or
+// send is recorded in the tracker and followed by a wait that doubles with
+// each consecutive failure; a successful send resets it. Run returns promptly
+// once done is closed, including while waiting.
+func Run[T any](config Config[T], queue <-chan T, done <-chan struct{}, tracker *health.Tracker, logger logging.Logger) {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+
+	var conn Conn[T]
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	backoff := config.MinBackoff
+	// fail records a failure and waits before the next attempt. It returns
+	// false if done was closed in the meantime.
+	fail := func(msg string, err error) bool {
+		tracker.Failure()
+		logger.Warnw(msg, "address", config.Address, "error", err, "retryIn", backoff)
+		select {
+		case <-time.After(backoff):
+			backoff = min(backoff*2, config.MaxBackoff)
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for {
+		var msg T
+		select {
+		case msg = <-queue:
+		case <-done:
+			return
+		}
+
+		for {
+			if conn != nil && conn.Closed() {
+				conn.Close()
+				conn = nil
+			}
+			if conn == nil {
+				var err error
+				conn, err = config.Dial()
+				if err != nil {
+					conn = nil
+					if !fail("Failed to connect to "+config.Name, err) {
+						return
+					}
+					continue
+				}
+			}
+
+			if err := conn.Send(msg); err != nil {
+				conn.Close()
+				conn = nil
+				if !fail("Failed to send to "+config.Name, err) {
+					return
+				}
+				continue
+			}
+			tracker.Success()
+			backoff = config.MinBackoff
+			break
+		}
+	}
+}
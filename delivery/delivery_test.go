@@ -0,0 +1,120 @@
+package delivery
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/health"
+)
+
+// fakeConn fails every send when broken and records the rest.
+type fakeConn struct {
+	broken bool
+	sent   *[]string
+	mu     *sync.Mutex
+}
+
+func (c *fakeConn) Send(msg string) error {
+	if c.broken {
+		return errors.New("connection reset by peer")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.sent = append(*c.sent, msg)
+	return nil
+}
+
+func (c *fakeConn) Closed() bool { return false }
+func (c *fakeConn) Close()       {}
+
+func TestRunBacksOffAfterSendFailures(t *testing.T) {
+	var dials atomic.Int32
+	var broken atomic.Bool
+	broken.Store(true)
+	var mu sync.Mutex
+	var sent []string
+
+	config := Config[string]{
+		Name: "test",
+		Dial: func() (Conn[string], error) {
+			dials.Add(1)
+			return &fakeConn{broken: broken.Load(), sent: &sent, mu: &mu}, nil
+		},
+		MinBackoff: 50 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	}
+	queue := make(chan string, 1)
+	done := make(chan struct{})
+	tracker := &health.Tracker{}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		Run(config, queue, done, tracker, logging.NewDevLogger().Named("test"))
+	}()
+
+	// The endpoint accepts connections but every write fails.
+	queue <- "first"
+	time.Sleep(300 * time.Millisecond)
+	if n := dials.Load(); n < 2 || n > 8 {
+		t.Errorf("expected a reconnect every 50ms, got %d connections in 300ms", n)
+	}
+	if tracker.Status().FailingSince.IsZero() {
+		t.Error("expected the tracker to record the failures")
+	}
+
+	broken.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(sent)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the message to be sent once the endpoint recovers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !tracker.Status().FailingSince.IsZero() {
+		t.Error("expected a successful send to end the failure")
+	}
+
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after done was closed")
+	}
+}
+
+func TestRunStopsWhileWaiting(t *testing.T) {
+	config := Config[string]{
+		Name: "test",
+		Dial: func() (Conn[string], error) {
+			return &fakeConn{broken: true}, nil
+		},
+		MinBackoff: time.Hour,
+	}
+	queue := make(chan string, 1)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		Run(config, queue, done, &health.Tracker{}, logging.NewDevLogger().Named("test"))
+	}()
+
+	queue <- "stuck"
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return while backing off")
+	}
+}
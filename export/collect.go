@@ -0,0 +1,180 @@
+package export
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/nodes"
+)
+
+// BBox is a bounding box in decimal degrees. When MinLon is greater than
+// MaxLon the box crosses the antimeridian.
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// Contains reports whether the point lies inside the box.
+func (b BBox) Contains(lat, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+// Filter restricts which positions are exported. Zero values don't filter.
+type Filter struct {
+	From, To time.Time
+	BBox     *BBox
+}
+
+func (f Filter) match(p Position) bool {
+	if !f.From.IsZero() && p.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && p.Time.After(f.To) {
+		return false
+	}
+	return f.BBox == nil || f.BBox.Contains(p.Lat, p.Lon)
+}
+
+// Position is a single reported location of a node.
+type Position struct {
+	Time            time.Time
+	Lat, Lon        float64
+	Altitude        int32   // Meters above sea level; 0 when unknown
+	PrecisionMeters float64 // Radius of reduced-precision positions; 0 for full precision
+}
+
+// Node is a node with its latest position and what is known about it.
+type Node struct {
+	Num       uint32
+	ID        string
+	LongName  string
+	ShortName string
+	Role      string
+	Hardware  string
+	Position  Position
+	// Telemetry holds the latest reading of each telemetry variant, keyed by
+	// kind (device, environment, ...) and then proto field name.
+	Telemetry map[string]map[string]float64
+}
+
+// Dataset is the position history and node details found in a set of packets.
+type Dataset struct {
+	nodes  map[uint32]*Node
+	tracks map[uint32][]Position
+}
+
+// Collect builds a dataset from packets, such as the broker cache. Copies of
+// a packet delivered by several gateways are counted once.
+func Collect(packets []*meshtreampb.Packet) *Dataset {
+	d := &Dataset{
+		nodes:  make(map[uint32]*Node),
+		tracks: make(map[uint32][]Position),
+	}
+
+	for num, summary := range nodes.Summarize(packets) {
+		if user := summary.User; user != nil {
+			n := d.node(num)
+			n.LongName, n.ShortName = user.GetLongName(), user.GetShortName()
+			n.Role, n.Hardware = user.GetRole().String(), user.GetHwModel().String()
+		}
+		for _, telemetry := range summary.Telemetry {
+			kind, fields := decoder.TelemetryMetrics(telemetry)
+			if kind == "" || len(fields) == 0 {
+				continue
+			}
+			n := d.node(num)
+			if n.Telemetry == nil {
+				n.Telemetry = make(map[string]map[string]float64)
+			}
+			n.Telemetry[kind] = fields
+		}
+		for _, fix := range summary.Positions {
+			lat, lon, _ := nodes.Coordinates(fix.Position)
+			d.addPosition(num, Position{
+				Time:            packetTime(fix.RxTime, fix.Position.GetTime()),
+				Lat:             lat,
+				Lon:             lon,
+				Altitude:        fix.Position.GetAltitude(),
+				PrecisionMeters: nodes.PrecisionMeters(fix.Position),
+			})
+		}
+	}
+
+	for num := range d.tracks {
+		sort.SliceStable(d.tracks[num], func(i, j int) bool {
+			return d.tracks[num][i].Time.Before(d.tracks[num][j].Time)
+		})
+	}
+	return d
+}
+
+func (d *Dataset) node(num uint32) *Node {
+	n, ok := d.nodes[num]
+	if !ok {
+		n = &Node{Num: num, ID: nodes.FormatID(num)}
+		d.nodes[num] = n
+	}
+	return n
+}
+
+func (d *Dataset) addPosition(num uint32, p Position) {
+	// Positions are sent as 1e-7 degree integers; round away float noise so
+	// exports show 37.2 rather than 37.199999999999996.
+	p.Lat = math.Round(p.Lat*1e7) / 1e7
+	p.Lon = math.Round(p.Lon*1e7) / 1e7
+	d.node(num)
+	d.tracks[num] = append(d.tracks[num], p)
+}
+
+// Nodes returns nodes with a position matching the filter, each placed at its
+// latest such position, ordered by node number.
+func (d *Dataset) Nodes(filter Filter) []Node {
+	var result []Node
+	for num, track := range d.tracks {
+		for i := len(track) - 1; i >= 0; i-- {
+			if filter.match(track[i]) {
+				n := *d.nodes[num]
+				n.Position = track[i]
+				result = append(result, n)
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Num < result[j].Num })
+	return result
+}
+
+// Track returns a node's details and its positions matching the filter, in
+// time order. ok is false when the node has never reported a position.
+func (d *Dataset) Track(num uint32, filter Filter) (node Node, track []Position, ok bool) {
+	positions, ok := d.tracks[num]
+	if !ok {
+		return Node{}, nil, false
+	}
+	for _, p := range positions {
+		if filter.match(p) {
+			track = append(track, p)
+		}
+	}
+	return *d.nodes[num], track, true
+}
+
+// packetTime returns when a packet was received, falling back to the time
+// the node reported and then to now.
+func packetTime(rxTime uint64, reported uint32) time.Time {
+	if rxTime != 0 {
+		return time.Unix(int64(rxTime), 0)
+	}
+	if reported != 0 {
+		return time.Unix(int64(reported), 0)
+	}
+	return time.Now()
+}
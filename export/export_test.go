@@ -0,0 +1,164 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+var base = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func position(id, from uint32, at time.Time, lat, lon float64) *meshtreampb.Packet {
+	return &meshtreampb.Packet{Data: &meshtreampb.Data{
+		Id:      id,
+		From:    from,
+		RxTime:  uint64(at.Unix()),
+		PortNum: pb.PortNum_POSITION_APP,
+		Payload: &meshtreampb.Data_Position{Position: &pb.Position{
+			LatitudeI:  proto.Int32(int32(lat * 1e7)),
+			LongitudeI: proto.Int32(int32(lon * 1e7)),
+			Altitude:   proto.Int32(40),
+		}},
+	}}
+}
+
+func testPackets() []*meshtreampb.Packet {
+	return []*meshtreampb.Packet{
+		{Data: &meshtreampb.Data{
+			From:    1,
+			PortNum: pb.PortNum_NODEINFO_APP,
+			Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{
+				LongName: "Base & Camp", ShortName: "BC", Role: pb.Config_DeviceConfig_ROUTER, HwModel: pb.HardwareModel_RAK4631,
+			}},
+		}},
+		{Data: &meshtreampb.Data{
+			From:    1,
+			PortNum: pb.PortNum_TELEMETRY_APP,
+			Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+				Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(87)}},
+			}},
+		}},
+		position(10, 1, base, 37.0, -122.0),
+		position(10, 1, base, 37.0, -122.0), // second gateway
+		position(11, 1, base.Add(2*time.Hour), 37.5, -122.5),
+		position(12, 1, base.Add(time.Hour), 37.2, -122.2), // arrives out of order
+		position(20, 2, base.Add(time.Hour), 51.5, -0.1),
+	}
+}
+
+func TestNodesFilter(t *testing.T) {
+	d := Collect(testPackets())
+
+	all := d.Nodes(Filter{})
+	if len(all) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(all))
+	}
+	if all[0].LongName != "Base & Camp" || all[0].Role != "ROUTER" || all[0].Hardware != "RAK4631" {
+		t.Errorf("unexpected node details: %+v", all[0])
+	}
+	if !near(all[0].Position.Lat, 37.5) || !all[0].Position.Time.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("expected latest position, got %+v", all[0].Position)
+	}
+	if all[0].Telemetry["device"]["battery_level"] != 87 {
+		t.Errorf("expected latest telemetry, got %v", all[0].Telemetry)
+	}
+
+	early := d.Nodes(Filter{To: base.Add(90 * time.Minute)})
+	if len(early) != 2 || !near(early[0].Position.Lat, 37.2) {
+		t.Errorf("time filter should pick the latest position before 'to': %+v", early)
+	}
+
+	europe := d.Nodes(Filter{BBox: &BBox{MinLon: -10, MinLat: 35, MaxLon: 30, MaxLat: 60}})
+	if len(europe) != 1 || europe[0].Num != 2 {
+		t.Errorf("bbox filter returned %+v", europe)
+	}
+
+	// A box crossing the antimeridian.
+	pacific := BBox{MinLon: 170, MinLat: -10, MaxLon: -170, MaxLat: 10}
+	if !pacific.Contains(0, 179) || !pacific.Contains(0, -179) || pacific.Contains(0, 0) {
+		t.Error("antimeridian box containment is wrong")
+	}
+}
+
+func TestTrack(t *testing.T) {
+	d := Collect(testPackets())
+
+	_, track, ok := d.Track(1, Filter{From: base.Add(30 * time.Minute)})
+	if !ok || len(track) != 2 {
+		t.Fatalf("expected 2 points, got %+v", track)
+	}
+	if !near(track[0].Lat, 37.2) || !near(track[1].Lat, 37.5) {
+		t.Errorf("track should be in time order: %+v", track)
+	}
+	if _, _, ok := d.Track(3, Filter{}); ok {
+		t.Error("expected no track for an unknown node")
+	}
+}
+
+func TestWriters(t *testing.T) {
+	d := Collect(testPackets())
+	list := d.Nodes(Filter{})
+
+	var buf bytes.Buffer
+	if err := WriteGeoJSON(&buf, list); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Type     string    `json:"type"`
+		Features []Feature `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+		t.Fatalf("unexpected collection: %s", buf.String())
+	}
+	f := fc.Features[0]
+	if f.ID != "!00000001" || !near(f.Geometry.Coordinates[0], -122.5) || !near(f.Geometry.Coordinates[1], 37.5) || f.Geometry.Coordinates[2] != 40 {
+		t.Errorf("unexpected feature: %+v", f)
+	}
+	if f.Properties["long_name"] != "Base & Camp" || f.Properties["device.battery_level"] != 87.0 {
+		t.Errorf("unexpected properties: %v", f.Properties)
+	}
+
+	buf.Reset()
+	if err := WriteKML(&buf, list); err != nil {
+		t.Fatal(err)
+	}
+	var kml kmlDocument
+	if err := xml.Unmarshal(buf.Bytes(), &kml); err != nil {
+		t.Fatalf("invalid KML: %v\n%s", err, buf.String())
+	}
+	if len(kml.Placemarks) != 2 || kml.Placemarks[0].Name != "Base & Camp" || kml.Placemarks[0].Point.Coordinates != "-122.5000000,37.5000000,40" {
+		t.Errorf("unexpected KML: %s", buf.String())
+	}
+
+	node, track, _ := d.Track(1, Filter{})
+	buf.Reset()
+	if err := WriteGPX(&buf, node, track); err != nil {
+		t.Fatal(err)
+	}
+	var gpx gpxDocument
+	if err := xml.Unmarshal(buf.Bytes(), &gpx); err != nil {
+		t.Fatalf("invalid GPX: %v\n%s", err, buf.String())
+	}
+	if len(gpx.Track.Segment) != 3 || gpx.Track.Segment[0].Time != "2026-05-01T12:00:00Z" || *gpx.Track.Segment[0].Ele != 40 {
+		t.Errorf("unexpected GPX: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `xmlns="http://www.topografix.com/GPX/1/1"`) {
+		t.Errorf("missing GPX namespace: %s", buf.String())
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
@@ -0,0 +1,219 @@
+package export
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Feature is a GeoJSON point feature for a node.
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a GeoJSON point; coordinates are longitude, latitude and
+// optionally altitude.
+type Geometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// properties returns a node's details as flat key-value pairs shared by the
+// GeoJSON and KML output.
+func properties(n Node) map[string]interface{} {
+	props := map[string]interface{}{
+		"id":   n.ID,
+		"num":  n.Num,
+		"time": n.Position.Time.UTC().Format(time.RFC3339),
+	}
+	for key, v := range map[string]string{
+		"long_name":  n.LongName,
+		"short_name": n.ShortName,
+		"role":       n.Role,
+		"hardware":   n.Hardware,
+	} {
+		if v != "" {
+			props[key] = v
+		}
+	}
+	if n.Position.Altitude != 0 {
+		props["altitude"] = n.Position.Altitude
+	}
+	if n.Position.PrecisionMeters != 0 {
+		props["precision_meters"] = n.Position.PrecisionMeters
+	}
+	for kind, fields := range n.Telemetry {
+		for field, v := range fields {
+			props[kind+"."+field] = v
+		}
+	}
+	return props
+}
+
+// WriteGeoJSON writes nodes as a GeoJSON FeatureCollection of points.
+func WriteGeoJSON(w io.Writer, nodes []Node) error {
+	features := make([]Feature, 0, len(nodes))
+	for _, n := range nodes {
+		coords := []float64{n.Position.Lon, n.Position.Lat}
+		if n.Position.Altitude != 0 {
+			coords = append(coords, float64(n.Position.Altitude))
+		}
+		features = append(features, Feature{
+			Type:       "Feature",
+			ID:         n.ID,
+			Geometry:   Geometry{Type: "Point", Coordinates: coords},
+			Properties: properties(n),
+		})
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Namespace  string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	ID          string    `xml:"id,attr"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"description,omitempty"`
+	When        string    `xml:"TimeStamp>when"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Point       kmlPoint  `xml:"Point"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPoint struct {
+	AltitudeMode string `xml:"altitudeMode,omitempty"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+// WriteKML writes nodes as KML placemarks with their details as extended data.
+func WriteKML(w io.Writer, nodes []Node) error {
+	doc := kmlDocument{
+		Namespace:  "http://www.opengis.net/kml/2.2",
+		Name:       "Meshtastic nodes",
+		Placemarks: make([]kmlPlacemark, 0, len(nodes)),
+	}
+	for _, n := range nodes {
+		pm := kmlPlacemark{
+			ID:   n.ID,
+			Name: displayName(n),
+			When: n.Position.Time.UTC().Format(time.RFC3339),
+		}
+		if n.LongName != "" {
+			pm.Description = n.ID
+		}
+
+		props := properties(n)
+		keys := make([]string, 0, len(props))
+		for k := range props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			pm.Data = append(pm.Data, kmlData{Name: k, Value: fmt.Sprint(props[k])})
+		}
+
+		coords := formatFloat(n.Position.Lon) + "," + formatFloat(n.Position.Lat)
+		if n.Position.Altitude != 0 {
+			coords += "," + strconv.Itoa(int(n.Position.Altitude))
+			pm.Point.AltitudeMode = "absolute"
+		}
+		pm.Point.Coordinates = coords
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+	return writeXML(w, doc)
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name `xml:"gpx"`
+	Namespace string   `xml:"xmlns,attr"`
+	Version   string   `xml:"version,attr"`
+	Creator   string   `xml:"creator,attr"`
+	Name      string   `xml:"metadata>name"`
+	Track     gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Desc    string     `xml:"desc,omitempty"`
+	Segment []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Ele  *int32 `xml:"ele,omitempty"`
+	Time string `xml:"time"`
+}
+
+// WriteGPX writes a node's positions as a single GPX track.
+func WriteGPX(w io.Writer, node Node, track []Position) error {
+	doc := gpxDocument{
+		Namespace: "http://www.topografix.com/GPX/1/1",
+		Version:   "1.1",
+		Creator:   "meshstream",
+		Name:      displayName(node) + " track",
+		Track: gpxTrack{
+			Name:    displayName(node),
+			Segment: make([]gpxPoint, 0, len(track)),
+		},
+	}
+	if node.LongName != "" {
+		doc.Track.Desc = node.ID
+	}
+	for _, p := range track {
+		pt := gpxPoint{
+			Lat:  formatFloat(p.Lat),
+			Lon:  formatFloat(p.Lon),
+			Time: p.Time.UTC().Format(time.RFC3339),
+		}
+		if p.Altitude != 0 {
+			alt := p.Altitude
+			pt.Ele = &alt
+		}
+		doc.Track.Segment = append(doc.Track.Segment, pt)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func displayName(n Node) string {
+	if n.LongName != "" {
+		return n.LongName
+	}
+	return n.ID
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 7, 64)
+}
@@ -24,9 +24,12 @@ const (
 
 // Packet represents a complete decoded MQTT message
 type Packet struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Info          *TopicInfo             `protobuf:"bytes,2,opt,name=info,proto3" json:"info,omitempty"`
-	Data          *Data                  `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Info  *TopicInfo             `protobuf:"bytes,2,opt,name=info,proto3" json:"info,omitempty"`
+	Data  *Data                  `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// Sequence number assigned by the broker, increasing in arrival order.
+	// Used as the SSE event ID for resuming streams.
+	Seq           uint64 `protobuf:"varint,3,opt,name=seq,proto3" json:"seq,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -75,15 +78,25 @@ func (x *Packet) GetData() *Data {
 	return nil
 }
 
+func (x *Packet) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
 // TopicInfo contains parsed information about a Meshtastic MQTT topic
 type TopicInfo struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	FullTopic     string                 `protobuf:"bytes,1,opt,name=full_topic,json=fullTopic,proto3" json:"full_topic,omitempty"`
-	RegionPath    string                 `protobuf:"bytes,2,opt,name=region_path,json=regionPath,proto3" json:"region_path,omitempty"`
-	Version       string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
-	Format        string                 `protobuf:"bytes,4,opt,name=format,proto3" json:"format,omitempty"`
-	Channel       string                 `protobuf:"bytes,5,opt,name=channel,proto3" json:"channel,omitempty"`
-	UserId        string                 `protobuf:"bytes,6,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	FullTopic  string                 `protobuf:"bytes,1,opt,name=full_topic,json=fullTopic,proto3" json:"full_topic,omitempty"`
+	RegionPath string                 `protobuf:"bytes,2,opt,name=region_path,json=regionPath,proto3" json:"region_path,omitempty"`
+	Version    string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	Format     string                 `protobuf:"bytes,4,opt,name=format,proto3" json:"format,omitempty"`
+	Channel    string                 `protobuf:"bytes,5,opt,name=channel,proto3" json:"channel,omitempty"`
+	UserId     string                 `protobuf:"bytes,6,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// "origin" user property of MQTT v5 messages: the instance or gateway
+	// that published the message, if it says so
+	Origin        string `protobuf:"bytes,7,opt,name=origin,proto3" json:"origin,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -160,6 +173,13 @@ func (x *TopicInfo) GetUserId() string {
 	return ""
 }
 
+func (x *TopicInfo) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
 // Data provides a flattened structure for decoded Meshtastic packets
 type Data struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -857,10 +877,11 @@ var File_meshstream_meshstream_proto protoreflect.FileDescriptor
 const file_meshstream_meshstream_proto_rawDesc = "" +
 	"\n" +
 	"\x1bmeshstream/meshstream.proto\x12\n" +
-	"meshstream\x1a\x15meshtastic/mesh.proto\x1a\x19meshtastic/portnums.proto\x1a\x1ameshtastic/telemetry.proto\x1a\x15meshtastic/mqtt.proto\x1a meshtastic/remote_hardware.proto\x1a\x16meshtastic/admin.proto\x1a\x19meshtastic/paxcount.proto\"Y\n" +
+	"meshstream\x1a\x15meshtastic/mesh.proto\x1a\x19meshtastic/portnums.proto\x1a\x1ameshtastic/telemetry.proto\x1a\x15meshtastic/mqtt.proto\x1a meshtastic/remote_hardware.proto\x1a\x16meshtastic/admin.proto\x1a\x19meshtastic/paxcount.proto\"k\n" +
 	"\x06Packet\x12)\n" +
 	"\x04info\x18\x02 \x01(\v2\x15.meshstream.TopicInfoR\x04info\x12$\n" +
-	"\x04data\x18\x01 \x01(\v2\x10.meshstream.DataR\x04data\"\xb0\x01\n" +
+	"\x04data\x18\x01 \x01(\v2\x10.meshstream.DataR\x04data\x12\x10\n" +
+	"\x03seq\x18\x03 \x01(\x04R\x03seq\"\xc8\x01\n" +
 	"\tTopicInfo\x12\x1d\n" +
 	"\n" +
 	"full_topic\x18\x01 \x01(\tR\tfullTopic\x12\x1f\n" +
@@ -869,7 +890,8 @@ const file_meshstream_meshstream_proto_rawDesc = "" +
 	"\aversion\x18\x03 \x01(\tR\aversion\x12\x16\n" +
 	"\x06format\x18\x04 \x01(\tR\x06format\x12\x18\n" +
 	"\achannel\x18\x05 \x01(\tR\achannel\x12\x17\n" +
-	"\auser_id\x18\x06 \x01(\tR\x06userId\"\xc4\x0e\n" +
+	"\auser_id\x18\x06 \x01(\tR\x06userId\x12\x16\n" +
+	"\x06origin\x18\a \x01(\tR\x06origin\"\xc4\x0e\n" +
 	"\x04Data\x12\x1d\n" +
 	"\n" +
 	"channel_id\x18\x01 \x01(\tR\tchannelId\x12\x1d\n" +
@@ -0,0 +1,607 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: meshstream/service.proto
+
+package meshtreampb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	meshtastic "meshstream/generated/meshtastic"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Filter selects packets. A packet must match one value of every field that
+// is set; an empty filter matches everything.
+type Filter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Channels      []string               `protobuf:"bytes,1,rep,name=channels,proto3" json:"channels,omitempty"` // Channel names, e.g. LongFast
+	Ports         []meshtastic.PortNum   `protobuf:"varint,2,rep,packed,name=ports,proto3,enum=meshtastic.PortNum" json:"ports,omitempty"`
+	Nodes         []uint32               `protobuf:"varint,3,rep,packed,name=nodes,proto3" json:"nodes,omitempty"` // Packets from or to these nodes
+	Gateways      []string               `protobuf:"bytes,4,rep,name=gateways,proto3" json:"gateways,omitempty"`   // Gateway IDs, e.g. !abcd1234
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Filter) Reset() {
+	*x = Filter{}
+	mi := &file_meshstream_service_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Filter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Filter) ProtoMessage() {}
+
+func (x *Filter) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Filter.ProtoReflect.Descriptor instead.
+func (*Filter) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Filter) GetChannels() []string {
+	if x != nil {
+		return x.Channels
+	}
+	return nil
+}
+
+func (x *Filter) GetPorts() []meshtastic.PortNum {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+func (x *Filter) GetNodes() []uint32 {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *Filter) GetGateways() []string {
+	if x != nil {
+		return x.Gateways
+	}
+	return nil
+}
+
+type QueryPacketsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filter        *Filter                `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	Since         uint64                 `protobuf:"varint,2,opt,name=since,proto3" json:"since,omitempty"` // Unix seconds; 0 for no lower bound
+	Until         uint64                 `protobuf:"varint,3,opt,name=until,proto3" json:"until,omitempty"` // Unix seconds; 0 for no upper bound
+	Limit         uint32                 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"` // Maximum packets, keeping the most recent; 0 for all
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryPacketsRequest) Reset() {
+	*x = QueryPacketsRequest{}
+	mi := &file_meshstream_service_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryPacketsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryPacketsRequest) ProtoMessage() {}
+
+func (x *QueryPacketsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryPacketsRequest.ProtoReflect.Descriptor instead.
+func (*QueryPacketsRequest) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *QueryPacketsRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *QueryPacketsRequest) GetSince() uint64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *QueryPacketsRequest) GetUntil() uint64 {
+	if x != nil {
+		return x.Until
+	}
+	return 0
+}
+
+func (x *QueryPacketsRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type QueryPacketsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Packets       []*Packet              `protobuf:"bytes,1,rep,name=packets,proto3" json:"packets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryPacketsResponse) Reset() {
+	*x = QueryPacketsResponse{}
+	mi := &file_meshstream_service_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryPacketsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryPacketsResponse) ProtoMessage() {}
+
+func (x *QueryPacketsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryPacketsResponse.ProtoReflect.Descriptor instead.
+func (*QueryPacketsResponse) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *QueryPacketsResponse) GetPackets() []*Packet {
+	if x != nil {
+		return x.Packets
+	}
+	return nil
+}
+
+type ListNodesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListNodesRequest) Reset() {
+	*x = ListNodesRequest{}
+	mi := &file_meshstream_service_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListNodesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNodesRequest) ProtoMessage() {}
+
+func (x *ListNodesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNodesRequest.ProtoReflect.Descriptor instead.
+func (*ListNodesRequest) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{3}
+}
+
+type ListNodesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nodes         []*Node                `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListNodesResponse) Reset() {
+	*x = ListNodesResponse{}
+	mi := &file_meshstream_service_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListNodesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListNodesResponse) ProtoMessage() {}
+
+func (x *ListNodesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListNodesResponse.ProtoReflect.Descriptor instead.
+func (*ListNodesResponse) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListNodesResponse) GetNodes() []*Node {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+type GetNodeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Num           uint32                 `protobuf:"varint,1,opt,name=num,proto3" json:"num,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetNodeRequest) Reset() {
+	*x = GetNodeRequest{}
+	mi := &file_meshstream_service_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetNodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetNodeRequest) ProtoMessage() {}
+
+func (x *GetNodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetNodeRequest.ProtoReflect.Descriptor instead.
+func (*GetNodeRequest) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetNodeRequest) GetNum() uint32 {
+	if x != nil {
+		return x.Num
+	}
+	return 0
+}
+
+// Node is what the cached packets say about a node. Each field holds the
+// latest value seen.
+type Node struct {
+	state              protoimpl.MessageState         `protogen:"open.v1"`
+	Num                uint32                         `protobuf:"varint,1,opt,name=num,proto3" json:"num,omitempty"`
+	Id                 string                         `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`             // !xxxxxxxx
+	User               *meshtastic.User               `protobuf:"bytes,3,opt,name=user,proto3" json:"user,omitempty"`         // From NODEINFO or a map report
+	Position           *meshtastic.Position           `protobuf:"bytes,4,opt,name=position,proto3" json:"position,omitempty"` // From a position or map report
+	DeviceMetrics      *meshtastic.DeviceMetrics      `protobuf:"bytes,5,opt,name=device_metrics,json=deviceMetrics,proto3" json:"device_metrics,omitempty"`
+	EnvironmentMetrics *meshtastic.EnvironmentMetrics `protobuf:"bytes,6,opt,name=environment_metrics,json=environmentMetrics,proto3" json:"environment_metrics,omitempty"`
+	LastHeard          uint64                         `protobuf:"varint,7,opt,name=last_heard,json=lastHeard,proto3" json:"last_heard,omitempty"`       // Unix seconds
+	PacketCount        uint32                         `protobuf:"varint,8,opt,name=packet_count,json=packetCount,proto3" json:"packet_count,omitempty"` // Distinct packets sent by the node
+	Channels           []string                       `protobuf:"bytes,9,rep,name=channels,proto3" json:"channels,omitempty"`                           // Channels the node was heard on
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *Node) Reset() {
+	*x = Node{}
+	mi := &file_meshstream_service_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Node) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Node) ProtoMessage() {}
+
+func (x *Node) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Node.ProtoReflect.Descriptor instead.
+func (*Node) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Node) GetNum() uint32 {
+	if x != nil {
+		return x.Num
+	}
+	return 0
+}
+
+func (x *Node) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Node) GetUser() *meshtastic.User {
+	if x != nil {
+		return x.User
+	}
+	return nil
+}
+
+func (x *Node) GetPosition() *meshtastic.Position {
+	if x != nil {
+		return x.Position
+	}
+	return nil
+}
+
+func (x *Node) GetDeviceMetrics() *meshtastic.DeviceMetrics {
+	if x != nil {
+		return x.DeviceMetrics
+	}
+	return nil
+}
+
+func (x *Node) GetEnvironmentMetrics() *meshtastic.EnvironmentMetrics {
+	if x != nil {
+		return x.EnvironmentMetrics
+	}
+	return nil
+}
+
+func (x *Node) GetLastHeard() uint64 {
+	if x != nil {
+		return x.LastHeard
+	}
+	return 0
+}
+
+func (x *Node) GetPacketCount() uint32 {
+	if x != nil {
+		return x.PacketCount
+	}
+	return 0
+}
+
+func (x *Node) GetChannels() []string {
+	if x != nil {
+		return x.Channels
+	}
+	return nil
+}
+
+type ReplicateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AfterSeq      uint64                 `protobuf:"varint,1,opt,name=after_seq,json=afterSeq,proto3" json:"after_seq,omitempty"` // Last sequence number the follower received; 0 for a snapshot
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicateRequest) Reset() {
+	*x = ReplicateRequest{}
+	mi := &file_meshstream_service_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicateRequest) ProtoMessage() {}
+
+func (x *ReplicateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_meshstream_service_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicateRequest.ProtoReflect.Descriptor instead.
+func (*ReplicateRequest) Descriptor() ([]byte, []int) {
+	return file_meshstream_service_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ReplicateRequest) GetAfterSeq() uint64 {
+	if x != nil {
+		return x.AfterSeq
+	}
+	return 0
+}
+
+var File_meshstream_service_proto protoreflect.FileDescriptor
+
+const file_meshstream_service_proto_rawDesc = "" +
+	"\n" +
+	"\x18meshstream/service.proto\x12\n" +
+	"meshstream\x1a\x1bmeshstream/meshstream.proto\x1a\x15meshtastic/mesh.proto\x1a\x19meshtastic/portnums.proto\x1a\x1ameshtastic/telemetry.proto\"\x81\x01\n" +
+	"\x06Filter\x12\x1a\n" +
+	"\bchannels\x18\x01 \x03(\tR\bchannels\x12)\n" +
+	"\x05ports\x18\x02 \x03(\x0e2\x13.meshtastic.PortNumR\x05ports\x12\x14\n" +
+	"\x05nodes\x18\x03 \x03(\rR\x05nodes\x12\x1a\n" +
+	"\bgateways\x18\x04 \x03(\tR\bgateways\"\x83\x01\n" +
+	"\x13QueryPacketsRequest\x12*\n" +
+	"\x06filter\x18\x01 \x01(\v2\x12.meshstream.FilterR\x06filter\x12\x14\n" +
+	"\x05since\x18\x02 \x01(\x04R\x05since\x12\x14\n" +
+	"\x05until\x18\x03 \x01(\x04R\x05until\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\rR\x05limit\"D\n" +
+	"\x14QueryPacketsResponse\x12,\n" +
+	"\apackets\x18\x01 \x03(\v2\x12.meshstream.PacketR\apackets\"\x12\n" +
+	"\x10ListNodesRequest\";\n" +
+	"\x11ListNodesResponse\x12&\n" +
+	"\x05nodes\x18\x01 \x03(\v2\x10.meshstream.NodeR\x05nodes\"\"\n" +
+	"\x0eGetNodeRequest\x12\x10\n" +
+	"\x03num\x18\x01 \x01(\rR\x03num\"\xf1\x02\n" +
+	"\x04Node\x12\x10\n" +
+	"\x03num\x18\x01 \x01(\rR\x03num\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12$\n" +
+	"\x04user\x18\x03 \x01(\v2\x10.meshtastic.UserR\x04user\x120\n" +
+	"\bposition\x18\x04 \x01(\v2\x14.meshtastic.PositionR\bposition\x12@\n" +
+	"\x0edevice_metrics\x18\x05 \x01(\v2\x19.meshtastic.DeviceMetricsR\rdeviceMetrics\x12O\n" +
+	"\x13environment_metrics\x18\x06 \x01(\v2\x1e.meshtastic.EnvironmentMetricsR\x12environmentMetrics\x12\x1d\n" +
+	"\n" +
+	"last_heard\x18\a \x01(\x04R\tlastHeard\x12!\n" +
+	"\fpacket_count\x18\b \x01(\rR\vpacketCount\x12\x1a\n" +
+	"\bchannels\x18\t \x03(\tR\bchannels\"/\n" +
+	"\x10ReplicateRequest\x12\x1b\n" +
+	"\tafter_seq\x18\x01 \x01(\x04R\bafterSeq2\xde\x02\n" +
+	"\n" +
+	"Meshstream\x129\n" +
+	"\rStreamPackets\x12\x12.meshstream.Filter\x1a\x12.meshstream.Packet0\x01\x12Q\n" +
+	"\fQueryPackets\x12\x1f.meshstream.QueryPacketsRequest\x1a .meshstream.QueryPacketsResponse\x12H\n" +
+	"\tListNodes\x12\x1c.meshstream.ListNodesRequest\x1a\x1d.meshstream.ListNodesResponse\x127\n" +
+	"\aGetNode\x12\x1a.meshstream.GetNodeRequest\x1a\x10.meshstream.Node\x12?\n" +
+	"\tReplicate\x12\x1c.meshstream.ReplicateRequest\x1a\x12.meshstream.Packet0\x01B(Z&proto/generated/meshstream;meshtreampbb\x06proto3"
+
+var (
+	file_meshstream_service_proto_rawDescOnce sync.Once
+	file_meshstream_service_proto_rawDescData []byte
+)
+
+func file_meshstream_service_proto_rawDescGZIP() []byte {
+	file_meshstream_service_proto_rawDescOnce.Do(func() {
+		file_meshstream_service_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_meshstream_service_proto_rawDesc), len(file_meshstream_service_proto_rawDesc)))
+	})
+	return file_meshstream_service_proto_rawDescData
+}
+
+var file_meshstream_service_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_meshstream_service_proto_goTypes = []any{
+	(*Filter)(nil),                        // 0: meshstream.Filter
+	(*QueryPacketsRequest)(nil),           // 1: meshstream.QueryPacketsRequest
+	(*QueryPacketsResponse)(nil),          // 2: meshstream.QueryPacketsResponse
+	(*ListNodesRequest)(nil),              // 3: meshstream.ListNodesRequest
+	(*ListNodesResponse)(nil),             // 4: meshstream.ListNodesResponse
+	(*GetNodeRequest)(nil),                // 5: meshstream.GetNodeRequest
+	(*Node)(nil),                          // 6: meshstream.Node
+	(*ReplicateRequest)(nil),              // 7: meshstream.ReplicateRequest
+	(meshtastic.PortNum)(0),               // 8: meshtastic.PortNum
+	(*Packet)(nil),                        // 9: meshstream.Packet
+	(*meshtastic.User)(nil),               // 10: meshtastic.User
+	(*meshtastic.Position)(nil),           // 11: meshtastic.Position
+	(*meshtastic.DeviceMetrics)(nil),      // 12: meshtastic.DeviceMetrics
+	(*meshtastic.EnvironmentMetrics)(nil), // 13: meshtastic.EnvironmentMetrics
+}
+var file_meshstream_service_proto_depIdxs = []int32{
+	8,  // 0: meshstream.Filter.ports:type_name -> meshtastic.PortNum
+	0,  // 1: meshstream.QueryPacketsRequest.filter:type_name -> meshstream.Filter
+	9,  // 2: meshstream.QueryPacketsResponse.packets:type_name -> meshstream.Packet
+	6,  // 3: meshstream.ListNodesResponse.nodes:type_name -> meshstream.Node
+	10, // 4: meshstream.Node.user:type_name -> meshtastic.User
+	11, // 5: meshstream.Node.position:type_name -> meshtastic.Position
+	12, // 6: meshstream.Node.device_metrics:type_name -> meshtastic.DeviceMetrics
+	13, // 7: meshstream.Node.environment_metrics:type_name -> meshtastic.EnvironmentMetrics
+	0,  // 8: meshstream.Meshstream.StreamPackets:input_type -> meshstream.Filter
+	1,  // 9: meshstream.Meshstream.QueryPackets:input_type -> meshstream.QueryPacketsRequest
+	3,  // 10: meshstream.Meshstream.ListNodes:input_type -> meshstream.ListNodesRequest
+	5,  // 11: meshstream.Meshstream.GetNode:input_type -> meshstream.GetNodeRequest
+	7,  // 12: meshstream.Meshstream.Replicate:input_type -> meshstream.ReplicateRequest
+	9,  // 13: meshstream.Meshstream.StreamPackets:output_type -> meshstream.Packet
+	2,  // 14: meshstream.Meshstream.QueryPackets:output_type -> meshstream.QueryPacketsResponse
+	4,  // 15: meshstream.Meshstream.ListNodes:output_type -> meshstream.ListNodesResponse
+	6,  // 16: meshstream.Meshstream.GetNode:output_type -> meshstream.Node
+	9,  // 17: meshstream.Meshstream.Replicate:output_type -> meshstream.Packet
+	13, // [13:18] is the sub-list for method output_type
+	8,  // [8:13] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_meshstream_service_proto_init() }
+func file_meshstream_service_proto_init() {
+	if File_meshstream_service_proto != nil {
+		return
+	}
+	file_meshstream_meshstream_proto_init()
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_meshstream_service_proto_rawDesc), len(file_meshstream_service_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_meshstream_service_proto_goTypes,
+		DependencyIndexes: file_meshstream_service_proto_depIdxs,
+		MessageInfos:      file_meshstream_service_proto_msgTypes,
+	}.Build()
+	File_meshstream_service_proto = out.File
+	file_meshstream_service_proto_goTypes = nil
+	file_meshstream_service_proto_depIdxs = nil
+}
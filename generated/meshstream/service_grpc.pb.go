@@ -0,0 +1,304 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: meshstream/service.proto
+
+package meshtreampb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Meshstream_StreamPackets_FullMethodName = "/meshstream.Meshstream/StreamPackets"
+	Meshstream_QueryPackets_FullMethodName  = "/meshstream.Meshstream/QueryPackets"
+	Meshstream_ListNodes_FullMethodName     = "/meshstream.Meshstream/ListNodes"
+	Meshstream_GetNode_FullMethodName       = "/meshstream.Meshstream/GetNode"
+	Meshstream_Replicate_FullMethodName     = "/meshstream.Meshstream/Replicate"
+)
+
+// MeshstreamClient is the client API for Meshstream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Meshstream serves decoded packets and the nodes seen in them. It is served
+// on the same port as the HTTP API.
+type MeshstreamClient interface {
+	// StreamPackets sends the cached packets matching the filter and then
+	// follows live traffic until the client cancels.
+	StreamPackets(ctx context.Context, in *Filter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Packet], error)
+	// QueryPackets returns cached packets matching a filter and time range.
+	QueryPackets(ctx context.Context, in *QueryPacketsRequest, opts ...grpc.CallOption) (*QueryPacketsResponse, error)
+	// ListNodes returns every node heard in the cached packets.
+	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error)
+	// GetNode returns a single node, or NOT_FOUND if it isn't in the cache.
+	GetNode(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*Node, error)
+	// Replicate feeds a follower replica in cluster mode. It sends the packets
+	// after after_seq if they are still cached, or else the materialized
+	// snapshot, and then follows live traffic. Packets keep the sequence
+	// numbers of this server. Requires access to every packet.
+	Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Packet], error)
+}
+
+type meshstreamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMeshstreamClient(cc grpc.ClientConnInterface) MeshstreamClient {
+	return &meshstreamClient{cc}
+}
+
+func (c *meshstreamClient) StreamPackets(ctx context.Context, in *Filter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Packet], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Meshstream_ServiceDesc.Streams[0], Meshstream_StreamPackets_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Filter, Packet]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Meshstream_StreamPacketsClient = grpc.ServerStreamingClient[Packet]
+
+func (c *meshstreamClient) QueryPackets(ctx context.Context, in *QueryPacketsRequest, opts ...grpc.CallOption) (*QueryPacketsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryPacketsResponse)
+	err := c.cc.Invoke(ctx, Meshstream_QueryPackets_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshstreamClient) ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListNodesResponse)
+	err := c.cc.Invoke(ctx, Meshstream_ListNodes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshstreamClient) GetNode(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*Node, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Node)
+	err := c.cc.Invoke(ctx, Meshstream_GetNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshstreamClient) Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Packet], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Meshstream_ServiceDesc.Streams[1], Meshstream_Replicate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReplicateRequest, Packet]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Meshstream_ReplicateClient = grpc.ServerStreamingClient[Packet]
+
+// MeshstreamServer is the server API for Meshstream service.
+// All implementations must embed UnimplementedMeshstreamServer
+// for forward compatibility.
+//
+// Meshstream serves decoded packets and the nodes seen in them. It is served
+// on the same port as the HTTP API.
+type MeshstreamServer interface {
+	// StreamPackets sends the cached packets matching the filter and then
+	// follows live traffic until the client cancels.
+	StreamPackets(*Filter, grpc.ServerStreamingServer[Packet]) error
+	// QueryPackets returns cached packets matching a filter and time range.
+	QueryPackets(context.Context, *QueryPacketsRequest) (*QueryPacketsResponse, error)
+	// ListNodes returns every node heard in the cached packets.
+	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
+	// GetNode returns a single node, or NOT_FOUND if it isn't in the cache.
+	GetNode(context.Context, *GetNodeRequest) (*Node, error)
+	// Replicate feeds a follower replica in cluster mode. It sends the packets
+	// after after_seq if they are still cached, or else the materialized
+	// snapshot, and then follows live traffic. Packets keep the sequence
+	// numbers of this server. Requires access to every packet.
+	Replicate(*ReplicateRequest, grpc.ServerStreamingServer[Packet]) error
+	mustEmbedUnimplementedMeshstreamServer()
+}
+
+// UnimplementedMeshstreamServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMeshstreamServer struct{}
+
+func (UnimplementedMeshstreamServer) StreamPackets(*Filter, grpc.ServerStreamingServer[Packet]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamPackets not implemented")
+}
+func (UnimplementedMeshstreamServer) QueryPackets(context.Context, *QueryPacketsRequest) (*QueryPacketsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryPackets not implemented")
+}
+func (UnimplementedMeshstreamServer) ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
+}
+func (UnimplementedMeshstreamServer) GetNode(context.Context, *GetNodeRequest) (*Node, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNode not implemented")
+}
+func (UnimplementedMeshstreamServer) Replicate(*ReplicateRequest, grpc.ServerStreamingServer[Packet]) error {
+	return status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedMeshstreamServer) mustEmbedUnimplementedMeshstreamServer() {}
+func (UnimplementedMeshstreamServer) testEmbeddedByValue()                    {}
+
+// UnsafeMeshstreamServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MeshstreamServer will
+// result in compilation errors.
+type UnsafeMeshstreamServer interface {
+	mustEmbedUnimplementedMeshstreamServer()
+}
+
+func RegisterMeshstreamServer(s grpc.ServiceRegistrar, srv MeshstreamServer) {
+	// If the following call pancis, it indicates UnimplementedMeshstreamServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Meshstream_ServiceDesc, srv)
+}
+
+func _Meshstream_StreamPackets_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Filter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MeshstreamServer).StreamPackets(m, &grpc.GenericServerStream[Filter, Packet]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Meshstream_StreamPacketsServer = grpc.ServerStreamingServer[Packet]
+
+func _Meshstream_QueryPackets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryPacketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshstreamServer).QueryPackets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Meshstream_QueryPackets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshstreamServer).QueryPackets(ctx, req.(*QueryPacketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Meshstream_ListNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNodesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshstreamServer).ListNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Meshstream_ListNodes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshstreamServer).ListNodes(ctx, req.(*ListNodesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Meshstream_GetNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshstreamServer).GetNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Meshstream_GetNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshstreamServer).GetNode(ctx, req.(*GetNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Meshstream_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReplicateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MeshstreamServer).Replicate(m, &grpc.GenericServerStream[ReplicateRequest, Packet]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Meshstream_ReplicateServer = grpc.ServerStreamingServer[Packet]
+
+// Meshstream_ServiceDesc is the grpc.ServiceDesc for Meshstream service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Meshstream_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "meshstream.Meshstream",
+	HandlerType: (*MeshstreamServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "QueryPackets",
+			Handler:    _Meshstream_QueryPackets_Handler,
+		},
+		{
+			MethodName: "ListNodes",
+			Handler:    _Meshstream_ListNodes_Handler,
+		},
+		{
+			MethodName: "GetNode",
+			Handler:    _Meshstream_GetNode_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamPackets",
+			Handler:       _Meshstream_StreamPackets_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Replicate",
+			Handler:       _Meshstream_Replicate_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "meshstream/service.proto",
+}
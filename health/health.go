@@ -0,0 +1,144 @@
+// Package health decides whether the packet pipeline is working well enough
+// to serve traffic. Components record the outcome of their work in a Tracker,
+// and Evaluate compares the state of the whole pipeline with thresholds.
+package health
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is how long a component has been failing, if it is.
+type Status struct {
+	FailingSince time.Time // Zero while the component works
+}
+
+// Tracker records whether a component's latest attempt to connect or deliver
+// succeeded. The zero value is a healthy tracker. It is safe for concurrent
+// use.
+type Tracker struct {
+	mu     sync.Mutex
+	status Status
+	now    func() time.Time // injectable for testing
+}
+
+// Success records a successful attempt, ending any failure.
+func (t *Tracker) Success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.FailingSince = time.Time{}
+}
+
+// Failure records a failed attempt. The component is failing since the first
+// failure after the last success.
+func (t *Tracker) Failure() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status.FailingSince.IsZero() {
+		t.status.FailingSince = t.time()
+	}
+}
+
+// Status returns the recorded state.
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+func (t *Tracker) time() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Config holds the readiness thresholds. A zero threshold disables its check.
+type Config struct {
+	MaxPacketAge    time.Duration // Longest time without a decoded packet
+	MaxBacklog      int           // Most packets waiting for the broker
+	MaxDisconnected time.Duration // Longest time the MQTT connection or cluster leader is down
+	MaxFailure      time.Duration // Longest time the persistent store or a sink keeps failing
+}
+
+// State is the state of the pipeline at one point in time.
+type State struct {
+	Started    time.Time         // When the pipeline started, for the packet age before the first packet
+	Connection Status            // MQTT connection, or cluster leader for a follower
+	LastPacket time.Time         // When the latest packet was decoded; zero before the first
+	Backlog    int               // Packets waiting for the broker
+	Store      *Status           // Persistent store; nil when there is none
+	Sinks      map[string]Status // Running sinks by name
+}
+
+// Check is the outcome of one readiness check.
+type Check struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// Report is the outcome of every readiness check.
+type Report struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+// Evaluate checks the state against the thresholds as of now.
+func Evaluate(config Config, state State, now time.Time) Report {
+	report := Report{Ready: true}
+	add := func(name string, ok bool, detail string) {
+		report.Checks = append(report.Checks, Check{Name: name, OK: ok, Detail: detail})
+		report.Ready = report.Ready && ok
+	}
+
+	add("connection", withinThreshold(state.Connection, config.MaxDisconnected, now), describe(state.Connection, "connected", "disconnected", now))
+
+	if state.LastPacket.IsZero() {
+		age := now.Sub(state.Started)
+		add("packets", config.MaxPacketAge == 0 || age <= config.MaxPacketAge,
+			fmt.Sprintf("no packet decoded in %s since start", round(age)))
+	} else {
+		age := now.Sub(state.LastPacket)
+		add("packets", config.MaxPacketAge == 0 || age <= config.MaxPacketAge,
+			fmt.Sprintf("last packet decoded %s ago", round(age)))
+	}
+
+	add("backlog", config.MaxBacklog == 0 || state.Backlog <= config.MaxBacklog,
+		fmt.Sprintf("%d packets waiting", state.Backlog))
+
+	if state.Store != nil {
+		add("store", withinThreshold(*state.Store, config.MaxFailure, now), describe(*state.Store, "saving", "failing", now))
+	}
+
+	names := make([]string, 0, len(state.Sinks))
+	for name := range state.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status := state.Sinks[name]
+		add("sink:"+name, withinThreshold(status, config.MaxFailure, now), describe(status, "delivering", "failing", now))
+	}
+	return report
+}
+
+// withinThreshold reports whether a component has not been failing for
+// longer than the threshold.
+func withinThreshold(status Status, threshold time.Duration, now time.Time) bool {
+	return threshold == 0 || status.FailingSince.IsZero() || now.Sub(status.FailingSince) <= threshold
+}
+
+// describe summarizes a status for the report.
+func describe(status Status, working, failing string, now time.Time) string {
+	if status.FailingSince.IsZero() {
+		return working
+	}
+	return fmt.Sprintf("%s for %s", failing, round(now.Sub(status.FailingSince)))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Second)
+}
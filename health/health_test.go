@@ -0,0 +1,83 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := &Tracker{now: func() time.Time { return now }}
+	if !tracker.Status().FailingSince.IsZero() {
+		t.Fatal("expected a new tracker to be healthy")
+	}
+
+	tracker.Failure()
+	now = now.Add(time.Minute)
+	tracker.Failure()
+	if got := tracker.Status().FailingSince; !got.Equal(now.Add(-time.Minute)) {
+		t.Errorf("expected to be failing since the first failure, got %v", got)
+	}
+
+	tracker.Success()
+	if !tracker.Status().FailingSince.IsZero() {
+		t.Error("expected a success to end the failure")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	config := Config{
+		MaxPacketAge:    time.Hour,
+		MaxBacklog:      90,
+		MaxDisconnected: 5 * time.Minute,
+		MaxFailure:      15 * time.Minute,
+	}
+	healthy := State{
+		Started:    now.Add(-24 * time.Hour),
+		LastPacket: now.Add(-time.Minute),
+		Backlog:    3,
+		Store:      &Status{},
+		Sinks:      map[string]Status{"tak": {}, "influx": {FailingSince: now.Add(-time.Minute)}},
+	}
+
+	report := Evaluate(config, healthy, now)
+	if !report.Ready {
+		t.Errorf("expected ready, got %+v", report)
+	}
+	var names []string
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+	}
+	want := []string{"connection", "packets", "backlog", "store", "sink:influx", "sink:tak"}
+	if len(names) != len(want) {
+		t.Fatalf("expected checks %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected checks %v, got %v", want, names)
+		}
+	}
+	if report.Checks[4].Detail != "failing for 1m0s" {
+		t.Errorf("unexpected sink detail %q", report.Checks[4].Detail)
+	}
+
+	failing := map[string]func(*State){
+		"connection": func(s *State) { s.Connection.FailingSince = now.Add(-10 * time.Minute) },
+		"packets":    func(s *State) { s.LastPacket = now.Add(-2 * time.Hour) },
+		"backlog":    func(s *State) { s.Backlog = 100 },
+		"store":      func(s *State) { s.Store = &Status{FailingSince: now.Add(-time.Hour)} },
+		"sink:tak":   func(s *State) { s.Sinks = map[string]Status{"tak": {FailingSince: now.Add(-time.Hour)}} },
+		"no packets": func(s *State) { s.Started, s.LastPacket = now.Add(-2*time.Hour), time.Time{} },
+	}
+	for name, modify := range failing {
+		state := healthy
+		modify(&state)
+		if report := Evaluate(config, state, now); report.Ready {
+			t.Errorf("%s: expected not ready, got %+v", name, report)
+		}
+		if report := Evaluate(Config{}, state, now); !report.Ready {
+			t.Errorf("%s: expected ready with the checks disabled, got %+v", name, report)
+		}
+	}
+}
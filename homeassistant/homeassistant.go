@@ -0,0 +1,329 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// Config holds configuration for Home Assistant MQTT discovery
+type Config struct {
+	DiscoveryPrefix string   // Home Assistant discovery prefix (default: "homeassistant")
+	StatePrefix     string   // Prefix for state topics (default: "meshstream")
+	Nodes           []uint32 // Nodes to expose to Home Assistant
+	SkipCache       bool     // See mqtt.SubscriberConfig.SkipCache
+}
+
+// sensor describes a telemetry value exposed as a Home Assistant sensor.
+type sensor struct {
+	kind        string // Telemetry variant, as returned by decoder.TelemetryMetrics
+	field       string // Proto field name within the variant
+	name        string
+	deviceClass string
+	unit        string
+}
+
+// key is the sensor's name in the node's state payload and discovery topic.
+func (s sensor) key() string {
+	return s.kind + "_" + s.field
+}
+
+// sensors lists the telemetry values published for each node. Values a node
+// never reports never get a discovery config, so absent sensors don't show up
+// as "unknown" entities.
+var sensors = []sensor{
+	{kind: "device", field: "battery_level", name: "Battery", deviceClass: "battery", unit: "%"},
+	{kind: "device", field: "voltage", name: "Voltage", deviceClass: "voltage", unit: "V"},
+	{kind: "device", field: "channel_utilization", name: "Channel utilization", unit: "%"},
+	{kind: "device", field: "air_util_tx", name: "Airtime", unit: "%"},
+	{kind: "environment", field: "temperature", name: "Temperature", deviceClass: "temperature", unit: "°C"},
+	{kind: "environment", field: "relative_humidity", name: "Humidity", deviceClass: "humidity", unit: "%"},
+	{kind: "environment", field: "barometric_pressure", name: "Pressure", deviceClass: "pressure", unit: "hPa"},
+	{kind: "environment", field: "voltage", name: "Sensor voltage", deviceClass: "voltage", unit: "V"},
+	{kind: "environment", field: "current", name: "Sensor current", deviceClass: "current", unit: "mA"},
+	{kind: "power", field: "ch1_voltage", name: "Channel 1 voltage", deviceClass: "voltage", unit: "V"},
+	{kind: "power", field: "ch1_current", name: "Channel 1 current", deviceClass: "current", unit: "mA"},
+	{kind: "power", field: "ch2_voltage", name: "Channel 2 voltage", deviceClass: "voltage", unit: "V"},
+	{kind: "power", field: "ch2_current", name: "Channel 2 current", deviceClass: "current", unit: "mA"},
+	{kind: "power", field: "ch3_voltage", name: "Channel 3 voltage", deviceClass: "voltage", unit: "V"},
+	{kind: "power", field: "ch3_current", name: "Channel 3 current", deviceClass: "current", unit: "mA"},
+}
+
+// nodeState tracks what has been published for a single node.
+type nodeState struct {
+	values    map[string]float64 // latest sensor values, keyed by sensor.key()
+	announced map[string]bool    // discovery configs already published
+	user      *pb.User           // latest NODEINFO received by this integration
+	device    string             // device name used in published discovery configs
+}
+
+// Integration publishes Home Assistant discovery configs and state for
+// allowlisted nodes.
+type Integration struct {
+	*mqtt.BaseSubscriber
+	config    Config
+	publisher mqtt.Publisher
+	directory *nodes.Directory
+	allowed   map[uint32]bool
+	mu        sync.Mutex
+	state     map[uint32]*nodeState
+	health    health.Tracker
+	logger    logging.Logger
+}
+
+// NewIntegration creates a Home Assistant integration that subscribes to the
+// broker and publishes to Home Assistant's MQTT broker via publisher.
+func NewIntegration(config Config, broker *mqtt.Broker, publisher mqtt.Publisher, directory *nodes.Directory, logger logging.Logger) (*Integration, error) {
+	if len(config.Nodes) == 0 {
+		return nil, fmt.Errorf("no nodes allowlisted for Home Assistant")
+	}
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = "homeassistant"
+	}
+	if config.StatePrefix == "" {
+		config.StatePrefix = "meshstream"
+	}
+
+	ha := &Integration{
+		config:    config,
+		publisher: publisher,
+		directory: directory,
+		allowed:   make(map[uint32]bool, len(config.Nodes)),
+		state:     make(map[uint32]*nodeState),
+		logger:    logger.Named("homeassistant"),
+	}
+	for _, id := range config.Nodes {
+		ha.allowed[id] = true
+	}
+
+	ha.BaseSubscriber = mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "HomeAssistant",
+		Broker:     broker,
+		BufferSize: 100,
+		SkipCache:  config.SkipCache,
+		DropCopies: true,
+		Processor:  ha.process,
+		Logger:     logger,
+	})
+	ha.Start()
+
+	return ha, nil
+}
+
+// process handles a single packet from the broker.
+func (ha *Integration) process(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	if !ha.allowed[data.GetFrom()] {
+		return
+	}
+
+	switch data.GetPortNum() {
+	case pb.PortNum_TELEMETRY_APP:
+		ha.handleTelemetry(data.GetFrom(), data.GetTelemetry())
+	case pb.PortNum_POSITION_APP:
+		ha.handlePosition(data.GetFrom(), data.GetPosition())
+	case pb.PortNum_NODEINFO_APP:
+		ha.handleNodeInfo(data.GetFrom(), data.GetNodeInfo())
+	}
+}
+
+// handleTelemetry publishes sensor discovery for newly seen values and the
+// node's merged sensor state.
+func (ha *Integration) handleTelemetry(nodeID uint32, telemetry *pb.Telemetry) {
+	kind, fields := decoder.TelemetryMetrics(telemetry)
+	if len(fields) == 0 {
+		return
+	}
+
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	state := ha.nodeState(nodeID)
+	updated := false
+	for _, s := range sensors {
+		value, ok := fields[s.field]
+		if s.kind != kind || !ok {
+			continue
+		}
+		if !state.announced[s.key()] {
+			ha.publishJSON(ha.sensorConfigTopic(nodeID, s), ha.sensorConfig(nodeID, s), true)
+			state.announced[s.key()] = true
+		}
+		state.values[s.key()] = value
+		updated = true
+	}
+
+	if updated {
+		ha.publishJSON(ha.stateTopic(nodeID, "state"), state.values, true)
+	}
+}
+
+// handlePosition publishes the node's location as a device_tracker.
+func (ha *Integration) handlePosition(nodeID uint32, pos *pb.Position) {
+	lat, lon, ok := nodes.Coordinates(pos)
+	if !ok {
+		return
+	}
+
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	state := ha.nodeState(nodeID)
+	if !state.announced["tracker"] {
+		ha.publishJSON(ha.trackerConfigTopic(nodeID), ha.trackerConfig(nodeID), true)
+		state.announced["tracker"] = true
+	}
+
+	attributes := map[string]interface{}{
+		"latitude":  lat,
+		"longitude": lon,
+	}
+	if pos.Altitude != nil {
+		attributes["altitude"] = pos.GetAltitude()
+	}
+	if accuracy := nodes.PrecisionMeters(pos); accuracy > 0 {
+		attributes["gps_accuracy"] = accuracy
+	}
+	ha.publishJSON(ha.stateTopic(nodeID, "location"), attributes, true)
+}
+
+// handleNodeInfo republishes discovery configs when a node's name changes, so
+// Home Assistant picks up the new device name.
+func (ha *Integration) handleNodeInfo(nodeID uint32, user *pb.User) {
+	if user == nil {
+		return
+	}
+
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	state := ha.nodeState(nodeID)
+	state.user = user
+	if state.device == ha.deviceName(nodeID) {
+		return
+	}
+	for _, s := range sensors {
+		if state.announced[s.key()] {
+			ha.publishJSON(ha.sensorConfigTopic(nodeID, s), ha.sensorConfig(nodeID, s), true)
+		}
+	}
+	if state.announced["tracker"] {
+		ha.publishJSON(ha.trackerConfigTopic(nodeID), ha.trackerConfig(nodeID), true)
+	}
+}
+
+// nodeState returns the publishing state for a node, creating it if needed.
+// Must be called with ha.mu held.
+func (ha *Integration) nodeState(nodeID uint32) *nodeState {
+	state, ok := ha.state[nodeID]
+	if !ok {
+		state = &nodeState{
+			values:    make(map[string]float64),
+			announced: make(map[string]bool),
+			user:      ha.directory.User(nodeID),
+		}
+		ha.state[nodeID] = state
+	}
+	return state
+}
+
+// deviceName returns the name shown for a node's device in Home Assistant.
+// Must be called with ha.mu held.
+func (ha *Integration) deviceName(nodeID uint32) string {
+	if user := ha.nodeState(nodeID).user; user.GetLongName() != "" {
+		return user.GetLongName()
+	}
+	return ha.directory.LongName(nodeID)
+}
+
+// objectID returns the Home Assistant object ID for a node.
+func objectID(nodeID uint32) string {
+	return "meshtastic_" + strings.TrimPrefix(nodes.FormatID(nodeID), "!")
+}
+
+func (ha *Integration) sensorConfigTopic(nodeID uint32, s sensor) string {
+	return fmt.Sprintf("%s/sensor/%s/%s/config", ha.config.DiscoveryPrefix, objectID(nodeID), s.key())
+}
+
+func (ha *Integration) trackerConfigTopic(nodeID uint32) string {
+	return fmt.Sprintf("%s/device_tracker/%s/config", ha.config.DiscoveryPrefix, objectID(nodeID))
+}
+
+func (ha *Integration) stateTopic(nodeID uint32, name string) string {
+	return fmt.Sprintf("%s/%s/%s", ha.config.StatePrefix, strings.TrimPrefix(nodes.FormatID(nodeID), "!"), name)
+}
+
+// device returns the Home Assistant device block shared by a node's entities.
+// Must be called with ha.mu held.
+func (ha *Integration) device(nodeID uint32) map[string]interface{} {
+	state := ha.nodeState(nodeID)
+	state.device = ha.deviceName(nodeID)
+
+	device := map[string]interface{}{
+		"identifiers":  []string{objectID(nodeID)},
+		"name":         state.device,
+		"manufacturer": "Meshtastic",
+	}
+	if state.user != nil {
+		device["model"] = state.user.GetHwModel().String()
+	}
+	return device
+}
+
+func (ha *Integration) sensorConfig(nodeID uint32, s sensor) map[string]interface{} {
+	config := map[string]interface{}{
+		"name":           s.name,
+		"unique_id":      objectID(nodeID) + "_" + s.key(),
+		"state_topic":    ha.stateTopic(nodeID, "state"),
+		"value_template": fmt.Sprintf("{{ value_json.%s }}", s.key()),
+		"state_class":    "measurement",
+		"device":         ha.device(nodeID),
+	}
+	if s.deviceClass != "" {
+		config["device_class"] = s.deviceClass
+	}
+	if s.unit != "" {
+		config["unit_of_measurement"] = s.unit
+	}
+	return config
+}
+
+func (ha *Integration) trackerConfig(nodeID uint32) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                  "Location",
+		"unique_id":             objectID(nodeID) + "_location",
+		"json_attributes_topic": ha.stateTopic(nodeID, "location"),
+		"source_type":           "gps",
+		"device":                ha.device(nodeID),
+	}
+}
+
+// publishJSON marshals and publishes a payload, logging any failure.
+func (ha *Integration) publishJSON(topic string, payload interface{}, retained bool) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		ha.logger.Errorw("Failed to marshal Home Assistant payload", "error", err, "topic", topic)
+		return
+	}
+	if err := ha.publisher.Publish(topic, body, retained); err != nil {
+		ha.health.Failure()
+		ha.logger.Warnw("Failed to publish to Home Assistant", "error", err, "topic", topic)
+		return
+	}
+	ha.health.Success()
+}
+
+// Health reports whether publishing to Home Assistant is failing.
+func (ha *Integration) Health() health.Status {
+	return ha.health.Status()
+}
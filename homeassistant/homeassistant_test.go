@@ -0,0 +1,200 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/nodes"
+)
+
+// fakePublisher records published messages by topic.
+type fakePublisher struct {
+	mu       sync.Mutex
+	messages map[string][]byte
+	counts   map[string]int
+}
+
+func (f *fakePublisher) Publish(topic string, payload []byte, retained bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.messages == nil {
+		f.messages = make(map[string][]byte)
+		f.counts = make(map[string]int)
+	}
+	f.messages[topic] = payload
+	f.counts[topic]++
+	return nil
+}
+
+// waitFor polls until a message has been published on topic.
+func (f *fakePublisher) waitFor(t *testing.T, topic string) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		payload, ok := f.messages[topic]
+		f.mu.Unlock()
+		if ok {
+			var out map[string]interface{}
+			if err := json.Unmarshal(payload, &out); err != nil {
+				t.Fatalf("invalid JSON on %s: %v", topic, err)
+			}
+			return out
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("nothing published on %s", topic)
+	return nil
+}
+
+func (f *fakePublisher) has(topic string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.messages[topic]
+	return ok
+}
+
+func newTestIntegration(t *testing.T, allowed ...uint32) (chan *meshtreampb.Packet, *fakePublisher) {
+	t.Helper()
+	mesh := testutil.NewMesh(t)
+	publisher := &fakePublisher{}
+
+	ha, err := NewIntegration(Config{Nodes: allowed}, mesh.Broker, publisher, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatalf("failed to create integration: %v", err)
+	}
+	t.Cleanup(ha.Close)
+	return mesh.Source, publisher
+}
+
+func telemetryPacket(from uint32, telemetry *pb.Telemetry) *meshtreampb.Packet {
+	return &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			From:    from,
+			PortNum: pb.PortNum_TELEMETRY_APP,
+			Payload: &meshtreampb.Data_Telemetry{Telemetry: telemetry},
+		},
+		Info: &meshtreampb.TopicInfo{},
+	}
+}
+
+func TestIntegrationPublishesTelemetry(t *testing.T) {
+	source, publisher := newTestIntegration(t, 0xabcd1234)
+
+	source <- &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			From:    0xabcd1234,
+			PortNum: pb.PortNum_NODEINFO_APP,
+			Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{LongName: "Hilltop", HwModel: pb.HardwareModel_RAK4631}},
+		},
+		Info: &meshtreampb.TopicInfo{},
+	}
+	source <- telemetryPacket(0xabcd1234, &pb.Telemetry{Variant: &pb.Telemetry_DeviceMetrics{
+		DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(76), ChannelUtilization: proto.Float32(8.5)},
+	}})
+
+	config := publisher.waitFor(t, "homeassistant/sensor/meshtastic_abcd1234/device_battery_level/config")
+	if config["device_class"] != "battery" || config["unit_of_measurement"] != "%" {
+		t.Errorf("unexpected battery config: %v", config)
+	}
+	if config["state_topic"] != "meshstream/abcd1234/state" {
+		t.Errorf("unexpected state topic: %v", config["state_topic"])
+	}
+	device := config["device"].(map[string]interface{})
+	if device["name"] != "Hilltop" || device["model"] != "RAK4631" {
+		t.Errorf("unexpected device block: %v", device)
+	}
+
+	state := publisher.waitFor(t, "meshstream/abcd1234/state")
+	if state["device_battery_level"] != 76.0 || state["device_channel_utilization"] != 8.5 {
+		t.Errorf("unexpected state: %v", state)
+	}
+
+	// Sensors the node never reported are not announced.
+	if publisher.has("homeassistant/sensor/meshtastic_abcd1234/device_voltage/config") {
+		t.Error("voltage sensor should not be announced without a reading")
+	}
+}
+
+func TestIntegrationPublishesTracker(t *testing.T) {
+	source, publisher := newTestIntegration(t, 0xabcd1234)
+
+	source <- &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			From:    0xabcd1234,
+			PortNum: pb.PortNum_POSITION_APP,
+			Payload: &meshtreampb.Data_Position{Position: &pb.Position{
+				LatitudeI:     proto.Int32(377749000),
+				LongitudeI:    proto.Int32(-1224194000),
+				PrecisionBits: 16,
+			}},
+		},
+		Info: &meshtreampb.TopicInfo{},
+	}
+
+	config := publisher.waitFor(t, "homeassistant/device_tracker/meshtastic_abcd1234/config")
+	if config["json_attributes_topic"] != "meshstream/abcd1234/location" || config["source_type"] != "gps" {
+		t.Errorf("unexpected tracker config: %v", config)
+	}
+	location := publisher.waitFor(t, "meshstream/abcd1234/location")
+	if location["latitude"].(float64) < 37.77 || location["gps_accuracy"].(float64) < 300 {
+		t.Errorf("unexpected location attributes: %v", location)
+	}
+}
+
+func TestIntegrationIgnoresCopies(t *testing.T) {
+	source, publisher := newTestIntegration(t, 0xabcd1234)
+
+	battery := func(id, level uint32) *meshtreampb.Packet {
+		packet := telemetryPacket(0xabcd1234, &pb.Telemetry{Variant: &pb.Telemetry_DeviceMetrics{
+			DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(level)},
+		}})
+		packet.Data.Id = id
+		return packet
+	}
+	// The same reading delivered by two gateways, then a new one.
+	source <- battery(7, 50)
+	source <- battery(7, 50)
+	source <- battery(8, 60)
+
+	deadline := time.Now().Add(time.Second)
+	for publisher.waitFor(t, "meshstream/abcd1234/state")["device_battery_level"] != 60.0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if count := publisher.counts["meshstream/abcd1234/state"]; count != 2 {
+		t.Errorf("expected the state published once per packet, got %d", count)
+	}
+}
+
+func TestIntegrationIgnoresUnlistedNodes(t *testing.T) {
+	source, publisher := newTestIntegration(t, 0xabcd1234)
+
+	source <- telemetryPacket(0x11111111, &pb.Telemetry{Variant: &pb.Telemetry_DeviceMetrics{
+		DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(50)},
+	}})
+	source <- telemetryPacket(0xabcd1234, &pb.Telemetry{Variant: &pb.Telemetry_DeviceMetrics{
+		DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(50)},
+	}})
+
+	publisher.waitFor(t, "meshstream/abcd1234/state")
+	if publisher.has("meshstream/11111111/state") {
+		t.Error("unlisted node should not be published")
+	}
+}
+
+func TestIntegrationRequiresAllowlist(t *testing.T) {
+	logger := logging.NewDevLogger().Named("test")
+	if _, err := NewIntegration(Config{}, nil, &fakePublisher{}, nodes.NewDirectory(), logger); err == nil {
+		t.Error("expected an error without allowlisted nodes")
+	}
+}
@@ -0,0 +1,202 @@
+package influx
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/nodes"
+)
+
+func deviceTelemetry(from uint32, battery uint32) *meshtreampb.Packet {
+	return &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			From:      from,
+			GatewayId: "!0000beef",
+			PortNum:   pb.PortNum_TELEMETRY_APP,
+			RxTime:    1700000000,
+			Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+				Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{
+					BatteryLevel: proto.Uint32(battery),
+					Voltage:      proto.Float32(4.25),
+				}},
+			}},
+		},
+		Info: &meshtreampb.TopicInfo{Channel: "LongFast", RegionPath: "US/bay area"},
+	}
+}
+
+func TestPoint(t *testing.T) {
+	directory := nodes.NewDirectory()
+	directory.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    0xabcd1234,
+		PortNum: pb.PortNum_NODEINFO_APP,
+		Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{ShortName: "H,T"}},
+	}})
+
+	line, ok := Point(deviceTelemetry(0xabcd1234, 90), directory)
+	if !ok {
+		t.Fatal("expected a point for telemetry packet")
+	}
+
+	want := `meshtastic_device,node=!abcd1234,short_name=H\,T,gateway=!0000beef,channel=LongFast,region=US/bay\ area battery_level=90,voltage=4.25 1700000000`
+	if line != want {
+		t.Errorf("unexpected line protocol\nwant: %s\n got: %s", want, line)
+	}
+
+	if _, ok := Point(&meshtreampb.Packet{Data: &meshtreampb.Data{From: 1, PortNum: pb.PortNum_TEXT_MESSAGE_APP}}, directory); ok {
+		t.Error("non-telemetry packets should not produce points")
+	}
+}
+
+func TestPointSkipsUnwritableValues(t *testing.T) {
+	packet := deviceTelemetry(0xabcd1234, 90)
+	packet.Data.GatewayId = `gw\`
+	packet.GetData().GetTelemetry().GetDeviceMetrics().Voltage = proto.Float32(float32(math.NaN()))
+
+	line, ok := Point(packet, nodes.NewDirectory())
+	if !ok {
+		t.Fatal("expected a point for telemetry packet")
+	}
+	want := `meshtastic_device,node=!abcd1234,gateway=gw,channel=LongFast,region=US/bay\ area battery_level=90 1700000000`
+	if line != want {
+		t.Errorf("unexpected line protocol\nwant: %s\n got: %s", want, line)
+	}
+
+	packet.GetData().GetTelemetry().GetDeviceMetrics().BatteryLevel = nil
+	packet.GetData().GetTelemetry().GetDeviceMetrics().Voltage = proto.Float32(float32(math.Inf(1)))
+	if line, ok := Point(packet, nodes.NewDirectory()); ok {
+		t.Errorf("expected no point without finite values, got %s", line)
+	}
+}
+
+func newTestSink(t *testing.T, config Config) (chan *meshtreampb.Packet, *Sink) {
+	t.Helper()
+	mesh := testutil.NewMesh(t)
+	sink, err := NewSink(config, mesh.Broker, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	return mesh.Source, sink
+}
+
+func TestSinkWritesToInfluxHTTP(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+		calls  atomic.Int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to exercise retry.
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "mesh" || r.URL.Query().Get("precision") != "s" {
+			t.Errorf("unexpected write request: %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("unexpected authorization header: %q", r.Header.Get("Authorization"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	source, sink := newTestSink(t, Config{
+		URL:          server.URL,
+		Org:          "meshorg",
+		Bucket:       "mesh",
+		Token:        "secret",
+		BatchSize:    2,
+		RetryBackoff: time.Millisecond,
+	})
+
+	source <- deviceTelemetry(1, 50)
+	source <- deviceTelemetry(2, 60)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(bodies)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	sink.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected one successful batch write, got %d", len(bodies))
+	}
+	if lines := strings.Split(strings.TrimSpace(bodies[0]), "\n"); len(lines) != 2 {
+		t.Errorf("expected 2 points in batch, got %d: %q", len(lines), bodies[0])
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected one retry after a 503, got %d calls", calls.Load())
+	}
+}
+
+func TestSinkDropsRejectedBatch(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "partial write: field type conflict", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	source, sink := newTestSink(t, Config{URL: server.URL, Bucket: "mesh", BatchSize: 1, RetryBackoff: time.Millisecond})
+	source <- deviceTelemetry(1, 50)
+	time.Sleep(50 * time.Millisecond)
+	sink.Close()
+
+	if calls.Load() != 1 {
+		t.Errorf("rejected batches should not be retried, got %d calls", calls.Load())
+	}
+}
+
+func TestSinkWritesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.lp")
+	source, sink := newTestSink(t, Config{FilePath: path, FlushInterval: time.Hour})
+
+	source <- deviceTelemetry(1, 50)
+	time.Sleep(20 * time.Millisecond)
+
+	// Closing flushes points that haven't filled a batch yet.
+	sink.Close()
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read line-protocol file: %v", err)
+	}
+	if !strings.HasPrefix(string(contents), "meshtastic_device,node=!00000001") {
+		t.Errorf("unexpected file contents: %q", contents)
+	}
+}
+
+func TestSinkRequiresDestination(t *testing.T) {
+	logger := logging.NewDevLogger().Named("test")
+	if _, err := NewSink(Config{}, nil, nodes.NewDirectory(), logger); err == nil {
+		t.Error("expected an error without a destination")
+	}
+}
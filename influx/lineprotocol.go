@@ -0,0 +1,96 @@
+package influx
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
+)
+
+// measurementPrefix is prepended to the telemetry variant to name each
+// measurement, e.g. "meshtastic_device" or "meshtastic_air_quality".
+const measurementPrefix = "meshtastic_"
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// Point converts a telemetry packet into a single line-protocol point. It
+// returns false for packets that carry no telemetry values.
+//
+// The point is tagged with the source node, its short name (when known from
+// the directory), the reporting gateway, channel and region. Every populated
+// metric becomes a float field named after its proto field.
+func Point(packet *meshtreampb.Packet, directory *nodes.Directory) (string, bool) {
+	data := packet.GetData()
+	if data.GetPortNum() != pb.PortNum_TELEMETRY_APP || data.GetFrom() == 0 {
+		return "", false
+	}
+	kind, fields := decoder.TelemetryMetrics(data.GetTelemetry())
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	var sb strings.Builder
+	sb.WriteString(measurementEscaper.Replace(measurementPrefix + kind))
+
+	tags := [][2]string{
+		{"node", nodes.FormatID(data.GetFrom())},
+		{"short_name", directory.ShortName(data.GetFrom())},
+		{"gateway", data.GetGatewayId()},
+		{"channel", packet.GetInfo().GetChannel()},
+		{"region", packet.GetInfo().GetRegionPath()},
+	}
+	for _, tag := range tags {
+		// A trailing backslash would escape the separator after the value.
+		value := strings.TrimRight(tag[1], `\`)
+		// Empty tag values are not allowed in line protocol.
+		if value == "" {
+			continue
+		}
+		sb.WriteByte(',')
+		sb.WriteString(tag[0])
+		sb.WriteByte('=')
+		sb.WriteString(tagEscaper.Replace(value))
+	}
+
+	// Sort fields so output is deterministic. Line protocol has no NaN or
+	// infinity, and one such value would get the whole batch rejected.
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i == 0 {
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(tagEscaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(strconv.FormatFloat(fields[k], 'f', -1, 64))
+	}
+
+	// Use the reception time rather than the device clock, which is often unset.
+	ts := int64(data.GetRxTime())
+	if ts == 0 {
+		ts = time.Now().Unix()
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(ts, 10))
+
+	return sb.String(), true
+}
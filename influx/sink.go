@@ -0,0 +1,225 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// Config holds configuration for the telemetry sink. Either URL or FilePath
+// must be set; when both are set, points are written to both.
+type Config struct {
+	URL    string // InfluxDB v2 base URL, e.g. http://localhost:8086
+	Org    string // InfluxDB organization
+	Bucket string // InfluxDB bucket
+	Token  string // InfluxDB API token
+
+	FilePath string // Line-protocol file to append points to
+
+	BatchSize     int           // Points per write (default: 500)
+	FlushInterval time.Duration // Maximum time points wait before being written (default: 10s)
+	MaxRetries    int           // Retries for a failed batch before it is dropped (default: 3)
+	RetryBackoff  time.Duration // Initial delay between retries, doubled each attempt (default: 1s)
+
+	SkipCache bool // See mqtt.SubscriberConfig.SkipCache
+}
+
+// Sink converts decoded telemetry into InfluxDB line protocol and writes it in
+// batches.
+type Sink struct {
+	*mqtt.BaseSubscriber
+	config    Config
+	writers   []writer
+	directory *nodes.Directory
+
+	mu      sync.Mutex
+	pending [][]byte
+
+	flush   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	health  health.Tracker
+	logger  logging.Logger
+}
+
+// NewSink creates a telemetry sink subscribed to the broker.
+func NewSink(config Config, broker *mqtt.Broker, directory *nodes.Directory, logger logging.Logger) (*Sink, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	} else if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+
+	s := &Sink{
+		config:    config,
+		directory: directory,
+		flush:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		logger:    logger.Named("influx"),
+	}
+
+	if config.URL != "" {
+		if config.Bucket == "" {
+			return nil, fmt.Errorf("an InfluxDB bucket is required")
+		}
+		w, err := newHTTPWriter(config.URL, config.Org, config.Bucket, config.Token)
+		if err != nil {
+			return nil, err
+		}
+		s.writers = append(s.writers, w)
+	}
+	if config.FilePath != "" {
+		s.writers = append(s.writers, &fileWriter{path: config.FilePath})
+	}
+	if len(s.writers) == 0 {
+		return nil, fmt.Errorf("no InfluxDB URL or line-protocol file configured")
+	}
+
+	s.BaseSubscriber = mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "InfluxSink",
+		Broker:     broker,
+		BufferSize: 100,
+		SkipCache:  config.SkipCache,
+		Processor:  s.process,
+		CloseHook:  s.stop,
+		Logger:     logger,
+	})
+
+	go s.flushLoop()
+	s.Start()
+
+	return s, nil
+}
+
+// process queues a line-protocol point for each telemetry packet.
+func (s *Sink) process(packet *meshtreampb.Packet) {
+	line, ok := Point(packet, s.directory)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, []byte(line))
+	full := len(s.pending) >= s.config.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flushLoop writes pending points when a batch fills or the flush interval
+// elapses.
+func (s *Sink) flushLoop() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.writePending()
+		case <-s.flush:
+			s.writePending()
+		case <-s.done:
+			// Write whatever is left before shutting down.
+			s.writePending()
+			return
+		}
+	}
+}
+
+// writePending drains the queue in batches and writes each one.
+func (s *Sink) writePending() {
+	for {
+		s.mu.Lock()
+		n := min(len(s.pending), s.config.BatchSize)
+		batch := s.pending[:n]
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		body := append(bytes.Join(batch, []byte("\n")), '\n')
+		for _, w := range s.writers {
+			if err := s.writeWithRetry(w, body); err != nil {
+				s.health.Failure()
+				s.logger.Errorw("Dropping telemetry batch after failed write",
+					"error", err,
+					"destination", w.String(),
+					"points", n,
+				)
+			} else {
+				s.health.Success()
+			}
+		}
+	}
+}
+
+// writeWithRetry writes a batch, retrying transient failures with
+// exponential backoff.
+func (s *Sink) writeWithRetry(w writer, body []byte) error {
+	backoff := s.config.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= s.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				// Shutting down: make one last attempt without waiting.
+			}
+			backoff *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = w.write(ctx, body)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return err
+		}
+		s.logger.Warnw("Telemetry write failed, retrying", "error", err, "attempt", attempt+1)
+	}
+	return err
+}
+
+// stop flushes remaining points and stops the flush loop.
+func (s *Sink) stop() {
+	close(s.done)
+	<-s.stopped
+}
+
+// Health reports whether writes are failing.
+func (s *Sink) Health() health.Status {
+	return s.health.Status()
+}
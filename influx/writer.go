@@ -0,0 +1,112 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writer delivers a batch of newline-separated line-protocol points.
+type writer interface {
+	write(ctx context.Context, batch []byte) error
+	String() string
+}
+
+// permanentError marks a write failure that retrying will not fix, such as a
+// rejected point or bad credentials.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// httpWriter writes to the InfluxDB v2 HTTP write API.
+type httpWriter struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func newHTTPWriter(baseURL, org, bucket, token string) (*httpWriter, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/api/v2/write")
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "s")
+	u.RawQuery = q.Encode()
+
+	return &httpWriter{
+		endpoint: u.String(),
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (w *httpWriter) write(ctx context.Context, batch []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint, bytes.NewReader(batch))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("InfluxDB write failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return &permanentError{err}
+}
+
+func (w *httpWriter) String() string {
+	return w.endpoint
+}
+
+// fileWriter appends points to a line-protocol file.
+type fileWriter struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (w *fileWriter) write(_ context.Context, batch []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(batch); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *fileWriter) String() string {
+	return w.path
+}
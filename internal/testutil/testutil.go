@@ -0,0 +1,50 @@
+// Package testutil holds the setup shared by tests of the broker's
+// consumers, such as the sinks.
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// Mesh is a broker fed by Source, with a node directory, for testing what
+// consumes packets from it.
+type Mesh struct {
+	Source    chan *meshtreampb.Packet // Packets sent here are broadcast by Broker
+	Broker    *mqtt.Broker
+	Directory *nodes.Directory
+	Logger    logging.Logger
+}
+
+// NewMesh creates a broker and an empty directory. The broker is closed when
+// the test ends, after cleanups registered later, such as closing a sink
+// created with it.
+func NewMesh(t testing.TB) *Mesh {
+	t.Helper()
+	logger := logging.NewDevLogger().Named("test")
+	source := make(chan *meshtreampb.Packet, 20)
+	broker := mqtt.NewBroker(source, 100, time.Hour, logger)
+	t.Cleanup(broker.Close)
+	return &Mesh{
+		Source:    source,
+		Broker:    broker,
+		Directory: nodes.NewDirectory(),
+		Logger:    logger,
+	}
+}
+
+// Name records a node's long name in the directory, as its NODEINFO would.
+func (m *Mesh) Name(num uint32, longName string) {
+	m.Directory.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    num,
+		PortNum: pb.PortNum_NODEINFO_APP,
+		Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{LongName: longName}},
+	}})
+}
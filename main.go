@@ -5,15 +5,25 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/dpup/prefab/logging"
 
+	"meshstream/alerts"
+	"meshstream/auth"
+	"meshstream/cluster"
 	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/health"
 	"meshstream/mqtt"
+	"meshstream/nodes"
 	"meshstream/server"
+	"meshstream/snapshot"
+	"meshstream/timeseries"
+	"meshstream/topology"
 )
 
 // Config holds all the configuration parameters
@@ -30,83 +40,347 @@ type Config struct {
 	MQTTMaxReconnect   time.Duration
 	MQTTUseTLS         bool
 	MQTTTLSPort        int
+	MQTTTransport      string // tcp or websocket
+	MQTTPort           int
+	MQTTWebSocketPath  string
+	MQTTCAFile         string
+	MQTTCertFile       string
+	MQTTKeyFile        string
+	MQTTServerName     string
+	MQTTInsecure       bool
+	MQTTVersion        int // 3 or 5
+	MQTTShareGroup     string
+	MQTTSessionExpiry  time.Duration
+	MQTTOrigin         string
+
+	// Embedded MQTT broker configuration
+	EmbeddedBroker       bool
+	EmbeddedBrokerAddr   string
+	EmbeddedBrokerUsers  []string
+	EmbeddedBrokerBridge bool
+
+	// Cluster configuration
+	ClusterLeader string
+	ClusterToken  string
+	ClusterTLS    bool
+
+	// Home Assistant configuration
+	HABroker          string
+	HAUsername        string
+	HAPassword        string
+	HADiscoveryPrefix string
+	HANodes           []string
+
+	// InfluxDB telemetry sink configuration
+	InfluxURL           string
+	InfluxOrg           string
+	InfluxBucket        string
+	InfluxToken         string
+	InfluxFile          string
+	InfluxBatchSize     int
+	InfluxFlushInterval time.Duration
+
+	// Telemetry history configuration
+	MetricsRawRetention    time.Duration
+	MetricsRollupInterval  time.Duration
+	MetricsRollupRetention time.Duration
+	MetricsFile            string
+
+	// Alerting configuration
+	AlertsConfig string
+
+	// Chat bridge configuration
+	BridgeChannels       []string
+	BridgeDiscordWebhook string
+	BridgeSlackWebhook   string
+	BridgeMatrixServer   string
+	BridgeMatrixRoom     string
+	BridgeMatrixToken    string
+
+	// APRS-IS gateway configuration
+	APRSServer   string
+	APRSCallsign string
+	APRSPasscode int
+	APRSStations []string
+	APRSInterval time.Duration
+	APRSComment  string
+
+	// TAK output configuration
+	TAKAddress  string
+	TAKProtocol string
+	TAKChannels []string
+
+	// Config file and the sections of it that aren't flags
+	ConfigFile      string
+	FileAlerts      *alerts.Config    // Alerting rules, unless AlertsConfig is set
+	FileAuth        *auth.Config      // Access control, unless AuthConfig is set
+	FileCachePolicy *mqtt.CachePolicy // Cache policy, unless CachePolicy is set
 
 	// Web server configuration
 	ServerHost string
 	ServerPort string
 	StaticDir  string
+	AuthConfig string
+
+	// Readiness thresholds
+	ReadyMaxPacketAge    time.Duration
+	ReadyMaxBacklog      int
+	ReadyMaxDisconnected time.Duration
+	ReadyMaxSinkFailure  time.Duration
 
 	// Channel keys configuration (name:key pairs)
 	ChannelKeys []string
 
 	// Statistics configuration
-	StatsInterval  time.Duration
-	CacheSize      int
-	CacheRetention time.Duration
-	VerboseLogging bool
+	StatsInterval        time.Duration
+	CacheSize            int
+	CacheRetention       time.Duration
+	CachePolicy          string   // YAML file tuning cache eviction
+	CachePartitionBy     string   // none, region, channel or region+channel
+	CachePartitionSizes  []string // partition=size budgets overriding CacheSize
+	CacheMaxPartitions   int      // Most partitions without a budget of their own
+	SlowSubscriberPolicy string   // drop-oldest, drop-newest or disconnect
+	SnapshotChatSize     int
+	VerboseLogging       bool
 }
 
-// getEnv retrieves an environment variable with the given prefix or returns the default value
+// fileSettings holds the values of the config file, keyed by environment
+// variable name without the MESHSTREAM_ prefix. getEnv falls back to them, so
+// flags take precedence over environment variables, which take precedence
+// over the config file.
+var fileSettings map[string]string
+
+// defaultMQTTClientID is the MQTT client ID, before it is made unique to the
+// process.
+const defaultMQTTClientID = "meshstream"
+
+// processStart makes the MQTT client ID unique to this process. It is fixed
+// so that reloading the config yields the same ID.
+var processStart = time.Now()
+
+// getEnv retrieves an environment variable with the given prefix, or the
+// config file setting, or returns the default value
 func getEnv(key, defaultValue string) string {
 	envKey := "MESHSTREAM_" + key
 	if val, exists := os.LookupEnv(envKey); exists {
 		return val
 	}
+	if val, exists := fileSettings[key]; exists {
+		return val
+	}
 	return defaultValue
 }
 
-// parseConfig parses command line flags and environment variables
+// configPath returns the config file named by --config or MESHSTREAM_CONFIG.
+// It is looked up before the other flags are defined, since their defaults
+// depend on the file.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("MESHSTREAM_CONFIG")
+}
+
+// parseConfig parses command line flags, environment variables and the
+// config file, exiting if they are invalid
 func parseConfig() *Config {
+	config, err := loadConfig(os.Args[1:], flag.ExitOnError)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	return config
+}
+
+// loadConfig parses command line flags, environment variables and the
+// config file. It is called again on SIGHUP to pick up config file changes.
+func loadConfig(args []string, errorHandling flag.ErrorHandling) (*Config, error) {
+	config := &Config{ConfigFile: configPath(args)}
+	fileSettings = nil
+	if config.ConfigFile != "" {
+		file, err := loadConfigFile(config.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", config.ConfigFile, err)
+		}
+		fileSettings = file.settings
+		config.FileAlerts = file.alerts
+		config.FileAuth = file.auth
+		config.FileCachePolicy = file.cachePolicy
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], errorHandling)
+
 	// Print custom usage message
-	flag.Usage = func() {
+	flags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "MeshStream: A Meshtastic MQTT streaming service\n\n")
 		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nAll options can also be set using environment variables with the MESHSTREAM_ prefix.\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nAll options can also be set using environment variables with the MESHSTREAM_ prefix,\n")
+		fmt.Fprintf(os.Stderr, "or in the YAML file given by --config.\n")
 		fmt.Fprintf(os.Stderr, "Example: MESHSTREAM_MQTT_BROKER=mqtt.example.com MESHSTREAM_SERVER_PORT=8081 %s\n\n", os.Args[0])
 	}
 
-	config := &Config{}
+	flags.String("config", config.ConfigFile, "YAML config file; reloaded on SIGHUP")
 
 	// MQTT configuration
-	flag.StringVar(&config.MQTTBroker, "mqtt-broker", getEnv("MQTT_BROKER", "mqtt.bayme.sh"), "MQTT broker address")
-	flag.StringVar(&config.MQTTUsername, "mqtt-username", getEnv("MQTT_USERNAME", "meshdev"), "MQTT username")
-	flag.StringVar(&config.MQTTPassword, "mqtt-password", getEnv("MQTT_PASSWORD", "large4cats"), "MQTT password")
-	flag.StringVar(&config.MQTTTopicPrefix, "mqtt-topic-prefix", getEnv("MQTT_TOPIC_PREFIX", "msh/US/bayarea"), "MQTT topic prefix")
-	flag.StringVar(&config.MQTTClientID, "mqtt-client-id", getEnv("MQTT_CLIENT_ID", "meshstream"), "MQTT client ID")
+	flags.StringVar(&config.MQTTBroker, "mqtt-broker", getEnv("MQTT_BROKER", "mqtt.bayme.sh"), "MQTT broker address")
+	flags.StringVar(&config.MQTTUsername, "mqtt-username", getEnv("MQTT_USERNAME", "meshdev"), "MQTT username")
+	flags.StringVar(&config.MQTTPassword, "mqtt-password", getEnv("MQTT_PASSWORD", "large4cats"), "MQTT password")
+	flags.StringVar(&config.MQTTTopicPrefix, "mqtt-topic-prefix", getEnv("MQTT_TOPIC_PREFIX", "msh/US/bayarea"), "MQTT topic prefix")
+	flags.StringVar(&config.MQTTClientID, "mqtt-client-id", getEnv("MQTT_CLIENT_ID", defaultMQTTClientID), "MQTT client ID")
 
 	// MQTT connection tuning parameters
-	flag.IntVar(&config.MQTTKeepAlive, "mqtt-keepalive", intFromEnv("MQTT_KEEPALIVE", 60), "MQTT keep alive interval in seconds")
-	flag.DurationVar(&config.MQTTConnectTimeout, "mqtt-connect-timeout", durationFromEnv("MQTT_CONNECT_TIMEOUT", 30*time.Second), "MQTT connection timeout")
-	flag.DurationVar(&config.MQTTPingTimeout, "mqtt-ping-timeout", durationFromEnv("MQTT_PING_TIMEOUT", 10*time.Second), "MQTT ping timeout")
-	flag.DurationVar(&config.MQTTMaxReconnect, "mqtt-max-reconnect", durationFromEnv("MQTT_MAX_RECONNECT", 5*time.Minute), "MQTT maximum reconnect interval")
-	flag.BoolVar(&config.MQTTUseTLS, "mqtt-use-tls", boolFromEnv("MQTT_USE_TLS", false), "Use TLS for MQTT connection")
-	flag.IntVar(&config.MQTTTLSPort, "mqtt-tls-port", intFromEnv("MQTT_TLS_PORT", 8883), "MQTT TLS port")
+	flags.IntVar(&config.MQTTKeepAlive, "mqtt-keepalive", intFromEnv("MQTT_KEEPALIVE", 60), "MQTT keep alive interval in seconds")
+	flags.DurationVar(&config.MQTTConnectTimeout, "mqtt-connect-timeout", durationFromEnv("MQTT_CONNECT_TIMEOUT", 30*time.Second), "MQTT connection timeout")
+	flags.DurationVar(&config.MQTTPingTimeout, "mqtt-ping-timeout", durationFromEnv("MQTT_PING_TIMEOUT", 10*time.Second), "MQTT ping timeout")
+	flags.DurationVar(&config.MQTTMaxReconnect, "mqtt-max-reconnect", durationFromEnv("MQTT_MAX_RECONNECT", 5*time.Minute), "MQTT maximum reconnect interval")
+	flags.BoolVar(&config.MQTTUseTLS, "mqtt-use-tls", boolFromEnv("MQTT_USE_TLS", false), "Use TLS for MQTT connection")
+	flags.IntVar(&config.MQTTTLSPort, "mqtt-tls-port", intFromEnv("MQTT_TLS_PORT", 0), "MQTT TLS port (default 8883, or 443 over WebSocket)")
+	flags.StringVar(&config.MQTTTransport, "mqtt-transport", getEnv("MQTT_TRANSPORT", mqtt.TransportTCP), "MQTT transport: tcp or websocket")
+	flags.IntVar(&config.MQTTPort, "mqtt-port", intFromEnv("MQTT_PORT", 0), "MQTT port without TLS (default 1883, or 80 over WebSocket)")
+	flags.StringVar(&config.MQTTWebSocketPath, "mqtt-websocket-path", getEnv("MQTT_WEBSOCKET_PATH", "/mqtt"), "HTTP path of the broker's WebSocket endpoint")
+	flags.StringVar(&config.MQTTCAFile, "mqtt-ca-file", getEnv("MQTT_CA_FILE", ""), "PEM bundle of CAs to verify the MQTT broker with instead of the system roots")
+	flags.StringVar(&config.MQTTCertFile, "mqtt-cert-file", getEnv("MQTT_CERT_FILE", ""), "PEM client certificate for mutual TLS with the MQTT broker")
+	flags.StringVar(&config.MQTTKeyFile, "mqtt-key-file", getEnv("MQTT_KEY_FILE", ""), "PEM private key of the MQTT client certificate")
+	flags.StringVar(&config.MQTTServerName, "mqtt-server-name", getEnv("MQTT_SERVER_NAME", ""), "Name expected in the MQTT broker's certificate and sent as SNI (default: the broker address)")
+	flags.IntVar(&config.MQTTVersion, "mqtt-protocol-version", intFromEnv("MQTT_PROTOCOL_VERSION", 3), "MQTT protocol version: 3 (3.1.1) or 5")
+	flags.StringVar(&config.MQTTShareGroup, "mqtt-share-group", getEnv("MQTT_SHARE_GROUP", ""), "Subscribe as $share/<group>/<topic> so replicas in the group split the messages between them")
+	flags.DurationVar(&config.MQTTSessionExpiry, "mqtt-session-expiry", durationFromEnv("MQTT_SESSION_EXPIRY", 0), "MQTT v5 only: how long the broker keeps queueing messages for this client after it disconnects; keeps the client ID as given, so --mqtt-client-id must be set")
+	flags.StringVar(&config.MQTTOrigin, "mqtt-origin", getEnv("MQTT_ORIGIN", ""), "MQTT v5 only: origin user property added to published messages (default: the client ID)")
+	flags.BoolVar(&config.MQTTInsecure, "mqtt-insecure-skip-verify", boolFromEnv("MQTT_INSECURE_SKIP_VERIFY", false), "Don't verify the MQTT broker's certificate (testing only)")
+
+	// Embedded MQTT broker configuration
+	flags.BoolVar(&config.EmbeddedBroker, "embedded-broker", boolFromEnv("EMBEDDED_BROKER", false), "Run an in-process MQTT broker that gateways connect to directly")
+	flags.StringVar(&config.EmbeddedBrokerAddr, "embedded-broker-addr", getEnv("EMBEDDED_BROKER_ADDR", ":1883"), "Listen address for the embedded MQTT broker")
+	embeddedUsersFlag := flags.String("embedded-broker-users", getEnv("EMBEDDED_BROKER_USERS", ""), "Comma-separated list of user:password[:topic|topic...] accounts for the embedded broker")
+	flags.BoolVar(&config.EmbeddedBrokerBridge, "embedded-broker-bridge", boolFromEnv("EMBEDDED_BROKER_BRIDGE", false), "Forward messages received by the embedded broker to --mqtt-broker")
+
+	// Cluster configuration
+	flags.StringVar(&config.ClusterLeader, "cluster-leader", getEnv("CLUSTER_LEADER", ""), "Follow another meshstream replica at host:port instead of connecting to MQTT")
+	flags.StringVar(&config.ClusterToken, "cluster-token", getEnv("CLUSTER_TOKEN", ""), "Bearer token to authenticate to the cluster leader with")
+	flags.BoolVar(&config.ClusterTLS, "cluster-tls", boolFromEnv("CLUSTER_TLS", false), "Connect to the cluster leader with TLS")
+
+	// Home Assistant MQTT discovery configuration
+	flags.StringVar(&config.HABroker, "ha-broker", getEnv("HA_BROKER", ""), "Home Assistant MQTT broker address; enables discovery when set")
+	flags.StringVar(&config.HAUsername, "ha-username", getEnv("HA_USERNAME", ""), "Home Assistant MQTT username")
+	flags.StringVar(&config.HAPassword, "ha-password", getEnv("HA_PASSWORD", ""), "Home Assistant MQTT password")
+	flags.StringVar(&config.HADiscoveryPrefix, "ha-discovery-prefix", getEnv("HA_DISCOVERY_PREFIX", "homeassistant"), "Home Assistant MQTT discovery prefix")
+	haNodesFlag := flags.String("ha-nodes", getEnv("HA_NODES", ""), "Comma-separated list of node IDs (e.g. !abcd1234) to expose to Home Assistant")
+
+	// Telemetry time-series sink configuration
+	flags.StringVar(&config.InfluxURL, "influx-url", getEnv("INFLUX_URL", ""), "InfluxDB v2 URL to write telemetry points to")
+	flags.StringVar(&config.InfluxOrg, "influx-org", getEnv("INFLUX_ORG", ""), "InfluxDB organization")
+	flags.StringVar(&config.InfluxBucket, "influx-bucket", getEnv("INFLUX_BUCKET", "meshtastic"), "InfluxDB bucket")
+	flags.StringVar(&config.InfluxToken, "influx-token", getEnv("INFLUX_TOKEN", ""), "InfluxDB API token")
+	flags.StringVar(&config.InfluxFile, "influx-file", getEnv("INFLUX_FILE", ""), "File to append telemetry points to in line protocol")
+	flags.IntVar(&config.InfluxBatchSize, "influx-batch-size", intFromEnv("INFLUX_BATCH_SIZE", 500), "Maximum number of telemetry points per write")
+	flags.DurationVar(&config.InfluxFlushInterval, "influx-flush-interval", durationFromEnv("INFLUX_FLUSH_INTERVAL", 10*time.Second), "Maximum time telemetry points are buffered before being written")
+
+	// Telemetry history configuration
+	flags.DurationVar(&config.MetricsRawRetention, "metrics-raw-retention", durationFromEnv("METRICS_RAW_RETENTION", 24*time.Hour), "How long raw telemetry samples are kept for the metrics API")
+	flags.DurationVar(&config.MetricsRollupInterval, "metrics-rollup-interval", durationFromEnv("METRICS_ROLLUP_INTERVAL", time.Hour), "Width of the telemetry aggregates kept after raw samples expire")
+	flags.DurationVar(&config.MetricsRollupRetention, "metrics-rollup-retention", durationFromEnv("METRICS_ROLLUP_RETENTION", 90*24*time.Hour), "How long telemetry aggregates are kept")
+	flags.StringVar(&config.MetricsFile, "metrics-file", getEnv("METRICS_FILE", ""), "File used to persist telemetry history across restarts")
+
+	// Alerting configuration
+	flags.StringVar(&config.AlertsConfig, "alerts-config", getEnv("ALERTS_CONFIG", ""), "YAML file declaring alerting rules and notifiers")
+
+	// Chat bridge configuration
+	bridgeChannelsFlag := flags.String("bridge-channels", getEnv("BRIDGE_CHANNELS", ""), "Comma-separated list of channels whose text messages are forwarded to chat services")
+	flags.StringVar(&config.BridgeDiscordWebhook, "bridge-discord-webhook", getEnv("BRIDGE_DISCORD_WEBHOOK", ""), "Discord webhook URL for the chat bridge")
+	flags.StringVar(&config.BridgeSlackWebhook, "bridge-slack-webhook", getEnv("BRIDGE_SLACK_WEBHOOK", ""), "Slack incoming webhook URL for the chat bridge")
+	flags.StringVar(&config.BridgeMatrixServer, "bridge-matrix-homeserver", getEnv("BRIDGE_MATRIX_HOMESERVER", ""), "Matrix homeserver URL for the chat bridge")
+	flags.StringVar(&config.BridgeMatrixRoom, "bridge-matrix-room", getEnv("BRIDGE_MATRIX_ROOM", ""), "Matrix room ID for the chat bridge")
+	flags.StringVar(&config.BridgeMatrixToken, "bridge-matrix-token", getEnv("BRIDGE_MATRIX_TOKEN", ""), "Matrix access token for the chat bridge")
+
+	// APRS-IS gateway configuration
+	flags.StringVar(&config.APRSServer, "aprs-server", getEnv("APRS_SERVER", "rotate.aprs2.net:14580"), "APRS-IS server host:port")
+	flags.StringVar(&config.APRSCallsign, "aprs-callsign", getEnv("APRS_CALLSIGN", ""), "Callsign used to log in to APRS-IS (enables the APRS gateway)")
+	flags.IntVar(&config.APRSPasscode, "aprs-passcode", intFromEnv("APRS_PASSCODE", 0), "APRS-IS passcode (computed from the callsign if not set)")
+	aprsStationsFlag := flags.String("aprs-stations", getEnv("APRS_STATIONS", ""), "Comma-separated node=CALLSIGN-SSID pairs of nodes whose positions are reported to APRS-IS")
+	flags.DurationVar(&config.APRSInterval, "aprs-interval", durationFromEnv("APRS_INTERVAL", 10*time.Minute), "Minimum time between APRS reports for each station")
+	flags.StringVar(&config.APRSComment, "aprs-comment", getEnv("APRS_COMMENT", ""), "Text appended to each APRS position comment")
+
+	// TAK output configuration
+	flags.StringVar(&config.TAKAddress, "tak-address", getEnv("TAK_ADDRESS", ""), "TAK server or multicast group host:port for Cursor-on-Target output, e.g. 239.2.3.1:6969")
+	flags.StringVar(&config.TAKProtocol, "tak-protocol", getEnv("TAK_PROTOCOL", "udp"), "Transport for Cursor-on-Target output: udp or tcp")
+	takChannelsFlag := flags.String("tak-channels", getEnv("TAK_CHANNELS", ""), "Comma-separated list of channels whose text messages are sent as GeoChat (default: all)")
 
 	// Web server configuration
-	flag.StringVar(&config.ServerHost, "server-host", getEnv("SERVER_HOST", "localhost"), "Web server host")
-	flag.StringVar(&config.ServerPort, "server-port", getEnv("SERVER_PORT", "5446"), "Web server port")
-	flag.StringVar(&config.StaticDir, "static-dir", getEnv("STATIC_DIR", "./server/static"), "Directory containing static web files")
+	flags.StringVar(&config.ServerHost, "server-host", getEnv("SERVER_HOST", "localhost"), "Web server host")
+	flags.StringVar(&config.ServerPort, "server-port", getEnv("SERVER_PORT", "5446"), "Web server port")
+	flags.StringVar(&config.StaticDir, "static-dir", getEnv("STATIC_DIR", "./server/static"), "Directory containing static web files")
+	flags.StringVar(&config.AuthConfig, "auth-config", getEnv("AUTH_CONFIG", ""), "YAML file declaring API tokens, users, OIDC and their channel policies")
+
+	// Readiness thresholds
+	flags.DurationVar(&config.ReadyMaxPacketAge, "ready-max-packet-age", durationFromEnv("READY_MAX_PACKET_AGE", time.Hour), "Report not ready when no packet was decoded for this long (0 disables)")
+	flags.IntVar(&config.ReadyMaxBacklog, "ready-max-backlog", intFromEnv("READY_MAX_BACKLOG", 90), "Report not ready when more packets than this wait for the broker, out of 100 (0 disables)")
+	flags.DurationVar(&config.ReadyMaxDisconnected, "ready-max-disconnected", durationFromEnv("READY_MAX_DISCONNECTED", 5*time.Minute), "Report not ready when the MQTT broker or cluster leader is unreachable for this long (0 disables)")
+	flags.DurationVar(&config.ReadyMaxSinkFailure, "ready-max-sink-failure", durationFromEnv("READY_MAX_SINK_FAILURE", 15*time.Minute), "Report not ready when a sink or the metrics file keeps failing for this long (0 disables)")
 
 	// Channel key configuration (comma separated list of name:key pairs)
 	channelKeysDefault := getEnv("CHANNEL_KEYS", "LongFast:"+decoder.DefaultPrivateKey)
-	channelKeysFlag := flag.String("channel-keys", channelKeysDefault, "Comma-separated list of channel:key pairs for encrypted channels")
-
-	flag.IntVar(&config.CacheSize, "cache-size", intFromEnv("CACHE_SIZE", 5000), "Maximum number of packets to retain in the cache")
-	flag.DurationVar(&config.CacheRetention, "cache-retention", durationFromEnv("CACHE_RETENTION", 3*time.Hour), "How long to retain a node's packets after its last activity")
-	flag.BoolVar(&config.VerboseLogging, "verbose", boolFromEnv("VERBOSE_LOGGING", false), "Enable verbose message logging")
-
-	flag.Parse()
+	channelKeysFlag := flags.String("channel-keys", channelKeysDefault, "Comma-separated list of channel:key pairs for encrypted channels")
+
+	flags.IntVar(&config.CacheSize, "cache-size", intFromEnv("CACHE_SIZE", 5000), "Maximum number of packets to retain in the cache")
+	flags.DurationVar(&config.CacheRetention, "cache-retention", durationFromEnv("CACHE_RETENTION", 3*time.Hour), "How long to retain a node's packets after its last activity")
+	flags.StringVar(&config.CachePolicy, "cache-policy", getEnv("CACHE_POLICY", ""), "YAML file declaring cache eviction priorities, quotas, role boosts and pinned nodes")
+	flags.StringVar(&config.CachePartitionBy, "cache-partition-by", getEnv("CACHE_PARTITION_BY", "none"), "Keep a separate cache per region, channel or region+channel, each holding up to cache-size packets")
+	cachePartitionSizesFlag := flags.String("cache-partition-sizes", getEnv("CACHE_PARTITION_SIZES", ""), "Comma-separated list of partition=size budgets, e.g. US/bayarea=10000,EU_868:LongFast=2000")
+	flags.IntVar(&config.CacheMaxPartitions, "cache-max-partitions", intFromEnv("CACHE_MAX_PARTITIONS", 100), "Maximum number of cache partitions; packets of further regions or channels aren't cached (0 for no limit)")
+	flags.IntVar(&config.SnapshotChatSize, "snapshot-chat-size", intFromEnv("SNAPSHOT_CHAT_SIZE", 100), "Number of text messages per channel included in client snapshots")
+	flags.StringVar(&config.SlowSubscriberPolicy, "slow-subscriber-policy", getEnv("SLOW_SUBSCRIBER_POLICY", "drop-newest"), "What to do when a stream or WebSocket client falls behind: drop-oldest, drop-newest or disconnect")
+	flags.BoolVar(&config.VerboseLogging, "verbose", boolFromEnv("VERBOSE_LOGGING", false), "Enable verbose message logging")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
 
 	if *channelKeysFlag != "" {
 		config.ChannelKeys = strings.Split(*channelKeysFlag, ",")
 	}
+	if *haNodesFlag != "" {
+		config.HANodes = strings.Split(*haNodesFlag, ",")
+	}
+	if *bridgeChannelsFlag != "" {
+		config.BridgeChannels = strings.Split(*bridgeChannelsFlag, ",")
+	}
+	if *aprsStationsFlag != "" {
+		config.APRSStations = strings.Split(*aprsStationsFlag, ",")
+	}
+	if *takChannelsFlag != "" {
+		config.TAKChannels = strings.Split(*takChannelsFlag, ",")
+	}
+	if *embeddedUsersFlag != "" {
+		config.EmbeddedBrokerUsers = strings.Split(*embeddedUsersFlag, ",")
+	}
+	if *cachePartitionSizesFlag != "" {
+		config.CachePartitionSizes = strings.Split(*cachePartitionSizesFlag, ",")
+	}
 
-	// Unique client ID for this process.
-	config.MQTTClientID = fmt.Sprintf("%s-%d-%d", config.MQTTClientID, os.Getpid(), time.Now().Unix())
+	// Unique client ID for this process, unless the broker should resume
+	// its session after a restart, which needs the same ID. Replicas sharing
+	// the default would keep taking over each other's session.
+	if config.MQTTSessionExpiry == 0 {
+		config.MQTTClientID = fmt.Sprintf("%s-%d-%d", config.MQTTClientID, os.Getpid(), processStart.Unix())
+	} else if config.MQTTClientID == defaultMQTTClientID {
+		return nil, fmt.Errorf("--mqtt-session-expiry needs a --mqtt-client-id unique to this instance")
+	}
 
-	return config
+	return config, nil
 }
 
 // Helper function to parse duration from environment with default
@@ -157,28 +431,45 @@ func boolFromEnv(key string, defaultValue bool) bool {
 	}
 }
 
+// parseEmbeddedUsers converts user:password[:topic|topic...] entries into
+// embedded broker accounts
+func parseEmbeddedUsers(entries []string) ([]mqtt.EmbeddedUser, error) {
+	users := make([]mqtt.EmbeddedUser, 0, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid embedded broker user %q, should be 'user:password[:topic|topic]'", parts[0])
+		}
+		user := mqtt.EmbeddedUser{Username: parts[0], Password: parts[1]}
+		if len(parts) == 3 && parts[2] != "" {
+			user.Topics = strings.Split(parts[2], "|")
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// parsePartitionSizes converts partition=size entries into cache partition
+// budgets
+func parsePartitionSizes(entries []string) (map[string]int, error) {
+	sizes := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		size, err := strconv.Atoi(value)
+		if !ok || err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid cache partition size %q, should be 'partition=size'", entry)
+		}
+		sizes[strings.TrimSpace(key)] = size
+	}
+	return sizes, nil
+}
+
 func main() {
 	config := parseConfig()
 	logger := logging.NewProdLogger().Named("main")
 
 	// Initialize channel keys
-	for _, channelKeyPair := range config.ChannelKeys {
-		parts := strings.SplitN(channelKeyPair, ":", 2)
-		if len(parts) != 2 {
-			logger.Errorw("Invalid channel key format, should be 'channel:key'", "pair", channelKeyPair)
-			continue
-		}
-
-		channelName := parts[0]
-		channelKey := parts[1]
-
-		err := decoder.AddChannelKey(channelName, channelKey)
-		if err != nil {
-			logger.Errorw("Failed to initialize channel key", "channel", channelName, "key", channelKey, "error", err)
-		} else {
-			logger.Infof("Initialized channel key for '%s'", channelName)
-		}
-	}
+	applyChannelKeys(config.ChannelKeys, logger)
 
 	// Configure and create the MQTT client
 	mqttConfig := mqtt.Config{
@@ -196,22 +487,117 @@ func main() {
 		MaxReconnectTime: config.MQTTMaxReconnect,
 		UseTLS:           config.MQTTUseTLS,
 		TLSPort:          config.MQTTTLSPort,
+
+		// Transport and TLS parameters
+		Transport:          config.MQTTTransport,
+		Port:               config.MQTTPort,
+		WebSocketPath:      config.MQTTWebSocketPath,
+		CAFile:             config.MQTTCAFile,
+		CertFile:           config.MQTTCertFile,
+		KeyFile:            config.MQTTKeyFile,
+		ServerName:         config.MQTTServerName,
+		InsecureSkipVerify: config.MQTTInsecure,
+
+		// Protocol parameters
+		ProtocolVersion: config.MQTTVersion,
+		ShareGroup:      config.MQTTShareGroup,
+		SessionExpiry:   config.MQTTSessionExpiry,
+		Origin:          config.MQTTOrigin,
 	}
 
-	mqttClient := mqtt.NewClient(mqttConfig, logger)
+	var mqttClient *mqtt.Client
+	var embeddedBroker *mqtt.EmbeddedBroker
+	var follower *cluster.Follower
+	var messagesChan <-chan *meshtreampb.Packet
+	var source func() health.Status // Connection to the packet source, if it can be lost
+	mqttServer := config.MQTTBroker
+
+	if config.ClusterLeader != "" {
+		// Only the leader connects to MQTT; followers receive its packets.
+		if config.EmbeddedBroker {
+			logger.Fatal("A cluster follower can't run the embedded MQTT broker")
+		}
+		var err error
+		follower, err = cluster.NewFollower(cluster.Config{
+			Leader:           config.ClusterLeader,
+			Token:            config.ClusterToken,
+			UseTLS:           config.ClusterTLS,
+			MaxReconnectTime: config.MQTTMaxReconnect,
+		}, logger)
+		if err != nil {
+			logger.Fatalw("Invalid cluster configuration", "error", err)
+		}
+		follower.Start()
+		messagesChan = follower.Messages()
+		source = follower.Health
+		mqttServer = "cluster:" + config.ClusterLeader
+		logger.Infof("Following cluster leader %s", config.ClusterLeader)
+	} else if config.EmbeddedBroker {
+		users, err := parseEmbeddedUsers(config.EmbeddedBrokerUsers)
+		if err != nil {
+			logger.Fatalw("Invalid embedded broker configuration", "error", err)
+		}
+		embeddedConfig := mqtt.EmbeddedConfig{
+			ListenAddr: config.EmbeddedBrokerAddr,
+			Users:      users,
+		}
 
-	// Connect to the MQTT broker
-	if err := mqttClient.Connect(); err != nil {
-		logger.Fatalw("Failed to connect to MQTT broker", "error", err)
-	}
+		// Optionally forward gateway uplinks to the upstream broker. The
+		// bridge client only publishes, so it has no topic subscription.
+		if config.EmbeddedBrokerBridge {
+			mqttConfig.Topic = ""
+			mqttClient = mqtt.NewClient(mqttConfig, logger)
+			if err := mqttClient.Connect(); err != nil {
+				logger.Fatalw("Failed to connect to upstream MQTT broker", "error", err)
+			}
+			embeddedConfig.Bridge = mqttClient
+		}
+
+		embeddedBroker = mqtt.NewEmbeddedBroker(embeddedConfig, logger)
+		if err := embeddedBroker.Start(); err != nil {
+			logger.Fatalw("Failed to start embedded MQTT broker", "error", err)
+		}
+		messagesChan = embeddedBroker.Messages()
+		mqttServer = "embedded:" + embeddedBroker.Addr()
+	} else {
+		mqttClient = mqtt.NewClient(mqttConfig, logger)
+
+		// Connect to the MQTT broker
+		if err := mqttClient.Connect(); err != nil {
+			logger.Fatalw("Failed to connect to MQTT broker", "error", err)
+		}
 
-	// Get the messages channel to receive decoded messages
-	messagesChan := mqttClient.Messages()
+		// Get the messages channel to receive decoded messages
+		messagesChan = mqttClient.Messages()
+		source = mqttClient.Health
+	}
 
 	// Create a message broker to distribute messages to multiple consumers
 	// Cache packets for new subscribers based on configuration
 	broker := mqtt.NewBroker(messagesChan, config.CacheSize, config.CacheRetention, logger)
-	logger.Infof("Message broker initialized with cache size: %d, retention: %s", config.CacheSize, config.CacheRetention)
+	slowSubscriberPolicy, err := mqtt.ParseSlowSubscriberPolicy(config.SlowSubscriberPolicy)
+	if err != nil {
+		logger.Fatalw("Invalid slow subscriber policy", "error", err)
+	}
+	broker.SetSlowSubscriberPolicy(slowSubscriberPolicy)
+	partitionBy, err := mqtt.ParsePartitionBy(config.CachePartitionBy)
+	if err != nil {
+		logger.Fatalw("Invalid cache partitioning", "error", err)
+	}
+	partitionSizes, err := parsePartitionSizes(config.CachePartitionSizes)
+	if err != nil {
+		logger.Fatalw("Invalid cache partition sizes", "error", err)
+	}
+	broker.SetCachePartitions(mqtt.PartitionConfig{By: partitionBy, Size: config.CacheSize, Sizes: partitionSizes, Max: config.CacheMaxPartitions})
+	cachePolicy, err := cachePolicySettings(config)
+	if err != nil {
+		logger.Fatalw("Failed to load cache policy", "error", err)
+	}
+	broker.SetCachePolicy(cachePolicy)
+	if config.CachePolicy != "" || config.FileCachePolicy != nil {
+		logger.Infow("Cache policy loaded", "quotas", len(cachePolicy.Quotas), "pinnedNodes", len(cachePolicy.PinnedNodes))
+	}
+	logger.Infof("Message broker initialized with cache size: %d, retention: %s, partitioned by: %s", config.CacheSize, config.CacheRetention, partitionBy)
 
 	// Create a message logger that subscribes to the broker
 	// and also logs to stdout
@@ -226,16 +612,87 @@ func main() {
 		logger.Infof("Message logger initialized with verbose mode: %t", config.VerboseLogging)
 	}
 
+	// Track node identities so integrations can resolve names
+	nodeDirectory := nodes.NewDirectory()
+	directorySubscriber := mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "NodeDirectory",
+		Broker:     broker,
+		BufferSize: 100,
+		Processor:  nodeDirectory.Observe,
+		Logger:     logger,
+	})
+	directorySubscriber.Start()
+
+	// Keep per-node telemetry history for the metrics API
+	metricsStore := timeseries.NewStore(timeseries.Config{
+		RawRetention:    config.MetricsRawRetention,
+		RollupInterval:  config.MetricsRollupInterval,
+		RollupRetention: config.MetricsRollupRetention,
+		FilePath:        config.MetricsFile,
+	}, logger)
+	metricsSubscriber := mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "TelemetryHistory",
+		Broker:     broker,
+		BufferSize: 100,
+		DropCopies: true,
+		Processor:  metricsStore.Record,
+		Logger:     logger,
+	})
+	metricsSubscriber.Start()
+
+	// Infer mesh links for the topology API
+	topologyGraph := topology.NewGraph(topology.Config{}, nodeDirectory)
+	topologySubscriber := mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "Topology",
+		Broker:     broker,
+		BufferSize: 100,
+		Processor:  topologyGraph.Observe,
+		Logger:     logger,
+	})
+	topologySubscriber.Start()
+
+	// Materialize the latest state per node so new clients can skip the replay
+	snapshotStore := snapshot.NewStore(snapshot.Config{
+		ChatSize:  config.SnapshotChatSize,
+		Retention: config.CacheRetention,
+	})
+	snapshotSubscriber := mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "Snapshot",
+		Broker:     broker,
+		BufferSize: 1000,
+		Processor:  snapshotStore.Observe,
+		Logger:     logger,
+	})
+	snapshotSubscriber.Start()
+
+	// Start the integrations: Home Assistant, InfluxDB, alerting, the chat
+	// bridge, APRS-IS and TAK
+	integrations := newSinks(broker, nodeDirectory, logger)
+	if err := integrations.apply(config, false); err != nil {
+		logger.Fatalw("Failed to initialize integrations", "error", err)
+	}
+
+	// Authenticate API requests
+	authenticator, err := newAuthenticator(config, logger)
+	if err != nil {
+		logger.Fatalw("Failed to load auth config", "error", err)
+	}
+
 	// Start the web server
 	webServer := server.New(server.Config{
 		Host:          config.ServerHost,
 		Port:          config.ServerPort,
 		Broker:        broker,
 		Logger:        logger,
-		MQTTServer:    config.MQTTBroker,
+		MQTTServer:    mqttServer,
 		MQTTTopicPath: config.MQTTTopicPrefix + "/#",
 		StaticDir:     config.StaticDir,
 		ChannelKeys:   config.ChannelKeys,
+		Metrics:       metricsStore,
+		Topology:      topologyGraph,
+		Auth:          authenticator,
+		Snapshot:      snapshotStore,
+		Readiness:     readinessCheck(config, broker, source, metricsStore, integrations),
 	})
 
 	// Start the server in a goroutine
@@ -246,16 +703,25 @@ func main() {
 		}
 	}()
 
-	// Setup signal handling for graceful shutdown
+	// Setup signal handling for graceful shutdown and config reloads
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Process messages until interrupt received
 	logger.Info("Waiting for messages... Press Ctrl+C to exit")
 	logger.Infof("Web server running at http://%s:%s", config.ServerHost, config.ServerPort)
 
-	// Wait for interrupt signal
-	<-sig
+	// Wait for interrupt signal, reloading the config on SIGHUP
+	current := config
+	for s := <-sig; s == syscall.SIGHUP; s = <-sig {
+		newConfig, err := loadConfig(os.Args[1:], flag.ContinueOnError)
+		if err != nil {
+			logger.Errorw("Failed to reload config, keeping the current one", "error", err)
+			continue
+		}
+		reloadConfig(current, newConfig, broker, integrations, webServer, logger)
+		current = newConfig
+	}
 
 	// Got an interrupt signal, shutting down
 	logger.Info("Shutting down...")
@@ -266,14 +732,28 @@ func main() {
 		logger.Errorw("Error stopping web server", "error", err)
 	}
 
-	// Then stop the logger
+	// Then stop the logger and integrations
 	if messageLogger != nil {
 		messageLogger.Close()
 	}
+	integrations.Close()
+	snapshotSubscriber.Close()
+	topologySubscriber.Close()
+	metricsSubscriber.Close()
+	metricsStore.Close()
+	directorySubscriber.Close()
 
 	// Close the broker (which will close all subscriber channels)
 	broker.Close()
 
-	// Then disconnect the MQTT client
-	mqttClient.Disconnect()
+	// Then stop accepting gateway connections and disconnect the MQTT client
+	if embeddedBroker != nil {
+		embeddedBroker.Close()
+	}
+	if mqttClient != nil {
+		mqttClient.Disconnect()
+	}
+	if follower != nil {
+		follower.Close()
+	}
 }
@@ -1,48 +1,21 @@
 package mqtt
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	meshtreampb "meshstream/generated/meshstream"
 	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
 
 	"github.com/dpup/prefab/logging"
 )
 
-// Time to wait before giving up on sending cached packets to a new subscriber.
-var cacheGracePeriod = 1500 * time.Millisecond
-
-// minEvictAge is the minimum age a packet must reach before it is eligible for
-// priority-based eviction. Recent traffic is never evicted; only historical
-// data competes under cache pressure.
-var minEvictAge = time.Hour
-
-// typePriority defines the eviction priority for each packet type.
-// Higher values indicate greater importance: under cache pressure the oldest
-// eligible packet of the lowest-priority type is evicted first, so rare
-// high-value types (neighbour-info, chat) outlive frequent low-value types
-// (node-info, telemetry) in the historical window.
-//
-// Packet types not listed use defaultTypePriority.
-var typePriority = map[pb.PortNum]int{
-	pb.PortNum_TEXT_MESSAGE_APP:            5, // chat — preserve history
-	pb.PortNum_TEXT_MESSAGE_COMPRESSED_APP: 5,
-	pb.PortNum_NEIGHBORINFO_APP:            4, // rare; protect from eviction
-	pb.PortNum_TRACEROUTE_APP:              3,
-	pb.PortNum_POSITION_APP:                3,
-	pb.PortNum_NODEINFO_APP:                2, // frequent; lower priority
-	pb.PortNum_TELEMETRY_APP:               2,
-	pb.PortNum_ROUTING_APP:                 2,
-	pb.PortNum_MAP_REPORT_APP:              2,
-}
-
-// defaultTypePriority applies to any port type not listed above.
-const defaultTypePriority = 1
-
 // isRouterRole returns true for device roles that act as infrastructure nodes.
-// These nodes transmit position and node-info far less often than client nodes,
-// so their state packets need elevated protection from eviction.
+// These nodes transmit far less often than client nodes, so they are exempt
+// from retention pruning.
 func isRouterRole(role pb.Config_DeviceConfig_Role) bool {
 	switch role {
 	case pb.Config_DeviceConfig_ROUTER,
@@ -59,10 +32,11 @@ type entry struct {
 	insertedAt int64 // unix timestamp when this packet was added to the cache
 }
 
-// NodeAwareCache stores packets with two eviction axes:
+// NodeAwareCache stores packets with two eviction axes, both tuned by its
+// CachePolicy:
 //
-//  1. Age protection: packets younger than minEvictAge are never evicted.
-//     This keeps all recent traffic intact regardless of pressure.
+//  1. Age protection: packets younger than MinAge are only evicted when
+//     nothing older is left. This keeps recent traffic intact under pressure.
 //
 //  2. Priority-based eviction for historical data: when the global cap is hit
 //     and old packets must be removed, the cache evicts from the lowest-priority
@@ -71,6 +45,11 @@ type entry struct {
 //     is most likely to resend, so its old packet is cheapest to lose. Silent
 //     nodes' (flaky/distant) historical packets are thus protected.
 //
+// A port with a quota gives up its own packets, by the same rules, once it
+// holds more than its quota, however empty the rest of the cache is. Packets
+// from pinned nodes are never pruned and are evicted only when the cache holds
+// nothing else.
+//
 // Node retention: once a node has been silent for [retention], its packets are
 // excluded from GetAll and proactively pruned when the cache is under pressure.
 // Router nodes (ROUTER, ROUTER_CLIENT, ROUTER_LATE) are exempt from retention
@@ -82,76 +61,110 @@ type entry struct {
 type NodeAwareCache struct {
 	mu           sync.Mutex
 	entries      []entry
-	nodeLastSeen map[uint32]int64 // nodeID → unix timestamp of most recent packet
-	routerNodes  map[uint32]bool  // nodeIDs identified as router-role devices
-	maxSize      int              // global safety cap
+	nodeLastSeen map[uint32]int64                       // nodeID → unix timestamp of most recent packet
+	nodeRoles    map[uint32]pb.Config_DeviceConfig_Role // nodeID → role from its latest NODEINFO
+	portCounts   map[pb.PortNum]int                     // port → cached packets
+	policy       CachePolicy
+	maxSize      int // global safety cap
 	retention    time.Duration
 	nowFunc      func() time.Time // injectable for testing
+	maxRemoved   uint64           // highest sequence number evicted or pruned
 }
 
 // NewNodeAwareCache creates a cache with the given safety cap and node
-// retention window.
+// retention window, using the default policy.
 func NewNodeAwareCache(maxSize int, retention time.Duration) *NodeAwareCache {
 	return &NodeAwareCache{
 		entries:      make([]entry, 0, min(maxSize, 256)),
 		nodeLastSeen: make(map[uint32]int64),
-		routerNodes:  make(map[uint32]bool),
+		nodeRoles:    make(map[uint32]pb.Config_DeviceConfig_Role),
+		portCounts:   make(map[pb.PortNum]int),
+		policy:       DefaultCachePolicy(),
 		maxSize:      maxSize,
 		retention:    retention,
 		nowFunc:      time.Now,
 	}
 }
 
-// packetPriority returns the eviction priority for p. Router nodes' position
-// and node-info packets are elevated to priority 4 (equal to NEIGHBORINFO_APP)
-// because those nodes transmit far less frequently and their state is harder to
-// replace.
+// SetPolicy replaces the eviction policy. Packets already cached stay until
+// the new policy evicts them.
+func (c *NodeAwareCache) SetPolicy(policy CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+}
+
+// isRouter reports whether a node last announced a router role.
+// Must be called with c.mu held.
+func (c *NodeAwareCache) isRouter(nodeID uint32) bool {
+	role, ok := c.nodeRoles[nodeID]
+	return ok && isRouterRole(role)
+}
+
+// packetPriority returns the eviction priority for p: its port's priority,
+// raised by any role boost that matches its source node.
 // Must be called with c.mu held.
 func (c *NodeAwareCache) packetPriority(p *meshtreampb.Packet) int {
 	port := p.GetData().GetPortNum()
-	if c.routerNodes[p.GetData().GetFrom()] {
-		switch port {
-		case pb.PortNum_POSITION_APP, pb.PortNum_NODEINFO_APP:
-			return 4 // elevated: same tier as NEIGHBORINFO_APP
-		}
+	pri, ok := c.policy.Priorities[port]
+	if !ok {
+		pri = c.policy.DefaultPriority
+	}
+	if len(c.policy.RoleBoosts) == 0 {
+		return pri
 	}
-	if pri, ok := typePriority[port]; ok {
+	role, ok := c.nodeRoles[p.GetData().GetFrom()]
+	if !ok {
 		return pri
 	}
-	return defaultTypePriority
+	for _, boost := range c.policy.RoleBoosts {
+		if boost.Roles[role] && (len(boost.Ports) == 0 || boost.Ports[port]) {
+			pri = max(pri, boost.Priority)
+		}
+	}
+	return pri
 }
 
-// Add records a packet. Recent packets (younger than minEvictAge) are never
-// evicted. When the global cap is hit, stale-node packets are pruned first;
-// if still over the limit, the best historical eviction candidate is removed.
+// Add records a packet. Recent packets (younger than the policy's MinAge) are
+// evicted only as a last resort. When a port exceeds its quota, one of its
+// packets is evicted. When the global cap is hit, stale-node packets are
+// pruned first; if still over the limit, the best historical eviction
+// candidate is removed.
 func (c *NodeAwareCache) Add(packet *meshtreampb.Packet) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	nodeID := packet.GetData().GetFrom()
+	port := packet.GetData().GetPortNum()
 	nowUnix := c.nowFunc().Unix()
 
 	if nodeID != 0 {
 		c.nodeLastSeen[nodeID] = nowUnix
 
-		// Keep router-node membership up to date from NODEINFO_APP packets.
-		if packet.GetData().GetPortNum() == pb.PortNum_NODEINFO_APP {
+		// Keep node roles up to date from NODEINFO_APP packets.
+		if port == pb.PortNum_NODEINFO_APP {
 			if user := packet.GetData().GetNodeInfo(); user != nil {
-				if isRouterRole(user.GetRole()) {
-					c.routerNodes[nodeID] = true
-				} else {
-					delete(c.routerNodes, nodeID)
-				}
+				c.nodeRoles[nodeID] = user.GetRole()
 			}
 		}
 	}
 
 	c.entries = append(c.entries, entry{pkt: packet, insertedAt: nowUnix})
+	c.portCounts[port]++
+
+	if quota, ok := c.policy.Quotas[port]; ok && c.portCounts[port] > quota {
+		c.evict(nowUnix, func(p *meshtreampb.Packet) bool {
+			return p.GetData().GetPortNum() == port
+		})
+	}
 
 	if len(c.entries) > c.maxSize {
 		c.pruneStale(nowUnix)
 		if len(c.entries) > c.maxSize {
-			c.evict(nowUnix)
+			if !c.evict(nowUnix, nil) {
+				// Only pinned nodes' packets are left; the cap still holds.
+				c.remove(c.pickEvictTarget(-1, nil))
+			}
 		}
 	}
 }
@@ -167,15 +180,7 @@ func (c *NodeAwareCache) GetAll() []*meshtreampb.Packet {
 		return []*meshtreampb.Packet{}
 	}
 
-	cutoff := c.nowFunc().Unix() - int64(c.retention.Seconds())
-
-	activeNodes := make(map[uint32]bool, len(c.nodeLastSeen))
-	for nodeID, lastSeen := range c.nodeLastSeen {
-		if c.routerNodes[nodeID] || lastSeen >= cutoff {
-			activeNodes[nodeID] = true
-		}
-	}
-
+	activeNodes := c.activeNodes()
 	result := make([]*meshtreampb.Packet, 0, len(c.entries))
 	for _, e := range c.entries {
 		nodeID := e.pkt.GetData().GetFrom()
@@ -186,26 +191,84 @@ func (c *NodeAwareCache) GetAll() []*meshtreampb.Packet {
 	return result
 }
 
-// evict removes the best eviction candidate. It first tries entries old enough
-// to be eligible (insertedAt ≤ nowUnix - minEvictAge); if none qualify it falls
-// back to all entries so the cap is always enforced.
+// activeNodes returns the nodes whose packets GetAll includes.
+// Must be called with c.mu held.
+func (c *NodeAwareCache) activeNodes() map[uint32]bool {
+	cutoff := c.nowFunc().Unix() - int64(c.retention.Seconds())
+
+	active := make(map[uint32]bool, len(c.nodeLastSeen))
+	for nodeID, lastSeen := range c.nodeLastSeen {
+		if c.isRouter(nodeID) || c.policy.PinnedNodes[nodeID] || lastSeen >= cutoff {
+			active[nodeID] = true
+		}
+	}
+	return active
+}
+
+// evict removes the best eviction candidate among unpinned packets accepted
+// by match (nil accepts all). It first tries entries old enough to be
+// eligible (insertedAt ≤ nowUnix - MinAge); if none qualify it falls back to
+// all of them. Returns false if there was no candidate.
 // Must be called with c.mu held.
-func (c *NodeAwareCache) evict(nowUnix int64) {
-	ageThreshold := nowUnix - int64(minEvictAge.Seconds())
+func (c *NodeAwareCache) evict(nowUnix int64, match func(*meshtreampb.Packet) bool) bool {
+	unpinned := func(p *meshtreampb.Packet) bool {
+		return !c.policy.PinnedNodes[p.GetData().GetFrom()] && (match == nil || match(p))
+	}
+	ageThreshold := nowUnix - int64(c.policy.MinAge.Seconds())
 
-	idx := c.pickEvictTarget(ageThreshold)
+	idx := c.pickEvictTarget(ageThreshold, unpinned)
 	if idx < 0 {
-		// All entries are recent; cap must still be enforced.
-		idx = c.pickEvictTarget(-1)
+		// All candidates are recent; the limit must still be enforced.
+		idx = c.pickEvictTarget(-1, unpinned)
 	}
+	if idx < 0 {
+		return false
+	}
+	c.remove(idx)
+	return true
+}
+
+// remove deletes the entry at idx, if any.
+// Must be called with c.mu held.
+func (c *NodeAwareCache) remove(idx int) {
 	if idx < 0 {
 		return
 	}
+	c.noteRemoved(c.entries[idx].pkt)
 	c.entries = append(c.entries[:idx], c.entries[idx+1:]...)
 }
 
+// noteRemoved records a packet leaving the cache: its sequence number and
+// its port's count.
+// Must be called with c.mu held.
+func (c *NodeAwareCache) noteRemoved(p *meshtreampb.Packet) {
+	c.maxRemoved = max(c.maxRemoved, p.GetSeq())
+	port := p.GetData().GetPortNum()
+	if c.portCounts[port]--; c.portCounts[port] <= 0 {
+		delete(c.portCounts, port)
+	}
+}
+
+// MaxRemoved returns the highest sequence number of any packet evicted or
+// pruned from the cache, or 0 if none has been removed.
+func (c *NodeAwareCache) MaxRemoved() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxRemoved
+}
+
+// prune removes the packets of nodes silent for longer than the retention
+// window and returns how many packets are left.
+func (c *NodeAwareCache) prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneStale(c.nowFunc().Unix())
+	return len(c.entries)
+}
+
 // pickEvictTarget returns the index of the best eviction candidate among entries
-// with insertedAt ≤ ageThreshold. Pass ageThreshold = -1 to consider all entries.
+// with insertedAt ≤ ageThreshold that are accepted by match (nil accepts all).
+// Pass ageThreshold = -1 to consider all entries.
 //
 // Selection criteria (in order):
 //  1. Lowest priority tier — least important packet types go first.
@@ -215,18 +278,22 @@ func (c *NodeAwareCache) evict(nowUnix int64) {
 //     a tier (they have no source that will refresh them).
 //
 // Returns -1 if no qualifying entries exist.
-func (c *NodeAwareCache) pickEvictTarget(ageThreshold int64) int {
+func (c *NodeAwareCache) pickEvictTarget(ageThreshold int64, match func(*meshtreampb.Packet) bool) int {
+	qualifies := func(e entry) bool {
+		return (ageThreshold < 0 || e.insertedAt <= ageThreshold) && (match == nil || match(e.pkt))
+	}
+
 	// First pass: find minimum priority among qualifying entries.
-	minPri := -1
+	minPri, found := 0, false
 	for _, e := range c.entries {
-		if ageThreshold >= 0 && e.insertedAt > ageThreshold {
+		if !qualifies(e) {
 			continue
 		}
-		if pri := c.packetPriority(e.pkt); minPri < 0 || pri < minPri {
-			minPri = pri
+		if pri := c.packetPriority(e.pkt); !found || pri < minPri {
+			minPri, found = pri, true
 		}
 	}
-	if minPri < 0 {
+	if !found {
 		return -1 // no qualifying entries
 	}
 
@@ -235,10 +302,7 @@ func (c *NodeAwareCache) pickEvictTarget(ageThreshold int64) int {
 	bestIdx := -1
 	bestLastSeen := int64(-1)
 	for i, e := range c.entries {
-		if ageThreshold >= 0 && e.insertedAt > ageThreshold {
-			continue
-		}
-		if c.packetPriority(e.pkt) != minPri {
+		if !qualifies(e) || c.packetPriority(e.pkt) != minPri {
 			continue
 		}
 		nodeID := e.pkt.GetData().GetFrom()
@@ -259,9 +323,10 @@ func (c *NodeAwareCache) pruneStale(nowUnix int64) {
 
 	stale := make(map[uint32]bool)
 	for nodeID, lastSeen := range c.nodeLastSeen {
-		if !c.routerNodes[nodeID] && lastSeen < cutoff {
+		if !c.isRouter(nodeID) && !c.policy.PinnedNodes[nodeID] && lastSeen < cutoff {
 			stale[nodeID] = true
 			delete(c.nodeLastSeen, nodeID)
+			delete(c.nodeRoles, nodeID)
 		}
 	}
 	if len(stale) == 0 {
@@ -273,34 +338,143 @@ func (c *NodeAwareCache) pruneStale(nowUnix int64) {
 	for _, e := range c.entries {
 		if nodeID := e.pkt.GetData().GetFrom(); nodeID == 0 || !stale[nodeID] {
 			out = append(out, e)
+		} else {
+			c.noteRemoved(e.pkt)
 		}
 	}
 	c.entries = out
 }
 
+// CacheStats describes what the cache holds.
+type CacheStats struct {
+	Partition string          `json:"partition,omitempty"`
+	Size      int             `json:"size"`
+	Capacity  int             `json:"capacity"`
+	MinAge    string          `json:"minAge"`
+	Ports     []PortOccupancy `json:"ports"` // Most packets first
+	Nodes     []NodeOccupancy `json:"nodes"` // Most packets first
+}
+
+// PortOccupancy is the number of cached packets of one port.
+type PortOccupancy struct {
+	Port     string `json:"port"`
+	Packets  int    `json:"packets"`
+	Priority int    `json:"priority"`
+	Quota    int    `json:"quota,omitempty"`
+}
+
+// NodeOccupancy is the number of cached packets from one node.
+type NodeOccupancy struct {
+	Node     string    `json:"node"`
+	Packets  int       `json:"packets"`
+	LastSeen time.Time `json:"lastSeen"`
+	Role     string    `json:"role,omitempty"`
+	Active   bool      `json:"active"` // Whether GetAll includes its packets
+	Pinned   bool      `json:"pinned,omitempty"`
+}
+
+// Stats returns the cache's occupancy by port and by source node. Packets
+// without a source node are counted under port only.
+func (c *NodeAwareCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Size:     len(c.entries),
+		Capacity: c.maxSize,
+		MinAge:   c.policy.MinAge.String(),
+		Ports:    make([]PortOccupancy, 0, len(c.portCounts)),
+	}
+	for port, count := range c.portCounts {
+		pri, ok := c.policy.Priorities[port]
+		if !ok {
+			pri = c.policy.DefaultPriority
+		}
+		stats.Ports = append(stats.Ports, PortOccupancy{
+			Port:     port.String(),
+			Packets:  count,
+			Priority: pri,
+			Quota:    c.policy.Quotas[port],
+		})
+	}
+	sort.Slice(stats.Ports, func(i, j int) bool {
+		if stats.Ports[i].Packets != stats.Ports[j].Packets {
+			return stats.Ports[i].Packets > stats.Ports[j].Packets
+		}
+		return stats.Ports[i].Port < stats.Ports[j].Port
+	})
+
+	nodeCounts := make(map[uint32]int)
+	for _, e := range c.entries {
+		if nodeID := e.pkt.GetData().GetFrom(); nodeID != 0 {
+			nodeCounts[nodeID]++
+		}
+	}
+	active := c.activeNodes()
+	stats.Nodes = make([]NodeOccupancy, 0, len(nodeCounts))
+	for nodeID, count := range nodeCounts {
+		node := NodeOccupancy{
+			Node:     nodes.FormatID(nodeID),
+			Packets:  count,
+			LastSeen: time.Unix(c.nodeLastSeen[nodeID], 0).UTC(),
+			Active:   active[nodeID],
+			Pinned:   c.policy.PinnedNodes[nodeID],
+		}
+		if role, ok := c.nodeRoles[nodeID]; ok {
+			node.Role = role.String()
+		}
+		stats.Nodes = append(stats.Nodes, node)
+	}
+	sort.Slice(stats.Nodes, func(i, j int) bool {
+		if stats.Nodes[i].Packets != stats.Nodes[j].Packets {
+			return stats.Nodes[i].Packets > stats.Nodes[j].Packets
+		}
+		return stats.Nodes[i].Node < stats.Nodes[j].Node
+	})
+	return stats
+}
+
 // ── Broker ────────────────────────────────────────────────────────────────────
 
-// Broker distributes messages from a source channel to multiple subscriber channels.
+// Broker distributes messages from a source channel to multiple subscriber
+// channels. Each subscriber has its own bounded buffer drained by its own
+// goroutine, so packets arrive in order and a slow subscriber only affects
+// itself; what happens when its buffer is full is set by its
+// SlowSubscriberPolicy.
 type Broker struct {
 	sourceChan      <-chan *meshtreampb.Packet
-	subscribers     map[chan *meshtreampb.Packet]struct{}
+	subscribers     map[<-chan *meshtreampb.Packet]*subscription
 	subscriberMutex sync.RWMutex
+	nextID          uint64
+	policy          SlowSubscriberPolicy // Default for subscribers that don't set one
 	done            chan struct{}
 	wg              sync.WaitGroup
 	logger          logging.Logger
-	cache           *NodeAwareCache
+	cache           *partitionedCache
+	firstSeq        uint64        // Sequence number of the first packet
+	lastSeq         atomic.Uint64 // Sequence number of the latest packet
+	lastPacket      atomic.Int64  // Unix nanoseconds when the latest packet arrived
 }
 
-// NewBroker creates a new broker. cacheSize is the global safety cap on total
-// retained packets; retention controls per-node eviction after silence.
+// NewBroker creates a new broker. cacheSize is the safety cap on retained
+// packets, per partition once SetCachePartitions splits the cache; retention
+// controls per-node eviction after silence.
+// Subscribers drop their newest packets when they fall behind, unless
+// SetSlowSubscriberPolicy says otherwise.
 func NewBroker(sourceChannel <-chan *meshtreampb.Packet, cacheSize int, retention time.Duration, logger logging.Logger) *Broker {
 	broker := &Broker{
 		sourceChan:  sourceChannel,
-		subscribers: make(map[chan *meshtreampb.Packet]struct{}),
+		subscribers: make(map[<-chan *meshtreampb.Packet]*subscription),
+		policy:      DropNewest,
 		done:        make(chan struct{}),
 		logger:      logger.Named("mqtt.broker"),
-		cache:       NewNodeAwareCache(cacheSize, retention),
+		cache:       newPartitionedCache(cacheSize, retention),
+		// Start numbering from the current time in microseconds, so sequence
+		// numbers from an earlier run are below this run's, as long as it
+		// handled fewer than a million packets a second.
+		firstSeq: uint64(time.Now().UnixMicro()),
 	}
+	broker.lastSeq.Store(broker.firstSeq - 1)
 
 	broker.wg.Add(1)
 	go broker.dispatchLoop()
@@ -308,52 +482,124 @@ func NewBroker(sourceChannel <-chan *meshtreampb.Packet, cacheSize int, retentio
 	return broker
 }
 
+// SetSlowSubscriberPolicy sets the policy for subscribers that don't choose
+// their own. It applies to subscriptions made afterwards.
+func (b *Broker) SetSlowSubscriberPolicy(policy SlowSubscriberPolicy) {
+	b.subscriberMutex.Lock()
+	defer b.subscriberMutex.Unlock()
+	b.policy = policy
+}
+
+// SetCachePolicy sets the cache's eviction policy.
+func (b *Broker) SetCachePolicy(policy CachePolicy) {
+	b.cache.setPolicy(policy)
+}
+
+// SetCachePartitions splits the cache into partitions, each with its own
+// budget. Call it before the first packet arrives; packets already cached
+// are dropped.
+func (b *Broker) SetCachePartitions(config PartitionConfig) {
+	b.cache.setConfig(config)
+}
+
 // Subscribe creates and returns a new subscriber channel. The subscriber
 // immediately receives all currently cached packets.
 func (b *Broker) Subscribe(bufferSize int) <-chan *meshtreampb.Packet {
-	subscriberChan := make(chan *meshtreampb.Packet, bufferSize)
+	return b.SubscribeWith(SubscribeOptions{BufferSize: bufferSize})
+}
+
+// CanResume reports whether a subscriber that last saw seq can resume with
+// SubscribeOptions.After without missing packets. It is false when seq is
+// unknown or packets after it have left the cache partitions accepted by
+// partitions, or any partition if it is nil.
+func (b *Broker) CanResume(seq uint64, partitions func(PartitionKey) bool) bool {
+	return seq+1 >= b.firstSeq && seq <= b.lastSeq.Load() && seq >= b.cache.MaxRemoved(partitions)
+}
+
+// LastSeq returns the sequence number of the latest packet.
+func (b *Broker) LastSeq() uint64 {
+	return b.lastSeq.Load()
+}
 
+// LastPacketTime returns when the latest packet arrived, or the zero time
+// before the first.
+func (b *Broker) LastPacketTime() time.Time {
+	if t := b.lastPacket.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// Backlog returns how many packets are waiting in the source channel.
+func (b *Broker) Backlog() int {
+	return len(b.sourceChan)
+}
+
+// SubscribeWith creates a subscriber channel with the given options. The
+// cache is snapshotted and the subscriber registered atomically with respect
+// to incoming packets, so every packet is delivered exactly once: cached
+// packets first, at the subscriber's own pace, then live ones buffered in the
+// meantime. The channel is closed by Unsubscribe, by Close, or when the
+// Disconnect policy drops the subscriber.
+func (b *Broker) SubscribeWith(opts SubscribeOptions) <-chan *meshtreampb.Packet {
 	b.subscriberMutex.Lock()
-	b.subscribers[subscriberChan] = struct{}{}
+	b.nextID++
+	if opts.Policy == "" {
+		opts.Policy = b.policy
+	}
+	sub := newSubscription(b.nextID, opts)
+	var cachedPackets []*meshtreampb.Packet
+	for _, packet := range b.cache.GetAll(opts.Partitions) {
+		if packet.GetSeq() > opts.After {
+			cachedPackets = append(cachedPackets, packet)
+		}
+	}
+	b.subscribers[sub.out] = sub
 	b.subscriberMutex.Unlock()
 
-	cachedPackets := b.cache.GetAll()
-	if len(cachedPackets) > 0 {
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					b.logger.Warn("Recovered from panic when sending cached packets, channel likely closed")
-				}
-			}()
-
-			for _, packet := range cachedPackets {
-				select {
-				case subscriberChan <- packet:
-				case <-time.After(cacheGracePeriod):
-					b.logger.Warn("Timeout when sending cached packet to new subscriber")
-					return
-				}
-			}
-		}()
+	go sub.run(cachedPackets)
+
+	return sub.out
+}
+
+// Subscribers returns buffer and drop statistics for each subscriber,
+// ordered by ID.
+func (b *Broker) Subscribers() []SubscriberStats {
+	b.subscriberMutex.RLock()
+	stats := make([]SubscriberStats, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		stats = append(stats, sub.stats())
 	}
+	b.subscriberMutex.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// CachedPackets returns the packets currently retained in the cache, in
+// arrival order.
+func (b *Broker) CachedPackets() []*meshtreampb.Packet {
+	return b.cache.GetAll(nil)
+}
 
-	return subscriberChan
+// CacheStats returns each cache partition's occupancy by port and by source
+// node.
+func (b *Broker) CacheStats() []CacheStats {
+	return b.cache.Stats()
 }
 
 // Unsubscribe removes a subscriber and closes its channel.
 func (b *Broker) Unsubscribe(ch <-chan *meshtreampb.Packet) {
 	b.subscriberMutex.Lock()
-	defer b.subscriberMutex.Unlock()
+	sub, ok := b.subscribers[ch]
+	delete(b.subscribers, ch)
+	b.subscriberMutex.Unlock()
 
-	for subCh := range b.subscribers {
-		if subCh == ch {
-			delete(b.subscribers, subCh)
-			close(subCh)
-			return
-		}
+	if !ok {
+		b.logger.Warn("Subscriber channel not found - cannot unsubscribe")
+		return
 	}
-
-	b.logger.Warn("Subscriber channel not found - cannot unsubscribe")
+	sub.close()
 }
 
 // Close shuts down the broker and closes all subscriber channels.
@@ -362,12 +608,13 @@ func (b *Broker) Close() {
 	b.wg.Wait()
 
 	b.subscriberMutex.Lock()
-	defer b.subscriberMutex.Unlock()
+	subscribers := b.subscribers
+	b.subscribers = make(map[<-chan *meshtreampb.Packet]*subscription)
+	b.subscriberMutex.Unlock()
 
-	for ch := range b.subscribers {
-		close(ch)
+	for _, sub := range subscribers {
+		sub.close()
 	}
-	b.subscribers = make(map[chan *meshtreampb.Packet]struct{})
 }
 
 // dispatchLoop continuously reads from the source channel and distributes to subscribers.
@@ -388,34 +635,39 @@ func (b *Broker) dispatchLoop() {
 				return
 			}
 
-			b.cache.Add(packet)
 			b.broadcast(packet)
 		}
 	}
 }
 
-// broadcast sends a packet to all active subscribers without blocking.
+// broadcast numbers and caches a packet, then buffers it for every subscriber
+// without blocking. Holding subscriberMutex throughout means a new subscriber
+// either finds the packet in its cache snapshot or receives it live, never
+// both or neither. Subscribers with the Disconnect policy and a full buffer
+// are removed.
 func (b *Broker) broadcast(packet *meshtreampb.Packet) {
+	var slow []*subscription
+
 	b.subscriberMutex.RLock()
-	subscribers := make([]chan *meshtreampb.Packet, 0, len(b.subscribers))
-	for ch := range b.subscribers {
-		subscribers = append(subscribers, ch)
+	packet.Seq = b.lastSeq.Load() + 1
+	b.cache.Add(packet)
+	b.lastSeq.Store(packet.Seq)
+	b.lastPacket.Store(time.Now().UnixNano())
+	for _, sub := range b.subscribers {
+		if !sub.push(packet) {
+			slow = append(slow, sub)
+		}
 	}
 	b.subscriberMutex.RUnlock()
 
-	for _, ch := range subscribers {
-		go func(ch chan *meshtreampb.Packet) {
-			defer func() {
-				if r := recover(); r != nil {
-					b.logger.Warn("Recovered from panic in broadcast, channel likely closed")
-				}
-			}()
-
-			select {
-			case ch <- packet:
-			default:
-				b.logger.Warn("Subscriber buffer full, dropping message")
-			}
-		}(ch)
+	for _, sub := range slow {
+		b.subscriberMutex.Lock()
+		_, ok := b.subscribers[sub.out]
+		delete(b.subscribers, sub.out)
+		b.subscriberMutex.Unlock()
+		if ok {
+			b.logger.Warnw("Disconnecting slow subscriber", "subscriber", sub.name, "buffered", len(sub.ring))
+			sub.close()
+		}
 	}
 }
@@ -14,9 +14,9 @@ import (
 
 // TestMain disables age protection globally so pressure tests run without
 // needing to advance mock clocks. Individual tests that specifically cover
-// age-based behaviour set minEvictAge themselves.
+// age-based behaviour set their cache's policy.MinAge themselves.
 func TestMain(m *testing.M) {
-	minEvictAge = 0
+	defaultCachePolicy.MinAge = 0
 	os.Exit(m.Run())
 }
 
@@ -168,12 +168,11 @@ func TestBeladyApproximation(t *testing.T) {
 	now = time.Unix(int64(90*time.Minute/time.Second), 0)
 	c.Add(pkt(3, 2, pb.PortNum_NODEINFO_APP)) // R resends, cache now at cap=3
 
-	// At T=2h: pressure. Both node 1 and node 2 have old packets (age > minEvictAge=0).
+	// At T=2h: pressure. Both node 1 and node 2 have old packets (age > MinAge).
 	// Among lowest-priority (both are NODEINFO), evict from node 2 (most recently active
 	// at T=90m) rather than node 1 (last seen T=0).
 	now = time.Unix(int64(2*time.Hour/time.Second), 0)
-	minEvictAge = time.Hour
-	t.Cleanup(func() { minEvictAge = 0 })
+	c.policy.MinAge = time.Hour
 
 	c.Add(pkt(4, 3, pb.PortNum_NODEINFO_APP)) // new node — triggers eviction
 
@@ -203,15 +202,13 @@ func TestBeladyApproximation(t *testing.T) {
 	}
 }
 
-// TestMinAgeProtectsRecentPackets verifies that packets younger than minEvictAge
-// are never evicted even under heavy cap pressure.
+// TestMinAgeProtectsRecentPackets verifies that the cap is still enforced
+// when every packet is younger than the policy's MinAge.
 func TestMinAgeProtectsRecentPackets(t *testing.T) {
-	minEvictAge = time.Hour
-	t.Cleanup(func() { minEvictAge = 0 })
-
 	now := time.Unix(0, 0)
 	c := NewNodeAwareCache(3, 24*time.Hour)
 	c.nowFunc = func() time.Time { return now }
+	c.policy.MinAge = time.Hour
 
 	// Three packets at T=0 fill the cache.
 	for i := uint32(1); i <= 3; i++ {
@@ -615,53 +612,156 @@ func TestBrokerPriorityEvictionOnReplay(t *testing.T) {
 	}
 }
 
-// TestSubscriberBufferFull verifies that when cache replay times out due to a
-// full subscriber buffer, live packets can still be delivered once there is room.
-func TestSubscriberBufferFull(t *testing.T) {
-	orig := cacheGracePeriod
-	cacheGracePeriod = time.Millisecond // short enough to time out on packet 2+
-	t.Cleanup(func() { cacheGracePeriod = orig })
-
+// TestBrokerReplayIsPaced verifies that the cache replay waits for a slow
+// subscriber instead of being cut short, is bracketed by markers, and is
+// followed by the live packets that arrived meanwhile.
+func TestBrokerReplayIsPaced(t *testing.T) {
 	sourceChan := make(chan *meshtreampb.Packet, 10)
 	broker := newTestBroker(sourceChan, 100)
 	defer broker.Close()
 
 	for i := uint32(1); i <= 5; i++ {
 		sourceChan <- pkt(i, i, pb.PortNum_NODEINFO_APP)
-		time.Sleep(10 * time.Millisecond)
 	}
+	time.Sleep(10 * time.Millisecond)
 
-	small := broker.Subscribe(1)
+	small := broker.SubscribeWith(SubscribeOptions{BufferSize: 1, Markers: true})
+	sourceChan <- pkt(99, 99, pb.PortNum_NODEINFO_APP)
+	time.Sleep(50 * time.Millisecond)
 
-	// Read the first cached packet to confirm replay started.
-	select {
-	case <-small:
-	case <-time.After(500 * time.Millisecond):
-		t.Error("timed out waiting for first cached packet")
-		return
+	want := []*meshtreampb.Packet{ReplayStart, nil, nil, nil, nil, nil, ReplayEnd, nil}
+	wantIDs := []uint32{0, 1, 2, 3, 4, 5, 0, 99}
+	for i := range want {
+		select {
+		case p := <-small:
+			if want[i] != nil && p != want[i] {
+				t.Fatalf("pos %d: expected a replay marker, got %v", i, p)
+			}
+			if want[i] == nil && p.GetData().GetId() != wantIDs[i] {
+				t.Fatalf("pos %d: want ID %d, got %v", i, wantIDs[i], p)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("timed out waiting for position %d", i)
+		}
 	}
+}
 
-	// Wait for the cache goroutine to finish (timeout = 1 ms; 10 ms is ample).
-	time.Sleep(10 * time.Millisecond)
+// TestBrokerSubscribeIsAtomic verifies that subscribers joining while packets
+// flow receive every packet exactly once, across the replay/live handoff.
+func TestBrokerSubscribeIsAtomic(t *testing.T) {
+	const n = 2000
+	sourceChan := make(chan *meshtreampb.Packet)
+	broker := newTestBroker(sourceChan, n)
+	defer broker.Close()
 
-	// Drain any residual cached packets that squeezed in before the timeout.
-	drained := false
-	for !drained {
-		select {
-		case <-small:
-		default:
-			drained = true
+	go func() {
+		for i := uint32(1); i <= n; i++ {
+			sourceChan <- pkt(i, 0, pb.PortNum_UNKNOWN_APP)
 		}
+	}()
+
+	var wg sync.WaitGroup
+	for s := 0; s < 10; s++ {
+		sub := broker.Subscribe(n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := uint32(1)
+			for want <= n {
+				select {
+				case p := <-sub:
+					if p.Data.Id != want {
+						t.Errorf("sub %d: want %d, got %d", s, want, p.Data.Id)
+						return
+					}
+					want++
+				case <-time.After(time.Second):
+					t.Errorf("sub %d: timed out waiting for %d", s, want)
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Millisecond)
 	}
+	wg.Wait()
+}
 
-	// Buffer is now empty — a new live packet should be deliverable.
-	sourceChan <- pkt(99, 99, pb.PortNum_NODEINFO_APP)
-	select {
-	case p := <-small:
-		if p.Data.Id != 99 {
-			t.Errorf("want live packet ID 99, got %d", p.Data.Id)
+// TestBrokerResume verifies that a resuming subscriber only receives
+// packets after its last sequence number, and that unknown or evicted
+// sequence numbers fall back to a full replay.
+func TestBrokerResume(t *testing.T) {
+	sourceChan := make(chan *meshtreampb.Packet, 10)
+	broker := newTestBroker(sourceChan, 3)
+	defer broker.Close()
+	// A fixed clock makes the oldest packet the eviction target.
+	broker.cache.nowFunc = func() time.Time { return time.Unix(1000, 0) }
+
+	for i := uint32(1); i <= 3; i++ {
+		sourceChan <- pkt(i, i, pb.PortNum_NODEINFO_APP)
+		time.Sleep(10 * time.Millisecond)
+	}
+	cached := broker.CachedPackets()
+	if len(cached) != 3 {
+		t.Fatalf("expected 3 cached packets, got %d", len(cached))
+	}
+	for i := 1; i < len(cached); i++ {
+		if cached[i].Seq != cached[i-1].Seq+1 {
+			t.Errorf("sequence numbers not consecutive: %d then %d", cached[i-1].Seq, cached[i].Seq)
 		}
-	case <-time.After(100 * time.Millisecond):
-		t.Error("timed out waiting for live packet")
+	}
+	if broker.LastSeq() != cached[2].Seq {
+		t.Errorf("LastSeq: want %d, got %d", cached[2].Seq, broker.LastSeq())
+	}
+
+	receive := func(sub <-chan *meshtreampb.Packet, n int) []uint32 {
+		t.Helper()
+		var received []uint32
+		for i := 0; i < n; i++ {
+			select {
+			case p := <-sub:
+				received = append(received, p.Data.Id)
+			case <-time.After(200 * time.Millisecond):
+				t.Fatalf("timed out waiting for packet %d, got %v", i+1, received)
+			}
+		}
+		return received
+	}
+
+	// Resume the way the SSE handler does.
+	subscribeAfter := func(seq uint64) (<-chan *meshtreampb.Packet, bool) {
+		if !broker.CanResume(seq, nil) {
+			return broker.Subscribe(10), false
+		}
+		return broker.SubscribeWith(SubscribeOptions{BufferSize: 10, After: seq}), true
+	}
+
+	sub, ok := subscribeAfter(cached[0].Seq)
+	if !ok {
+		t.Fatal("expected resume after a cached packet to succeed")
+	}
+	if got := receive(sub, 2); got[0] != 2 || got[1] != 3 {
+		t.Errorf("want packets 2 and 3, got %v", got)
+	}
+
+	for _, seq := range []uint64{cached[2].Seq + 1, 42} {
+		sub, ok = subscribeAfter(seq)
+		if ok {
+			t.Errorf("expected resume after unknown seq %d to report a gap", seq)
+		}
+		receive(sub, 3)
+	}
+
+	// Evicting packet 1 means a client that last saw it is still whole, but one
+	// that saw nothing after the packet before it has missed it.
+	sourceChan <- pkt(4, 4, pb.PortNum_NODEINFO_APP)
+	time.Sleep(10 * time.Millisecond)
+	if got := ids(broker.CachedPackets()); got[0] != 2 {
+		t.Fatalf("expected packet 1 to be evicted, cache holds %v", got)
+	}
+	if !broker.CanResume(cached[0].Seq, nil) {
+		t.Error("expected resume after the evicted packet to succeed")
+	}
+	if broker.CanResume(cached[0].Seq-1, nil) {
+		t.Error("expected resume before the evicted packet to report a gap")
 	}
 }
@@ -0,0 +1,198 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"meshstream/decoder"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
+)
+
+// CachePolicy decides which packets NodeAwareCache gives up first when it is
+// full. See NodeAwareCache for how the fields are applied.
+type CachePolicy struct {
+	MinAge          time.Duration      // Packets younger than this are only evicted if nothing older is left
+	DefaultPriority int                // Priority of ports not in Priorities
+	Priorities      map[pb.PortNum]int // Higher values are evicted later
+	Quotas          map[pb.PortNum]int // Most packets of a port kept at once
+	RoleBoosts      []RoleBoost
+	PinnedNodes     map[uint32]bool // Nodes whose packets are never evicted or pruned
+}
+
+// RoleBoost raises the priority of packets from nodes with certain device
+// roles, learned from their NODEINFO.
+type RoleBoost struct {
+	Roles    map[pb.Config_DeviceConfig_Role]bool
+	Ports    map[pb.PortNum]bool // Empty means every port
+	Priority int                 // Packets get at least this priority
+}
+
+// defaultCachePolicy favours rare, high-value packet types (chat, neighbor
+// info) over frequent ones (node info, telemetry), and protects the state of
+// router nodes, which transmit far less often than clients.
+var defaultCachePolicy = CachePolicy{
+	MinAge:          time.Hour,
+	DefaultPriority: 1,
+	Priorities: map[pb.PortNum]int{
+		pb.PortNum_TEXT_MESSAGE_APP:            5, // chat — preserve history
+		pb.PortNum_TEXT_MESSAGE_COMPRESSED_APP: 5,
+		pb.PortNum_NEIGHBORINFO_APP:            4, // rare; protect from eviction
+		pb.PortNum_TRACEROUTE_APP:              3,
+		pb.PortNum_POSITION_APP:                3,
+		pb.PortNum_NODEINFO_APP:                2, // frequent; lower priority
+		pb.PortNum_TELEMETRY_APP:               2,
+		pb.PortNum_ROUTING_APP:                 2,
+		pb.PortNum_MAP_REPORT_APP:              2,
+	},
+	RoleBoosts: []RoleBoost{{
+		Roles: map[pb.Config_DeviceConfig_Role]bool{
+			pb.Config_DeviceConfig_ROUTER:        true,
+			pb.Config_DeviceConfig_ROUTER_CLIENT: true,
+			pb.Config_DeviceConfig_ROUTER_LATE:   true,
+		},
+		Ports: map[pb.PortNum]bool{
+			pb.PortNum_POSITION_APP: true,
+			pb.PortNum_NODEINFO_APP: true,
+		},
+		Priority: 4, // same tier as NEIGHBORINFO_APP
+	}},
+}
+
+// DefaultCachePolicy returns the policy used when none is configured.
+func DefaultCachePolicy() CachePolicy {
+	return defaultCachePolicy
+}
+
+// CachePolicyConfig is the cache policy file. Unset fields keep their
+// defaults, and priorities are merged over the default priorities:
+//
+//	min_age: 30m
+//	priorities:
+//	  telemetry: 6
+//	  text: 3
+//	quotas:
+//	  nodeinfo: 500
+//	role_boosts:
+//	  - roles: [ROUTER, ROUTER_LATE]
+//	    ports: [position, nodeinfo]
+//	    priority: 7
+//	pinned_nodes: ["!abcd1234"]
+type CachePolicyConfig struct {
+	MinAge          *time.Duration    `yaml:"min_age"`          // default: 1h
+	DefaultPriority *int              `yaml:"default_priority"` // default: 1
+	Priorities      map[string]int    `yaml:"priorities"`       // Port name or number → priority
+	Quotas          map[string]int    `yaml:"quotas"`           // Port name or number → packets
+	RoleBoosts      []RoleBoostConfig `yaml:"role_boosts"`      // Replaces the default router boost when set
+	PinnedNodes     []string          `yaml:"pinned_nodes"`     // Node IDs
+}
+
+// RoleBoostConfig declares a RoleBoost.
+type RoleBoostConfig struct {
+	Roles    []string `yaml:"roles"` // Device roles, e.g. ROUTER
+	Ports    []string `yaml:"ports"` // Port names or numbers; empty means every port
+	Priority int      `yaml:"priority"`
+}
+
+// LoadCachePolicy reads a YAML cache policy file.
+func LoadCachePolicy(path string) (CachePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CachePolicy{}, err
+	}
+	return ParseCachePolicy(data)
+}
+
+// ParseCachePolicy parses a YAML cache policy document. Unknown keys are
+// rejected so that a typo doesn't silently fall back to a default.
+func ParseCachePolicy(data []byte) (CachePolicy, error) {
+	var config CachePolicyConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return CachePolicy{}, fmt.Errorf("invalid cache policy: %v", err)
+	}
+	return config.Compile()
+}
+
+// Compile validates the policy and merges it over the default policy.
+func (c *CachePolicyConfig) Compile() (CachePolicy, error) {
+	policy := DefaultCachePolicy()
+	if c.MinAge != nil {
+		if *c.MinAge < 0 {
+			return CachePolicy{}, fmt.Errorf("cache policy: min_age must not be negative")
+		}
+		policy.MinAge = *c.MinAge
+	}
+	if c.DefaultPriority != nil {
+		policy.DefaultPriority = *c.DefaultPriority
+	}
+
+	policy.Priorities = make(map[pb.PortNum]int)
+	for port, priority := range defaultCachePolicy.Priorities {
+		policy.Priorities[port] = priority
+	}
+	for name, priority := range c.Priorities {
+		port, err := decoder.ParsePort(name)
+		if err != nil {
+			return CachePolicy{}, fmt.Errorf("cache policy priorities: %v", err)
+		}
+		policy.Priorities[port] = priority
+	}
+
+	policy.Quotas = make(map[pb.PortNum]int)
+	for name, quota := range c.Quotas {
+		port, err := decoder.ParsePort(name)
+		if err != nil {
+			return CachePolicy{}, fmt.Errorf("cache policy quotas: %v", err)
+		}
+		if quota < 1 {
+			return CachePolicy{}, fmt.Errorf("cache policy quotas: %s must be at least 1", name)
+		}
+		policy.Quotas[port] = quota
+	}
+
+	if c.RoleBoosts != nil {
+		policy.RoleBoosts = nil
+		for i, b := range c.RoleBoosts {
+			boost := RoleBoost{
+				Roles:    make(map[pb.Config_DeviceConfig_Role]bool),
+				Ports:    make(map[pb.PortNum]bool),
+				Priority: b.Priority,
+			}
+			if len(b.Roles) == 0 {
+				return CachePolicy{}, fmt.Errorf("cache policy role boost %d: roles are required", i+1)
+			}
+			for _, name := range b.Roles {
+				role, ok := pb.Config_DeviceConfig_Role_value[strings.ToUpper(name)]
+				if !ok {
+					return CachePolicy{}, fmt.Errorf("cache policy role boost %d: unknown role %q", i+1, name)
+				}
+				boost.Roles[pb.Config_DeviceConfig_Role(role)] = true
+			}
+			for _, name := range b.Ports {
+				port, err := decoder.ParsePort(name)
+				if err != nil {
+					return CachePolicy{}, fmt.Errorf("cache policy role boost %d: %v", i+1, err)
+				}
+				boost.Ports[port] = true
+			}
+			policy.RoleBoosts = append(policy.RoleBoosts, boost)
+		}
+	}
+
+	pinned, err := nodes.ParseIDs(c.PinnedNodes)
+	if err != nil {
+		return CachePolicy{}, fmt.Errorf("cache policy pinned_nodes: %v", err)
+	}
+	policy.PinnedNodes = make(map[uint32]bool)
+	for _, num := range pinned {
+		policy.PinnedNodes[num] = true
+	}
+	return policy, nil
+}
@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+func TestParseCachePolicy(t *testing.T) {
+	policy, err := ParseCachePolicy([]byte(`
+min_age: 30m
+priorities:
+  telemetry: 6
+  text: 3
+quotas:
+  nodeinfo: 2
+role_boosts:
+  - roles: [client_mute]
+    ports: [position]
+    priority: 7
+pinned_nodes: ["!00000009"]
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if policy.MinAge != 30*time.Minute {
+		t.Errorf("expected min_age 30m, got %v", policy.MinAge)
+	}
+	if policy.Priorities[pb.PortNum_TELEMETRY_APP] != 6 || policy.Priorities[pb.PortNum_TEXT_MESSAGE_APP] != 3 {
+		t.Errorf("expected overridden priorities, got %v", policy.Priorities)
+	}
+	if policy.Priorities[pb.PortNum_NEIGHBORINFO_APP] != 4 {
+		t.Errorf("expected default priorities to be kept, got %v", policy.Priorities)
+	}
+	if policy.Quotas[pb.PortNum_NODEINFO_APP] != 2 {
+		t.Errorf("unexpected quotas %v", policy.Quotas)
+	}
+	if len(policy.RoleBoosts) != 1 || !policy.RoleBoosts[0].Roles[pb.Config_DeviceConfig_CLIENT_MUTE] {
+		t.Errorf("expected the role boosts to replace the default, got %+v", policy.RoleBoosts)
+	}
+	if !policy.PinnedNodes[9] {
+		t.Errorf("expected node 9 to be pinned, got %v", policy.PinnedNodes)
+	}
+	if defaultCachePolicy.Priorities[pb.PortNum_TELEMETRY_APP] != 2 {
+		t.Error("parsing must not modify the default policy")
+	}
+
+	invalid := map[string]string{
+		"unknown key":      "min_age: 1h\nmax_age: 2h\n",
+		"negative min_age": "min_age: -1h\n",
+		"unknown port":     "priorities:\n  bogus: 3\n",
+		"zero quota":       "quotas:\n  telemetry: 0\n",
+		"unknown role":     "role_boosts:\n  - roles: [overlord]\n    priority: 3\n",
+		"boost no roles":   "role_boosts:\n  - ports: [position]\n    priority: 3\n",
+		"bad node":         "pinned_nodes: [\"!xyz\"]\n",
+	}
+	for name, doc := range invalid {
+		if _, err := ParseCachePolicy([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestCacheQuota verifies that a port over its quota gives up its own packets
+// while other ports are untouched.
+func TestCacheQuota(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewNodeAwareCache(100, time.Hour)
+	c.nowFunc = func() time.Time { return now }
+	c.policy.Quotas = map[pb.PortNum]int{pb.PortNum_TELEMETRY_APP: 2}
+
+	c.Add(pkt(1, 1, pb.PortNum_TELEMETRY_APP))
+	c.Add(pkt(2, 2, pb.PortNum_NODEINFO_APP))
+	now = now.Add(time.Minute)
+	c.Add(pkt(3, 2, pb.PortNum_TELEMETRY_APP))
+	now = now.Add(time.Minute)
+	c.policy.MinAge = time.Minute
+	c.Add(pkt(4, 3, pb.PortNum_TELEMETRY_APP))
+
+	// Packet 4 is too recent to evict; of the older telemetry packets, node
+	// 2's goes as node 2 sent more recently than node 1.
+	got := ids(c.GetAll())
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 4 {
+		t.Errorf("expected [1 2 4], got %v", got)
+	}
+}
+
+// TestCachePinnedNodes verifies that pinned nodes' packets survive eviction
+// and retention pruning.
+func TestCachePinnedNodes(t *testing.T) {
+	now := time.Now()
+	c := NewNodeAwareCache(3, time.Hour)
+	c.nowFunc = func() time.Time { return now }
+	c.policy.PinnedNodes = map[uint32]bool{1: true}
+
+	c.Add(pkt(1, 1, pb.PortNum_UNKNOWN_APP)) // lowest priority, but pinned
+	c.Add(pkt(2, 2, pb.PortNum_UNKNOWN_APP))
+
+	now = now.Add(2 * time.Hour)
+	c.Add(pkt(3, 3, pb.PortNum_TEXT_MESSAGE_APP))
+	c.Add(pkt(4, 3, pb.PortNum_TEXT_MESSAGE_APP))
+	c.Add(pkt(5, 3, pb.PortNum_TEXT_MESSAGE_APP))
+
+	got := ids(c.GetAll())
+	if len(got) != 3 || got[0] != 1 {
+		t.Errorf("expected the pinned node's packet to survive, got %v", got)
+	}
+}
+
+// TestCacheRoleBoost verifies that a configured boost protects the boosted
+// role's packets and that a node losing the role loses the boost.
+func TestCacheRoleBoost(t *testing.T) {
+	c := NewNodeAwareCache(3, time.Hour)
+	c.policy.RoleBoosts = []RoleBoost{{
+		Roles:    map[pb.Config_DeviceConfig_Role]bool{pb.Config_DeviceConfig_TRACKER: true},
+		Priority: 9,
+	}}
+	nodeInfo := func(id, from uint32, role pb.Config_DeviceConfig_Role) *meshtreampb.Packet {
+		p := pkt(id, from, pb.PortNum_NODEINFO_APP)
+		p.Data.Payload = &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{Role: role}}
+		return p
+	}
+
+	c.Add(nodeInfo(1, 1, pb.Config_DeviceConfig_TRACKER))
+	c.Add(pkt(2, 2, pb.PortNum_TEXT_MESSAGE_APP))
+	c.Add(pkt(3, 3, pb.PortNum_TEXT_MESSAGE_APP))
+	c.Add(pkt(4, 4, pb.PortNum_TEXT_MESSAGE_APP))
+	if got := ids(c.GetAll()); got[0] != 1 {
+		t.Fatalf("expected the tracker's node info to outlive chat, got %v", got)
+	}
+
+	c.Add(nodeInfo(5, 1, pb.Config_DeviceConfig_CLIENT))
+	if got := ids(c.GetAll()); len(got) != 3 || got[0] == 1 {
+		t.Errorf("expected the node info to lose its boost, got %v", got)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := NewNodeAwareCache(10, time.Hour)
+	c.policy.Quotas = map[pb.PortNum]int{pb.PortNum_TELEMETRY_APP: 5}
+	c.Add(routerNodeInfoPkt(1, 1))
+	c.Add(pkt(2, 2, pb.PortNum_TELEMETRY_APP))
+	c.Add(pkt(3, 2, pb.PortNum_TELEMETRY_APP))
+	c.Add(pkt(4, 0, pb.PortNum_ROUTING_APP))
+
+	stats := c.Stats()
+	if stats.Size != 4 || stats.Capacity != 10 {
+		t.Errorf("unexpected size %d/%d", stats.Size, stats.Capacity)
+	}
+	if len(stats.Ports) != 3 || stats.Ports[0].Port != "TELEMETRY_APP" || stats.Ports[0].Packets != 2 || stats.Ports[0].Quota != 5 {
+		t.Errorf("unexpected ports %+v", stats.Ports)
+	}
+	if len(stats.Nodes) != 2 || stats.Nodes[0].Node != "!00000002" || stats.Nodes[0].Packets != 2 {
+		t.Fatalf("unexpected nodes %+v", stats.Nodes)
+	}
+	if stats.Nodes[1].Role != "ROUTER" || !stats.Nodes[1].Active {
+		t.Errorf("expected node 1 to be an active router, got %+v", stats.Nodes[1])
+	}
+}
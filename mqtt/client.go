@@ -1,15 +1,24 @@
 package mqtt
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/dpup/prefab/logging"
+	"github.com/eclipse/paho.golang/autopaho"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 
 	"meshstream/decoder"
 	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/health"
 )
 
 // Config holds configuration for the MQTT client
@@ -19,7 +28,7 @@ type Config struct {
 	Username string
 	Password string
 	ClientID string
-	Topic    string
+	Topic    string // Topic filter to subscribe to; empty for publish-only clients
 
 	// Connection tuning parameters
 	KeepAlive        int           // Keep alive interval in seconds (default: 60)
@@ -27,7 +36,108 @@ type Config struct {
 	PingTimeout      time.Duration // Ping timeout (default: 10s)
 	MaxReconnectTime time.Duration // Maximum time between reconnect attempts (default: 5m)
 	UseTLS           bool          // Whether to use TLS/SSL (default: false)
-	TLSPort          int           // TLS port to use if UseTLS is true (default: 8883)
+	TLSPort          int           // TLS port to use if UseTLS is true (default: 8883, or 443 over WebSocket)
+
+	// Transport settings
+	Transport     string // "tcp" (default) or "websocket"
+	Port          int    // Port to use if UseTLS is false (default: 1883, or 80 over WebSocket)
+	WebSocketPath string // HTTP path of the WebSocket endpoint (default: /mqtt)
+
+	// TLS settings, used if UseTLS is true
+	CAFile             string // PEM bundle of CAs to verify the broker with instead of the system roots
+	CertFile           string // PEM client certificate for mutual TLS
+	KeyFile            string // PEM private key of CertFile
+	ServerName         string // Name expected in the broker's certificate and sent as SNI (default: Broker)
+	InsecureSkipVerify bool   // Don't verify the broker's certificate; for testing only
+
+	// Protocol settings
+	ProtocolVersion int           // 3 for MQTT 3.1.1 (default) or 5 for MQTT v5
+	ShareGroup      string        // Subscribe as $share/<group>/<topic>, so each message goes to one client of the group
+	SessionExpiry   time.Duration // v5: how long the broker keeps the session and queues messages after a disconnect; 0 ends it on disconnect
+	Origin          string        // v5: value of the origin user property added to publishes (default: ClientID)
+}
+
+// Transports supported by Config.Transport.
+const (
+	TransportTCP       = "tcp"
+	TransportWebSocket = "websocket"
+)
+
+// brokerURL returns the URL paho connects to, e.g. ssl://host:8883 or
+// wss://host:443/mqtt.
+func (c Config) brokerURL() (string, error) {
+	var scheme string
+	var port int
+	switch c.Transport {
+	case "", TransportTCP:
+		scheme, port = "tcp", 1883
+		if c.UseTLS {
+			scheme, port = "ssl", 8883
+		}
+	case TransportWebSocket:
+		scheme, port = "ws", 80
+		if c.UseTLS {
+			scheme, port = "wss", 443
+		}
+	default:
+		return "", fmt.Errorf("unsupported MQTT transport %q, expected tcp or websocket", c.Transport)
+	}
+	if c.UseTLS && c.TLSPort > 0 {
+		port = c.TLSPort
+	}
+	if !c.UseTLS && c.Port > 0 {
+		port = c.Port
+	}
+
+	u := url.URL{Scheme: scheme, Host: c.Broker + ":" + strconv.Itoa(port)}
+	if c.Transport == TransportWebSocket {
+		u.Path = "/mqtt"
+		if c.WebSocketPath != "" {
+			u.Path = "/" + strings.TrimPrefix(c.WebSocketPath, "/")
+		}
+	}
+	return u.String(), nil
+}
+
+// tlsConfig builds the TLS settings of the connection, or returns nil if TLS
+// is off.
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if !c.UseTLS {
+		if c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != "" || c.InsecureSkipVerify {
+			return nil, fmt.Errorf("MQTT TLS options are set but TLS is off")
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		config.ServerName = c.Broker
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading MQTT CA file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("MQTT client certificates need both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading MQTT client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
 }
 
 // Client manages the MQTT connection and message processing
@@ -39,7 +149,12 @@ type Client struct {
 	logger          logging.Logger
 	isConnected     bool
 	connectionMutex sync.RWMutex
+	health          health.Tracker // How long the connection has been down
 	healthCheckStop chan struct{}
+
+	// MQTT v5 connection, used instead of client if ProtocolVersion is 5
+	v5       *autopaho.ConnectionManager
+	cancelV5 context.CancelFunc
 }
 
 // NewClient creates a new MQTT client with the provided configuration
@@ -76,35 +191,56 @@ func (c *Client) Connect() error {
 		maxReconnectTime = c.config.MaxReconnectTime
 	}
 
-	// Determine protocol and port
-	protocol := "tcp"
-	port := 1883
-	if c.config.UseTLS {
-		protocol = "ssl"
-		port = 8883
-		if c.config.TLSPort > 0 {
-			port = c.config.TLSPort
-		}
+	// Determine protocol, port and TLS settings
+	brokerURL, err := c.config.brokerURL()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := c.config.tlsConfig()
+	if err != nil {
+		return err
+	}
+	switch c.config.ProtocolVersion {
+	case 0, 3, 5:
+	default:
+		return fmt.Errorf("unsupported MQTT protocol version %d, expected 3 or 5", c.config.ProtocolVersion)
+	}
+	if c.config.ProtocolVersion != 5 && (c.config.SessionExpiry > 0 || c.config.Origin != "") {
+		return fmt.Errorf("MQTT session expiry and origin need protocol version 5")
 	}
 
 	// Log detailed connection settings
 	c.logger.Infow("Connecting to MQTT broker with settings",
 		"broker", c.config.Broker,
-		"port", port,
-		"protocol", protocol,
+		"url", brokerURL,
 		"clientID", c.config.ClientID,
 		"username", c.config.Username,
 		"passwordLength", len(c.config.Password),
-		"topic", c.config.Topic,
+		"topic", c.config.subscriptionTopic(),
+		"protocolVersion", max(c.config.ProtocolVersion, 3),
+		"sessionExpiry", c.config.SessionExpiry,
 		"keepAlive", keepAlive,
 		"connectTimeout", connectTimeout,
 		"pingTimeout", pingTimeout,
 		"maxReconnectTime", maxReconnectTime,
 		"useTLS", c.config.UseTLS,
+		"caFile", c.config.CAFile,
+		"clientCert", c.config.CertFile != "",
+		"insecureSkipVerify", c.config.InsecureSkipVerify,
 	)
+	if c.config.InsecureSkipVerify {
+		c.logger.Warn("MQTT broker certificate verification is disabled")
+	}
+
+	if c.config.ProtocolVersion == 5 {
+		return c.connectV5(brokerURL, tlsConfig, keepAlive, connectTimeout, maxReconnectTime)
+	}
 
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("%s://%s:%d", protocol, c.config.Broker, port))
+	opts.AddBroker(brokerURL)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 	opts.SetClientID(c.config.ClientID)
 	opts.SetUsername(c.config.Username)
 	opts.SetPassword(c.config.Password)
@@ -134,6 +270,7 @@ func (c *Client) Connect() error {
 	// Initial connection status
 	c.connectionMutex.Lock()
 	c.isConnected = true
+	c.health.Success()
 	c.connectionMutex.Unlock()
 
 	// Start health check
@@ -155,11 +292,32 @@ func (c *Client) Disconnect() {
 	}
 
 	close(c.done)
-	token := c.client.Unsubscribe(c.config.Topic)
-	token.Wait()
+	if c.v5 != nil {
+		c.disconnectV5()
+		return
+	}
+	if c.config.Topic != "" {
+		token := c.client.Unsubscribe(c.config.subscriptionTopic())
+		token.Wait()
+	}
 	c.client.Disconnect(250)
 }
 
+// Publish sends a payload to the broker on the given topic with QoS 0
+func (c *Client) Publish(topic string, payload []byte, retained bool) error {
+	if c.v5 != nil {
+		return c.publishV5(topic, payload, retained)
+	}
+	token := c.client.Publish(topic, 0, retained, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("error publishing to %s: %v", topic, err)
+	}
+	return nil
+}
+
 // Messages returns a channel of decoded messages
 // The consumer should read from this channel to receive decoded messages
 func (c *Client) Messages() <-chan *meshtreampb.Packet {
@@ -173,6 +331,11 @@ func (c *Client) IsConnected() bool {
 	return c.isConnected
 }
 
+// Health reports how long the connection has been down, if it is.
+func (c *Client) Health() health.Status {
+	return c.health.Status()
+}
+
 // monitorConnectionHealth periodically checks the connection status
 // and logs warnings if the connection appears to be down
 func (c *Client) monitorConnectionHealth(interval time.Duration) {
@@ -195,6 +358,7 @@ func (c *Client) monitorConnectionHealth(interval time.Duration) {
 				// Update our internal connection state
 				c.connectionMutex.Lock()
 				c.isConnected = false
+				c.health.Failure()
 				c.connectionMutex.Unlock()
 
 				// If we've had too many consecutive failures, try to force reconnection
@@ -223,6 +387,7 @@ func (c *Client) monitorConnectionHealth(interval time.Duration) {
 				// Update our internal connection state
 				c.connectionMutex.Lock()
 				c.isConnected = true
+				c.health.Success()
 				c.connectionMutex.Unlock()
 			}
 		case <-c.healthCheckStop:
@@ -235,46 +400,60 @@ func (c *Client) monitorConnectionHealth(interval time.Duration) {
 func (c *Client) messageHandler(client mqtt.Client, msg mqtt.Message) {
 	c.logger.Debugf("Received message from topic: %s", msg.Topic())
 
+	packet := decodeTopicMessage(msg.Topic(), msg.Payload(), c.logger)
+	if packet == nil {
+		return
+	}
+	c.deliver(packet)
+}
+
+// deliver hands a decoded packet to the consumer of Messages.
+func (c *Client) deliver(packet *meshtreampb.Packet) {
+	// Send the decoded message to the channel, but don't block if buffer is full
+	select {
+	case c.decodedMessages <- packet:
+		// Message sent successfully
+	case <-c.done:
+		// Client is shutting down
+		return
+	default:
+		// Channel buffer is full, log a warning and drop the message
+		c.logger.Warn("Message buffer full, dropping message")
+	}
+}
+
+// decodeTopicMessage parses an MQTT topic and decodes its payload into a
+// packet. It returns nil for messages in formats that are not decoded.
+func decodeTopicMessage(topic string, payload []byte, logger logging.Logger) *meshtreampb.Packet {
 	// Parse the topic structure
-	topicInfo, err := decoder.ParseTopic(msg.Topic())
+	topicInfo, err := decoder.ParseTopic(topic)
 	if err != nil {
-		c.logger.Errorw("Error parsing topic",
+		logger.Errorw("Error parsing topic",
 			"error", err,
-			"topic", msg.Topic(),
-			"payload_hex", fmt.Sprintf("%x", msg.Payload()),
+			"topic", topic,
+			"payload_hex", fmt.Sprintf("%x", payload),
 		)
-		return
+		return nil
 	}
 
 	// Process different message formats
 	switch topicInfo.Format {
 	case "e", "c", "map":
 		// Binary encoded protobuf message
-		data := decoder.DecodeMessage(msg.Payload(), topicInfo)
+		data := decoder.DecodeMessage(payload, topicInfo)
 
 		// Create packet with both the data and topic info
-		packet := NewPacket(data, topicInfo)
-
-		// Send the decoded message to the channel, but don't block if buffer is full
-		select {
-		case c.decodedMessages <- packet:
-			// Message sent successfully
-		case <-c.done:
-			// Client is shutting down
-			return
-		default:
-			// Channel buffer is full, log a warning and drop the message
-			c.logger.Warn("Message buffer full, dropping message")
-		}
+		return NewPacket(data, topicInfo)
 
 	case "json":
 		// TODO: Add support for JSON format messages in the future
-		c.logger.Debugf("Ignoring JSON format message from topic: %s", msg.Topic())
+		logger.Debugf("Ignoring JSON format message from topic: %s", topic)
 
 	default:
 		// Unsupported format, log and ignore
-		c.logger.Infow("Unsupported format", "format", topicInfo.Format, "topic", msg.Topic())
+		logger.Infow("Unsupported format", "format", topicInfo.Format, "topic", topic)
 	}
+	return nil
 }
 
 // connectHandler is called when the client connects to the broker
@@ -284,14 +463,27 @@ func (c *Client) connectHandler(client mqtt.Client) {
 		"clientID", c.config.ClientID,
 		"topic", c.config.Topic)
 
+	// Mark the connection as up again right away after a reconnection,
+	// rather than at the next health check
+	c.connectionMutex.Lock()
+	c.isConnected = true
+	c.health.Success()
+	c.connectionMutex.Unlock()
+
+	// Publish-only clients (such as an upstream bridge) have no topic
+	if c.config.Topic == "" {
+		return
+	}
+
 	// Subscribe to the configured topic after each reconnection
-	token := client.Subscribe(c.config.Topic, 0, nil)
+	topic := c.config.subscriptionTopic()
+	token := client.Subscribe(topic, 0, nil)
 	if token.Wait() && token.Error() != nil {
 		c.logger.Errorw("Failed to subscribe to topic on reconnect",
 			"error", token.Error(),
-			"topic", c.config.Topic)
+			"topic", topic)
 	} else {
-		c.logger.Infof("Successfully (re)subscribed to topic: %s", c.config.Topic)
+		c.logger.Infof("Successfully (re)subscribed to topic: %s", topic)
 	}
 }
 
@@ -306,6 +498,7 @@ func (c *Client) connectionLostHandler(client mqtt.Client, err error) {
 	// Update connection status
 	c.connectionMutex.Lock()
 	c.isConnected = false
+	c.health.Failure()
 	c.connectionMutex.Unlock()
 }
 
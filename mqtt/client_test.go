@@ -1,6 +1,15 @@
 package mqtt
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -72,3 +81,120 @@ func TestMessagesChannel(t *testing.T) {
 		t.Fatal("Timed out waiting for message from channel")
 	}
 }
+
+func TestBrokerURL(t *testing.T) {
+	tests := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{Broker: "mqtt.example.com"}, "tcp://mqtt.example.com:1883"},
+		{Config{Broker: "mqtt.example.com", Port: 1884}, "tcp://mqtt.example.com:1884"},
+		{Config{Broker: "mqtt.example.com", UseTLS: true}, "ssl://mqtt.example.com:8883"},
+		{Config{Broker: "mqtt.example.com", UseTLS: true, TLSPort: 8884, Port: 1884}, "ssl://mqtt.example.com:8884"},
+		{Config{Broker: "mqtt.example.com", Transport: TransportWebSocket}, "ws://mqtt.example.com:80/mqtt"},
+		{Config{Broker: "mqtt.example.com", Transport: TransportWebSocket, UseTLS: true, WebSocketPath: "ws/mqtt"}, "wss://mqtt.example.com:443/ws/mqtt"},
+		{Config{Broker: "mqtt.example.com", Transport: TransportWebSocket, Port: 9001, WebSocketPath: "/"}, "ws://mqtt.example.com:9001/"},
+	}
+	for _, tt := range tests {
+		url, err := tt.config.brokerURL()
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt.config, err)
+		} else if url != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, url)
+		}
+	}
+
+	if _, err := (Config{Broker: "mqtt.example.com", Transport: "quic"}).brokerURL(); err == nil {
+		t.Error("expected an error for an unknown transport")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	config, err := Config{Broker: "mqtt.example.com"}.tlsConfig()
+	if err != nil || config != nil {
+		t.Errorf("expected no TLS config when TLS is off, got %v, %v", config, err)
+	}
+	if _, err := (Config{CAFile: certFile}).tlsConfig(); err == nil {
+		t.Error("expected an error for TLS options without TLS")
+	}
+
+	config, err = Config{Broker: "mqtt.example.com", UseTLS: true}.tlsConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.ServerName != "mqtt.example.com" || config.RootCAs != nil || len(config.Certificates) != 0 {
+		t.Errorf("expected system roots and the broker as server name, got %+v", config)
+	}
+
+	config, err = Config{
+		Broker:     "10.0.0.5",
+		UseTLS:     true,
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "broker.internal",
+	}.tlsConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.ServerName != "broker.internal" {
+		t.Errorf("expected server name broker.internal, got %s", config.ServerName)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 {
+		t.Errorf("expected the CA bundle and client certificate to be loaded, got %+v", config)
+	}
+
+	invalid := map[string]Config{
+		"missing key":   {UseTLS: true, CertFile: certFile},
+		"missing CA":    {UseTLS: true, CAFile: filepath.Join(dir, "missing.pem")},
+		"CA is not PEM": {UseTLS: true, CAFile: keyFile + ".txt"},
+		"key mismatch":  {UseTLS: true, CertFile: certFile, KeyFile: certFile},
+	}
+	if err := os.WriteFile(keyFile+".txt", []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range invalid {
+		if _, err := c.tlsConfig(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM
+// files and returns their paths.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "broker.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
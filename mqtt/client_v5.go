@@ -0,0 +1,191 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// OriginProperty is the MQTT v5 user property naming the instance or gateway
+// that published a message. It is recorded as TopicInfo.Origin.
+const OriginProperty = "origin"
+
+// subscriptionTopic returns the topic filter to subscribe to, as a shared
+// subscription if ShareGroup is set.
+func (c Config) subscriptionTopic() string {
+	if c.ShareGroup == "" || c.Topic == "" {
+		return c.Topic
+	}
+	return "$share/" + c.ShareGroup + "/" + c.Topic
+}
+
+// subscriptionQoS returns the QoS to subscribe with. A persistent session
+// only queues messages for the client while it is away at QoS 1 or above.
+func (c Config) subscriptionQoS() byte {
+	if c.SessionExpiry > 0 {
+		return 1
+	}
+	return 0
+}
+
+// origin returns the value of the origin property added to publishes.
+func (c Config) origin() string {
+	if c.Origin != "" {
+		return c.Origin
+	}
+	return c.ClientID
+}
+
+// connectV5 connects with MQTT v5 through paho.golang's autopaho, which
+// reconnects and resubscribes by itself.
+func (c *Client) connectV5(brokerURL string, tlsConfig *tls.Config, keepAlive int, connectTimeout, maxReconnectTime time.Duration) error {
+	serverURL, err := url.Parse(brokerURL)
+	if err != nil {
+		return fmt.Errorf("invalid MQTT broker URL %s: %v", brokerURL, err)
+	}
+	if c.config.SessionExpiry > time.Duration(^uint32(0))*time.Second {
+		return fmt.Errorf("MQTT session expiry %s is too long", c.config.SessionExpiry)
+	}
+
+	config := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{serverURL},
+		TlsCfg:                        tlsConfig,
+		KeepAlive:                     uint16(keepAlive),
+		CleanStartOnInitialConnection: c.config.SessionExpiry == 0,
+		SessionExpiryInterval:         uint32(c.config.SessionExpiry / time.Second),
+		ConnectTimeout:                connectTimeout,
+		ReconnectBackoff: func(attempt int) time.Duration {
+			if attempt == 0 {
+				return 0
+			}
+			return min(time.Second<<min(attempt-1, 16), maxReconnectTime)
+		},
+		ConnectUsername: c.config.Username,
+		ConnectPassword: []byte(c.config.Password),
+		OnConnectionUp:  c.connectionUpV5,
+		OnConnectionDown: func() bool {
+			c.connectionLostHandler(nil, fmt.Errorf("connection down"))
+			return true
+		},
+		OnConnectError: func(err error) {
+			c.logger.Warnw("Failed to connect to MQTT broker, retrying",
+				"error", err,
+				"broker", c.config.Broker,
+				"clientID", c.config.ClientID)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:          c.config.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){c.messageHandlerV5},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ctx, config)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("error connecting to MQTT broker: %v", err)
+	}
+
+	connectCtx, cancelConnect := context.WithTimeout(ctx, connectTimeout)
+	defer cancelConnect()
+	if err := cm.AwaitConnection(connectCtx); err != nil {
+		cancel()
+		c.logger.Errorw("Failed to connect to MQTT broker",
+			"error", err,
+			"broker", c.config.Broker,
+			"clientID", c.config.ClientID)
+		return fmt.Errorf("error connecting to MQTT broker: %v", err)
+	}
+
+	c.v5, c.cancelV5 = cm, cancel
+	return nil
+}
+
+// connectionUpV5 subscribes after each (re)connection. autopaho requires it
+// not to block, so the subscription is made in the background.
+func (c *Client) connectionUpV5(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+	c.logger.Infow("Connected to MQTT Broker",
+		"broker", c.config.Broker,
+		"clientID", c.config.ClientID,
+		"topic", c.config.subscriptionTopic(),
+		"sessionPresent", connack.SessionPresent)
+
+	c.connectionMutex.Lock()
+	c.isConnected = true
+	c.health.Success()
+	c.connectionMutex.Unlock()
+
+	// Publish-only clients (such as an upstream bridge) have no topic
+	if c.config.Topic == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		topic := c.config.subscriptionTopic()
+		_, err := cm.Subscribe(ctx, &paho.Subscribe{
+			Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: c.config.subscriptionQoS()}},
+		})
+		if err != nil {
+			c.logger.Errorw("Failed to subscribe to topic on reconnect",
+				"error", err,
+				"topic", topic)
+			return
+		}
+		c.logger.Infof("Successfully (re)subscribed to topic: %s", topic)
+	}()
+}
+
+// messageHandlerV5 decodes a received MQTT v5 message and records its origin.
+func (c *Client) messageHandlerV5(received paho.PublishReceived) (bool, error) {
+	publish := received.Packet
+	c.logger.Debugf("Received message from topic: %s", publish.Topic)
+
+	packet := decodeTopicMessage(publish.Topic, publish.Payload, c.logger)
+	if packet == nil {
+		return true, nil
+	}
+	if publish.Properties != nil {
+		packet.Info.Origin = publish.Properties.User.Get(OriginProperty)
+	}
+	c.deliver(packet)
+	return true, nil
+}
+
+// publishV5 publishes with the origin user property set.
+func (c *Client) publishV5(topic string, payload []byte, retained bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	properties := &paho.PublishProperties{}
+	properties.User.Add(OriginProperty, c.config.origin())
+	_, err := c.v5.Publish(ctx, &paho.Publish{
+		Topic:      topic,
+		Retain:     retained,
+		Payload:    payload,
+		Properties: properties,
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing to %s: %v", topic, err)
+	}
+	return nil
+}
+
+// disconnectV5 unsubscribes, unless the session should outlive the
+// process, and disconnects.
+func (c *Client) disconnectV5() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if c.config.Topic != "" && c.config.SessionExpiry == 0 {
+		_, _ = c.v5.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{c.config.subscriptionTopic()}})
+	}
+	if err := c.v5.Disconnect(ctx); err != nil {
+		c.logger.Warnw("Error disconnecting from MQTT broker", "error", err)
+	}
+	c.cancelV5()
+}
@@ -0,0 +1,120 @@
+package mqtt
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+func TestSubscriptionTopic(t *testing.T) {
+	if topic := (Config{Topic: "msh/US/#"}).subscriptionTopic(); topic != "msh/US/#" {
+		t.Errorf("expected the plain topic, got %s", topic)
+	}
+	if topic := (Config{Topic: "msh/US/#", ShareGroup: "meshstream"}).subscriptionTopic(); topic != "$share/meshstream/msh/US/#" {
+		t.Errorf("expected a shared subscription, got %s", topic)
+	}
+	if topic := (Config{ShareGroup: "meshstream"}).subscriptionTopic(); topic != "" {
+		t.Errorf("expected no subscription for a publish-only client, got %s", topic)
+	}
+}
+
+func TestConnectValidatesProtocol(t *testing.T) {
+	logger := logging.NewDevLogger().Named("test")
+	invalid := map[string]Config{
+		"unknown version":         {Broker: "127.0.0.1", ProtocolVersion: 4},
+		"session expiry with 3.1": {Broker: "127.0.0.1", SessionExpiry: time.Hour},
+		"origin with 3.1":         {Broker: "127.0.0.1", Origin: "replica-a"},
+	}
+	for name, config := range invalid {
+		if err := NewClient(config, logger).Connect(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// connectV5Client connects an MQTT v5 client to a local broker.
+func connectV5Client(t *testing.T, addr string, config Config) *Client {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Broker = host
+	config.Port, _ = strconv.Atoi(port)
+	config.ProtocolVersion = 5
+	config.ConnectTimeout = 2 * time.Second
+	client := NewClient(config, logging.NewDevLogger().Named("test"))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("failed to connect %s: %v", config.ClientID, err)
+	}
+	t.Cleanup(client.Disconnect)
+	return client
+}
+
+func TestClientV5SharedSubscription(t *testing.T) {
+	broker := startEmbeddedBroker(t, EmbeddedConfig{})
+
+	replicas := []*Client{
+		connectV5Client(t, broker.Addr(), Config{ClientID: "replica-1", Topic: "msh/US/#", ShareGroup: "meshstream"}),
+		connectV5Client(t, broker.Addr(), Config{ClientID: "replica-2", Topic: "msh/US/#", ShareGroup: "meshstream"}),
+	}
+	publisher := connectV5Client(t, broker.Addr(), Config{ClientID: "bridge", Origin: "gateway-bridge"})
+
+	// Wait for the subscriptions, which are made in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && sharedSubscribers(broker, "msh/US/2/e/LongFast/!0000abcd") < len(replicas) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	const messages = 10
+	for i := range messages {
+		if err := publisher.Publish("msh/US/2/e/LongFast/!0000abcd", textEnvelope(t, uint32(i+1), 0x1234, "hello"), false); err != nil {
+			t.Fatalf("publish failed: %v", err)
+		}
+	}
+
+	received := make(map[uint32]int)
+	timeout := time.After(3 * time.Second)
+	for len(received) < messages {
+		var packet *meshtreampb.Packet
+		select {
+		case packet = <-replicas[0].Messages():
+		case packet = <-replicas[1].Messages():
+		case <-timeout:
+			t.Fatalf("received %d of %d messages", len(received), messages)
+		}
+		if packet.GetInfo().GetOrigin() != "gateway-bridge" {
+			t.Errorf("expected origin gateway-bridge, got %q", packet.GetInfo().GetOrigin())
+		}
+		received[packet.GetData().GetId()]++
+	}
+
+	// Each message goes to exactly one member of the group
+	select {
+	case packet := <-replicas[0].Messages():
+		t.Errorf("unexpected duplicate delivery of %d", packet.GetData().GetId())
+	case packet := <-replicas[1].Messages():
+		t.Errorf("unexpected duplicate delivery of %d", packet.GetData().GetId())
+	case <-time.After(200 * time.Millisecond):
+	}
+	for id, count := range received {
+		if count != 1 {
+			t.Errorf("message %d delivered %d times", id, count)
+		}
+	}
+}
+
+// sharedSubscribers counts the clients with a shared subscription matching
+// the topic.
+func sharedSubscribers(broker *EmbeddedBroker, topic string) int {
+	count := 0
+	for _, group := range broker.server.Topics.Subscribers(topic).Shared {
+		count += len(group)
+	}
+	return count
+}
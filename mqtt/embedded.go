@@ -0,0 +1,246 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dpup/prefab/logging"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+// bridgeQueueSize is how many publishes may wait to be forwarded upstream
+// before new ones are dropped.
+const bridgeQueueSize = 1000
+
+// EmbeddedUser is an account that gateways use to connect to the embedded broker.
+type EmbeddedUser struct {
+	Username string
+	Password string
+	Topics   []string // Topic filters the user may publish and subscribe to; empty allows all
+}
+
+// Publisher forwards raw MQTT messages to another broker.
+type Publisher interface {
+	Publish(topic string, payload []byte, retained bool) error
+}
+
+// EmbeddedConfig holds configuration for the in-process MQTT broker
+type EmbeddedConfig struct {
+	ListenAddr string         // TCP address gateways connect to (default: ":1883")
+	Users      []EmbeddedUser // Accounts allowed to connect; empty allows anonymous access
+	Bridge     Publisher      // Optional upstream broker that received publishes are forwarded to
+}
+
+// EmbeddedBroker runs an MQTT broker inside the meshstream process. Meshtastic
+// gateways publish to it directly and every publish is decoded in-process,
+// without a loopback client subscription.
+type EmbeddedBroker struct {
+	config          EmbeddedConfig
+	server          *mochi.Server
+	decodedMessages chan *meshtreampb.Packet
+	bridgeQueue     chan bridgeMessage // Publishes waiting to be forwarded upstream
+	bridgeDropped   atomic.Uint64      // Publishes not forwarded because the queue was full
+	done            chan struct{}
+	closeOnce       sync.Once
+	logger          logging.Logger
+}
+
+// NewEmbeddedBroker creates an embedded broker with the provided configuration
+func NewEmbeddedBroker(config EmbeddedConfig, logger logging.Logger) *EmbeddedBroker {
+	if config.ListenAddr == "" {
+		config.ListenAddr = ":1883"
+	}
+	return &EmbeddedBroker{
+		config:          config,
+		decodedMessages: make(chan *meshtreampb.Packet, 100),
+		bridgeQueue:     make(chan bridgeMessage, bridgeQueueSize),
+		done:            make(chan struct{}),
+		logger:          logger.Named("mqtt.embedded"),
+	}
+}
+
+// Start begins listening for gateway connections
+func (e *EmbeddedBroker) Start() error {
+	e.server = mochi.New(&mochi.Options{
+		InlineClient: false,
+		Logger:       slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+
+	if err := e.addAuthHook(); err != nil {
+		return fmt.Errorf("error configuring embedded broker auth: %v", err)
+	}
+	if err := e.server.AddHook(&publishHook{broker: e}, nil); err != nil {
+		return fmt.Errorf("error adding embedded broker publish hook: %v", err)
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: e.config.ListenAddr})
+	if err := e.server.AddListener(tcp); err != nil {
+		return fmt.Errorf("error listening on %s: %v", e.config.ListenAddr, err)
+	}
+
+	if e.config.Bridge != nil {
+		go e.bridgeLoop()
+	}
+
+	e.logger.Infow("Starting embedded MQTT broker",
+		"address", tcp.Address(),
+		"users", len(e.config.Users),
+		"bridge", e.config.Bridge != nil,
+	)
+
+	// Serve starts the listeners in the background and returns immediately
+	return e.server.Serve()
+}
+
+// Addr returns the address the broker is listening on
+func (e *EmbeddedBroker) Addr() string {
+	if e.server == nil {
+		return e.config.ListenAddr
+	}
+	if l, ok := e.server.Listeners.Get("tcp"); ok {
+		return l.Address()
+	}
+	return e.config.ListenAddr
+}
+
+// Messages returns a channel of decoded messages
+func (e *EmbeddedBroker) Messages() <-chan *meshtreampb.Packet {
+	return e.decodedMessages
+}
+
+// BridgeDropped returns how many publishes weren't forwarded upstream because
+// the bridge fell behind.
+func (e *EmbeddedBroker) BridgeDropped() uint64 {
+	return e.bridgeDropped.Load()
+}
+
+// Close stops the broker and disconnects all gateways
+func (e *EmbeddedBroker) Close() {
+	e.closeOnce.Do(func() {
+		close(e.done)
+		if e.server != nil {
+			if err := e.server.Close(); err != nil {
+				e.logger.Warnw("Error closing embedded broker", "error", err)
+			}
+		}
+	})
+}
+
+// addAuthHook installs username/password authentication and per-user topic
+// ACLs. Without configured users the broker accepts anonymous connections.
+func (e *EmbeddedBroker) addAuthHook() error {
+	if len(e.config.Users) == 0 {
+		e.logger.Warn("Embedded broker has no users configured, allowing anonymous access")
+		return e.server.AddHook(new(auth.AllowHook), nil)
+	}
+
+	ledger := &auth.Ledger{
+		Users: auth.Users{},
+		ACL:   auth.ACLRules{},
+	}
+	for _, user := range e.config.Users {
+		ledger.Users[user.Username] = auth.UserRule{
+			Username: auth.RString(user.Username),
+			Password: auth.RString(user.Password),
+		}
+		if len(user.Topics) == 0 {
+			continue
+		}
+
+		// Topics outside the user's filters are denied rather than falling
+		// through to the ledger's allow-by-default behaviour.
+		filters := auth.Filters{"#": auth.Deny}
+		for _, topic := range user.Topics {
+			filters[auth.RString(topic)] = auth.ReadWrite
+		}
+		ledger.ACL = append(ledger.ACL, auth.ACLRule{
+			Username: auth.RString(user.Username),
+			Filters:  filters,
+		})
+	}
+
+	return e.server.AddHook(new(auth.Hook), &auth.Options{Ledger: ledger})
+}
+
+// handlePublish decodes a publish received from a gateway and forwards it to
+// the upstream bridge if one is configured.
+func (e *EmbeddedBroker) handlePublish(clientID, topic string, payload []byte) {
+	e.logger.Debugf("Received message from gateway %s on topic: %s", clientID, topic)
+
+	if e.config.Bridge != nil {
+		// Publishing upstream can wait on a slow or reconnecting broker, which
+		// would stall the gateway's connection, so it happens in bridgeLoop.
+		select {
+		case e.bridgeQueue <- bridgeMessage{topic: topic, payload: payload}:
+		default:
+			dropped := e.bridgeDropped.Add(1)
+			e.logger.Warnw("Bridge queue full, dropping message", "topic", topic, "dropped", dropped)
+		}
+	}
+
+	packet := decodeTopicMessage(topic, payload, e.logger)
+	if packet == nil {
+		return
+	}
+
+	select {
+	case e.decodedMessages <- packet:
+	case <-e.done:
+	default:
+		e.logger.Warn("Message buffer full, dropping message")
+	}
+}
+
+// bridgeMessage is a publish waiting to be forwarded upstream.
+type bridgeMessage struct {
+	topic   string
+	payload []byte
+}
+
+// bridgeLoop forwards queued publishes to the upstream bridge until the
+// broker is closed.
+func (e *EmbeddedBroker) bridgeLoop() {
+	for {
+		select {
+		case <-e.done:
+			return
+		case msg := <-e.bridgeQueue:
+			if err := e.config.Bridge.Publish(msg.topic, msg.payload, false); err != nil {
+				e.logger.Warnw("Failed to bridge message upstream", "error", err, "topic", msg.topic)
+			}
+		}
+	}
+}
+
+// publishHook feeds every publish accepted by the embedded broker into the
+// decoder.
+type publishHook struct {
+	mochi.HookBase
+	broker *EmbeddedBroker
+}
+
+// ID returns the ID of the hook.
+func (h *publishHook) ID() string {
+	return "meshstream-decoder"
+}
+
+// Provides indicates which hook methods this hook provides.
+func (h *publishHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mochi.OnPublished}, []byte{b})
+}
+
+// OnPublished is called after a client's publish has passed ACL checks.
+func (h *publishHook) OnPublished(cl *mochi.Client, pk packets.Packet) {
+	// Copy the payload since it outlives the hook call.
+	payload := append([]byte(nil), pk.Payload...)
+	h.broker.handlePublish(cl.ID, pk.TopicName, payload)
+}
@@ -0,0 +1,190 @@
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"google.golang.org/protobuf/proto"
+
+	pb "meshstream/generated/meshtastic"
+)
+
+// recordingPublisher captures messages forwarded to the upstream bridge.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (r *recordingPublisher) Publish(topic string, payload []byte, retained bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics = append(r.topics, topic)
+	return nil
+}
+
+func (r *recordingPublisher) Topics() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.topics...)
+}
+
+// textEnvelope builds an encoded ServiceEnvelope carrying a decoded text message.
+func textEnvelope(t *testing.T, id, from uint32, text string) []byte {
+	t.Helper()
+	payload, err := proto.Marshal(&pb.ServiceEnvelope{
+		ChannelId: "LongFast",
+		GatewayId: "!0000abcd",
+		Packet: &pb.MeshPacket{
+			Id:   id,
+			From: from,
+			To:   0xffffffff,
+			PayloadVariant: &pb.MeshPacket_Decoded{
+				Decoded: &pb.Data{Portnum: pb.PortNum_TEXT_MESSAGE_APP, Payload: []byte(text)},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal envelope: %v", err)
+	}
+	return payload
+}
+
+func startEmbeddedBroker(t *testing.T, config EmbeddedConfig) *EmbeddedBroker {
+	t.Helper()
+	config.ListenAddr = "127.0.0.1:0"
+	broker := NewEmbeddedBroker(config, logging.NewDevLogger().Named("test"))
+	if err := broker.Start(); err != nil {
+		t.Fatalf("failed to start embedded broker: %v", err)
+	}
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+func connectGateway(t *testing.T, addr, username, password string) (paho.Client, error) {
+	t.Helper()
+	opts := paho.NewClientOptions()
+	opts.AddBroker("tcp://" + addr)
+	opts.SetClientID(fmt.Sprintf("gateway-%d", time.Now().UnixNano()))
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetConnectTimeout(2 * time.Second)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	token.Wait()
+	if token.Error() == nil {
+		t.Cleanup(func() { client.Disconnect(0) })
+	}
+	return client, token.Error()
+}
+
+func TestEmbeddedBrokerDecodesPublishes(t *testing.T) {
+	bridge := &recordingPublisher{}
+	broker := startEmbeddedBroker(t, EmbeddedConfig{
+		Users:  []EmbeddedUser{{Username: "gw", Password: "secret"}},
+		Bridge: bridge,
+	})
+
+	gateway, err := connectGateway(t, broker.Addr(), "gw", "secret")
+	if err != nil {
+		t.Fatalf("gateway failed to connect: %v", err)
+	}
+
+	topic := "msh/US/bayarea/2/e/LongFast/!0000abcd"
+	gateway.Publish(topic, 0, false, textEnvelope(t, 7, 42, "hello mesh")).Wait()
+
+	select {
+	case packet := <-broker.Messages():
+		if packet.GetData().GetTextMessage() != "hello mesh" {
+			t.Errorf("want text %q, got %q", "hello mesh", packet.GetData().GetTextMessage())
+		}
+		if packet.GetInfo().GetChannel() != "LongFast" {
+			t.Errorf("want channel LongFast, got %q", packet.GetInfo().GetChannel())
+		}
+		if packet.GetData().GetFrom() != 42 {
+			t.Errorf("want from 42, got %d", packet.GetData().GetFrom())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for decoded packet")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(bridge.Topics()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := bridge.Topics(); len(got) != 1 || got[0] != topic {
+		t.Errorf("expected publish bridged upstream on %s, got %v", topic, got)
+	}
+}
+
+// stalledPublisher blocks every publish until it is released, like an
+// upstream broker that is down.
+type stalledPublisher struct {
+	release chan struct{}
+}
+
+func (s *stalledPublisher) Publish(topic string, payload []byte, retained bool) error {
+	<-s.release
+	return nil
+}
+
+func TestEmbeddedBrokerDropsWhenBridgeStalls(t *testing.T) {
+	bridge := &stalledPublisher{release: make(chan struct{})}
+	broker := startEmbeddedBroker(t, EmbeddedConfig{Bridge: bridge})
+	defer close(bridge.release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// One publish is held by the stalled bridge and the queue fills up
+		for i := 0; i < bridgeQueueSize+3; i++ {
+			broker.handlePublish("gateway", "msh/US/2/e/LongFast/!0000abcd", nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publishes blocked on the stalled bridge")
+	}
+	if dropped := broker.BridgeDropped(); dropped < 2 || dropped > 3 {
+		t.Errorf("expected 2 or 3 dropped publishes, got %d", dropped)
+	}
+}
+
+func TestEmbeddedBrokerRejectsBadPassword(t *testing.T) {
+	broker := startEmbeddedBroker(t, EmbeddedConfig{
+		Users: []EmbeddedUser{{Username: "gw", Password: "secret"}},
+	})
+
+	if _, err := connectGateway(t, broker.Addr(), "gw", "wrong"); err == nil {
+		t.Error("expected connection with wrong password to be rejected")
+	}
+}
+
+func TestEmbeddedBrokerEnforcesTopicACL(t *testing.T) {
+	broker := startEmbeddedBroker(t, EmbeddedConfig{
+		Users: []EmbeddedUser{{Username: "gw", Password: "secret", Topics: []string{"msh/US/bayarea/#"}}},
+	})
+
+	gateway, err := connectGateway(t, broker.Addr(), "gw", "secret")
+	if err != nil {
+		t.Fatalf("gateway failed to connect: %v", err)
+	}
+
+	// Outside the ACL: must not reach the decoder.
+	gateway.Publish("msh/EU/2/e/LongFast/!0000abcd", 0, false, textEnvelope(t, 1, 1, "denied")).Wait()
+	// Inside the ACL.
+	gateway.Publish("msh/US/bayarea/2/e/LongFast/!0000abcd", 0, false, textEnvelope(t, 2, 1, "allowed")).Wait()
+
+	select {
+	case packet := <-broker.Messages():
+		if packet.GetData().GetTextMessage() != "allowed" {
+			t.Errorf("expected only the allowed topic to be decoded, got %q", packet.GetData().GetTextMessage())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for decoded packet")
+	}
+}
@@ -0,0 +1,280 @@
+package mqtt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+// PartitionBy selects how the broker splits its cache.
+type PartitionBy string
+
+const (
+	// PartitionNone keeps every packet in one cache.
+	PartitionNone PartitionBy = "none"
+	// PartitionRegion keeps a cache per region path, e.g. US/bayarea.
+	PartitionRegion PartitionBy = "region"
+	// PartitionChannel keeps a cache per channel, e.g. LongFast.
+	PartitionChannel PartitionBy = "channel"
+	// PartitionRegionChannel keeps a cache per channel of each region.
+	PartitionRegionChannel PartitionBy = "region+channel"
+)
+
+// ParsePartitionBy parses a partitioning mode by name. An empty name is
+// PartitionNone.
+func ParsePartitionBy(name string) (PartitionBy, error) {
+	switch by := PartitionBy(name); by {
+	case "":
+		return PartitionNone, nil
+	case PartitionNone, PartitionRegion, PartitionChannel, PartitionRegionChannel:
+		return by, nil
+	}
+	return "", fmt.Errorf("unknown cache partitioning %q, should be none, region, channel or region+channel", name)
+}
+
+// PartitionKey identifies a cache partition. Fields the broker doesn't
+// partition by are empty.
+type PartitionKey struct {
+	Region  string
+	Channel string
+}
+
+// String returns the key as "region", "channel" or "region:channel",
+// the form used for partition budgets.
+func (k PartitionKey) String() string {
+	if k.Region != "" && k.Channel != "" {
+		return k.Region + ":" + k.Channel
+	}
+	return k.Region + k.Channel
+}
+
+// PartitionConfig controls how the broker splits its cache.
+type PartitionConfig struct {
+	By    PartitionBy
+	Size  int            // Budget of partitions not listed in Sizes
+	Sizes map[string]int // PartitionKey.String() → budget
+	Max   int            // Most partitions at once; those listed in Sizes are always kept (0: no limit)
+}
+
+const (
+	// sweepInterval is how often empty partitions are dropped.
+	sweepInterval = time.Minute
+	// removedKeys bounds the partitions whose removed packets are tracked
+	// once they are no longer cached.
+	removedKeys = 1000
+)
+
+// partitionedCache is a set of NodeAwareCaches, created as packets for them
+// arrive, so that busy regions or channels can't evict a quiet one's history.
+// Partition keys come from MQTT topics, which anyone publishing to the broker
+// controls, so partitions left empty by retention are dropped and past the
+// configured maximum, packets of new partitions aren't cached.
+type partitionedCache struct {
+	mu         sync.RWMutex
+	config     PartitionConfig
+	retention  time.Duration
+	policy     CachePolicy
+	partitions map[PartitionKey]*NodeAwareCache
+	removed    map[PartitionKey]uint64 // Highest sequence number of each dropped partition, or of its uncached packets
+	forgotten  uint64                  // Highest sequence number of entries no longer in removed
+	lastSweep  time.Time
+	nowFunc    func() time.Time // injectable for testing
+}
+
+func newPartitionedCache(size int, retention time.Duration) *partitionedCache {
+	return &partitionedCache{
+		config:     PartitionConfig{By: PartitionNone, Size: size},
+		retention:  retention,
+		policy:     DefaultCachePolicy(),
+		partitions: make(map[PartitionKey]*NodeAwareCache),
+		removed:    make(map[PartitionKey]uint64),
+		nowFunc:    time.Now,
+	}
+}
+
+// setConfig changes the partitioning. Packets already cached are dropped,
+// so it should be called before the first packet arrives.
+func (c *partitionedCache) setConfig(config PartitionConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if config.By == "" {
+		config.By = PartitionNone
+	}
+	c.config = config
+	c.partitions = make(map[PartitionKey]*NodeAwareCache)
+	c.removed = make(map[PartitionKey]uint64)
+	c.forgotten = 0
+}
+
+// setPolicy sets the eviction policy of every partition.
+func (c *partitionedCache) setPolicy(policy CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+	for _, partition := range c.partitions {
+		partition.SetPolicy(policy)
+	}
+}
+
+// key returns the partition a packet belongs to.
+// Must be called with c.mu held.
+func (c *partitionedCache) key(packet *meshtreampb.Packet) PartitionKey {
+	info := packet.GetInfo()
+	switch c.config.By {
+	case PartitionRegion:
+		return PartitionKey{Region: info.GetRegionPath()}
+	case PartitionChannel:
+		return PartitionKey{Channel: info.GetChannel()}
+	case PartitionRegionChannel:
+		return PartitionKey{Region: info.GetRegionPath(), Channel: info.GetChannel()}
+	}
+	return PartitionKey{}
+}
+
+// Add records a packet in its partition, creating the partition if needed.
+func (c *partitionedCache) Add(packet *meshtreampb.Packet) {
+	c.mu.RLock()
+	partition := c.partitions[c.key(packet)]
+	if partition != nil && c.nowFunc().Sub(c.lastSweep) < sweepInterval {
+		// The read lock keeps a sweep from dropping the partition meanwhile.
+		partition.Add(packet)
+		c.mu.RUnlock()
+		return
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nowFunc().Sub(c.lastSweep) >= sweepInterval {
+		c.sweep()
+	}
+	key := c.key(packet)
+	if partition := c.partition(key); partition != nil {
+		partition.Add(packet)
+	} else {
+		c.remove(key, packet.GetSeq())
+	}
+}
+
+// remove records that packets of a partition up to seq are not cached, so
+// that only subscribers that could match the partition can't resume before
+// it. Past removedKeys partitions, the older half are forgotten and count
+// for every subscriber.
+// Must be called with c.mu held for writing.
+func (c *partitionedCache) remove(key PartitionKey, seq uint64) {
+	c.removed[key] = max(c.removed[key], seq)
+	if len(c.removed) <= removedKeys {
+		return
+	}
+	seqs := make([]uint64, 0, len(c.removed))
+	for _, seq := range c.removed {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	cutoff := seqs[len(seqs)/2]
+	for key, seq := range c.removed {
+		if seq <= cutoff {
+			c.forgotten = max(c.forgotten, seq)
+			delete(c.removed, key)
+		}
+	}
+}
+
+// partition returns the partition for a key, creating it unless that would
+// exceed the maximum.
+// Must be called with c.mu held for writing.
+func (c *partitionedCache) partition(key PartitionKey) *NodeAwareCache {
+	if partition := c.partitions[key]; partition != nil {
+		return partition
+	}
+	size, ok := c.config.Sizes[key.String()]
+	if !ok {
+		if c.config.Max > 0 && len(c.partitions) >= c.config.Max {
+			return nil
+		}
+		size = c.config.Size
+	}
+	partition := NewNodeAwareCache(size, c.retention)
+	partition.policy = c.policy
+	partition.nowFunc = c.nowFunc
+	c.partitions[key] = partition
+	return partition
+}
+
+// sweep drops partitions left empty once silent nodes are pruned.
+// Must be called with c.mu held for writing.
+func (c *partitionedCache) sweep() {
+	c.lastSweep = c.nowFunc()
+	for key, partition := range c.partitions {
+		if partition.prune() == 0 {
+			c.remove(key, partition.MaxRemoved())
+			delete(c.partitions, key)
+		}
+	}
+}
+
+// selected returns the partitions accepted by match (nil accepts all).
+func (c *partitionedCache) selected(match func(PartitionKey) bool) []*NodeAwareCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	partitions := make([]*NodeAwareCache, 0, len(c.partitions))
+	for key, partition := range c.partitions {
+		if match == nil || match(key) {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+// GetAll returns the packets of the partitions accepted by match (nil
+// accepts all) in arrival order. See NodeAwareCache.GetAll.
+func (c *partitionedCache) GetAll(match func(PartitionKey) bool) []*meshtreampb.Packet {
+	partitions := c.selected(match)
+	if len(partitions) == 1 {
+		return partitions[0].GetAll()
+	}
+	var packets []*meshtreampb.Packet
+	for _, partition := range partitions {
+		packets = append(packets, partition.GetAll()...)
+	}
+	if packets == nil {
+		return []*meshtreampb.Packet{}
+	}
+	sort.Slice(packets, func(i, j int) bool { return packets[i].GetSeq() < packets[j].GetSeq() })
+	return packets
+}
+
+// MaxRemoved returns the highest sequence number removed from the partitions
+// accepted by match (nil accepts all), or not cached.
+func (c *partitionedCache) MaxRemoved(match func(PartitionKey) bool) uint64 {
+	c.mu.RLock()
+	removed := c.forgotten
+	for key, seq := range c.removed {
+		if match == nil || match(key) {
+			removed = max(removed, seq)
+		}
+	}
+	c.mu.RUnlock()
+	for _, partition := range c.selected(match) {
+		removed = max(removed, partition.MaxRemoved())
+	}
+	return removed
+}
+
+// Stats returns the occupancy of each partition, ordered by key.
+func (c *partitionedCache) Stats() []CacheStats {
+	c.mu.RLock()
+	stats := make([]CacheStats, 0, len(c.partitions))
+	for key, partition := range c.partitions {
+		s := partition.Stats()
+		s.Partition = key.String()
+		stats = append(stats, s)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Partition < stats[j].Partition })
+	return stats
+}
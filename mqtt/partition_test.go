@@ -0,0 +1,136 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+// regionPkt builds a packet from a region and channel.
+func regionPkt(id uint32, region, channel string) *meshtreampb.Packet {
+	p := pkt(id, id, pb.PortNum_TEXT_MESSAGE_APP)
+	p.Seq = uint64(id)
+	p.Info = &meshtreampb.TopicInfo{RegionPath: region, Channel: channel}
+	return p
+}
+
+func TestParsePartitionBy(t *testing.T) {
+	for name, want := range map[string]PartitionBy{"": PartitionNone, "region": PartitionRegion, "region+channel": PartitionRegionChannel} {
+		if got, err := ParsePartitionBy(name); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", name, got, err)
+		}
+	}
+	if _, err := ParsePartitionBy("gateway"); err == nil {
+		t.Error("expected an unknown partitioning to be rejected")
+	}
+}
+
+// TestPartitionedCache verifies that a busy region evicts only its own
+// packets, that budgets apply per partition, and that packets from several
+// partitions come back in arrival order.
+func TestPartitionedCache(t *testing.T) {
+	c := newPartitionedCache(2, time.Hour)
+	// A fixed clock makes the oldest packet the eviction target.
+	c.nowFunc = func() time.Time { return time.Unix(1000, 0) }
+	c.setConfig(PartitionConfig{By: PartitionRegion, Size: 2, Sizes: map[string]int{"EU_868": 3}})
+
+	c.Add(regionPkt(1, "US/bayarea", "LongFast"))
+	c.Add(regionPkt(2, "US/quiet", "LongFast"))
+	for i := uint32(3); i <= 8; i++ {
+		c.Add(regionPkt(i, "US/bayarea", "LongFast"))
+	}
+	for i := uint32(9); i <= 12; i++ {
+		c.Add(regionPkt(i, "EU_868", "LongFast"))
+	}
+
+	if got := ids(c.GetAll(nil)); len(got) != 6 || got[0] != 2 || got[1] != 7 || got[5] != 12 {
+		t.Errorf("expected [2 7 8 10 11 12] in order, got %v", got)
+	}
+	quiet := func(key PartitionKey) bool { return key.Region == "US/quiet" }
+	if got := ids(c.GetAll(quiet)); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected only the quiet region's packet, got %v", got)
+	}
+	if c.MaxRemoved(quiet) != 0 || c.MaxRemoved(nil) != 9 {
+		t.Errorf("unexpected removals: quiet %d, all %d", c.MaxRemoved(quiet), c.MaxRemoved(nil))
+	}
+
+	stats := c.Stats()
+	if len(stats) != 3 || stats[0].Partition != "EU_868" || stats[0].Capacity != 3 || stats[1].Size != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// TestBrokerReplaysSelectedPartitions verifies that a subscriber replays
+// only the partitions it asks for, but still receives all live packets.
+func TestBrokerReplaysSelectedPartitions(t *testing.T) {
+	sourceChan := make(chan *meshtreampb.Packet, 10)
+	broker := newTestBroker(sourceChan, 10)
+	defer broker.Close()
+	broker.SetCachePartitions(PartitionConfig{By: PartitionChannel, Size: 10})
+
+	sourceChan <- regionPkt(1, "US", "LongFast")
+	sourceChan <- regionPkt(2, "US", "Private")
+	sourceChan <- regionPkt(3, "US", "LongFast")
+	for deadline := time.Now().Add(time.Second); len(broker.CachedPackets()) < 3 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sub := broker.SubscribeWith(SubscribeOptions{
+		BufferSize: 10,
+		Partitions: func(key PartitionKey) bool { return key.Channel == "LongFast" },
+	})
+	sourceChan <- regionPkt(4, "US", "Private")
+	for _, want := range []uint32{1, 3, 4} {
+		select {
+		case p := <-sub:
+			if p.Data.Id != want {
+				t.Errorf("want %d, got %d", want, p.Data.Id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %d", want)
+		}
+	}
+}
+
+// TestPartitionLimit verifies that topics can't create partitions without
+// bound: past the maximum, packets of new partitions aren't cached, and
+// partitions left empty by retention are dropped.
+func TestPartitionLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newPartitionedCache(10, time.Hour)
+	c.nowFunc = func() time.Time { return now }
+	c.setConfig(PartitionConfig{By: PartitionRegion, Size: 10, Sizes: map[string]int{"US/home": 10}, Max: 3})
+
+	for i := uint32(1); i <= 100; i++ {
+		c.Add(regionPkt(i, fmt.Sprintf("XX/spam%d", i), "LongFast"))
+	}
+	c.Add(regionPkt(101, "US/home", "LongFast"))
+	if got := ids(c.GetAll(nil)); len(got) != 4 || got[2] != 3 || got[3] != 101 {
+		t.Errorf("expected three spam partitions and the configured one, got %v", got)
+	}
+	if len(c.Stats()) != 4 {
+		t.Errorf("expected 4 partitions, got %d", len(c.Stats()))
+	}
+	if c.MaxRemoved(nil) != 100 {
+		t.Errorf("expected uncached packets to count as removed, got %d", c.MaxRemoved(nil))
+	}
+	home := func(key PartitionKey) bool { return key.Region == "US/home" }
+	if c.MaxRemoved(home) != 0 {
+		t.Errorf("expected uncached packets of other partitions not to count, got %d", c.MaxRemoved(home))
+	}
+
+	// Once the spam is past retention its partitions are dropped, making
+	// room for new ones.
+	now = now.Add(2 * time.Hour)
+	c.Add(regionPkt(102, "US/new", "LongFast"))
+	stats := c.Stats()
+	if len(stats) != 1 || stats[0].Partition != "US/new" {
+		t.Errorf("expected only the new partition, got %+v", stats)
+	}
+	if got := ids(c.GetAll(nil)); len(got) != 1 || got[0] != 102 {
+		t.Errorf("expected only the new packet, got %v", got)
+	}
+}
@@ -2,16 +2,34 @@ package mqtt
 
 import (
 	"sync"
+	"time"
 
 	"github.com/dpup/prefab/logging"
 	meshtreampb "meshstream/generated/meshstream"
 )
 
+const (
+	// replayWindow is how long before a subscriber started a packet may have
+	// been received and still count as live.
+	replayWindow = time.Minute
+	// copyHistory bounds the packet keys kept to drop copies from other
+	// gateways.
+	copyHistory = 1000
+)
+
+// packetKey identifies a mesh packet across gateways.
+type packetKey struct {
+	from, id uint32
+}
+
 // SubscriberConfig holds configuration for creating a subscriber
 type SubscriberConfig struct {
 	Name       string                    // Descriptive name for the subscriber
 	Broker     *Broker                   // The broker to subscribe to
 	BufferSize int                       // Channel buffer size
+	Policy     SlowSubscriberPolicy      // What to do when the buffer is full (default: DropNewest)
+	SkipCache  bool                      // Only process packets that arrive after Start, not the cache, e.g. when a sink recreated on reload has handled it already
+	DropCopies bool                      // Only process the first copy of a packet delivered by several gateways
 	Processor  func(*meshtreampb.Packet) // Function to process each packet
 	StartHook  func()                    // Optional hook called when starting
 	CloseHook  func()                    // Optional hook called when closing
@@ -29,6 +47,12 @@ type BaseSubscriber struct {
 	startHook  func()
 	closeHook  func()
 	BufferSize int
+	policy     SlowSubscriberPolicy
+	skipCache  bool
+	dropCopies bool
+	seen       map[packetKey]bool // Recent packets, when dropping copies; only used by run
+	order      []packetKey
+	start      time.Time
 	logger     logging.Logger
 }
 
@@ -37,6 +61,13 @@ func NewBaseSubscriber(config SubscriberConfig) *BaseSubscriber {
 
 	subscriberLogger := config.Logger.Named("mqtt.subscriber." + config.Name)
 
+	// The broker's policy is meant for stream clients, which reconnect when
+	// disconnected. An in-process subscriber has nothing to reconnect it, so
+	// it drops packets rather than stopping for good.
+	if config.Policy == "" {
+		config.Policy = DropNewest
+	}
+
 	return &BaseSubscriber{
 		broker:     config.Broker,
 		name:       config.Name,
@@ -45,6 +76,11 @@ func NewBaseSubscriber(config SubscriberConfig) *BaseSubscriber {
 		startHook:  config.StartHook,
 		closeHook:  config.CloseHook,
 		BufferSize: config.BufferSize,
+		policy:     config.Policy,
+		skipCache:  config.SkipCache,
+		dropCopies: config.DropCopies,
+		seen:       make(map[packetKey]bool),
+		start:      time.Now(),
 		logger:     subscriberLogger,
 	}
 }
@@ -52,7 +88,15 @@ func NewBaseSubscriber(config SubscriberConfig) *BaseSubscriber {
 // Start begins subscriber processing
 func (b *BaseSubscriber) Start() {
 	// Subscribe to the broker
-	b.channel = b.broker.Subscribe(b.BufferSize)
+	opts := SubscribeOptions{
+		Name:       b.name,
+		BufferSize: b.BufferSize,
+		Policy:     b.policy,
+	}
+	if b.skipCache {
+		opts.After = b.broker.LastSeq()
+	}
+	b.channel = b.broker.SubscribeWith(opts)
 
 	// Call the start hook if provided
 	if b.startHook != nil {
@@ -79,7 +123,7 @@ func (b *BaseSubscriber) run() {
 				return
 			}
 
-			if packet != nil && b.processor != nil {
+			if packet != nil && b.processor != nil && (!b.dropCopies || b.firstCopy(packet)) {
 				b.processor(packet)
 			}
 
@@ -90,6 +134,37 @@ func (b *BaseSubscriber) run() {
 	}
 }
 
+// firstCopy reports whether this is the first copy of a packet, remembering
+// it so copies delivered by other gateways are recognized.
+func (b *BaseSubscriber) firstCopy(packet *meshtreampb.Packet) bool {
+	data := packet.GetData()
+	if data.GetId() == 0 {
+		return true
+	}
+	key := packetKey{from: data.GetFrom(), id: data.GetId()}
+	if b.seen[key] {
+		return false
+	}
+	b.seen[key] = true
+	b.order = append(b.order, key)
+	if len(b.order) > copyHistory {
+		delete(b.seen, b.order[0])
+		b.order = b.order[1:]
+	}
+	return true
+}
+
+// Live reports whether a packet is current traffic rather than history: it
+// was received less than a minute before the subscriber was created, or
+// since. Packets replayed from the cache after a restart, or from a cluster
+// leader's snapshot, are not live, so subscribers that notify or forward can
+// skip them and not repeat themselves. Packets without a receive time are
+// live.
+func (b *BaseSubscriber) Live(packet *meshtreampb.Packet) bool {
+	rxTime := packet.GetData().GetRxTime()
+	return rxTime == 0 || !time.Unix(int64(rxTime), 0).Before(b.start.Add(-replayWindow))
+}
+
 // Close stops the subscriber and releases resources
 func (b *BaseSubscriber) Close() {
 	b.logger.Infof("Closing subscriber %s", b.name)
@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+func TestBaseSubscriberDropsCopies(t *testing.T) {
+	sourceChan := make(chan *meshtreampb.Packet, 10)
+	broker := newTestBroker(sourceChan, 10)
+	defer broker.Close()
+
+	processed := make(chan *meshtreampb.Packet, 10)
+	sub := NewBaseSubscriber(SubscriberConfig{
+		Name:       "test",
+		Broker:     broker,
+		BufferSize: 10,
+		DropCopies: true,
+		Processor:  func(p *meshtreampb.Packet) { processed <- p },
+		Logger:     logging.NewDevLogger(),
+	})
+	sub.Start()
+	defer sub.Close()
+
+	// The same packet from two gateways, another packet, and two packets
+	// without an ID, which can't be told apart.
+	for _, p := range []*meshtreampb.Packet{
+		pkt(1, 1, pb.PortNum_TEXT_MESSAGE_APP),
+		pkt(1, 1, pb.PortNum_TEXT_MESSAGE_APP),
+		pkt(2, 1, pb.PortNum_TEXT_MESSAGE_APP),
+		pkt(0, 1, pb.PortNum_TEXT_MESSAGE_APP),
+		pkt(0, 1, pb.PortNum_TEXT_MESSAGE_APP),
+	} {
+		sourceChan <- p
+	}
+	var ids []uint32
+	for range 4 {
+		select {
+		case p := <-processed:
+			ids = append(ids, p.GetData().GetId())
+		case <-time.After(time.Second):
+			t.Fatalf("timed out, processed %v", ids)
+		}
+	}
+	if ids[0] != 1 || ids[1] != 2 || ids[2] != 0 || ids[3] != 0 {
+		t.Errorf("expected [1 2 0 0], got %v", ids)
+	}
+}
+
+func TestBaseSubscriberIgnoresBrokerPolicy(t *testing.T) {
+	sourceChan := make(chan *meshtreampb.Packet, 10)
+	broker := newTestBroker(sourceChan, 10)
+	broker.SetSlowSubscriberPolicy(Disconnect)
+	defer broker.Close()
+
+	release := make(chan struct{})
+	processed := make(chan *meshtreampb.Packet, 10)
+	sub := NewBaseSubscriber(SubscriberConfig{
+		Name:       "test",
+		Broker:     broker,
+		BufferSize: 1,
+		Processor: func(p *meshtreampb.Packet) {
+			<-release
+			processed <- p
+		},
+		Logger: logging.NewDevLogger(),
+	})
+	sub.Start()
+	defer sub.Close()
+
+	// The processor blocks, so the buffer overflows.
+	for i := uint32(1); i <= 4; i++ {
+		sourceChan <- pkt(i, 1, pb.PortNum_TEXT_MESSAGE_APP)
+	}
+	time.Sleep(50 * time.Millisecond)
+	subscribers := broker.Subscribers()
+	close(release)
+	if len(subscribers) != 1 || subscribers[0].Dropped == 0 {
+		t.Fatalf("expected the subscriber to drop packets, got %+v", subscribers)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for len(processed) > 0 {
+		<-processed
+	}
+
+	sourceChan <- pkt(5, 1, pb.PortNum_TEXT_MESSAGE_APP)
+	select {
+	case p := <-processed:
+		if p.GetData().GetId() != 5 {
+			t.Errorf("expected packet 5 after the dropped ones, got %d", p.GetData().GetId())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber stopped after falling behind")
+	}
+}
+
+func TestBaseSubscriberLive(t *testing.T) {
+	sub := NewBaseSubscriber(SubscriberConfig{Name: "test", Logger: logging.NewDevLogger()})
+	received := func(ago time.Duration) *meshtreampb.Packet {
+		return &meshtreampb.Packet{Data: &meshtreampb.Data{RxTime: uint64(time.Now().Add(-ago).Unix())}}
+	}
+	if !sub.Live(received(0)) || !sub.Live(received(30*time.Second)) {
+		t.Error("expected recent packets to be live")
+	}
+	if sub.Live(received(10 * time.Minute)) {
+		t.Error("expected a packet from before the subscriber started not to be live")
+	}
+	if !sub.Live(&meshtreampb.Packet{Data: &meshtreampb.Data{}}) {
+		t.Error("expected a packet without a receive time to be live")
+	}
+}
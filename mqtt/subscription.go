@@ -0,0 +1,204 @@
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+// SlowSubscriberPolicy decides what happens when a packet arrives for a
+// subscriber whose buffer is full.
+type SlowSubscriberPolicy string
+
+const (
+	DropOldest SlowSubscriberPolicy = "drop-oldest" // Discard the oldest buffered packet to make room
+	DropNewest SlowSubscriberPolicy = "drop-newest" // Discard the arriving packet
+	Disconnect SlowSubscriberPolicy = "disconnect"  // Unsubscribe and close the channel
+)
+
+// ParseSlowSubscriberPolicy validates a policy name.
+func ParseSlowSubscriberPolicy(name string) (SlowSubscriberPolicy, error) {
+	switch policy := SlowSubscriberPolicy(name); policy {
+	case DropOldest, DropNewest, Disconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow subscriber policy %q, should be drop-oldest, drop-newest or disconnect", name)
+}
+
+// SubscribeOptions configures a subscription.
+type SubscribeOptions struct {
+	Name       string                  // Identifies the subscriber in stats and logs
+	BufferSize int                     // Packets buffered before the policy applies
+	Policy     SlowSubscriberPolicy    // Empty uses the broker's policy
+	After      uint64                  // Only replay cached packets with a greater sequence number
+	Markers    bool                    // Bracket the replayed packets with ReplayStart and ReplayEnd
+	Partitions func(PartitionKey) bool // Only replay cache partitions it accepts; nil replays all
+}
+
+// Replay markers bracket the cached packets sent to subscribers that set
+// SubscribeOptions.Markers. They carry no data; compare by identity.
+var (
+	ReplayStart = &meshtreampb.Packet{}
+	ReplayEnd   = &meshtreampb.Packet{}
+)
+
+// SubscriberStats describes one subscriber's buffer.
+type SubscriberStats struct {
+	ID       uint64               `json:"id"`
+	Name     string               `json:"name"`
+	Policy   SlowSubscriberPolicy `json:"policy"`
+	Since    time.Time            `json:"since"`
+	Buffered int                  `json:"buffered"`
+	Capacity int                  `json:"capacity"`
+	Dropped  uint64               `json:"dropped"`
+}
+
+// subscription buffers packets for one subscriber in a bounded ring and
+// delivers them in order from a single writer goroutine, so a slow reader
+// never blocks the broker or other subscribers.
+type subscription struct {
+	id      uint64
+	name    string
+	policy  SlowSubscriberPolicy
+	markers bool
+	since   time.Time
+	out     chan *meshtreampb.Packet
+
+	mu   sync.Mutex
+	ring []*meshtreampb.Packet
+	head int // Index of the oldest buffered packet
+	size int // Number of buffered packets
+
+	dropped atomic.Uint64
+	notify  chan struct{} // Signals the writer that the ring is non-empty
+	done    chan struct{} // Closed to stop the writer
+	exited  chan struct{} // Closed once the writer has closed out
+	stop    sync.Once
+}
+
+func newSubscription(id uint64, opts SubscribeOptions) *subscription {
+	return &subscription{
+		id:      id,
+		name:    opts.Name,
+		policy:  opts.Policy,
+		markers: opts.Markers,
+		since:   time.Now(),
+		out:     make(chan *meshtreampb.Packet),
+		ring:    make([]*meshtreampb.Packet, max(opts.BufferSize, 1)),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+}
+
+// push buffers a packet, applying the policy if the ring is full. It returns
+// false if the subscriber should be disconnected.
+func (s *subscription) push(packet *meshtreampb.Packet) bool {
+	s.mu.Lock()
+	if s.size == len(s.ring) {
+		switch s.policy {
+		case Disconnect:
+			s.mu.Unlock()
+			return false
+		case DropNewest:
+			s.mu.Unlock()
+			s.dropped.Add(1)
+			return true
+		default:
+			s.ring[s.head] = nil
+			s.head = (s.head + 1) % len(s.ring)
+			s.size--
+			s.dropped.Add(1)
+		}
+	}
+	s.ring[(s.head+s.size)%len(s.ring)] = packet
+	s.size++
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop removes the oldest buffered packet, or returns nil if there is none.
+func (s *subscription) pop() *meshtreampb.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size == 0 {
+		return nil
+	}
+	packet := s.ring[s.head]
+	s.ring[s.head] = nil
+	s.head = (s.head + 1) % len(s.ring)
+	s.size--
+	return packet
+}
+
+// run is the subscriber's only sender. Cached packets are replayed before
+// anything buffered since subscribing. It closes out when stopped.
+func (s *subscription) run(replay []*meshtreampb.Packet) {
+	defer close(s.exited)
+	defer close(s.out)
+
+	if !s.replay(replay) {
+		return
+	}
+	for {
+		packet := s.pop()
+		if packet == nil {
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case s.out <- packet:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// replay sends cached packets, waiting as long as the subscriber takes to
+// read them; live packets meanwhile wait in the ring. It returns false if
+// stopped.
+func (s *subscription) replay(packets []*meshtreampb.Packet) bool {
+	if s.markers {
+		packets = append(append([]*meshtreampb.Packet{ReplayStart}, packets...), ReplayEnd)
+	}
+	for _, packet := range packets {
+		select {
+		case s.out <- packet:
+		case <-s.done:
+			return false
+		}
+	}
+	return true
+}
+
+// close stops the writer and waits for it to close the channel.
+func (s *subscription) close() {
+	s.stop.Do(func() { close(s.done) })
+	<-s.exited
+}
+
+func (s *subscription) stats() SubscriberStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SubscriberStats{
+		ID:       s.id,
+		Name:     s.name,
+		Policy:   s.policy,
+		Since:    s.since,
+		Buffered: s.size,
+		Capacity: len(s.ring),
+		Dropped:  s.dropped.Load(),
+	}
+}
@@ -0,0 +1,130 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+func TestSubscriptionPolicies(t *testing.T) {
+	tests := []struct {
+		policy  SlowSubscriberPolicy
+		want    []uint32
+		dropped uint64
+	}{
+		{DropOldest, []uint32{3, 4}, 2},
+		{DropNewest, []uint32{1, 2}, 2},
+	}
+	for _, tt := range tests {
+		s := newSubscription(1, SubscribeOptions{BufferSize: 2, Policy: tt.policy})
+		for i := uint32(1); i <= 4; i++ {
+			if !s.push(pkt(i, 0, pb.PortNum_UNKNOWN_APP)) {
+				t.Fatalf("%s: push %d requested a disconnect", tt.policy, i)
+			}
+		}
+		var got []uint32
+		for p := s.pop(); p != nil; p = s.pop() {
+			got = append(got, p.Data.Id)
+		}
+		if len(got) != 2 || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("%s: want %v, got %v", tt.policy, tt.want, got)
+		}
+		if stats := s.stats(); stats.Dropped != tt.dropped || stats.Buffered != 0 || stats.Capacity != 2 {
+			t.Errorf("%s: unexpected stats %+v", tt.policy, stats)
+		}
+	}
+
+	s := newSubscription(1, SubscribeOptions{BufferSize: 2, Policy: Disconnect})
+	for i := uint32(1); i <= 3; i++ {
+		if ok := s.push(pkt(i, 0, pb.PortNum_UNKNOWN_APP)); ok != (i <= 2) {
+			t.Errorf("disconnect: push %d returned %v", i, ok)
+		}
+	}
+
+	if _, err := ParseSlowSubscriberPolicy("drop-everything"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+// TestBrokerDeliversInOrder verifies that a burst of packets reaches every
+// subscriber in arrival order.
+func TestBrokerDeliversInOrder(t *testing.T) {
+	const n = 500
+	sourceChan := make(chan *meshtreampb.Packet, n)
+	broker := newTestBroker(sourceChan, 10)
+	defer broker.Close()
+
+	subs := []<-chan *meshtreampb.Packet{broker.Subscribe(n), broker.Subscribe(n)}
+	for i := uint32(1); i <= n; i++ {
+		sourceChan <- pkt(i, 0, pb.PortNum_UNKNOWN_APP)
+	}
+	for s, sub := range subs {
+		for want := uint32(1); want <= n; want++ {
+			select {
+			case p := <-sub:
+				if p.Data.Id != want {
+					t.Fatalf("sub %d: want %d, got %d", s, want, p.Data.Id)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("sub %d: timed out waiting for %d", s, want)
+			}
+		}
+	}
+}
+
+// TestBrokerSlowSubscriberPolicy verifies that a subscriber with the
+// Disconnect policy is dropped when it falls behind, while others keep
+// receiving and drops are counted per subscriber.
+func TestBrokerSlowSubscriberPolicy(t *testing.T) {
+	sourceChan := make(chan *meshtreampb.Packet, 10)
+	broker := newTestBroker(sourceChan, 10)
+	defer broker.Close()
+	broker.SetSlowSubscriberPolicy(Disconnect)
+
+	slow := broker.SubscribeWith(SubscribeOptions{Name: "slow", BufferSize: 1})
+	lossy := broker.SubscribeWith(SubscribeOptions{Name: "lossy", BufferSize: 1, Policy: DropNewest})
+	fast := broker.SubscribeWith(SubscribeOptions{Name: "fast", BufferSize: 10})
+
+	// The writer holds at most one packet and the ring one more, so the
+	// fourth packet overflows the slow subscribers.
+	for i := uint32(1); i <= 4; i++ {
+		sourceChan <- pkt(i, 0, pb.PortNum_UNKNOWN_APP)
+	}
+	for want := uint32(1); want <= 4; want++ {
+		select {
+		case p := <-fast:
+			if p.Data.Id != want {
+				t.Errorf("fast: want %d, got %d", want, p.Data.Id)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("fast: timed out waiting for %d", want)
+		}
+	}
+
+	deadline := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-slow:
+			closed = !ok
+		case <-deadline:
+			t.Fatal("expected the slow subscriber's channel to be closed")
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	stats := broker.Subscribers()
+	if len(stats) != 2 || stats[0].Name != "lossy" || stats[1].Name != "fast" {
+		t.Fatalf("expected lossy and fast subscribers, got %+v", stats)
+	}
+	if stats[0].Policy != DropNewest || stats[0].Dropped < 2 {
+		t.Errorf("expected lossy to drop at least 2 packets, got %+v", stats[0])
+	}
+	if stats[1].Policy != Disconnect || stats[1].Dropped != 0 {
+		t.Errorf("expected fast to drop nothing, got %+v", stats[1])
+	}
+	if p := <-lossy; p.Data.Id != 1 {
+		t.Errorf("lossy: want the first packet, got %d", p.Data.Id)
+	}
+}
@@ -0,0 +1,62 @@
+package nodes
+
+import (
+	"sync"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+// Directory tracks the most recent identity (NODEINFO) announced by each node,
+// so that consumers can resolve node numbers to human-readable names.
+type Directory struct {
+	mu    sync.RWMutex
+	users map[uint32]*pb.User
+}
+
+// NewDirectory creates an empty node directory.
+func NewDirectory() *Directory {
+	return &Directory{
+		users: make(map[uint32]*pb.User),
+	}
+}
+
+// Observe records identity information carried by a packet. Packets other
+// than NODEINFO_APP are ignored, so it can be used directly as a subscriber
+// processor.
+func (d *Directory) Observe(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	user := data.GetNodeInfo()
+	if data.GetPortNum() != pb.PortNum_NODEINFO_APP || user == nil || data.GetFrom() == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[data.GetFrom()] = user
+}
+
+// User returns the last NODEINFO seen from a node, or nil if none is known.
+func (d *Directory) User(num uint32) *pb.User {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.users[num]
+}
+
+// LongName returns a node's long name, falling back to its short name and
+// then its "!xxxxxxxx" ID.
+func (d *Directory) LongName(num uint32) string {
+	user := d.User(num)
+	switch {
+	case user.GetLongName() != "":
+		return user.GetLongName()
+	case user.GetShortName() != "":
+		return user.GetShortName()
+	}
+	return FormatID(num)
+}
+
+// ShortName returns a node's short name, or an empty string if unknown.
+func (d *Directory) ShortName(num uint32) string {
+	return d.User(num).GetShortName()
+}
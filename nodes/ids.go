@@ -0,0 +1,53 @@
+package nodes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BroadcastID is the node number Meshtastic uses for packets sent to everyone.
+const BroadcastID = 0xffffffff
+
+// FormatID formats a node number in the canonical Meshtastic "!xxxxxxxx" form.
+func FormatID(num uint32) string {
+	return fmt.Sprintf("!%08x", num)
+}
+
+// ParseID parses a node ID given as "!abcd1234", "0xabcd1234" or a plain
+// decimal node number.
+func ParseID(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	var (
+		n   uint64
+		err error
+	)
+	switch {
+	case strings.HasPrefix(s, "!"):
+		n, err = strconv.ParseUint(s[1:], 16, 32)
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		n, err = strconv.ParseUint(s[2:], 16, 32)
+	default:
+		n, err = strconv.ParseUint(s, 10, 32)
+	}
+	if err != nil || s == "" {
+		return 0, fmt.Errorf("invalid node ID %q", s)
+	}
+	return uint32(n), nil
+}
+
+// ParseIDs parses a list of node IDs, ignoring empty entries.
+func ParseIDs(entries []string) ([]uint32, error) {
+	ids := make([]uint32, 0, len(entries))
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		id, err := ParseID(entry)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,124 @@
+package nodes
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+func TestParseID(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    uint32
+		wantErr bool
+	}{
+		{in: "!abcd1234", want: 0xabcd1234},
+		{in: "0xabcd1234", want: 0xabcd1234},
+		{in: "2882343476", want: 0xabcd1234},
+		{in: " !0000002a ", want: 42},
+		{in: "", wantErr: true},
+		{in: "!", wantErr: true},
+		{in: "!xyz", wantErr: true},
+		{in: "!1ffffffff", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := ParseID(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseID(%q): expected error, got %d", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseID(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+
+	if FormatID(42) != "!0000002a" {
+		t.Errorf("FormatID(42) = %q", FormatID(42))
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	d := NewDirectory()
+	if d.LongName(0xabcd1234) != "!abcd1234" {
+		t.Errorf("unknown node should fall back to its ID, got %q", d.LongName(0xabcd1234))
+	}
+
+	d.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    0xabcd1234,
+		PortNum: pb.PortNum_NODEINFO_APP,
+		Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{LongName: "Hilltop Router", ShortName: "HTR"}},
+	}})
+	// Non-NODEINFO packets are ignored.
+	d.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{From: 0xabcd1234, PortNum: pb.PortNum_TEXT_MESSAGE_APP}})
+
+	if got := d.LongName(0xabcd1234); got != "Hilltop Router" {
+		t.Errorf("want long name Hilltop Router, got %q", got)
+	}
+	if got := d.ShortName(0xabcd1234); got != "HTR" {
+		t.Errorf("want short name HTR, got %q", got)
+	}
+}
+
+func TestPositionHelpers(t *testing.T) {
+	pos := &pb.Position{LatitudeI: proto.Int32(377749000), LongitudeI: proto.Int32(-1224194000), PrecisionBits: 13}
+	lat, lon, ok := Coordinates(pos)
+	if !ok || lat < 37.77 || lat > 37.78 || lon > -122.41 || lon < -122.42 {
+		t.Errorf("unexpected coordinates %v, %v, %v", lat, lon, ok)
+	}
+	if m := PrecisionMeters(pos); m < 2900 || m > 2950 {
+		t.Errorf("13 precision bits should be ~2.9km, got %v", m)
+	}
+	if m := PrecisionMeters(&pb.Position{PrecisionBits: 32}); m != 0 {
+		t.Errorf("full precision should be 0, got %v", m)
+	}
+	if _, _, ok := Coordinates(&pb.Position{}); ok {
+		t.Error("position without a fix should not report coordinates")
+	}
+	// San Francisco to Oakland is roughly 13.4km.
+	if d := DistanceMeters(37.7749, -122.4194, 37.8044, -122.2712); d < 13000 || d > 13800 {
+		t.Errorf("unexpected distance %v", d)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	position := &pb.Position{LatitudeI: proto.Int32(377749290), LongitudeI: proto.Int32(-1224194160)}
+	packets := []*meshtreampb.Packet{
+		{Data: &meshtreampb.Data{Id: 1, From: 1, RxTime: 100, PortNum: pb.PortNum_POSITION_APP,
+			Payload: &meshtreampb.Data_Position{Position: position}}, Info: &meshtreampb.TopicInfo{Channel: "LongFast"}},
+		// The same packet from another gateway only adds where it was heard.
+		{Data: &meshtreampb.Data{Id: 1, From: 1, RxTime: 101, PortNum: pb.PortNum_POSITION_APP,
+			Payload: &meshtreampb.Data_Position{Position: position}}, Info: &meshtreampb.TopicInfo{Channel: "Ops"}},
+		{Data: &meshtreampb.Data{Id: 2, From: 1, RxTime: 102, PortNum: pb.PortNum_TELEMETRY_APP,
+			Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+				Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(80)}},
+			}}}},
+		// A map report without a position still names the node.
+		{Data: &meshtreampb.Data{Id: 3, From: 1, RxTime: 103, PortNum: pb.PortNum_MAP_REPORT_APP,
+			Payload: &meshtreampb.Data_MapReport{MapReport: &pb.MapReport{LongName: "Hilltop Router"}}}},
+		{Data: &meshtreampb.Data{From: 0, PortNum: pb.PortNum_TEXT_MESSAGE_APP}},
+	}
+
+	all := Summarize(packets)
+	if len(all) != 1 {
+		t.Fatalf("expected one node, got %d", len(all))
+	}
+	s := all[1]
+	if s.PacketCount != 3 || s.LastHeard != 103 || len(s.Channels) != 2 {
+		t.Errorf("unexpected counts: %d packets, last heard %d, channels %v", s.PacketCount, s.LastHeard, s.Channels)
+	}
+	if len(s.Positions) != 1 || s.Latest() != position {
+		t.Errorf("expected the one position, got %v", s.Positions)
+	}
+	if s.User.GetLongName() != "Hilltop Router" || s.User.GetId() != "!00000001" {
+		t.Errorf("expected the user from the map report, got %v", s.User)
+	}
+	if s.Telemetry["device_metrics"].GetDeviceMetrics().GetBatteryLevel() != 80 {
+		t.Errorf("expected device metrics, got %v", s.Telemetry)
+	}
+}
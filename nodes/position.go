@@ -0,0 +1,46 @@
+package nodes
+
+import (
+	"math"
+
+	pb "meshstream/generated/meshtastic"
+)
+
+// Coordinates returns the position in decimal degrees. ok is false when the
+// position carries no fix.
+func Coordinates(pos *pb.Position) (lat, lon float64, ok bool) {
+	if pos == nil || pos.LatitudeI == nil || pos.LongitudeI == nil {
+		return 0, 0, false
+	}
+	if pos.GetLatitudeI() == 0 && pos.GetLongitudeI() == 0 {
+		return 0, 0, false
+	}
+	return float64(pos.GetLatitudeI()) * 1e-7, float64(pos.GetLongitudeI()) * 1e-7, true
+}
+
+// PrecisionMeters returns the radius in meters within which a position
+// reduced to its precision_bits is accurate. Full-precision positions
+// (precision_bits 0 or 32) return 0.
+func PrecisionMeters(pos *pb.Position) float64 {
+	bits := pos.GetPrecisionBits()
+	if bits == 0 || bits >= 32 {
+		return 0
+	}
+	// Half the earth's circumference divided by the number of representable
+	// steps, matching the table used by the Meshtastic apps.
+	return 23905787.925008 / math.Pow(2, float64(bits))
+}
+
+// earthRadiusMeters is the mean radius of the earth.
+const earthRadiusMeters = 6371008.8
+
+// DistanceMeters returns the great-circle distance between two points given
+// in decimal degrees.
+func DistanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
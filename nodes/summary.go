@@ -0,0 +1,135 @@
+package nodes
+
+import (
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+// Summary is what a set of packets says about one of the nodes that sent
+// them. It is shared by the APIs that report nodes, so they agree.
+type Summary struct {
+	Num         uint32
+	User        *pb.User                 // From the latest NODEINFO or map report
+	Positions   []Fix                    // Positions with a fix, from position packets and map reports, in arrival order
+	Telemetry   map[string]*pb.Telemetry // Latest reading of each variant, keyed by field name, e.g. device_metrics
+	LastHeard   uint64                   // Latest receive time, Unix seconds; 0 when unknown
+	PacketCount int                      // Distinct packets sent by the node
+	Channels    []string                 // Channels the node was heard on
+}
+
+// Fix is a position a node reported.
+type Fix struct {
+	Position *pb.Position
+	RxTime   uint64 // When it was received, Unix seconds; 0 when unknown
+}
+
+// Latest returns the most recent position, or nil when there is none.
+func (s *Summary) Latest() *pb.Position {
+	if len(s.Positions) == 0 {
+		return nil
+	}
+	return s.Positions[len(s.Positions)-1].Position
+}
+
+// msgKey identifies a mesh packet across gateways.
+type msgKey struct {
+	from, id uint32
+}
+
+// Summarize collects what packets, such as the broker cache, say about each
+// sending node. Packets are assumed to be in arrival order, so later values
+// win. Copies of a packet delivered by several gateways count once; they only
+// add to when and where the node was heard.
+func Summarize(packets []*meshtreampb.Packet) map[uint32]*Summary {
+	result := make(map[uint32]*Summary)
+	seen := make(map[msgKey]bool)
+
+	for _, packet := range packets {
+		data := packet.GetData()
+		from := data.GetFrom()
+		if from == 0 {
+			continue
+		}
+		s, ok := result[from]
+		if !ok {
+			s = &Summary{Num: from}
+			result[from] = s
+		}
+		s.LastHeard = max(s.LastHeard, data.GetRxTime())
+		if channel := packet.GetInfo().GetChannel(); channel != "" && !contains(s.Channels, channel) {
+			s.Channels = append(s.Channels, channel)
+		}
+		if id := data.GetId(); id != 0 {
+			key := msgKey{from: from, id: id}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		s.PacketCount++
+
+		switch data.GetPortNum() {
+		case pb.PortNum_NODEINFO_APP:
+			if user := data.GetNodeInfo(); user != nil {
+				s.User = user
+			}
+
+		case pb.PortNum_POSITION_APP:
+			if pos := data.GetPosition(); pos != nil {
+				s.addPosition(pos, data.GetRxTime())
+			}
+
+		case pb.PortNum_MAP_REPORT_APP:
+			report := data.GetMapReport()
+			if report == nil {
+				continue
+			}
+			s.User = &pb.User{
+				Id:        FormatID(from),
+				LongName:  report.GetLongName(),
+				ShortName: report.GetShortName(),
+				HwModel:   report.GetHwModel(),
+				Role:      report.GetRole(),
+			}
+			latitude, longitude, altitude := report.GetLatitudeI(), report.GetLongitudeI(), report.GetAltitude()
+			s.addPosition(&pb.Position{
+				LatitudeI:     &latitude,
+				LongitudeI:    &longitude,
+				Altitude:      &altitude,
+				PrecisionBits: report.GetPositionPrecision(),
+			}, data.GetRxTime())
+
+		case pb.PortNum_TELEMETRY_APP:
+			telemetry := data.GetTelemetry()
+			if telemetry == nil {
+				continue
+			}
+			msg := telemetry.ProtoReflect()
+			if variant := msg.Descriptor().Oneofs().ByName("variant"); variant != nil {
+				if field := msg.WhichOneof(variant); field != nil {
+					if s.Telemetry == nil {
+						s.Telemetry = make(map[string]*pb.Telemetry)
+					}
+					s.Telemetry[string(field.Name())] = telemetry
+				}
+			}
+		}
+	}
+	return result
+}
+
+// addPosition records a position unless it has no fix.
+func (s *Summary) addPosition(pos *pb.Position, rxTime uint64) {
+	if _, _, ok := Coordinates(pos); ok {
+		s.Positions = append(s.Positions, Fix{Position: pos, RxTime: rxTime})
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/timeseries"
+)
+
+// readinessCheck returns the /readyz check of the packet pipeline: the
+// connection to the packet source, if there is one to lose, the broker, the
+// metrics file when history is persisted, and every running sink.
+func readinessCheck(config *Config, broker *mqtt.Broker, connection func() health.Status, metrics *timeseries.Store, integrations *sinks) func() health.Report {
+	thresholds := health.Config{
+		MaxPacketAge:    config.ReadyMaxPacketAge,
+		MaxBacklog:      config.ReadyMaxBacklog,
+		MaxDisconnected: config.ReadyMaxDisconnected,
+		MaxFailure:      config.ReadyMaxSinkFailure,
+	}
+	persisted := config.MetricsFile != ""
+
+	return func() health.Report {
+		state := health.State{
+			Started:    processStart,
+			LastPacket: broker.LastPacketTime(),
+			Backlog:    broker.Backlog(),
+			Sinks:      integrations.Health(),
+		}
+		if connection != nil {
+			state.Connection = connection()
+		}
+		if persisted {
+			status := metrics.Health()
+			state.Store = &status
+		}
+		return health.Evaluate(thresholds, state, time.Now())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/auth"
+	"meshstream/decoder"
+	"meshstream/mqtt"
+	"meshstream/server"
+)
+
+// reloadConfig applies a reloaded config. Channel keys, access control, the
+// cache policy and the integrations change in place; the MQTT connection,
+// the web server and its clients are left alone.
+func reloadConfig(old, config *Config, broker *mqtt.Broker, integrations *sinks, webServer *server.Server, logger logging.Logger) {
+	logger.Infow("Reloading config", "file", config.ConfigFile)
+
+	applyChannelKeys(config.ChannelKeys, logger)
+
+	if authenticator, err := newAuthenticator(config, logger); err != nil {
+		logger.Errorw("Failed to reload auth config, keeping the current one", "error", err)
+	} else {
+		webServer.Reload(authenticator, config.ChannelKeys)
+	}
+
+	if cachePolicy, err := cachePolicySettings(config); err != nil {
+		logger.Errorw("Failed to reload cache policy, keeping the current one", "error", err)
+	} else {
+		broker.SetCachePolicy(cachePolicy)
+	}
+
+	if err := integrations.apply(config, true); err != nil {
+		logger.Errorw("Failed to reload integrations", "error", err)
+	}
+
+	if !reflect.DeepEqual(restartSettings(old), restartSettings(config)) {
+		logger.Warnw("Some changed settings only take effect after a restart", "file", config.ConfigFile)
+	}
+	logger.Info("Config reloaded")
+}
+
+// restartSettings returns the config without the settings reloadConfig
+// applies, leaving those that need a restart.
+func restartSettings(config *Config) Config {
+	c := *config
+	c.ChannelKeys = nil
+	c.AuthConfig, c.FileAuth = "", nil
+	c.CachePolicy, c.FileCachePolicy = "", nil
+	c.AlertsConfig, c.FileAlerts = "", nil
+	c.HABroker, c.HAUsername, c.HAPassword, c.HADiscoveryPrefix, c.HANodes = "", "", "", "", nil
+	c.InfluxURL, c.InfluxOrg, c.InfluxBucket, c.InfluxToken, c.InfluxFile = "", "", "", "", ""
+	c.InfluxBatchSize, c.InfluxFlushInterval = 0, 0
+	c.BridgeChannels, c.BridgeDiscordWebhook, c.BridgeSlackWebhook = nil, "", ""
+	c.BridgeMatrixServer, c.BridgeMatrixRoom, c.BridgeMatrixToken = "", "", ""
+	c.APRSServer, c.APRSCallsign, c.APRSPasscode, c.APRSStations = "", "", 0, nil
+	c.APRSInterval, c.APRSComment = 0, ""
+	c.TAKAddress, c.TAKProtocol, c.TAKChannels = "", "", nil
+	return c
+}
+
+// newAuthenticator returns the authenticator for the configured access
+// control, or nil if the API is open.
+func newAuthenticator(config *Config, logger logging.Logger) (*auth.Authenticator, error) {
+	authConfig, err := authSettings(config)
+	if err != nil || authConfig == nil {
+		return nil, err
+	}
+	logger.Infof("API authentication enabled with %d tokens and %d users", len(authConfig.Tokens), len(authConfig.Users))
+	return auth.NewAuthenticator(*authConfig, logger), nil
+}
+
+// authSettings returns the access control config, from --auth-config or
+// else the config file, or nil if the API is open.
+func authSettings(config *Config) (*auth.Config, error) {
+	if config.AuthConfig != "" {
+		authConfig, err := auth.LoadConfig(config.AuthConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load auth config from %s: %v", config.AuthConfig, err)
+		}
+		return &authConfig, nil
+	}
+	return config.FileAuth, nil
+}
+
+// cachePolicySettings returns the cache eviction policy, from --cache-policy
+// or else the config file, or the default policy.
+func cachePolicySettings(config *Config) (mqtt.CachePolicy, error) {
+	if config.CachePolicy != "" {
+		policy, err := mqtt.LoadCachePolicy(config.CachePolicy)
+		if err != nil {
+			return mqtt.CachePolicy{}, fmt.Errorf("failed to load cache policy from %s: %v", config.CachePolicy, err)
+		}
+		return policy, nil
+	}
+	if config.FileCachePolicy != nil {
+		return *config.FileCachePolicy, nil
+	}
+	return mqtt.DefaultCachePolicy(), nil
+}
+
+// applyChannelKeys replaces the decoder's channel keys with the configured
+// channel:key pairs. Malformed pairs are logged and skipped.
+func applyChannelKeys(channelKeys []string, logger logging.Logger) {
+	keys := make(map[string]string, len(channelKeys))
+	for _, channelKeyPair := range channelKeys {
+		channelName, channelKey, ok := strings.Cut(channelKeyPair, ":")
+		if !ok {
+			logger.Errorw("Invalid channel key format, should be 'channel:key'", "pair", channelKeyPair)
+			continue
+		}
+		if _, err := base64.StdEncoding.DecodeString(channelKey); err != nil {
+			logger.Errorw("Failed to initialize channel key", "channel", channelName, "error", err)
+			continue
+		}
+		keys[channelName] = channelKey
+	}
+	if err := decoder.SetChannelKeys(keys); err != nil {
+		logger.Errorw("Failed to initialize channel keys", "error", err)
+		return
+	}
+	for channelName := range keys {
+		logger.Infof("Initialized channel key for '%s'", channelName)
+	}
+}
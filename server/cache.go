@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleCache reports the broker cache's occupancy by port and by source
+// node, to help tune the cache policy.
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	if s.config.Broker == nil {
+		http.Error(w, "MQTT broker not available", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeJSON(w, s.config.Broker.CacheStats())
+}
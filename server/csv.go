@@ -0,0 +1,263 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
+)
+
+// csvColumn is a CSV export column. Payload columns list the ports they
+// apply to and are blank for other packets.
+type csvColumn struct {
+	name  string
+	ports []pb.PortNum
+	text  bool // Free text that anyone on the mesh can set, see csvText
+	value func(*meshtreampb.Packet) string
+}
+
+// commonColumns are included for every packet.
+var commonColumns = []csvColumn{
+	{name: "rx_time", value: func(p *meshtreampb.Packet) string {
+		if t := p.GetData().GetRxTime(); t != 0 {
+			return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+		}
+		return ""
+	}},
+	{name: "id", value: func(p *meshtreampb.Packet) string { return uintString(p.GetData().GetId()) }},
+	{name: "from", value: func(p *meshtreampb.Packet) string { return nodeString(p.GetData().GetFrom()) }},
+	{name: "to", value: func(p *meshtreampb.Packet) string { return nodeString(p.GetData().GetTo()) }},
+	{name: "channel", text: true, value: func(p *meshtreampb.Packet) string { return p.GetInfo().GetChannel() }},
+	{name: "gateway", text: true, value: func(p *meshtreampb.Packet) string { return p.GetData().GetGatewayId() }},
+	{name: "port", value: func(p *meshtreampb.Packet) string { return p.GetData().GetPortNum().String() }},
+	{name: "hop_start", value: func(p *meshtreampb.Packet) string { return uintString(p.GetData().GetHopStart()) }},
+	{name: "hop_limit", value: func(p *meshtreampb.Packet) string { return uintString(p.GetData().GetHopLimit()) }},
+	{name: "via_mqtt", value: func(p *meshtreampb.Packet) string { return strconv.FormatBool(p.GetData().GetViaMqtt()) }},
+	{name: "rx_snr", value: func(p *meshtreampb.Packet) string {
+		return strconv.FormatFloat(float64(p.GetData().GetRxSnr()), 'f', -1, 32)
+	}},
+	{name: "rx_rssi", value: func(p *meshtreampb.Packet) string { return strconv.Itoa(int(p.GetData().GetRxRssi())) }},
+	{name: "decode_error", value: func(p *meshtreampb.Packet) string { return p.GetData().GetDecodeError() }},
+}
+
+var (
+	positionPorts = []pb.PortNum{pb.PortNum_POSITION_APP, pb.PortNum_MAP_REPORT_APP, pb.PortNum_WAYPOINT_APP}
+	userPorts     = []pb.PortNum{pb.PortNum_NODEINFO_APP, pb.PortNum_MAP_REPORT_APP}
+	telemetryPort = []pb.PortNum{pb.PortNum_TELEMETRY_APP}
+)
+
+// payloadColumns flatten the payloads of common port types.
+var payloadColumns = []csvColumn{
+	{name: "text", text: true, ports: []pb.PortNum{pb.PortNum_TEXT_MESSAGE_APP}, value: func(p *meshtreampb.Packet) string {
+		return p.GetData().GetTextMessage()
+	}},
+	{name: "reply_id", ports: []pb.PortNum{pb.PortNum_TEXT_MESSAGE_APP}, value: func(p *meshtreampb.Packet) string {
+		return uintString(p.GetData().GetReplyId())
+	}},
+	{name: "latitude", ports: positionPorts, value: func(p *meshtreampb.Packet) string {
+		lat, _, ok := packetCoordinates(p.GetData())
+		return coordString(lat, ok)
+	}},
+	{name: "longitude", ports: positionPorts, value: func(p *meshtreampb.Packet) string {
+		_, lon, ok := packetCoordinates(p.GetData())
+		return coordString(lon, ok)
+	}},
+	{name: "altitude", ports: positionPorts, value: func(p *meshtreampb.Packet) string {
+		data := p.GetData()
+		if pos := data.GetPosition(); pos != nil && pos.Altitude != nil {
+			return strconv.Itoa(int(pos.GetAltitude()))
+		}
+		if mr := data.GetMapReport(); mr != nil && mr.GetAltitude() != 0 {
+			return strconv.Itoa(int(mr.GetAltitude()))
+		}
+		return ""
+	}},
+	{name: "precision_bits", ports: positionPorts, value: func(p *meshtreampb.Packet) string {
+		data := p.GetData()
+		if pos := data.GetPosition(); pos != nil {
+			return uintString(pos.GetPrecisionBits())
+		}
+		return uintString(data.GetMapReport().GetPositionPrecision())
+	}},
+	{name: "sats_in_view", ports: []pb.PortNum{pb.PortNum_POSITION_APP}, value: func(p *meshtreampb.Packet) string {
+		return uintString(p.GetData().GetPosition().GetSatsInView())
+	}},
+	{name: "waypoint_name", text: true, ports: []pb.PortNum{pb.PortNum_WAYPOINT_APP}, value: func(p *meshtreampb.Packet) string {
+		return p.GetData().GetWaypoint().GetName()
+	}},
+	{name: "long_name", text: true, ports: userPorts, value: func(p *meshtreampb.Packet) string {
+		data := p.GetData()
+		if u := data.GetNodeInfo(); u != nil {
+			return u.GetLongName()
+		}
+		return data.GetMapReport().GetLongName()
+	}},
+	{name: "short_name", text: true, ports: userPorts, value: func(p *meshtreampb.Packet) string {
+		data := p.GetData()
+		if u := data.GetNodeInfo(); u != nil {
+			return u.GetShortName()
+		}
+		return data.GetMapReport().GetShortName()
+	}},
+	{name: "hw_model", ports: userPorts, value: func(p *meshtreampb.Packet) string {
+		data := p.GetData()
+		if u := data.GetNodeInfo(); u != nil {
+			return u.GetHwModel().String()
+		}
+		if mr := data.GetMapReport(); mr != nil {
+			return mr.GetHwModel().String()
+		}
+		return ""
+	}},
+	{name: "role", ports: userPorts, value: func(p *meshtreampb.Packet) string {
+		data := p.GetData()
+		if u := data.GetNodeInfo(); u != nil {
+			return u.GetRole().String()
+		}
+		if mr := data.GetMapReport(); mr != nil {
+			return mr.GetRole().String()
+		}
+		return ""
+	}},
+	{name: "telemetry_kind", ports: telemetryPort, value: func(p *meshtreampb.Packet) string {
+		kind, _ := decoder.TelemetryMetrics(p.GetData().GetTelemetry())
+		return kind
+	}},
+	telemetryColumn("device", "battery_level"),
+	telemetryColumn("device", "voltage"),
+	telemetryColumn("device", "channel_utilization"),
+	telemetryColumn("device", "air_util_tx"),
+	telemetryColumn("device", "uptime_seconds"),
+	telemetryColumn("environment", "temperature"),
+	telemetryColumn("environment", "relative_humidity"),
+	telemetryColumn("environment", "barometric_pressure"),
+	{name: "neighbors", ports: []pb.PortNum{pb.PortNum_NEIGHBORINFO_APP}, value: func(p *meshtreampb.Packet) string {
+		var ids []string
+		for _, n := range p.GetData().GetNeighborInfo().GetNeighbors() {
+			ids = append(ids, nodes.FormatID(n.GetNodeId()))
+		}
+		return strings.Join(ids, " ")
+	}},
+	{name: "route", ports: []pb.PortNum{pb.PortNum_TRACEROUTE_APP}, value: func(p *meshtreampb.Packet) string {
+		var ids []string
+		for _, n := range p.GetData().GetRouteDiscovery().GetRoute() {
+			ids = append(ids, nodes.FormatID(n))
+		}
+		return strings.Join(ids, " ")
+	}},
+	{name: "routing_error", ports: []pb.PortNum{pb.PortNum_ROUTING_APP}, value: func(p *meshtreampb.Packet) string {
+		if r := p.GetData().GetRouting(); r != nil {
+			return r.GetErrorReason().String()
+		}
+		return ""
+	}},
+}
+
+// telemetryColumn exports one field of a telemetry variant, e.g.
+// device.battery_level.
+func telemetryColumn(kind, field string) csvColumn {
+	return csvColumn{name: kind + "." + field, ports: telemetryPort, value: func(p *meshtreampb.Packet) string {
+		k, fields := decoder.TelemetryMetrics(p.GetData().GetTelemetry())
+		if v, ok := fields[field]; ok && k == kind {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}}
+}
+
+// csvColumns returns the common columns and the payload columns for the
+// given ports, or for all ports when none are selected.
+func csvColumns(ports map[pb.PortNum]bool) []csvColumn {
+	columns := append([]csvColumn(nil), commonColumns...)
+	for _, c := range payloadColumns {
+		if ports == nil {
+			columns = append(columns, c)
+			continue
+		}
+		for _, port := range c.ports {
+			if ports[port] {
+				columns = append(columns, c)
+				break
+			}
+		}
+	}
+	return columns
+}
+
+func csvHeader(columns []csvColumn) []string {
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	return header
+}
+
+func csvRow(columns []csvColumn, packet *meshtreampb.Packet) []string {
+	port := packet.GetData().GetPortNum()
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		if c.ports != nil && !hasPort(c.ports, port) {
+			continue
+		}
+		row[i] = c.value(packet)
+		if c.text {
+			row[i] = csvText(row[i])
+		}
+	}
+	return row
+}
+
+// csvText keeps spreadsheets from evaluating a value as a formula, such as a
+// message reading =HYPERLINK(...), by prefixing it with a quote.
+func csvText(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func hasPort(ports []pb.PortNum, port pb.PortNum) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// packetCoordinates returns the location carried by a position, map report
+// or waypoint.
+func packetCoordinates(data *meshtreampb.Data) (lat, lon float64, ok bool) {
+	if pos := data.GetPosition(); pos != nil {
+		return nodes.Coordinates(pos)
+	}
+	if mr := data.GetMapReport(); mr != nil && (mr.GetLatitudeI() != 0 || mr.GetLongitudeI() != 0) {
+		return float64(mr.GetLatitudeI()) * 1e-7, float64(mr.GetLongitudeI()) * 1e-7, true
+	}
+	if wp := data.GetWaypoint(); wp != nil && wp.LatitudeI != nil && wp.LongitudeI != nil {
+		return float64(wp.GetLatitudeI()) * 1e-7, float64(wp.GetLongitudeI()) * 1e-7, true
+	}
+	return 0, 0, false
+}
+
+func coordString(v float64, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 7, 64)
+}
+
+func uintString(v uint32) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+func nodeString(num uint32) string {
+	if num == 0 {
+		return ""
+	}
+	return nodes.FormatID(num)
+}
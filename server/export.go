@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"meshstream/export"
+	"meshstream/nodes"
+)
+
+// handleExportNodes serves /api/export/nodes.geojson and nodes.kml: the latest
+// position of each node in the cache, optionally limited by from, to and bbox.
+func (s *Server) handleExportNodes(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.export")
+
+	if s.config.Broker == nil {
+		http.Error(w, "MQTT broker not available", http.StatusServiceUnavailable)
+		return
+	}
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list := export.Collect(s.visiblePackets(r.Context())).Nodes(filter)
+	logger.Debugw("Node export", "path", r.URL.Path, "nodes", len(list))
+
+	// Encode before writing anything, so a failure is an error response
+	// rather than an empty document.
+	var body bytes.Buffer
+	contentType := "application/geo+json"
+	if strings.HasSuffix(r.URL.Path, ".kml") {
+		contentType = "application/vnd.google-earth.kml+xml"
+		err = export.WriteKML(&body, list)
+	} else {
+		err = export.WriteGeoJSON(&body, list)
+	}
+	if err != nil {
+		logger.Errorw("Failed to encode export", "error", err)
+		http.Error(w, "Failed to encode export", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body.Bytes())
+}
+
+// handleExportTrack serves /api/export/tracks/{id}.gpx: a node's positions in
+// the cache as a GPX track, optionally limited by from, to and bbox.
+func (s *Server) handleExportTrack(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.export")
+
+	if s.config.Broker == nil {
+		http.Error(w, "MQTT broker not available", http.StatusServiceUnavailable)
+		return
+	}
+	id, ok := strings.CutSuffix(r.PathValue("file"), ".gpx")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	node, err := nodes.ParseID(id)
+	if err != nil {
+		http.Error(w, "Invalid node ID", http.StatusBadRequest)
+		return
+	}
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, track, ok := export.Collect(s.visiblePackets(r.Context())).Track(node, filter)
+	if !ok {
+		http.Error(w, "No positions for node", http.StatusNotFound)
+		return
+	}
+	logger.Debugw("Track export", "node", info.ID, "points", len(track))
+
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimPrefix(info.ID, "!")+".gpx"))
+	if err := export.WriteGPX(w, info, track); err != nil {
+		logger.Warnw("Failed to write export", "error", err)
+	}
+}
+
+// parseExportFilter reads the from, to and bbox query parameters. bbox is
+// minLon,minLat,maxLon,maxLat as in GeoJSON.
+func parseExportFilter(r *http.Request) (export.Filter, error) {
+	var filter export.Filter
+	query := r.URL.Query()
+
+	var err error
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = parseTime(v); err != nil {
+			return filter, fmt.Errorf("invalid 'from' time")
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = parseTime(v); err != nil {
+			return filter, fmt.Errorf("invalid 'to' time")
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return filter, fmt.Errorf("'from' must be before 'to'")
+	}
+
+	if v := query.Get("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return filter, fmt.Errorf("invalid 'bbox'; expected minLon,minLat,maxLon,maxLat")
+		}
+		var coords [4]float64
+		for i, p := range parts {
+			if coords[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+				return filter, fmt.Errorf("invalid 'bbox'; expected minLon,minLat,maxLon,maxLat")
+			}
+		}
+		box := export.BBox{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}
+		if box.MinLat > box.MaxLat || box.MinLat < -90 || box.MaxLat > 90 || box.MinLon < -180 || box.MaxLon > 180 {
+			return filter, fmt.Errorf("invalid 'bbox'; coordinates out of range")
+		}
+		filter.BBox = &box
+	}
+	return filter, nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+
+	"meshstream/auth"
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// PacketFilter selects packets for the streaming endpoints. Each parameter
+// may be repeated or comma-separated; a packet must match one value of every
+// parameter given. An empty filter matches everything the caller's Policy
+// allows.
+//
+//	channel  channel name from the MQTT topic, e.g. LongFast
+//	port     port name or number, e.g. TEXT_MESSAGE_APP, text, position or 3
+//	node     node ID the packet is from or addressed to, e.g. !abcd1234
+//	gateway  ID of the gateway that uploaded the packet
+type PacketFilter struct {
+	Channels map[string]bool
+	Ports    map[pb.PortNum]bool
+	Nodes    map[uint32]bool
+	Gateways map[string]bool
+	Policy   *auth.Policy // Set from the caller's identity, not the query
+}
+
+// parsePacketFilter reads a filter from query parameters.
+func parsePacketFilter(query url.Values) (*PacketFilter, error) {
+	f := &PacketFilter{}
+
+	for _, v := range splitParam(query, "channel") {
+		if f.Channels == nil {
+			f.Channels = make(map[string]bool)
+		}
+		f.Channels[v] = true
+	}
+
+	for _, v := range splitParam(query, "port") {
+		port, err := decoder.ParsePort(v)
+		if err != nil {
+			return nil, err
+		}
+		if f.Ports == nil {
+			f.Ports = make(map[pb.PortNum]bool)
+		}
+		f.Ports[port] = true
+	}
+
+	for _, v := range splitParam(query, "node") {
+		num, err := nodes.ParseID(v)
+		if err != nil {
+			return nil, err
+		}
+		if f.Nodes == nil {
+			f.Nodes = make(map[uint32]bool)
+		}
+		f.Nodes[num] = true
+	}
+
+	for _, v := range splitParam(query, "gateway") {
+		if f.Gateways == nil {
+			f.Gateways = make(map[string]bool)
+		}
+		f.Gateways[v] = true
+	}
+
+	return f, nil
+}
+
+// Match reports whether the packet passes the filter.
+func (f *PacketFilter) Match(packet *meshtreampb.Packet) bool {
+	if !f.Policy.Allows(packet) {
+		return false
+	}
+	data := packet.GetData()
+	if f.Channels != nil && !f.Channels[packet.GetInfo().GetChannel()] {
+		return false
+	}
+	if f.Ports != nil && !f.Ports[data.GetPortNum()] {
+		return false
+	}
+	if f.Nodes != nil && !f.Nodes[data.GetFrom()] && !f.Nodes[data.GetTo()] {
+		return false
+	}
+	if f.Gateways != nil && !f.Gateways[data.GetGatewayId()] {
+		return false
+	}
+	return true
+}
+
+// MatchPartition reports whether a broker cache partition may hold packets
+// that pass the filter, so a subscriber only replays those partitions. Parts
+// of the key the broker doesn't partition by are empty and match anything.
+func (f *PacketFilter) MatchPartition(key mqtt.PartitionKey) bool {
+	if key.Channel != "" {
+		if !f.Policy.AllowsChannel(key.Channel) || (f.Channels != nil && !f.Channels[key.Channel]) {
+			return false
+		}
+	}
+	return key.Region == "" || f.Policy.AllowsRegion(key.Region)
+}
+
+// splitParam returns the non-empty values of a repeated, comma-separated
+// query parameter.
+func splitParam(query url.Values, key string) []string {
+	var values []string
+	for _, v := range query[key] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"meshstream/auth"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+	"meshstream/snapshot"
+)
+
+// grpcService implements the Meshstream gRPC service on top of the broker.
+type grpcService struct {
+	meshtreampb.UnimplementedMeshstreamServer
+	s *Server
+}
+
+// StreamPackets replays the cache and follows live packets, like the SSE
+// stream.
+func (g *grpcService) StreamPackets(req *meshtreampb.Filter, stream meshtreampb.Meshstream_StreamPacketsServer) error {
+	ctx, err := g.authorize(stream.Context())
+	if err != nil {
+		return err
+	}
+	broker, err := g.broker()
+	if err != nil {
+		return err
+	}
+	filter := filterFromProto(req)
+	filter.Policy = auth.PolicyFrom(ctx)
+
+	logger := g.s.logger.Named("grpc.stream")
+	currentConnections := g.s.activeConnections.Add(1)
+	logger.Infow("gRPC packet stream opened", "activeConnections", currentConnections)
+	defer func() {
+		remaining := g.s.activeConnections.Add(-1)
+		logger.Infow("gRPC packet stream closed", "activeConnections", remaining)
+	}()
+
+	packetChan := broker.SubscribeWith(mqtt.SubscribeOptions{
+		Name:       "grpc " + peerAddr(ctx),
+		BufferSize: 100,
+		Partitions: filter.MatchPartition,
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			broker.Unsubscribe(packetChan)
+			return nil
+		case <-g.s.shutdown:
+			broker.Unsubscribe(packetChan)
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case packet, ok := <-packetChan:
+			if !ok {
+				// Broker closed, or dropped a client that fell behind
+				return status.Error(codes.Unavailable, "packet stream closed by server")
+			}
+			if packet == nil || !filter.Match(packet) {
+				continue
+			}
+			if err := stream.Send(packet); err != nil {
+				logger.Debugw("Failed to send packet", "error", err)
+				broker.Unsubscribe(packetChan)
+				return err
+			}
+		}
+	}
+}
+
+// QueryPackets returns matching cached packets, oldest first.
+func (g *grpcService) QueryPackets(ctx context.Context, req *meshtreampb.QueryPacketsRequest) (*meshtreampb.QueryPacketsResponse, error) {
+	ctx, err := g.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.broker(); err != nil {
+		return nil, err
+	}
+	if req.GetUntil() != 0 && req.GetSince() > req.GetUntil() {
+		return nil, status.Error(codes.InvalidArgument, "since is after until")
+	}
+	filter := filterFromProto(req.GetFilter())
+
+	var packets []*meshtreampb.Packet
+	for _, packet := range g.s.visiblePackets(ctx) {
+		if !filter.Match(packet) {
+			continue
+		}
+		rxTime := packet.GetData().GetRxTime()
+		if req.GetSince() != 0 && rxTime < req.GetSince() {
+			continue
+		}
+		if req.GetUntil() != 0 && (rxTime == 0 || rxTime > req.GetUntil()) {
+			continue
+		}
+		packets = append(packets, packet)
+	}
+	if limit := int(req.GetLimit()); limit > 0 && len(packets) > limit {
+		packets = packets[len(packets)-limit:]
+	}
+	return &meshtreampb.QueryPacketsResponse{Packets: packets}, nil
+}
+
+// ListNodes returns the nodes heard in the cache, ordered by node number.
+func (g *grpcService) ListNodes(ctx context.Context, req *meshtreampb.ListNodesRequest) (*meshtreampb.ListNodesResponse, error) {
+	ctx, err := g.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.broker(); err != nil {
+		return nil, err
+	}
+	all := collectNodes(g.s.visiblePackets(ctx))
+	resp := &meshtreampb.ListNodesResponse{Nodes: make([]*meshtreampb.Node, 0, len(all))}
+	for _, n := range all {
+		resp.Nodes = append(resp.Nodes, n)
+	}
+	sort.Slice(resp.Nodes, func(i, j int) bool { return resp.Nodes[i].Num < resp.Nodes[j].Num })
+	return resp, nil
+}
+
+// GetNode returns a single node from the cache.
+func (g *grpcService) GetNode(ctx context.Context, req *meshtreampb.GetNodeRequest) (*meshtreampb.Node, error) {
+	ctx, err := g.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.broker(); err != nil {
+		return nil, err
+	}
+	if req.GetNum() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "num is required")
+	}
+	node, ok := collectNodes(g.s.visiblePackets(ctx))[req.GetNum()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", nodes.FormatID(req.GetNum()))
+	}
+	return node, nil
+}
+
+// Replicate feeds a follower replica: the packets after the one it last
+// received if they are still cached, or else the snapshot, or the whole cache
+// when snapshots are disabled, and then live packets.
+func (g *grpcService) Replicate(req *meshtreampb.ReplicateRequest, stream meshtreampb.Meshstream_ReplicateServer) error {
+	ctx, err := g.authorize(stream.Context())
+	if err != nil {
+		return err
+	}
+	if !auth.PolicyFrom(ctx).Unrestricted() {
+		return status.Error(codes.PermissionDenied, "replication requires access to all channels and regions")
+	}
+	broker, err := g.broker()
+	if err != nil {
+		return err
+	}
+
+	// Let the follower know it was accepted before the first packet
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	logger := g.s.logger.Named("grpc.replicate").With("follower", peerAddr(ctx))
+	// A replica must not miss packets: if it falls behind, end the stream so
+	// it reconnects and resumes after the last packet it received.
+	opts := mqtt.SubscribeOptions{Name: "replica " + peerAddr(ctx), BufferSize: 1000, Policy: mqtt.Disconnect}
+	resumed := req.GetAfterSeq() != 0 && broker.CanResume(req.GetAfterSeq(), nil)
+	var snap snapshot.Snapshot
+	switch {
+	case resumed:
+		opts.After = req.GetAfterSeq()
+	case g.s.config.Snapshot != nil:
+		snap = g.s.config.Snapshot.Snapshot()
+		opts.After = snap.Seq
+	}
+	logger.Infow("Replica connected", "afterSeq", req.GetAfterSeq(), "resumed", resumed, "snapshotPackets", len(snap.Packets))
+	defer logger.Infow("Replica disconnected")
+
+	for _, packet := range snap.Packets {
+		if err := stream.Send(packet); err != nil {
+			return err
+		}
+	}
+
+	packetChan := broker.SubscribeWith(opts)
+	for {
+		select {
+		case <-ctx.Done():
+			broker.Unsubscribe(packetChan)
+			return nil
+		case <-g.s.shutdown:
+			broker.Unsubscribe(packetChan)
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case packet, ok := <-packetChan:
+			if !ok {
+				// Broker closed, or dropped a replica that fell behind
+				return status.Error(codes.Unavailable, "packet stream closed by server")
+			}
+			if packet == nil {
+				continue
+			}
+			if err := stream.Send(packet); err != nil {
+				logger.Debugw("Failed to send packet", "error", err)
+				broker.Unsubscribe(packetChan)
+				return err
+			}
+		}
+	}
+}
+
+// authorize authenticates the call from its "authorization" metadata, which
+// takes the same values as the HTTP header, and adds the identity to the
+// context.
+func (g *grpcService) authorize(ctx context.Context) (context.Context, error) {
+	authenticator := g.s.authenticator()
+	if authenticator == nil {
+		return ctx, nil
+	}
+	var header string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	identity, err := authenticator.AuthenticateHeader(ctx, header)
+	if err != nil {
+		g.s.logger.Debugw("gRPC call not authenticated", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	return auth.WithIdentity(ctx, identity), nil
+}
+
+// peerAddr returns the address of the calling client, for logs and stats.
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+func (g *grpcService) broker() (*mqtt.Broker, error) {
+	if g.s.isShuttingDown.Load() {
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	if g.s.config.Broker == nil {
+		return nil, status.Error(codes.Unavailable, "MQTT broker not available")
+	}
+	return g.s.config.Broker, nil
+}
+
+// filterFromProto converts a gRPC filter to the one used by the HTTP
+// endpoints.
+func filterFromProto(f *meshtreampb.Filter) *PacketFilter {
+	filter := &PacketFilter{}
+	for _, v := range f.GetChannels() {
+		if filter.Channels == nil {
+			filter.Channels = make(map[string]bool)
+		}
+		filter.Channels[v] = true
+	}
+	for _, v := range f.GetPorts() {
+		if filter.Ports == nil {
+			filter.Ports = make(map[pb.PortNum]bool)
+		}
+		filter.Ports[v] = true
+	}
+	for _, v := range f.GetNodes() {
+		if filter.Nodes == nil {
+			filter.Nodes = make(map[uint32]bool)
+		}
+		filter.Nodes[v] = true
+	}
+	for _, v := range f.GetGateways() {
+		if filter.Gateways == nil {
+			filter.Gateways = make(map[string]bool)
+		}
+		filter.Gateways[v] = true
+	}
+	return filter
+}
+
+// collectNodes summarizes what the packets say about each sending node.
+func collectNodes(packets []*meshtreampb.Packet) map[uint32]*meshtreampb.Node {
+	result := make(map[uint32]*meshtreampb.Node)
+	for num, summary := range nodes.Summarize(packets) {
+		result[num] = &meshtreampb.Node{
+			Num:                num,
+			Id:                 nodes.FormatID(num),
+			User:               summary.User,
+			Position:           summary.Latest(),
+			DeviceMetrics:      summary.Telemetry["device_metrics"].GetDeviceMetrics(),
+			EnvironmentMetrics: summary.Telemetry["environment_metrics"].GetEnvironmentMetrics(),
+			LastHeard:          summary.LastHeard,
+			PacketCount:        uint32(summary.PacketCount),
+			Channels:           summary.Channels,
+		}
+	}
+	return result
+}
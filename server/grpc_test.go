@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+func newTestGRPCClient(t *testing.T, s *Server) meshtreampb.MeshstreamClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	meshtreampb.RegisterMeshstreamServer(grpcServer, &grpcService{s: s})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return meshtreampb.NewMeshstreamClient(conn)
+}
+
+func TestGRPCStreamPackets(t *testing.T) {
+	s, source := newTestServer(t)
+	client := newTestGRPCClient(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamPackets(ctx, &meshtreampb.Filter{Ports: []pb.PortNum{pb.PortNum_TEXT_MESSAGE_APP}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.GetData().GetTextMessage() != `hello, "mesh"` {
+		t.Errorf("expected the cached text message, got %v", packet)
+	}
+
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 9, PortNum: pb.PortNum_POSITION_APP}}
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 10, PortNum: pb.PortNum_TEXT_MESSAGE_APP}}
+	if packet, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if packet.GetData().GetId() != 10 {
+		t.Errorf("expected live packet 10, got %d", packet.GetData().GetId())
+	}
+}
+
+func TestGRPCQueries(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := newTestGRPCClient(t, s)
+	ctx := context.Background()
+
+	resp, err := client.QueryPackets(ctx, &meshtreampb.QueryPacketsRequest{
+		Filter: &meshtreampb.Filter{Nodes: []uint32{0xabcd}},
+		Limit:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Packets) != 2 || resp.Packets[0].GetData().GetId() != 2 || resp.Packets[1].GetData().GetId() != 3 {
+		t.Errorf("expected the latest two packets involving !0000abcd, got %v", resp.Packets)
+	}
+
+	resp, err = client.QueryPackets(ctx, &meshtreampb.QueryPacketsRequest{Since: 1767225600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Packets) != 1 || resp.Packets[0].GetData().GetId() != 1 {
+		t.Errorf("expected only the packet with a receive time, got %v", resp.Packets)
+	}
+
+	list, err := client.ListNodes(ctx, &meshtreampb.ListNodesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Nodes) != 2 || list.Nodes[0].GetNum() != 0x1234 || list.Nodes[1].GetNum() != 0xabcd {
+		t.Fatalf("unexpected nodes %v", list.Nodes)
+	}
+
+	node, err := client.GetNode(ctx, &meshtreampb.GetNodeRequest{Num: 0xabcd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.GetId() != "!0000abcd" || node.GetPacketCount() != 2 || node.GetPosition().GetLatitudeI() != 377749290 || node.GetLastHeard() != 1767225600 {
+		t.Errorf("unexpected node %v", node)
+	}
+	if node, err = client.GetNode(ctx, &meshtreampb.GetNodeRequest{Num: 0x1234}); err != nil {
+		t.Fatal(err)
+	}
+	if node.GetDeviceMetrics().GetBatteryLevel() != 75 || len(node.GetChannels()) != 1 || node.GetChannels()[0] != "Private" {
+		t.Errorf("unexpected node %v", node)
+	}
+
+	_, err = client.GetNode(ctx, &meshtreampb.GetNodeRequest{Num: 0x9999})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestGRPCReplicate(t *testing.T) {
+	s, source := newTestSnapshotServer(t)
+	client := newTestGRPCClient(t, s)
+	cached := s.config.Broker.CachedPackets()
+	snap := s.config.Snapshot.Snapshot()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recvIDs := func(stream meshtreampb.Meshstream_ReplicateClient, n int) []uint32 {
+		t.Helper()
+		var ids []uint32
+		for range n {
+			packet, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, packet.GetData().GetId())
+		}
+		return ids
+	}
+
+	// A new follower is sent the snapshot and then live packets
+	stream, err := client.Replicate(ctx, &meshtreampb.ReplicateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []uint32
+	for _, p := range snap.Packets {
+		want = append(want, p.GetData().GetId())
+	}
+	if got := recvIDs(stream, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the snapshot packets %v, got %v", want, got)
+	}
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 10}, Info: &meshtreampb.TopicInfo{}}
+	packet, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.GetData().GetId() != 10 || packet.GetSeq() != s.config.Broker.LastSeq() {
+		t.Errorf("expected live packet 10 with seq %d, got %v", s.config.Broker.LastSeq(), packet)
+	}
+
+	// A reconnecting follower resumes after the last packet it received
+	stream, err = client.Replicate(ctx, &meshtreampb.ReplicateRequest{AfterSeq: cached[1].GetSeq()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recvIDs(stream, 2); !reflect.DeepEqual(got, []uint32{3, 10}) {
+		t.Errorf("expected the packets after the second one, got %v", got)
+	}
+
+	// Unless those packets are gone, then it gets a new snapshot
+	stream, err = client.Replicate(ctx, &meshtreampb.ReplicateRequest{AfterSeq: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recvIDs(stream, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the snapshot packets %v, got %v", want, got)
+	}
+}
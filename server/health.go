@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"meshstream/health"
+)
+
+// handleHealthz serves /healthz: the process is up and serving requests. It
+// fails only while shutting down, so orchestrators can use it as a liveness
+// probe.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if s.isShuttingDown.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleReadyz serves /readyz: whether the packet pipeline is healthy enough
+// to serve clients, with the outcome of each check. It responds 503 when any
+// check fails, so orchestrators can stop routing to, or restart, a wedged
+// instance.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// readiness evaluates the pipeline, or reports ready if there is no
+// readiness check. The server is never ready while shutting down.
+func (s *Server) readiness() health.Report {
+	report := health.Report{Ready: true}
+	if s.config.Readiness != nil {
+		report = s.config.Readiness()
+	}
+	if s.isShuttingDown.Load() {
+		report.Ready = false
+		report.Checks = append(report.Checks, health.Check{Name: "server", OK: false, Detail: "shutting down"})
+	}
+	return report
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"meshstream/health"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	s, _ := newTestServer(t)
+	ready := true
+	s.config.Readiness = func() health.Report {
+		return health.Report{Ready: ready, Checks: []health.Check{{Name: "packets", OK: ready}}}
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /healthz to be OK, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /readyz to be OK, got %d", rec.Code)
+	}
+
+	ready = false
+	rec = httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to fail, got %d", rec.Code)
+	}
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %q: %v", rec.Body.String(), err)
+	}
+	if report.Ready || len(report.Checks) != 1 || report.Checks[0].Name != "packets" {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// Neither endpoint passes once the server is shutting down
+	ready = true
+	s.isShuttingDown.Store(true)
+	rec = httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /healthz to fail while shutting down, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to fail while shutting down, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"meshstream/nodes"
+	"meshstream/timeseries"
+)
+
+// Defaults for the metrics endpoint when from, to or step are omitted.
+const (
+	defaultMetricsWindow = 24 * time.Hour
+	defaultMetricsStep   = 5 * time.Minute
+	maxMetricsBuckets    = 10000
+)
+
+// MetricsResponse is returned by the node metrics endpoint.
+type MetricsResponse struct {
+	Node    string              `json:"node"`
+	Metric  string              `json:"metric"`
+	From    time.Time           `json:"from"`
+	To      time.Time           `json:"to"`
+	Step    string              `json:"step"`
+	Buckets []timeseries.Bucket `json:"buckets"`
+}
+
+// handleNodeMetrics serves /api/nodes/{id}/metrics. Without a name parameter
+// it lists the metrics recorded for the node; otherwise it returns min, max,
+// avg and last for each step between from and to.
+func (s *Server) handleNodeMetrics(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.metrics")
+
+	if s.config.Metrics == nil {
+		http.Error(w, "Telemetry history is not enabled", http.StatusNotFound)
+		return
+	}
+
+	node, err := nodes.ParseID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid node ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		metrics := s.config.Metrics.Metrics(node)
+		if metrics == nil {
+			metrics = []string{}
+		}
+		s.writeJSON(w, map[string]interface{}{
+			"node":    nodes.FormatID(node),
+			"metrics": metrics,
+		})
+		return
+	}
+
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		if to, err = parseTime(v); err != nil {
+			http.Error(w, "Invalid 'to' time", http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-defaultMetricsWindow)
+	if v := query.Get("from"); v != "" {
+		if from, err = parseTime(v); err != nil {
+			http.Error(w, "Invalid 'from' time", http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+
+	step := defaultMetricsStep
+	if v := query.Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step < time.Second {
+			http.Error(w, "Invalid 'step' duration", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Sub(from)/step > maxMetricsBuckets {
+		http.Error(w, "Too many buckets; increase 'step' or narrow the range", http.StatusBadRequest)
+		return
+	}
+
+	metric, ok := s.config.Metrics.Resolve(node, name)
+	if !ok {
+		http.Error(w, "Unknown metric", http.StatusNotFound)
+		return
+	}
+
+	buckets := s.config.Metrics.Query(node, metric, from, to, step)
+	if buckets == nil {
+		buckets = []timeseries.Bucket{}
+	}
+
+	logger.Debugw("Metrics query", "node", nodes.FormatID(node), "metric", metric, "buckets", len(buckets))
+
+	s.writeJSON(w, MetricsResponse{
+		Node:    nodes.FormatID(node),
+		Metric:  metric,
+		From:    from.UTC(),
+		To:      to.UTC(),
+		Step:    step.String(),
+		Buckets: buckets,
+	})
+}
+
+// parseTime accepts RFC 3339 timestamps or Unix seconds.
+func parseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// writeJSON encodes v as the JSON response body. It is encoded before
+// anything is written, so a value that can't be encoded is reported as an
+// error rather than an empty response.
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Errorw("Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,7 +15,13 @@ import (
 	"github.com/dpup/prefab/logging"
 	"google.golang.org/protobuf/encoding/protojson"
 
+	"meshstream/auth"
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/health"
 	"meshstream/mqtt"
+	"meshstream/snapshot"
+	"meshstream/timeseries"
+	"meshstream/topology"
 )
 
 // Config holds server configuration
@@ -22,12 +29,17 @@ type Config struct {
 	Host          string
 	Port          string
 	Logger        logging.Logger
-	Broker        *mqtt.Broker // The MQTT message broker
-	MQTTServer    string       // MQTT server hostname
-	MQTTTopicPath string       // MQTT topic path being subscribed to
-	StaticDir     string       // Directory containing static web files
-	ChannelKeys   []string     // Channel keys for decryption
-	AllowedOrigin string       // CORS allowed origin; defaults to "*" (public stream)
+	Broker        *mqtt.Broker         // The MQTT message broker
+	MQTTServer    string               // MQTT server hostname
+	MQTTTopicPath string               // MQTT topic path being subscribed to
+	StaticDir     string               // Directory containing static web files
+	ChannelKeys   []string             // Channel keys for decryption
+	AllowedOrigin string               // CORS allowed origin; defaults to "*" (public stream)
+	Metrics       *timeseries.Store    // Telemetry history; nil disables the metrics endpoint
+	Topology      *topology.Graph      // Inferred mesh graph; nil disables the topology endpoint
+	Auth          *auth.Authenticator  // Authenticates API requests; nil leaves the API open
+	Snapshot      *snapshot.Store      // Materialized mesh state; nil disables snapshots
+	Readiness     func() health.Report // Checks the packet pipeline for /readyz; nil always reports ready
 }
 
 // Create connection info JSON to send to the client
@@ -39,9 +51,24 @@ type ConnectionInfo struct {
 	ServerTime int64  `json:"serverTime"`
 }
 
+// GapNotice is sent to a reconnecting SSE client when the packets after its
+// last event ID are no longer cached.
+type GapNotice struct {
+	Message     string `json:"message"`
+	LastEventID string `json:"lastEventId"`
+}
+
+// ReplayNotice marks the start and end of the cached packets replayed to a
+// new SSE client. Packets counts those sent, after filtering.
+type ReplayNotice struct {
+	Packets int `json:"packets"`
+}
+
 // Server encapsulates the HTTP server functionality
 type Server struct {
 	config Config
+	// Guards config.Auth and config.ChannelKeys, which Reload replaces
+	mu     sync.RWMutex
 	server *prefab.Server
 	// Channel to signal shutdown to active connections
 	shutdown chan struct{}
@@ -68,6 +95,22 @@ func New(config Config) *Server {
 	}
 }
 
+// Reload replaces the authenticator and channel keys, e.g. after the config
+// file changed. Open streams keep the identity they were authenticated with.
+func (s *Server) Reload(authenticator *auth.Authenticator, channelKeys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.Auth = authenticator
+	s.config.ChannelKeys = channelKeys
+}
+
+// authenticator returns the current authenticator, or nil if the API is open.
+func (s *Server) authenticator() *auth.Authenticator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Auth
+}
+
 // securityHeaders wraps a handler to add common HTTP security headers.
 func securityHeaders(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -78,6 +121,56 @@ func securityHeaders(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// authenticate wraps a handler to require credentials when authentication is
+// configured. The caller's identity, and so its policy, is added to the
+// request context. The authenticator is looked up per request, so Reload
+// takes effect immediately.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authenticator := s.authenticator()
+		if authenticator == nil {
+			next(w, r)
+			return
+		}
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			s.logger.Debugw("Request not authenticated", "path", r.URL.Path, "remoteAddr", r.RemoteAddr, "error", err)
+			w.Header().Set("WWW-Authenticate", authenticator.Challenge())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	}
+}
+
+// requireFullAccess wraps an authenticated handler that serves data derived
+// from all traffic, which can't be filtered by channel or region.
+func requireFullAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.PolicyFrom(r.Context()).Unrestricted() {
+			http.Error(w, "Forbidden: requires access to all channels and regions", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// visiblePackets returns the cached packets the request's identity may see.
+func (s *Server) visiblePackets(ctx context.Context) []*meshtreampb.Packet {
+	packets := s.config.Broker.CachedPackets()
+	policy := auth.PolicyFrom(ctx)
+	if policy.Unrestricted() {
+		return packets
+	}
+	visible := make([]*meshtreampb.Packet, 0, len(packets))
+	for _, p := range packets {
+		if policy.Allows(p) {
+			visible = append(visible, p)
+		}
+	}
+	return visible
+}
+
 // Start initializes and starts the web server
 func (s *Server) Start() error {
 	// Get port as integer
@@ -94,10 +187,27 @@ func (s *Server) Start() error {
 		prefab.WithContext(baseCtx),
 		prefab.WithHost(s.config.Host),
 		prefab.WithPort(port),
-		prefab.WithHTTPHandlerFunc("/api/status", securityHeaders(s.handleStatus)),
-		prefab.WithHTTPHandlerFunc("/api/stream", securityHeaders(s.handleStream)),
+		prefab.WithGRPCService(&meshtreampb.Meshstream_ServiceDesc, &grpcService{s: s}),
+		prefab.WithGRPCReflection(),
+		prefab.WithHTTPHandlerFunc("/healthz", s.handleHealthz),
+		prefab.WithHTTPHandlerFunc("/readyz", s.handleReadyz),
+		prefab.WithHTTPHandlerFunc("/api/status", securityHeaders(s.authenticate(s.handleStatus))),
+		prefab.WithHTTPHandlerFunc("/api/stream", securityHeaders(s.authenticate(s.handleStream))),
+		prefab.WithHTTPHandlerFunc("/api/snapshot", securityHeaders(s.authenticate(s.handleSnapshot))),
+		prefab.WithHTTPHandlerFunc("/api/stream.ndjson", securityHeaders(s.authenticate(s.handleStreamNDJSON))),
+		prefab.WithHTTPHandlerFunc("/api/export.csv", securityHeaders(s.authenticate(s.handleExportCSV))),
+		prefab.WithHTTPHandlerFunc("/api/ws", securityHeaders(s.authenticate(s.handleWebSocket))),
+		prefab.WithHTTPHandlerFunc("/api/cache", securityHeaders(s.authenticate(requireFullAccess(s.handleCache)))),
+		prefab.WithHTTPHandlerFunc("/api/nodes/{id}/metrics", securityHeaders(s.authenticate(requireFullAccess(s.handleNodeMetrics)))),
+		prefab.WithHTTPHandlerFunc("/api/subscribers", securityHeaders(s.authenticate(requireFullAccess(s.handleSubscribers)))),
+		prefab.WithHTTPHandlerFunc("/api/topology", securityHeaders(s.authenticate(requireFullAccess(s.handleTopology)))),
+		prefab.WithHTTPHandlerFunc("/api/export/nodes.geojson", securityHeaders(s.authenticate(s.handleExportNodes))),
+		prefab.WithHTTPHandlerFunc("/api/export/nodes.kml", securityHeaders(s.authenticate(s.handleExportNodes))),
+		prefab.WithHTTPHandlerFunc("/api/export/tracks/{file}", securityHeaders(s.authenticate(s.handleExportTrack))),
 		prefab.WithStaticFiles("/assets/", s.config.StaticDir),
-		prefab.WithHTTPHandlerFunc("/", s.fallbackHandler),
+		// The page itself asks for credentials so that browsers prompt for a
+		// password and send it with the stream requests.
+		prefab.WithHTTPHandlerFunc("/", s.authenticate(s.fallbackHandler)),
 	)
 
 	// Start the server
@@ -128,8 +238,11 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	logger := s.logger.Named("api.status")
 
 	// Extract channel names without keys for security
+	s.mu.RLock()
+	channelKeys := s.config.ChannelKeys
+	s.mu.RUnlock()
 	var channelNames []string
-	for _, channelKeyPair := range s.config.ChannelKeys {
+	for _, channelKeyPair := range channelKeys {
 		parts := strings.SplitN(channelKeyPair, ":", 2)
 		if len(parts) == 2 {
 			channelNames = append(channelNames, parts[0])
@@ -143,6 +256,7 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		"mqttServer":        s.config.MQTTServer,
 		"mqttTopic":         s.config.MQTTTopicPath,
 		"channels":          channelNames,
+		"ready":             s.readiness().Ready,
 	}
 
 	logger.Debug("Status endpoint called")
@@ -159,7 +273,18 @@ func (s *Server) fallbackHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, s.config.StaticDir+"/index.html")
 }
 
-// handleStream handles Server-Sent Events streaming of MQTT messages
+// packetMarshaler encodes packets for the streaming endpoints, using camelCase
+// names and including unpopulated fields.
+var packetMarshaler = protojson.MarshalOptions{
+	EmitUnpopulated: true,
+	Multiline:       false,
+	UseProtoNames:   false,
+}
+
+// handleStream handles Server-Sent Events streaming of MQTT messages. Query
+// parameters filter the packets sent; see PacketFilter. Each packet's event ID
+// is its broker sequence number, so a reconnecting client only receives the
+// packets it missed.
 func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 	logger := s.logger.Named("api.sse").With("remoteAddr", r.RemoteAddr)
 	ctx := r.Context()
@@ -186,6 +311,13 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := parsePacketFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Policy = auth.PolicyFrom(ctx)
+
 	// Set headers for SSE
 	allowedOrigin := s.config.AllowedOrigin
 	if allowedOrigin == "" {
@@ -203,8 +335,30 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Subscribe to the broker with a buffer size of 100
-	packetChan := s.config.Broker.Subscribe(100)
+	// Subscribe to the broker with a buffer size of 100, resuming after the
+	// last event the client saw if it is reconnecting. Only the cache
+	// partitions the filter can match are replayed.
+	opts := mqtt.SubscribeOptions{Name: "sse " + r.RemoteAddr, BufferSize: 100, Markers: true, Partitions: filter.MatchPartition}
+	lastEventID := lastEventID(r)
+	resumed := false
+	if lastEventID != "" {
+		if seq, err := strconv.ParseUint(lastEventID, 10, 64); err == nil && s.config.Broker.CanResume(seq, filter.MatchPartition) {
+			opts.After, resumed = seq, true
+		}
+		logger.Infow("SSE stream resuming", "lastEventId", lastEventID, "resumed", resumed)
+	}
+
+	// With snapshot=true, a client that isn't resuming gets the materialized
+	// state instead of the raw cache, then the packets that arrived after it.
+	var snapshotData []byte
+	if !resumed && s.config.Snapshot != nil && r.URL.Query().Get("snapshot") == "true" {
+		snap := s.config.Snapshot.Snapshot()
+		var count int
+		snapshotData, count = encodeSnapshot(snap, filter)
+		opts.After = snap.Seq
+		logger.Debugw("Sending snapshot", "packets", count, "bytes", len(snapshotData))
+	}
+	packetChan := s.config.Broker.SubscribeWith(opts)
 
 	// Signal when the client disconnects
 	notify := ctx.Done()
@@ -242,9 +396,29 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 	// Send the event with connection info and padded data
 	fmt.Fprintf(w, "event: connection_info\ndata: %s\n\n", infoJson)
 	fmt.Fprintf(w, "event: padding\ndata: %s\n\n", padding)
+
+	// Tell a reconnecting client when packets it missed are gone, so it knows
+	// the cache is being replayed in full, or a snapshot sent.
+	if lastEventID != "" && !resumed {
+		message := "Packets after the last event are no longer available, replaying the cache"
+		if snapshotData != nil {
+			message = "Packets after the last event are no longer available, sending a snapshot"
+		}
+		gapJson, _ := json.Marshal(GapNotice{Message: message, LastEventID: lastEventID})
+		fmt.Fprintf(w, "event: gap\ndata: %s\n\n", gapJson)
+	}
+	if snapshotData != nil {
+		// The event ID lets the client resume after the snapshot.
+		if opts.After > 0 {
+			fmt.Fprintf(w, "id: %d\n", opts.After)
+		}
+		fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", snapshotData)
+	}
 	flusher.Flush()
 
-	// Stream messages to the client
+	// Stream messages to the client. Cached packets come first, between
+	// replay_start and replay_complete events.
+	replaying, replayed := false, 0
 	for {
 		select {
 		case <-notify:
@@ -270,32 +444,55 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 
 		case packet, ok := <-packetChan:
 			if !ok {
-				// Channel closed, probably shutting down
+				// Channel closed: shutting down, or we fell behind
 				logger.Info("Packet channel closed, ending stream")
 				return
 			}
 
-			if packet == nil {
+			switch packet {
+			case nil:
+				continue
+			case mqtt.ReplayStart, mqtt.ReplayEnd:
+				replaying = packet == mqtt.ReplayStart
+				event := "replay_start"
+				if !replaying {
+					event = "replay_complete"
+					logger.Debugw("Cache replay complete", "packets", replayed)
+				}
+				noticeJson, _ := json.Marshal(ReplayNotice{Packets: replayed})
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, noticeJson)
+				flusher.Flush()
 				continue
 			}
 
-			// Use protojson Marshaler for the protobuf parts of the packet
-			// Create a marshaler with pretty printing enabled
-			marshaler := protojson.MarshalOptions{
-				EmitUnpopulated: true,
-				Multiline:       false,
-				UseProtoNames:   false, // Use camelCase names
+			if !filter.Match(packet) {
+				continue
 			}
 
-			data, err := marshaler.Marshal(packet)
+			data, err := packetMarshaler.Marshal(packet)
 			if err != nil {
 				logger.Errorw("Error marshaling packet to JSON", "error", err)
 				continue
 			}
 
-			// Send the event
-			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
-			flusher.Flush()
+			// Send the event. Flushing once per packet would slow the replay
+			// down, so replayed packets are flushed with the end marker.
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", packet.GetSeq(), data)
+			if replaying {
+				replayed++
+			} else {
+				flusher.Flush()
+			}
 		}
 	}
 }
+
+// lastEventID returns the ID of the last event a reconnecting client
+// received. Browsers send it in the Last-Event-ID header; clients that
+// reconnect by hand can pass it as the lastEventId query parameter.
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
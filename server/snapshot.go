@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"meshstream/auth"
+	"meshstream/snapshot"
+)
+
+// handleSnapshot serves /api/snapshot: the latest state of every node and
+// recent chat as a compact list of packets, filtered like the stream. A
+// client can apply the packets and then follow /api/stream with the returned
+// seq as lastEventId.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if s.config.Snapshot == nil {
+		http.Error(w, "Snapshots are not enabled", http.StatusNotFound)
+		return
+	}
+	filter, err := parsePacketFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Policy = auth.PolicyFrom(r.Context())
+
+	data, count := encodeSnapshot(s.config.Snapshot.Snapshot(), filter)
+	s.logger.Named("api.snapshot").Debugw("Snapshot requested", "packets", count, "bytes", len(data))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// encodeSnapshot returns the packets matching the filter as
+// {"seq":"123","packets":[...]}, using the stream's packet encoding, and how
+// many packets it contains. seq is a string, as protojson encodes uint64s.
+func encodeSnapshot(snap snapshot.Snapshot, filter *PacketFilter) ([]byte, int) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"seq":"` + strconv.FormatUint(snap.Seq, 10) + `","packets":[`)
+	count := 0
+	for _, packet := range snap.Packets {
+		if !filter.Match(packet) {
+			continue
+		}
+		data, err := packetMarshaler.Marshal(packet)
+		if err != nil {
+			continue
+		}
+		if count > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(data)
+		count++
+	}
+	buf.WriteString("]}")
+	return buf.Bytes(), count
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/snapshot"
+)
+
+func newTestSnapshotServer(t *testing.T) (*Server, chan *meshtreampb.Packet) {
+	t.Helper()
+	s, source := newTestServer(t)
+	store := snapshot.NewStore(snapshot.Config{ChatSize: 10, Retention: time.Hour})
+	for _, p := range s.config.Broker.CachedPackets() {
+		store.Observe(p)
+	}
+	s.config.Snapshot = store
+	return s, source
+}
+
+func seqString(seq uint64) string {
+	return strconv.FormatUint(seq, 10)
+}
+
+func TestSnapshot(t *testing.T) {
+	s, _ := newTestSnapshotServer(t)
+
+	rec := httptest.NewRecorder()
+	s.handleSnapshot(rec, httptest.NewRequest(http.MethodGet, "/api/snapshot?channel=LongFast", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	var snap struct {
+		Seq     string `json:"seq"`
+		Packets []struct {
+			Data struct {
+				ID float64 `json:"id"`
+			} `json:"data"`
+		} `json:"packets"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("invalid snapshot %q: %v", rec.Body.String(), err)
+	}
+	if len(snap.Packets) != 2 || snap.Packets[0].Data.ID != 1 || snap.Packets[1].Data.ID != 2 {
+		t.Errorf("expected the LongFast text and position, got %+v", snap.Packets)
+	}
+	if snap.Seq != seqString(s.config.Broker.LastSeq()) {
+		t.Errorf("expected seq %d, got %s", s.config.Broker.LastSeq(), snap.Seq)
+	}
+}
+
+func TestStreamSnapshot(t *testing.T) {
+	s, source := newTestSnapshotServer(t)
+	server := httptest.NewServer(http.HandlerFunc(s.handleStream))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?snapshot=true", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The snapshot replaces the replay: the cached packets are not resent,
+	// and the stream continues with the next live packet.
+	var events []string
+	var id, event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for len(events) < 4 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+			if event == "replay_complete" {
+				source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 10}, Info: &meshtreampb.TopicInfo{}}
+			}
+		case line == "":
+			if event != "padding" && event != "connection_info" {
+				events = append(events, strings.TrimSpace(event+" "+id))
+			}
+			id, event = "", ""
+		}
+	}
+
+	last := seqString(s.config.Broker.LastSeq())
+	want := []string{"snapshot " + seqString(s.config.Broker.LastSeq()-1), "replay_start", "replay_complete", "message " + last}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("want events %v, got %v", want, events)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/auth"
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/mqtt"
+)
+
+// handleStreamNDJSON serves /api/stream.ndjson: one protojson packet per
+// line, starting with the cache and then following live traffic. Pass
+// follow=false to stop after the cache. Accepts the same filters as the SSE
+// stream.
+func (s *Server) handleStreamNDJSON(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.ndjson").With("remoteAddr", r.RemoteAddr)
+
+	s.streamPackets(w, r, logger, "application/x-ndjson", true, func(packet *meshtreampb.Packet) error {
+		data, err := packetMarshaler.Marshal(packet)
+		if err != nil {
+			logger.Errorw("Error marshaling packet to JSON", "error", err)
+			return nil
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}, nil)
+}
+
+// handleExportCSV serves /api/export.csv: the cached packets flattened to one
+// row per packet, with payload columns for the port types selected by the
+// filter. Pass follow=true to keep streaming live traffic.
+func (s *Server) handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.csv").With("remoteAddr", r.RemoteAddr)
+
+	var (
+		writer  *csv.Writer
+		columns []csvColumn
+	)
+	s.streamPackets(w, r, logger, "text/csv; charset=utf-8", false, func(packet *meshtreampb.Packet) error {
+		if err := writer.Write(csvRow(columns, packet)); err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	}, func(filter *PacketFilter) error {
+		writer = csv.NewWriter(w)
+		columns = csvColumns(filter.Ports)
+		if err := writer.Write(csvHeader(columns)); err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+// streamPackets writes packets matching the request's filter using send. It
+// replays the cache and then, when following, live packets until the client
+// disconnects or the server shuts down. start, if set, runs once before the
+// first packet.
+func (s *Server) streamPackets(w http.ResponseWriter, r *http.Request, logger logging.Logger, contentType string, defaultFollow bool,
+	send func(*meshtreampb.Packet) error, start func(*PacketFilter) error) {
+
+	if s.isShuttingDown.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.config.Broker == nil {
+		http.Error(w, "MQTT broker not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter, err := parsePacketFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Policy = auth.PolicyFrom(r.Context())
+	follow := defaultFollow
+	if v := r.URL.Query().Get("follow"); v != "" {
+		if follow, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid 'follow' value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if follow && !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	currentConnections := s.activeConnections.Add(1)
+	logger.Infow("Packet stream requested", "follow", follow, "activeConnections", currentConnections)
+	defer func() {
+		remaining := s.activeConnections.Add(-1)
+		logger.Infow("Packet stream closed", "activeConnections", remaining)
+	}()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if start != nil {
+		if err := start(filter); err != nil {
+			logger.Debugw("Failed to start packet stream", "error", err)
+			return
+		}
+	}
+
+	if !follow {
+		for _, packet := range s.visiblePackets(r.Context()) {
+			if !filter.Match(packet) {
+				continue
+			}
+			if err := send(packet); err != nil {
+				logger.Debugw("Failed to write packet", "error", err)
+				return
+			}
+		}
+		return
+	}
+
+	packetChan := s.config.Broker.SubscribeWith(mqtt.SubscribeOptions{
+		Name:       "ndjson " + r.RemoteAddr,
+		BufferSize: 100,
+		Partitions: filter.MatchPartition,
+	})
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			s.config.Broker.Unsubscribe(packetChan)
+			return
+		case <-s.shutdown:
+			s.config.Broker.Unsubscribe(packetChan)
+			return
+		case packet, ok := <-packetChan:
+			if !ok {
+				// Broker closed, or dropped a client that fell behind
+				return
+			}
+			if packet == nil || !filter.Match(packet) {
+				continue
+			}
+			if err := send(packet); err != nil {
+				logger.Debugw("Failed to write packet", "error", err)
+				s.config.Broker.Unsubscribe(packetChan)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"meshstream/auth"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/mqtt"
+)
+
+func testPackets() []*meshtreampb.Packet {
+	return []*meshtreampb.Packet{
+		{
+			Data: &meshtreampb.Data{
+				Id: 1, From: 0xabcd, To: 0xffffffff, RxTime: 1767225600, GatewayId: "!gw1",
+				PortNum: pb.PortNum_TEXT_MESSAGE_APP,
+				Payload: &meshtreampb.Data_TextMessage{TextMessage: "hello, \"mesh\""},
+			},
+			Info: &meshtreampb.TopicInfo{Channel: "LongFast"},
+		},
+		{
+			Data: &meshtreampb.Data{
+				Id: 2, From: 0xabcd, To: 0xffffffff, GatewayId: "!gw2",
+				PortNum: pb.PortNum_POSITION_APP,
+				Payload: &meshtreampb.Data_Position{Position: &pb.Position{
+					LatitudeI: proto.Int32(377749290), LongitudeI: proto.Int32(-1224194160),
+				}},
+			},
+			Info: &meshtreampb.TopicInfo{Channel: "LongFast"},
+		},
+		{
+			Data: &meshtreampb.Data{
+				Id: 3, From: 0x1234, To: 0xabcd,
+				PortNum: pb.PortNum_TELEMETRY_APP,
+				Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+					Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(75)}},
+				}},
+			},
+			Info: &meshtreampb.TopicInfo{Channel: "Private"},
+		},
+	}
+}
+
+func TestPacketFilter(t *testing.T) {
+	packets := testPackets()
+	tests := []struct {
+		query string
+		want  []uint32
+	}{
+		{"", []uint32{1, 2, 3}},
+		{"channel=LongFast", []uint32{1, 2}},
+		{"port=position", []uint32{2}},
+		{"port=TEXT_MESSAGE_APP,67", []uint32{1, 3}},
+		{"node=!0000abcd", []uint32{1, 2, 3}},
+		{"node=!00001234", []uint32{3}},
+		{"gateway=!gw2&gateway=!gw1", []uint32{1, 2}},
+		{"channel=LongFast&port=telemetry", nil},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		filter, err := parsePacketFilter(query)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		var got []uint32
+		for _, p := range packets {
+			if filter.Match(p) {
+				got = append(got, p.GetData().GetId())
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+
+	query, _ := url.ParseQuery("channel=LongFast")
+	filter, _ := parsePacketFilter(query)
+	filter.Policy = &auth.Policy{Regions: []string{"US/bayarea"}}
+	partitions := map[mqtt.PartitionKey]bool{
+		{}:                        true,
+		{Channel: "LongFast"}:     true,
+		{Channel: "Private"}:      false,
+		{Region: "US/bayarea/sf"}: true,
+		{Region: "EU_868"}:        false,
+		{Region: "US/bayarea", Channel: "Private"}: false,
+	}
+	for key, want := range partitions {
+		if got := filter.MatchPartition(key); got != want {
+			t.Errorf("MatchPartition(%q): got %v, want %v", key, got, want)
+		}
+	}
+
+	for _, bad := range []string{"port=NOT_A_PORT", "node=xyz"} {
+		query, _ := url.ParseQuery(bad)
+		if _, err := parsePacketFilter(query); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, chan *meshtreampb.Packet) {
+	t.Helper()
+	mesh := testutil.NewMesh(t)
+	for _, p := range testPackets() {
+		mesh.Source <- p
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(mesh.Broker.CachedPackets()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return New(Config{Broker: mesh.Broker, Logger: mesh.Logger}), mesh.Source
+}
+
+func TestExportCSV(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	s.handleExportCSV(rec, httptest.NewRequest(http.MethodGet, "/api/export.csv?port=text,position", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 rows, got %v", rows)
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	if _, ok := col["device.battery_level"]; ok {
+		t.Error("telemetry columns should be omitted when filtering to other ports")
+	}
+	if rows[1][col["rx_time"]] != "2026-01-01T00:00:00Z" || rows[1][col["from"]] != "!0000abcd" || rows[1][col["text"]] != `hello, "mesh"` {
+		t.Errorf("unexpected text row: %v", rows[1])
+	}
+	if rows[2][col["latitude"]] != "37.7749290" || rows[2][col["text"]] != "" {
+		t.Errorf("unexpected position row: %v", rows[2])
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleExportCSV(rec, httptest.NewRequest(http.MethodGet, "/api/export.csv?port=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown port, got %d", rec.Code)
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	for v, want := range map[string]string{
+		"=HYPERLINK(\"http://example.com\")": "'=HYPERLINK(\"http://example.com\")",
+		"@SUM(A1)":                           "'@SUM(A1)",
+		"+1":                                 "'+1",
+		"-1":                                 "'-1",
+		"\tx":                                "'\tx",
+		"hello = world":                      "hello = world",
+		"":                                   "",
+	} {
+		if got := csvText(v); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", v, got, want)
+		}
+	}
+
+	row := csvRow(commonColumns, &meshtreampb.Packet{
+		Data: &meshtreampb.Data{RxRssi: -90},
+		Info: &meshtreampb.TopicInfo{Channel: "=cmd"},
+	})
+	if row[4] != "'=cmd" || row[len(row)-2] != "-90" {
+		t.Errorf("expected only text columns to be neutralized, got %v", row)
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	s, source := newTestServer(t)
+	server := httptest.NewServer(http.HandlerFunc(s.handleStreamNDJSON))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?channel=LongFast", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	readID := func() float64 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read line: %v", err)
+		}
+		var packet struct {
+			Data struct {
+				ID float64 `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(line), &packet); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		return packet.Data.ID
+	}
+
+	// Cached packets on the channel, then live ones.
+	if a, b := readID(), readID(); a+b != 3 {
+		t.Errorf("expected cached packets 1 and 2, got %v and %v", a, b)
+	}
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 9}, Info: &meshtreampb.TopicInfo{Channel: "Other"}}
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 10}, Info: &meshtreampb.TopicInfo{Channel: "LongFast"}}
+	if id := readID(); id != 10 {
+		t.Errorf("expected live packet 10, got %v", id)
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Cache-Control"), "no-cache") {
+		t.Errorf("expected no-cache header")
+	}
+}
+
+func TestStreamResume(t *testing.T) {
+	s, _ := newTestServer(t)
+	server := httptest.NewServer(http.HandlerFunc(s.handleStream))
+	defer server.Close()
+	cached := s.config.Broker.CachedPackets()
+
+	// readEvents returns the first n message, gap and replay events as
+	// "event id".
+	readEvents := func(lastEventID string, n int) []string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		req.Header.Set("Last-Event-ID", lastEventID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var events []string
+		var id, event string
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		for len(events) < n && scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case line == "":
+				if event == "message" || event == "gap" || strings.HasPrefix(event, "replay_") {
+					events = append(events, strings.TrimSpace(event+" "+id))
+				}
+				id, event = "", ""
+			}
+		}
+		return events
+	}
+
+	seq := func(p *meshtreampb.Packet) string { return strconv.FormatUint(p.GetSeq(), 10) }
+
+	got := readEvents(seq(cached[0]), 4)
+	want := []string{"replay_start", "message " + seq(cached[1]), "message " + seq(cached[2]), "replay_complete"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected the packets after the first, got %v", got)
+	}
+
+	got = readEvents("12345", 3)
+	if len(got) != 3 || got[0] != "gap" || got[1] != "replay_start" || got[2] != "message "+seq(cached[0]) {
+		t.Errorf("expected a gap notice and the full cache, got %v", got)
+	}
+}
+
+func TestStreamAuthorization(t *testing.T) {
+	s, _ := newTestServer(t)
+	config, err := auth.ParseConfig([]byte(`
+tokens:
+  - name: admin
+    token: admin-token-0123456789
+  - name: longfast
+    token: longfast-token-0123456789
+    channels: [LongFast]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.config.Auth = auth.NewAuthenticator(config, s.logger)
+
+	get := func(handler http.HandlerFunc, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/stream.ndjson?follow=false", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.authenticate(handler)(rec, req)
+		return rec
+	}
+
+	if rec := get(s.handleStreamNDJSON, ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected a 401 challenge without a token, got %d", rec.Code)
+	}
+	if rec := get(s.handleStreamNDJSON, "admin-token-0123456789"); strings.Count(rec.Body.String(), "\n") != 3 {
+		t.Errorf("expected all 3 packets for the admin token, got %q", rec.Body.String())
+	}
+	rec := get(s.handleStreamNDJSON, "longfast-token-0123456789")
+	if strings.Count(rec.Body.String(), "\n") != 2 || strings.Contains(rec.Body.String(), "Private") {
+		t.Errorf("expected only the LongFast packets, got %q", rec.Body.String())
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	if rec := get(requireFullAccess(ok), "longfast-token-0123456789"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a restricted token, got %d", rec.Code)
+	}
+	if rec := get(requireFullAccess(ok), "admin-token-0123456789"); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for an unrestricted token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleSubscribers lists the broker's subscribers with their buffer
+// occupancy and how many packets each has dropped for falling behind.
+func (s *Server) handleSubscribers(w http.ResponseWriter, r *http.Request) {
+	if s.config.Broker == nil {
+		http.Error(w, "MQTT broker not available", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeJSON(w, s.config.Broker.Subscribers())
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleTopology serves the inferred mesh graph as JSON, or as GraphViz DOT
+// when called with ?format=dot.
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.topology")
+
+	if s.config.Topology == nil {
+		http.Error(w, "Topology is not enabled", http.StatusNotFound)
+		return
+	}
+
+	topology := s.config.Topology.Snapshot()
+	logger.Debugw("Topology requested", "nodes", len(topology.Nodes), "edges", len(topology.Edges))
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		s.writeJSON(w, topology)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		if err := topology.WriteDOT(w); err != nil {
+			logger.Warnw("Failed to write DOT output", "error", err)
+		}
+	default:
+		http.Error(w, "Unsupported format; use json or dot", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,445 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"github.com/gorilla/websocket"
+
+	"meshstream/auth"
+	meshtreampb "meshstream/generated/meshstream"
+	"meshstream/mqtt"
+)
+
+// WebSocket timing and buffer limits.
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingInterval   = 30 * time.Second
+	wsQueueSize      = 256
+	wsReportInterval = 5 * time.Second
+	wsMaxMessageSize = 4096
+)
+
+// wsClientMessage is a control message from a WebSocket client:
+//
+//	{"type":"filter","filter":{"channel":["LongFast"],"port":["text"]}}
+//	{"type":"pause"}
+//	{"type":"resume"}
+//	{"type":"replay"}
+//	{"type":"status"}
+//
+// Filter keys are the query parameters accepted by PacketFilter.
+type wsClientMessage struct {
+	Type   string              `json:"type"`
+	Filter map[string][]string `json:"filter,omitempty"`
+}
+
+// wsEvent is a message sent to a WebSocket client. Packets use type
+// "message", matching the SSE event name.
+type wsEvent struct {
+	Type    string          `json:"type"`
+	Packet  json.RawMessage `json:"packet,omitempty"`
+	Message string          `json:"message,omitempty"`
+	*ConnectionInfo
+	*wsStatus
+}
+
+// wsStatus describes a connection's state and backpressure. Dropped counts
+// packets discarded because the client didn't read them fast enough.
+type wsStatus struct {
+	Paused   bool                `json:"paused"`
+	Filter   map[string][]string `json:"filter"`
+	Queued   int                 `json:"queued"`
+	Capacity int                 `json:"capacity"`
+	Dropped  uint64              `json:"dropped"`
+}
+
+// wsConn is one WebSocket client.
+type wsConn struct {
+	s      *Server
+	conn   *websocket.Conn
+	logger logging.Logger
+
+	packets chan []byte // Packet events, dropped when full
+	control chan []byte // Replies and notices, sent ahead of packets
+
+	policy *auth.Policy // The caller's policy, kept across filter changes
+
+	mu     sync.Mutex
+	filter *PacketFilter
+	query  url.Values
+	paused bool
+
+	dropped   atomic.Uint64
+	reported  uint64
+	replaying atomic.Bool // Set while the cache is being replayed, so only one replay runs at a time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// handleWebSocket serves /api/ws: the SSE packet stream over a WebSocket,
+// with filters that can be changed without reconnecting. The initial filter
+// comes from the query string, as for SSE.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	logger := s.logger.Named("api.ws").With("remoteAddr", r.RemoteAddr)
+
+	if s.isShuttingDown.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.config.Broker == nil {
+		http.Error(w, "MQTT broker not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parsePacketFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Policy = auth.PolicyFrom(r.Context())
+
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		logger.Debugw("WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	currentConnections := s.activeConnections.Add(1)
+	logger.Infow("WebSocket connected", "activeConnections", currentConnections)
+	defer func() {
+		remaining := s.activeConnections.Add(-1)
+		logger.Infow("WebSocket closed", "activeConnections", remaining)
+	}()
+
+	c := &wsConn{
+		s:       s,
+		conn:    conn,
+		logger:  logger,
+		packets: make(chan []byte, wsQueueSize),
+		control: make(chan []byte, 16),
+		policy:  filter.Policy,
+		filter:  filter,
+		query:   filterQuery(query),
+		done:    make(chan struct{}),
+	}
+	c.run()
+}
+
+// checkOrigin allows cross-origin WebSocket connections from the configured
+// origin, or from anywhere when the stream is public.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	allowed := s.config.AllowedOrigin
+	if allowed == "" || allowed == "*" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == allowed
+}
+
+func (c *wsConn) run() {
+	// The cache is replayed on connect, before any requested replay.
+	c.replaying.Store(true)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.writeLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		c.readLoop()
+	}()
+
+	c.sendControl(wsEvent{Type: "connection_info", ConnectionInfo: &ConnectionInfo{
+		Message:    "Connected to stream",
+		MQTTServer: c.s.config.MQTTServer,
+		MQTTTopic:  c.s.config.MQTTTopicPath,
+		Connected:  true,
+		ServerTime: time.Now().Unix(),
+	}})
+
+	// Replay only the cache partitions the filter at connect time can match.
+	c.mu.Lock()
+	filter := c.filter
+	c.mu.Unlock()
+	packetChan := c.s.config.Broker.SubscribeWith(mqtt.SubscribeOptions{
+		Name:       "ws " + c.conn.RemoteAddr().String(),
+		BufferSize: 100,
+		Markers:    true,
+		Partitions: filter.MatchPartition,
+	})
+	brokerClosed, replaying := false, false
+	defer c.replaying.Store(false)
+	report := time.NewTicker(wsReportInterval)
+	defer report.Stop()
+
+loop:
+	for {
+		select {
+		case <-c.done:
+			break loop
+		case <-c.s.shutdown:
+			c.sendControl(wsEvent{Type: "info", Message: "Server shutting down, connection closed"})
+			break loop
+		case <-report.C:
+			c.reportBackpressure()
+		case packet, ok := <-packetChan:
+			if !ok {
+				brokerClosed = true
+				break loop
+			}
+			switch packet {
+			case mqtt.ReplayStart:
+				replaying = true
+				c.queue(wsEvent{Type: "replay_start"}, true)
+			case mqtt.ReplayEnd:
+				replaying = false
+				c.replaying.Store(false)
+				c.queue(wsEvent{Type: "replay_complete", wsStatus: c.status()}, true)
+			default:
+				// The replay is paced to the client, so it waits for room.
+				c.deliver(packet, replaying)
+			}
+		}
+	}
+
+	if !brokerClosed {
+		c.s.config.Broker.Unsubscribe(packetChan)
+	}
+	c.close()
+	wg.Wait()
+}
+
+// deliver queues a packet if it passes the filter. Live packets are dropped
+// when the queue is full; replayed packets wait for room.
+func (c *wsConn) deliver(packet *meshtreampb.Packet, wait bool) {
+	if packet == nil {
+		return
+	}
+	c.mu.Lock()
+	paused, filter := c.paused, c.filter
+	c.mu.Unlock()
+	if paused || !filter.Match(packet) {
+		return
+	}
+
+	data, err := packetMarshaler.Marshal(packet)
+	if err != nil {
+		c.logger.Errorw("Error marshaling packet to JSON", "error", err)
+		return
+	}
+	c.queue(wsEvent{Type: "message", Packet: data}, wait)
+}
+
+// queue adds an event behind the packets already queued, dropping it if the
+// queue is full unless wait is set.
+func (c *wsConn) queue(event wsEvent, wait bool) {
+	msg, _ := json.Marshal(event)
+	if wait {
+		select {
+		case c.packets <- msg:
+		case <-c.done:
+		}
+		return
+	}
+	select {
+	case c.packets <- msg:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// reportBackpressure tells the client how many packets were dropped since
+// the last report, if any.
+func (c *wsConn) reportBackpressure() {
+	dropped := c.dropped.Load()
+	if dropped == c.reported {
+		return
+	}
+	c.logger.Debugw("WebSocket client falling behind", "dropped", dropped-c.reported)
+	c.reported = dropped
+	c.sendControl(wsEvent{Type: "backpressure", wsStatus: c.status()})
+}
+
+func (c *wsConn) status() *wsStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &wsStatus{
+		Paused:   c.paused,
+		Filter:   c.query,
+		Queued:   len(c.packets),
+		Capacity: cap(c.packets),
+		Dropped:  c.dropped.Load(),
+	}
+}
+
+// readLoop handles client control messages until the connection closes.
+func (c *wsConn) readLoop() {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.logger.Debugw("WebSocket read failed", "error", err)
+			}
+			return
+		}
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.sendControl(wsEvent{Type: "error", Message: "invalid message: " + err.Error()})
+			continue
+		}
+		c.handleMessage(msg)
+	}
+}
+
+func (c *wsConn) handleMessage(msg wsClientMessage) {
+	switch msg.Type {
+	case "filter":
+		query := url.Values(msg.Filter)
+		filter, err := parsePacketFilter(query)
+		if err != nil {
+			c.sendControl(wsEvent{Type: "error", Message: err.Error()})
+			return
+		}
+		filter.Policy = c.policy
+		c.mu.Lock()
+		c.filter, c.query = filter, filterQuery(query)
+		c.mu.Unlock()
+
+	case "pause", "resume":
+		c.mu.Lock()
+		c.paused = msg.Type == "pause"
+		c.mu.Unlock()
+
+	case "replay":
+		// Replays would interleave, and each holds a copy of the cache.
+		if !c.replaying.CompareAndSwap(false, true) {
+			c.sendControl(wsEvent{Type: "error", Message: "a replay is already in progress"})
+			return
+		}
+		// Wait for room rather than dropping, but off the read loop so
+		// control messages are still handled.
+		packets := c.s.config.Broker.CachedPackets()
+		go func() {
+			for _, p := range packets {
+				c.deliver(p, true)
+			}
+			c.replaying.Store(false)
+			c.queue(wsEvent{Type: "replay_done", wsStatus: c.status()}, true)
+		}()
+		return
+
+	case "status":
+
+	default:
+		c.sendControl(wsEvent{Type: "error", Message: "unknown message type " + msg.Type})
+		return
+	}
+	c.sendControl(wsEvent{Type: "status", wsStatus: c.status()})
+}
+
+// sendControl queues a message ahead of packets.
+func (c *wsConn) sendControl(event wsEvent) {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		c.logger.Errorw("Failed to encode WebSocket message", "error", err)
+		return
+	}
+	select {
+	case c.control <- msg:
+	case <-c.done:
+	}
+}
+
+// writeLoop is the connection's only writer.
+func (c *wsConn) writeLoop() {
+	defer c.close()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	write := func(msgType int, data []byte) bool {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := c.conn.WriteMessage(msgType, data); err != nil {
+			c.logger.Debugw("WebSocket write failed", "error", err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		// Control messages go first so replies aren't stuck behind packets.
+		select {
+		case msg := <-c.control:
+			if !write(websocket.TextMessage, msg) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case <-c.done:
+			// Flush pending notices, e.g. the shutdown message.
+			for {
+				select {
+				case msg := <-c.control:
+					write(websocket.TextMessage, msg)
+				default:
+					c.conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+					return
+				}
+			}
+		case msg := <-c.control:
+			if !write(websocket.TextMessage, msg) {
+				return
+			}
+		case msg := <-c.packets:
+			if !write(websocket.TextMessage, msg) {
+				return
+			}
+		case <-ping.C:
+			if !write(websocket.PingMessage, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// filterQuery returns only the filter parameters of a query, for reporting
+// the active filter back to the client.
+func filterQuery(query url.Values) map[string][]string {
+	result := make(map[string][]string)
+	for _, key := range []string{"channel", "port", "node", "gateway"} {
+		if values := splitParam(query, key); len(values) > 0 {
+			result[key] = values
+		}
+	}
+	return result
+}
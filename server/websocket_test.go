@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	meshtreampb "meshstream/generated/meshstream"
+)
+
+type testWSEvent struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Paused  bool   `json:"paused"`
+	Packet  struct {
+		Data struct {
+			ID float64 `json:"id"`
+		} `json:"data"`
+	} `json:"packet"`
+}
+
+func TestWebSocket(t *testing.T) {
+	s, source := newTestServer(t)
+	server := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?channel=LongFast", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	read := func() testWSEvent {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var event testWSEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		return event
+	}
+	// readUntil skips events until one of the given type arrives.
+	readUntil := func(eventType string) testWSEvent {
+		t.Helper()
+		for {
+			if event := read(); event.Type == eventType {
+				return event
+			}
+		}
+	}
+	send := func(msg string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cache is replayed on connect using the query string filter,
+	// between replay markers.
+	readUntil("replay_start")
+	if a, b := read(), read(); a.Type != "message" || b.Type != "message" || a.Packet.Data.ID+b.Packet.Data.ID != 3 {
+		t.Errorf("expected cached packets 1 and 2, got %+v and %+v", a, b)
+	}
+	if event := read(); event.Type != "replay_complete" {
+		t.Errorf("expected replay_complete after the cached packets, got %q", event.Type)
+	}
+
+	send(`{"type":"filter","filter":{"channel":["Private"]}}`)
+	readUntil("status")
+	send(`{"type":"replay"}`)
+	if event := readUntil("message"); event.Packet.Data.ID != 3 {
+		t.Errorf("expected replayed packet 3, got %v", event.Packet.Data.ID)
+	}
+	readUntil("replay_done")
+
+	send(`{"type":"pause"}`)
+	if event := readUntil("status"); !event.Paused {
+		t.Error("expected paused status")
+	}
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 10}, Info: &meshtreampb.TopicInfo{Channel: "Private"}}
+	time.Sleep(100 * time.Millisecond)
+	send(`{"type":"resume"}`)
+	if event := readUntil("status"); event.Paused {
+		t.Error("expected resumed status")
+	}
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{Id: 11}, Info: &meshtreampb.TopicInfo{Channel: "Private"}}
+	if event := readUntil("message"); event.Packet.Data.ID != 11 {
+		t.Errorf("expected live packet 11 after resuming, got %v", event.Packet.Data.ID)
+	}
+
+	send(`{"type":"filter","filter":{"port":["bogus"]}}`)
+	if event := readUntil("error"); !strings.Contains(event.Message, "bogus") {
+		t.Errorf("unexpected error message %q", event.Message)
+	}
+	send(`not json`)
+	readUntil("error")
+}
+
+func TestWebSocketOneReplayAtATime(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := &wsConn{
+		s:       s,
+		logger:  s.logger,
+		packets: make(chan []byte), // Nothing reads, so a replay never finishes
+		control: make(chan []byte, 16),
+		filter:  &PacketFilter{},
+		done:    make(chan struct{}),
+	}
+	defer close(c.done)
+
+	c.handleMessage(wsClientMessage{Type: "replay"})
+	c.handleMessage(wsClientMessage{Type: "replay"})
+
+	var event testWSEvent
+	if err := json.Unmarshal(<-c.control, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "error" || !strings.Contains(event.Message, "in progress") {
+		t.Errorf("expected an error for the second replay, got %+v", event)
+	}
+}
+
+func TestWebSocketBackpressure(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := &wsConn{
+		s:       s,
+		logger:  s.logger,
+		packets: make(chan []byte, 1),
+		control: make(chan []byte, 16),
+		filter:  &PacketFilter{},
+		done:    make(chan struct{}),
+	}
+	for _, p := range testPackets() {
+		c.deliver(p, false)
+	}
+	c.reportBackpressure()
+
+	var status struct {
+		Type     string `json:"type"`
+		Queued   int    `json:"queued"`
+		Capacity int    `json:"capacity"`
+		Dropped  uint64 `json:"dropped"`
+	}
+	if err := json.Unmarshal(<-c.control, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Type != "backpressure" || status.Dropped != 2 || status.Queued != 1 || status.Capacity != 1 {
+		t.Errorf("unexpected backpressure report %+v", status)
+	}
+
+	// No new drops, no new report.
+	c.reportBackpressure()
+	if len(c.control) != 0 {
+		t.Error("expected no report without new drops")
+	}
+}
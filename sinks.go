@@ -0,0 +1,418 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/alerts"
+	"meshstream/aprs"
+	"meshstream/chatbridge"
+	"meshstream/health"
+	"meshstream/homeassistant"
+	"meshstream/influx"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+	"meshstream/tak"
+)
+
+// haSettings configures the Home Assistant integration and its MQTT client.
+type haSettings struct {
+	Client      mqtt.Config
+	Integration homeassistant.Config
+}
+
+// sinks are the integrations fed by the broker. Each one is recreated when
+// its settings change on reload, while the others keep running.
+type sinks struct {
+	broker    *mqtt.Broker
+	directory *nodes.Directory
+	logger    logging.Logger
+
+	// Serializes apply and Close, which stop and start sinks
+	applyMu sync.Mutex
+	// Guards the running sinks, which Health reads while apply replaces them.
+	// It is only held to read or swap them, since stopping or starting a sink
+	// can take a while and readiness checks shouldn't wait for it.
+	mu sync.Mutex
+
+	// Settings each running sink was started with, nil when it is disabled
+	haConfig     *haSettings
+	influxConfig *influx.Config
+	alertsConfig *alerts.Config
+	bridgeConfig *chatbridge.Config
+	aprsConfig   *aprs.Config
+	takConfig    *tak.Config
+
+	haClient   *mqtt.Client
+	ha         *homeassistant.Integration
+	influx     *influx.Sink
+	alerts     *alerts.Engine
+	chatBridge *chatbridge.Bridge
+	aprs       *aprs.Gateway
+	tak        *tak.Output
+}
+
+func newSinks(broker *mqtt.Broker, directory *nodes.Directory, logger logging.Logger) *sinks {
+	return &sinks{broker: broker, directory: directory, logger: logger}
+}
+
+// apply starts, restarts or stops each sink whose settings differ from the
+// ones it is running with. Recreated sinks skip the broker cache if
+// skipCache is set, so that chat and alerts already handled aren't sent
+// again. A sink that fails to start stays stopped until its settings change.
+func (s *sinks) apply(config *Config, skipCache bool) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	var errs []error
+
+	ha, err := homeAssistantSettings(config)
+	if err == nil && !reflect.DeepEqual(ha, s.haConfig) {
+		s.stopHomeAssistant()
+		if ha != nil {
+			err = s.startHomeAssistant(ha, skipCache)
+		}
+	}
+	errs = append(errs, err)
+
+	influxConfig := influxSettings(config)
+	if !reflect.DeepEqual(influxConfig, s.influxConfig) {
+		s.mu.Lock()
+		running := s.influx
+		s.influx, s.influxConfig = nil, nil
+		s.mu.Unlock()
+		if running != nil {
+			running.Close()
+		}
+		if influxConfig != nil {
+			errs = append(errs, s.startInflux(*influxConfig, skipCache))
+		}
+	}
+
+	alertsConfig, err := alertsSettings(config)
+	if err == nil && !reflect.DeepEqual(alertsConfig, s.alertsConfig) {
+		s.mu.Lock()
+		running := s.alerts
+		s.alerts, s.alertsConfig = nil, nil
+		s.mu.Unlock()
+		if running != nil {
+			running.Close()
+		}
+		if alertsConfig != nil {
+			err = s.startAlerts(*alertsConfig, skipCache)
+		}
+	}
+	errs = append(errs, err)
+
+	bridgeConfig := chatBridgeSettings(config)
+	if !reflect.DeepEqual(bridgeConfig, s.bridgeConfig) {
+		s.mu.Lock()
+		running := s.chatBridge
+		s.chatBridge, s.bridgeConfig = nil, nil
+		s.mu.Unlock()
+		if running != nil {
+			running.Close()
+		}
+		if bridgeConfig != nil {
+			errs = append(errs, s.startChatBridge(*bridgeConfig, skipCache))
+		}
+	}
+
+	aprsConfig, err := aprsSettings(config)
+	if err == nil && !reflect.DeepEqual(aprsConfig, s.aprsConfig) {
+		s.mu.Lock()
+		running := s.aprs
+		s.aprs, s.aprsConfig = nil, nil
+		s.mu.Unlock()
+		if running != nil {
+			running.Close()
+		}
+		if aprsConfig != nil {
+			err = s.startAPRS(*aprsConfig, skipCache)
+		}
+	}
+	errs = append(errs, err)
+
+	takConfig := takSettings(config)
+	if !reflect.DeepEqual(takConfig, s.takConfig) {
+		s.mu.Lock()
+		running := s.tak
+		s.tak, s.takConfig = nil, nil
+		s.mu.Unlock()
+		if running != nil {
+			running.Close()
+		}
+		if takConfig != nil {
+			errs = append(errs, s.startTAK(*takConfig, skipCache))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *sinks) startHomeAssistant(settings *haSettings, skipCache bool) error {
+	client := mqtt.NewClient(settings.Client, s.logger)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to Home Assistant MQTT broker: %v", err)
+	}
+	config := settings.Integration
+	config.SkipCache = skipCache
+	integration, err := homeassistant.NewIntegration(config, s.broker, client, s.directory, s.logger)
+	if err != nil {
+		client.Disconnect()
+		return fmt.Errorf("failed to initialize Home Assistant integration: %v", err)
+	}
+	s.mu.Lock()
+	s.haClient, s.ha, s.haConfig = client, integration, settings
+	s.mu.Unlock()
+	s.logger.Infof("Home Assistant discovery enabled for %d nodes", len(config.Nodes))
+	return nil
+}
+
+func (s *sinks) stopHomeAssistant() {
+	s.mu.Lock()
+	client, integration := s.haClient, s.ha
+	s.haClient, s.ha, s.haConfig = nil, nil, nil
+	s.mu.Unlock()
+	if integration != nil {
+		integration.Close()
+		client.Disconnect()
+	}
+}
+
+func (s *sinks) startInflux(config influx.Config, skipCache bool) error {
+	settings := config
+	config.SkipCache = skipCache
+	sink, err := influx.NewSink(config, s.broker, s.directory, s.logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize telemetry sink: %v", err)
+	}
+	s.mu.Lock()
+	s.influx, s.influxConfig = sink, &settings
+	s.mu.Unlock()
+	s.logger.Info("Telemetry sink enabled")
+	return nil
+}
+
+func (s *sinks) startAlerts(config alerts.Config, skipCache bool) error {
+	settings := config
+	config.SkipCache = skipCache
+	engine, err := alerts.NewEngine(config, s.broker, s.directory, s.logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize alerting: %v", err)
+	}
+	s.mu.Lock()
+	s.alerts, s.alertsConfig = engine, &settings
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *sinks) startChatBridge(config chatbridge.Config, skipCache bool) error {
+	settings := config
+	config.SkipCache = skipCache
+	bridge, err := chatbridge.NewBridge(config, s.broker, s.directory, s.logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize chat bridge: %v", err)
+	}
+	s.mu.Lock()
+	s.chatBridge, s.bridgeConfig = bridge, &settings
+	s.mu.Unlock()
+	s.logger.Infof("Chat bridge enabled for channels: %s", strings.Join(config.Channels, ", "))
+	return nil
+}
+
+func (s *sinks) startAPRS(config aprs.Config, skipCache bool) error {
+	settings := config
+	config.SkipCache = skipCache
+	gateway, err := aprs.NewGateway(config, s.broker, s.directory, s.logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize APRS gateway: %v", err)
+	}
+	s.mu.Lock()
+	s.aprs, s.aprsConfig = gateway, &settings
+	s.mu.Unlock()
+	s.logger.Infof("APRS gateway enabled for %d nodes via %s", len(config.Stations), config.Server)
+	return nil
+}
+
+func (s *sinks) startTAK(config tak.Config, skipCache bool) error {
+	settings := config
+	config.SkipCache = skipCache
+	output, err := tak.NewOutput(config, s.broker, s.directory, s.logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize TAK output: %v", err)
+	}
+	s.mu.Lock()
+	s.tak, s.takConfig = output, &settings
+	s.mu.Unlock()
+	s.logger.Infof("TAK output enabled via %s %s", config.Protocol, config.Address)
+	return nil
+}
+
+// Close stops every sink.
+func (s *sinks) Close() {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	s.stopHomeAssistant()
+
+	s.mu.Lock()
+	influx, alerts, chatBridge, aprs, tak := s.influx, s.alerts, s.chatBridge, s.aprs, s.tak
+	s.influx, s.alerts, s.chatBridge, s.aprs, s.tak = nil, nil, nil, nil, nil
+	s.influxConfig, s.alertsConfig, s.bridgeConfig, s.aprsConfig, s.takConfig = nil, nil, nil, nil, nil
+	s.mu.Unlock()
+
+	if influx != nil {
+		influx.Close()
+	}
+	if alerts != nil {
+		alerts.Close()
+	}
+	if chatBridge != nil {
+		chatBridge.Close()
+	}
+	if aprs != nil {
+		aprs.Close()
+	}
+	if tak != nil {
+		tak.Close()
+	}
+}
+
+// Health reports the health of each running sink by name.
+func (s *sinks) Health() map[string]health.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make(map[string]health.Status)
+	if s.ha != nil {
+		statuses["home_assistant"] = s.ha.Health()
+	}
+	if s.influx != nil {
+		statuses["influx"] = s.influx.Health()
+	}
+	if s.alerts != nil {
+		statuses["alerts"] = s.alerts.Health()
+	}
+	if s.chatBridge != nil {
+		statuses["chat_bridge"] = s.chatBridge.Health()
+	}
+	if s.aprs != nil {
+		statuses["aprs"] = s.aprs.Health()
+	}
+	if s.tak != nil {
+		statuses["tak"] = s.tak.Health()
+	}
+	return statuses
+}
+
+// homeAssistantSettings returns the Home Assistant settings, or nil if the
+// integration is disabled.
+func homeAssistantSettings(config *Config) (*haSettings, error) {
+	if config.HABroker == "" {
+		return nil, nil
+	}
+	haNodes, err := nodes.ParseIDs(config.HANodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Home Assistant node list: %v", err)
+	}
+	return &haSettings{
+		Client: mqtt.Config{
+			Broker:   config.HABroker,
+			Username: config.HAUsername,
+			Password: config.HAPassword,
+			ClientID: config.MQTTClientID + "-ha",
+		},
+		Integration: homeassistant.Config{
+			DiscoveryPrefix: config.HADiscoveryPrefix,
+			Nodes:           haNodes,
+		},
+	}, nil
+}
+
+// influxSettings returns the telemetry sink settings, or nil if it is
+// disabled.
+func influxSettings(config *Config) *influx.Config {
+	if config.InfluxURL == "" && config.InfluxFile == "" {
+		return nil
+	}
+	return &influx.Config{
+		URL:           config.InfluxURL,
+		Org:           config.InfluxOrg,
+		Bucket:        config.InfluxBucket,
+		Token:         config.InfluxToken,
+		FilePath:      config.InfluxFile,
+		BatchSize:     config.InfluxBatchSize,
+		FlushInterval: config.InfluxFlushInterval,
+	}
+}
+
+// alertsSettings returns the alerting rules, from --alerts-config or else
+// the config file, or nil if alerting is disabled.
+func alertsSettings(config *Config) (*alerts.Config, error) {
+	if config.AlertsConfig != "" {
+		alertsConfig, err := alerts.LoadConfig(config.AlertsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load alerting rules from %s: %v", config.AlertsConfig, err)
+		}
+		return &alertsConfig, nil
+	}
+	return config.FileAlerts, nil
+}
+
+// chatBridgeSettings returns the chat bridge settings, or nil if it is
+// disabled.
+func chatBridgeSettings(config *Config) *chatbridge.Config {
+	if len(config.BridgeChannels) == 0 {
+		return nil
+	}
+	bridgeConfig := &chatbridge.Config{
+		Channels:       config.BridgeChannels,
+		DiscordWebhook: config.BridgeDiscordWebhook,
+		SlackWebhook:   config.BridgeSlackWebhook,
+	}
+	if config.BridgeMatrixServer != "" {
+		bridgeConfig.Matrix = &chatbridge.MatrixConfig{
+			Homeserver:  config.BridgeMatrixServer,
+			RoomID:      config.BridgeMatrixRoom,
+			AccessToken: config.BridgeMatrixToken,
+		}
+	}
+	return bridgeConfig
+}
+
+// aprsSettings returns the APRS-IS gateway settings, or nil if it is
+// disabled.
+func aprsSettings(config *Config) (*aprs.Config, error) {
+	if config.APRSCallsign == "" {
+		return nil, nil
+	}
+	stations, err := aprs.ParseStations(config.APRSStations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APRS station mapping: %v", err)
+	}
+	return &aprs.Config{
+		Server:      config.APRSServer,
+		Callsign:    config.APRSCallsign,
+		Passcode:    config.APRSPasscode,
+		Stations:    stations,
+		MinInterval: config.APRSInterval,
+		Comment:     config.APRSComment,
+	}, nil
+}
+
+// takSettings returns the Cursor-on-Target output settings, or nil if it is
+// disabled.
+func takSettings(config *Config) *tak.Config {
+	if config.TAKAddress == "" {
+		return nil
+	}
+	return &tak.Config{
+		Address:  config.TAKAddress,
+		Protocol: config.TAKProtocol,
+		Stale:    config.CacheRetention,
+		Channels: config.TAKChannels,
+	}
+}
@@ -0,0 +1,192 @@
+// Package snapshot materializes the current state of the mesh from the packet
+// stream, so a new client can be brought up to date with a few packets per
+// node instead of a replay of the whole cache.
+package snapshot
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+// Config controls what a Store keeps.
+type Config struct {
+	ChatSize  int           // Text messages kept per channel
+	Retention time.Duration // Nodes silent for longer are forgotten
+}
+
+// Snapshot is the materialized state as packets, in arrival order. Seq is the
+// sequence number of the latest packet the state includes; a client resumes
+// the stream after it.
+type Snapshot struct {
+	Seq     uint64
+	Packets []*meshtreampb.Packet
+}
+
+// nodeState holds the latest packet of each kind a node has sent.
+type nodeState struct {
+	lastHeard time.Time
+	latest    map[string]*meshtreampb.Packet // Keyed by packetKind
+}
+
+// Store keeps the latest NODEINFO, position, map report, neighbor info and
+// telemetry of each variant per node, and the most recent text messages per
+// channel. Its Observe method is a subscriber processor.
+type Store struct {
+	config Config
+
+	mu        sync.RWMutex
+	nodes     map[uint32]*nodeState
+	chat      map[string][]*meshtreampb.Packet // Channel ID → oldest first
+	lastSeq   uint64
+	lastPrune time.Time
+	nowFunc   func() time.Time // injectable for testing
+}
+
+// pruneInterval is how often Observe forgets silent nodes.
+const pruneInterval = time.Minute
+
+// NewStore creates an empty store.
+func NewStore(config Config) *Store {
+	return &Store{
+		config:  config,
+		nodes:   make(map[uint32]*nodeState),
+		chat:    make(map[string][]*meshtreampb.Packet),
+		nowFunc: time.Now,
+	}
+}
+
+// Observe updates the state from a packet.
+func (s *Store) Observe(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	kind := packetKind(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSeq = max(s.lastSeq, packet.GetSeq())
+
+	if data.GetFrom() == 0 || kind == "" {
+		return
+	}
+
+	node := s.nodes[data.GetFrom()]
+	if node == nil {
+		node = &nodeState{latest: make(map[string]*meshtreampb.Packet)}
+		s.nodes[data.GetFrom()] = node
+	}
+	now := s.nowFunc()
+	node.lastHeard = now
+	if now.Sub(s.lastPrune) >= pruneInterval {
+		s.prune(now)
+		s.lastPrune = now
+	}
+
+	if kind != "text" {
+		node.latest[kind] = packet
+		return
+	}
+	if s.config.ChatSize <= 0 {
+		return
+	}
+	channel := data.GetChannelId()
+	messages := append(s.chat[channel], packet)
+	if len(messages) > s.config.ChatSize {
+		messages = append(messages[:0:0], messages[len(messages)-s.config.ChatSize:]...)
+	}
+	s.chat[channel] = messages
+}
+
+// Snapshot returns the current state. Nodes silent for longer than the
+// retention window are left out, along with their chat.
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := s.nowFunc().Add(-s.config.Retention)
+	active := func(num uint32) bool {
+		node := s.nodes[num]
+		return node != nil && (s.config.Retention <= 0 || node.lastHeard.After(cutoff))
+	}
+
+	var packets []*meshtreampb.Packet
+	for num, node := range s.nodes {
+		if !active(num) {
+			continue
+		}
+		for _, p := range node.latest {
+			packets = append(packets, p)
+		}
+	}
+	for _, messages := range s.chat {
+		for _, p := range messages {
+			if active(p.GetData().GetFrom()) {
+				packets = append(packets, p)
+			}
+		}
+	}
+	sort.Slice(packets, func(i, j int) bool { return packets[i].GetSeq() < packets[j].GetSeq() })
+
+	return Snapshot{Seq: s.lastSeq, Packets: packets}
+}
+
+// prune forgets nodes silent for longer than the retention window, so the
+// store doesn't grow with every node ever heard.
+// Must be called with s.mu held.
+func (s *Store) prune(now time.Time) {
+	if s.config.Retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-s.config.Retention)
+	for num, node := range s.nodes {
+		if !node.lastHeard.After(cutoff) {
+			delete(s.nodes, num)
+		}
+	}
+	for channel, messages := range s.chat {
+		kept := messages[:0]
+		for _, p := range messages {
+			if s.nodes[p.GetData().GetFrom()] != nil {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.chat, channel)
+		} else {
+			s.chat[channel] = kept
+		}
+	}
+}
+
+// packetKind returns which piece of node state a packet replaces, "text"
+// for chat, or "" for packets that aren't kept.
+func packetKind(data *meshtreampb.Data) string {
+	switch data.GetPortNum() {
+	case pb.PortNum_NODEINFO_APP:
+		if data.GetNodeInfo() != nil {
+			return "nodeinfo"
+		}
+	case pb.PortNum_POSITION_APP:
+		if data.GetPosition() != nil {
+			return "position"
+		}
+	case pb.PortNum_MAP_REPORT_APP:
+		if data.GetMapReport() != nil {
+			return "mapreport"
+		}
+	case pb.PortNum_NEIGHBORINFO_APP:
+		if data.GetNeighborInfo() != nil {
+			return "neighborinfo"
+		}
+	case pb.PortNum_TELEMETRY_APP:
+		if kind, _ := decoder.TelemetryMetrics(data.GetTelemetry()); kind != "" {
+			return "telemetry." + kind
+		}
+	case pb.PortNum_TEXT_MESSAGE_APP:
+		return "text"
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+// packet numbers data as the broker would.
+func packet(seq uint64, from uint32, data *meshtreampb.Data) *meshtreampb.Packet {
+	data.Id, data.From, data.ChannelId = uint32(seq), from, "LongFast"
+	return &meshtreampb.Packet{Seq: seq, Data: data, Info: &meshtreampb.TopicInfo{}}
+}
+
+func nodeInfo(seq uint64, from uint32) *meshtreampb.Packet {
+	return packet(seq, from, &meshtreampb.Data{
+		PortNum: pb.PortNum_NODEINFO_APP,
+		Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{LongName: "node"}},
+	})
+}
+
+func text(seq uint64, from uint32) *meshtreampb.Packet {
+	return packet(seq, from, &meshtreampb.Data{
+		PortNum: pb.PortNum_TEXT_MESSAGE_APP,
+		Payload: &meshtreampb.Data_TextMessage{TextMessage: "hi"},
+	})
+}
+
+func telemetry(seq uint64, from uint32, t *pb.Telemetry) *meshtreampb.Packet {
+	return packet(seq, from, &meshtreampb.Data{
+		PortNum: pb.PortNum_TELEMETRY_APP,
+		Payload: &meshtreampb.Data_Telemetry{Telemetry: t},
+	})
+}
+
+func ids(packets []*meshtreampb.Packet) []uint64 {
+	var result []uint64
+	for _, p := range packets {
+		result = append(result, p.Seq)
+	}
+	return result
+}
+
+func TestStore(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	store := NewStore(Config{ChatSize: 2, Retention: time.Hour})
+	store.nowFunc = func() time.Time { return now }
+
+	device := &pb.Telemetry{Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(80)}}}
+	environment := &pb.Telemetry{Variant: &pb.Telemetry_EnvironmentMetrics{EnvironmentMetrics: &pb.EnvironmentMetrics{Temperature: proto.Float32(20)}}}
+	for _, p := range []*meshtreampb.Packet{
+		nodeInfo(1, 0xa),
+		packet(2, 0xa, &meshtreampb.Data{
+			PortNum: pb.PortNum_POSITION_APP,
+			Payload: &meshtreampb.Data_Position{Position: &pb.Position{}},
+		}),
+		telemetry(3, 0xa, device),
+		text(4, 0xa),
+		nodeInfo(5, 0xa), // Replaces 1
+		telemetry(6, 0xa, environment),
+		text(7, 0xb),
+		packet(8, 0xb, &meshtreampb.Data{PortNum: pb.PortNum_ROUTING_APP}), // Not kept
+		text(9, 0xa), // Pushes 4 out of the chat
+		telemetry(10, 0xb, device),
+	} {
+		store.Observe(p)
+	}
+
+	snap := store.Snapshot()
+	want := []uint64{2, 3, 5, 6, 7, 9, 10}
+	if got := ids(snap.Packets); len(got) != len(want) || snap.Seq != 10 {
+		t.Fatalf("want packets %v at seq 10, got %v at seq %d", want, got, snap.Seq)
+	}
+	for i, seq := range want {
+		if snap.Packets[i].Seq != seq {
+			t.Errorf("want packets %v, got %v", want, ids(snap.Packets))
+			break
+		}
+	}
+
+	// Node 0xb goes silent; once past retention its state and chat are dropped.
+	now = now.Add(50 * time.Minute)
+	store.Observe(nodeInfo(11, 0xa))
+	now = now.Add(20 * time.Minute)
+	if got := ids(store.Snapshot().Packets); len(got) != 5 || got[4] != 11 {
+		t.Errorf("expected node 0xb to be dropped, got %v", got)
+	}
+
+	store.Observe(nodeInfo(12, 0xc))
+	if _, ok := store.nodes[0xb]; ok {
+		t.Error("expected node 0xb to be pruned")
+	}
+}
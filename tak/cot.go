@@ -0,0 +1,228 @@
+package tak
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "meshstream/generated/meshtastic"
+)
+
+const (
+	// TypeFriendlyGround is the CoT type ATAK uses for its own PLI.
+	TypeFriendlyGround = "a-f-G-U-C"
+	// typeChat is the CoT type of a GeoChat message.
+	typeChat = "b-t-f"
+	// allChatRooms is the GeoChat room every ATAK client joins.
+	allChatRooms = "All Chat Rooms"
+	// unknown is the CoT value for an unknown altitude or error.
+	unknown = 9999999.0
+
+	timeFormat = "2006-01-02T15:04:05.000Z"
+	xmlHeader  = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+// Event is a Cursor-on-Target event.
+type Event struct {
+	XMLName xml.Name `xml:"event"`
+	Version string   `xml:"version,attr"`
+	UID     string   `xml:"uid,attr"`
+	Type    string   `xml:"type,attr"`
+	How     string   `xml:"how,attr"`
+	Time    string   `xml:"time,attr"`
+	Start   string   `xml:"start,attr"`
+	Stale   string   `xml:"stale,attr"`
+	Point   Point    `xml:"point"`
+	Detail  Detail   `xml:"detail"`
+}
+
+// Point is the location of an event. Values are preformatted because CoT
+// consumers don't accept exponent notation.
+type Point struct {
+	Lat string `xml:"lat,attr"`
+	Lon string `xml:"lon,attr"`
+	HAE string `xml:"hae,attr"`
+	CE  string `xml:"ce,attr"`
+	LE  string `xml:"le,attr"`
+}
+
+// Detail holds the event details ATAK understands for PLI and GeoChat.
+type Detail struct {
+	Contact *Contact `xml:"contact,omitempty"`
+	Group   *Group   `xml:"__group,omitempty"`
+	Status  *Status  `xml:"status,omitempty"`
+	Track   *Track   `xml:"track,omitempty"`
+	Chat    *Chat    `xml:"__chat,omitempty"`
+	Link    *Link    `xml:"link,omitempty"`
+	Remarks *Remarks `xml:"remarks,omitempty"`
+}
+
+// Contact is the callsign shown on the map.
+type Contact struct {
+	Callsign string `xml:"callsign,attr"`
+}
+
+// Group is the ATAK team color and role.
+type Group struct {
+	Name string `xml:"name,attr"`
+	Role string `xml:"role,attr"`
+}
+
+// Status reports the device battery level.
+type Status struct {
+	Battery uint32 `xml:"battery,attr"`
+}
+
+// Track is the speed (m/s) and course (degrees) of a unit.
+type Track struct {
+	Speed  string `xml:"speed,attr"`
+	Course string `xml:"course,attr"`
+}
+
+// Chat describes a GeoChat message and its room.
+type Chat struct {
+	Parent         string  `xml:"parent,attr"`
+	GroupOwner     string  `xml:"groupOwner,attr"`
+	MessageID      string  `xml:"messageId,attr"`
+	Chatroom       string  `xml:"chatroom,attr"`
+	ID             string  `xml:"id,attr"`
+	SenderCallsign string  `xml:"senderCallsign,attr"`
+	ChatGroup      ChatGrp `xml:"chatgrp"`
+}
+
+// ChatGrp lists the participants of a GeoChat room.
+type ChatGrp struct {
+	UID0 string `xml:"uid0,attr"`
+	UID1 string `xml:"uid1,attr"`
+	ID   string `xml:"id,attr"`
+}
+
+// Link relates an event to another, e.g. a chat to its sender.
+type Link struct {
+	UID      string `xml:"uid,attr"`
+	Type     string `xml:"type,attr"`
+	Relation string `xml:"relation,attr"`
+}
+
+// Remarks carries free text, such as a chat message body.
+type Remarks struct {
+	Source string `xml:"source,attr,omitempty"`
+	To     string `xml:"to,attr,omitempty"`
+	Time   string `xml:"time,attr,omitempty"`
+	Text   string `xml:",chardata"`
+}
+
+// Marshal encodes the event as a standalone XML document.
+func (e *Event) Marshal() ([]byte, error) {
+	body, err := xml.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xmlHeader), body...), nil
+}
+
+func newEvent(uid, typ, how string, t time.Time, stale time.Duration, point Point) *Event {
+	ts := formatTime(t)
+	return &Event{
+		Version: "2.0",
+		UID:     uid,
+		Type:    typ,
+		How:     how,
+		Time:    ts,
+		Start:   ts,
+		Stale:   formatTime(t.Add(stale)),
+		Point:   point,
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// newPoint formats a location. Zero hae or ce are reported as unknown.
+func newPoint(lat, lon, hae, ce float64) Point {
+	if hae == 0 {
+		hae = unknown
+	}
+	if ce == 0 {
+		ce = unknown
+	}
+	return Point{
+		Lat: formatFloat(lat, 7),
+		Lon: formatFloat(lon, 7),
+		HAE: formatFloat(hae, 1),
+		CE:  formatFloat(ce, 1),
+		LE:  formatFloat(unknown, 1),
+	}
+}
+
+// unknownPoint is used for events without a known location.
+var unknownPoint = newPoint(0, 0, 0, 0)
+
+func formatFloat(v float64, prec int) string {
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// chatEvent builds a GeoChat message. An empty toUID sends to All Chat Rooms.
+func chatEvent(senderUID, senderCallsign, toUID, toCallsign, messageID, text string, t time.Time, stale time.Duration, point Point) *Event {
+	room, roomID := allChatRooms, allChatRooms
+	if toUID != "" {
+		room, roomID = toCallsign, toUID
+		if room == "" {
+			room = toUID
+		}
+	}
+
+	e := newEvent("GeoChat."+senderUID+"."+roomID+"."+messageID, typeChat, "h-g-i-g-o", t, stale, point)
+	e.Detail = Detail{
+		Chat: &Chat{
+			Parent:         "RootContactGroup",
+			GroupOwner:     "false",
+			MessageID:      messageID,
+			Chatroom:       room,
+			ID:             roomID,
+			SenderCallsign: senderCallsign,
+			ChatGroup:      ChatGrp{UID0: senderUID, UID1: roomID, ID: roomID},
+		},
+		Link: &Link{UID: senderUID, Type: TypeFriendlyGround, Relation: "p-p"},
+		Remarks: &Remarks{
+			Source: "BAO.F.ATAK." + senderUID,
+			To:     roomID,
+			Time:   formatTime(t),
+			Text:   text,
+		},
+	}
+	return e
+}
+
+// teamName returns the ATAK team color name, e.g. "Dark Blue".
+func teamName(team pb.Team) string {
+	if team == pb.Team_Unspecifed_Color {
+		return "Cyan"
+	}
+	return strings.ReplaceAll(team.String(), "_", " ")
+}
+
+// roleNames maps member roles to the names ATAK displays.
+var roleNames = map[pb.MemberRole]string{
+	pb.MemberRole_TeamMember:      "Team Member",
+	pb.MemberRole_TeamLead:        "Team Lead",
+	pb.MemberRole_HQ:              "HQ",
+	pb.MemberRole_Sniper:          "Sniper",
+	pb.MemberRole_Medic:           "Medic",
+	pb.MemberRole_ForwardObserver: "Forward Observer",
+	pb.MemberRole_RTO:             "RTO",
+	pb.MemberRole_K9:              "K9",
+}
+
+func roleName(role pb.MemberRole) string {
+	if name, ok := roleNames[role]; ok {
+		return name
+	}
+	return "Team Member"
+}
@@ -0,0 +1,345 @@
+package tak
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/protobuf/proto"
+
+	"meshstream/delivery"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/health"
+	"meshstream/mqtt"
+	"meshstream/nodes"
+)
+
+// Config holds configuration for the TAK output.
+type Config struct {
+	Address   string        // TAK server or multicast group host:port (default: 239.2.3.1:6969, the SA multicast group)
+	Protocol  string        // "udp" or "tcp" (default: udp)
+	Stale     time.Duration // How long events stay on the map (default: 3h, the node retention window)
+	UIDPrefix string        // Prefix of CoT UIDs derived from node IDs (default: MESHTASTIC-)
+	Channels  []string      // Channels whose text messages are sent as GeoChat; empty means all
+	SkipCache bool          // See mqtt.SubscriberConfig.SkipCache
+}
+
+// location is the last known position of a node, used to place its chat.
+type location struct {
+	point Point
+	time  time.Time
+}
+
+// Output converts node positions, text messages and ATAK plugin packets to
+// Cursor-on-Target events and sends them to TAK clients or a TAK server.
+type Output struct {
+	*mqtt.BaseSubscriber
+	config    Config
+	channels  map[string]bool
+	directory *nodes.Directory
+
+	mu        sync.Mutex
+	locations map[uint32]location
+	battery   map[uint32]uint32
+
+	queue  chan []byte
+	done   chan struct{}
+	wg     sync.WaitGroup
+	health health.Tracker
+	logger logging.Logger
+}
+
+// NewOutput creates a TAK output subscribed to the broker. The connection is
+// opened lazily and re-established after errors.
+func NewOutput(config Config, broker *mqtt.Broker, directory *nodes.Directory, logger logging.Logger) (*Output, error) {
+	if config.Address == "" {
+		config.Address = "239.2.3.1:6969"
+	}
+	if config.Protocol == "" {
+		config.Protocol = "udp"
+	}
+	if config.Protocol != "udp" && config.Protocol != "tcp" {
+		return nil, fmt.Errorf("unsupported TAK protocol %q, expected udp or tcp", config.Protocol)
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid TAK address %q: %v", config.Address, err)
+	}
+	if config.Stale <= 0 {
+		config.Stale = 3 * time.Hour
+	}
+	if config.UIDPrefix == "" {
+		config.UIDPrefix = "MESHTASTIC-"
+	}
+
+	o := &Output{
+		config:    config,
+		directory: directory,
+		locations: make(map[uint32]location),
+		battery:   make(map[uint32]uint32),
+		queue:     make(chan []byte, 100),
+		done:      make(chan struct{}),
+		logger:    logger.Named("tak"),
+	}
+	if len(config.Channels) > 0 {
+		o.channels = make(map[string]bool, len(config.Channels))
+		for _, ch := range config.Channels {
+			o.channels[ch] = true
+		}
+	}
+
+	o.BaseSubscriber = mqtt.NewBaseSubscriber(mqtt.SubscriberConfig{
+		Name:       "TAK",
+		Broker:     broker,
+		BufferSize: 100,
+		SkipCache:  config.SkipCache,
+		DropCopies: true,
+		Processor:  o.process,
+		StartHook: func() {
+			o.wg.Add(1)
+			go o.sendLoop()
+		},
+		CloseHook: func() {
+			close(o.done)
+			o.wg.Wait()
+		},
+		Logger: logger,
+	})
+	o.Start()
+
+	return o, nil
+}
+
+// UID returns the CoT UID of a mesh node.
+func (o *Output) UID(num uint32) string {
+	return o.config.UIDPrefix + nodes.FormatID(num)
+}
+
+func (o *Output) process(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	received := time.Now()
+	if data.GetRxTime() != 0 {
+		received = time.Unix(int64(data.GetRxTime()), 0)
+	}
+
+	var event *Event
+	switch data.GetPortNum() {
+	case pb.PortNum_POSITION_APP:
+		event = o.positionEvent(data, received)
+	case pb.PortNum_TELEMETRY_APP:
+		if dev := data.GetTelemetry().GetDeviceMetrics(); dev != nil && dev.BatteryLevel != nil {
+			o.mu.Lock()
+			o.battery[data.GetFrom()] = min(dev.GetBatteryLevel(), 100)
+			o.mu.Unlock()
+		}
+	case pb.PortNum_TEXT_MESSAGE_APP:
+		event = o.textEvent(packet, received)
+	case pb.PortNum_ATAK_PLUGIN:
+		event = o.takEvent(packet, received)
+	}
+	if event == nil {
+		return
+	}
+
+	// Events that expired before they were seen, e.g. old positions replayed
+	// from the cache, would only flicker on the map.
+	if received.Add(o.config.Stale).Before(time.Now()) {
+		return
+	}
+	o.enqueue(event)
+}
+
+// positionEvent builds a PLI for a node's position report.
+func (o *Output) positionEvent(data *meshtreampb.Data, t time.Time) *Event {
+	pos := data.GetPosition()
+	lat, lon, ok := nodes.Coordinates(pos)
+	if !ok {
+		return nil
+	}
+	from := data.GetFrom()
+	point := newPoint(lat, lon, float64(pos.GetAltitude()), nodes.PrecisionMeters(pos))
+
+	o.mu.Lock()
+	o.locations[from] = location{point: point, time: t}
+	battery, hasBattery := o.battery[from]
+	o.mu.Unlock()
+
+	e := newEvent(o.UID(from), TypeFriendlyGround, "m-g", t, o.config.Stale, point)
+	e.Detail.Contact = &Contact{Callsign: o.directory.LongName(from)}
+	if hasBattery {
+		e.Detail.Status = &Status{Battery: battery}
+	}
+	if pos.GroundSpeed != nil || pos.GroundTrack != nil {
+		e.Detail.Track = &Track{
+			Speed: formatFloat(float64(pos.GetGroundSpeed()), 1),
+			// ground_track is in 1e-5 degrees.
+			Course: formatFloat(float64(pos.GetGroundTrack())*1e-5, 1),
+		}
+	}
+	e.Detail.Remarks = &Remarks{Text: "Meshtastic node " + nodes.FormatID(from)}
+	return e
+}
+
+// textEvent builds a GeoChat message for a broadcast text message.
+func (o *Output) textEvent(packet *meshtreampb.Packet, t time.Time) *Event {
+	data := packet.GetData()
+	if data.GetTo() != nodes.BroadcastID || data.GetTextMessage() == "" {
+		return nil
+	}
+	if o.channels != nil && !o.channels[packet.GetInfo().GetChannel()] {
+		return nil
+	}
+	// A restart shouldn't resend the cache's chat.
+	if !o.Live(packet) {
+		return nil
+	}
+
+	from := data.GetFrom()
+	messageID := strconv.FormatUint(uint64(data.GetId()), 16)
+	return chatEvent(o.UID(from), o.directory.LongName(from), "", "", messageID, data.GetTextMessage(), t, o.config.Stale, o.lastPoint(from))
+}
+
+// takEvent converts a packet from the Meshtastic ATAK plugin back into the
+// CoT event it was built from.
+func (o *Output) takEvent(packet *meshtreampb.Packet, t time.Time) *Event {
+	data := packet.GetData()
+	var tp pb.TAKPacket
+	if err := proto.Unmarshal(data.GetAtakPlugin(), &tp); err != nil {
+		o.logger.Debugw("Failed to decode TAK packet", "from", nodes.FormatID(data.GetFrom()), "error", err)
+		return nil
+	}
+
+	from := data.GetFrom()
+	uid, callsign := o.UID(from), o.directory.LongName(from)
+	// Compressed strings use unishox2, which isn't supported, so fall back to
+	// identifiers derived from the node.
+	if !tp.GetIsCompressed() && tp.GetContact() != nil {
+		if tp.GetContact().GetDeviceCallsign() != "" {
+			uid = tp.GetContact().GetDeviceCallsign()
+		}
+		if tp.GetContact().GetCallsign() != "" {
+			callsign = tp.GetContact().GetCallsign()
+		}
+	}
+
+	switch {
+	case tp.GetPli() != nil:
+		pli := tp.GetPli()
+		point := newPoint(float64(pli.GetLatitudeI())*1e-7, float64(pli.GetLongitudeI())*1e-7, float64(pli.GetAltitude()), 0)
+		o.mu.Lock()
+		o.locations[from] = location{point: point, time: t}
+		o.mu.Unlock()
+
+		e := newEvent(uid, TypeFriendlyGround, "m-g", t, o.config.Stale, point)
+		e.Detail.Contact = &Contact{Callsign: callsign}
+		if g := tp.GetGroup(); g != nil {
+			e.Detail.Group = &Group{Name: teamName(g.GetTeam()), Role: roleName(g.GetRole())}
+		}
+		if s := tp.GetStatus(); s != nil {
+			e.Detail.Status = &Status{Battery: s.GetBattery()}
+		}
+		e.Detail.Track = &Track{
+			Speed:  strconv.FormatUint(uint64(pli.GetSpeed()), 10),
+			Course: strconv.FormatUint(uint64(pli.GetCourse()), 10),
+		}
+		return e
+
+	case tp.GetChat() != nil:
+		if tp.GetIsCompressed() || !o.Live(packet) {
+			return nil
+		}
+		chat := tp.GetChat()
+		messageID := strconv.FormatUint(uint64(data.GetId()), 16)
+		return chatEvent(uid, callsign, chat.GetTo(), chat.GetToCallsign(), messageID, chat.GetMessage(), t, o.config.Stale, o.lastPoint(from))
+	}
+
+	// Generic detail payloads carry no event type or location to rebuild an
+	// event from.
+	return nil
+}
+
+// lastPoint returns where a node was last seen, or an unknown point.
+func (o *Output) lastPoint(num uint32) Point {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if loc, ok := o.locations[num]; ok && time.Since(loc.time) < o.config.Stale {
+		return loc.point
+	}
+	return unknownPoint
+}
+
+func (o *Output) enqueue(event *Event) {
+	msg, err := event.Marshal()
+	if err != nil {
+		o.logger.Errorw("Failed to encode CoT event", "uid", event.UID, "error", err)
+		return
+	}
+	select {
+	case o.queue <- msg:
+	default:
+		o.logger.Warnw("TAK queue full, dropping event", "uid", event.UID)
+	}
+}
+
+// sendLoop writes queued events, connecting as needed.
+func (o *Output) sendLoop() {
+	defer o.wg.Done()
+	delivery.Run(delivery.Config[[]byte]{
+		Name:    "TAK endpoint",
+		Address: o.config.Address,
+		Dial:    o.dial,
+	}, o.queue, o.done, &o.health, o.logger)
+}
+
+// connection is an open connection to the TAK endpoint.
+type connection struct {
+	conn net.Conn
+	dead chan struct{} // Closed when a TCP server hangs up
+}
+
+// dial connects to the configured endpoint.
+func (o *Output) dial() (delivery.Conn[[]byte], error) {
+	conn, err := net.DialTimeout(o.config.Protocol, o.config.Address, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &connection{conn: conn, dead: make(chan struct{})}
+	if o.config.Protocol == "tcp" {
+		o.logger.Infow("Connected to TAK server", "address", o.config.Address)
+		// TAK servers may send pings; drain them and notice disconnects.
+		go func() {
+			defer close(c.dead)
+			io.Copy(io.Discard, conn)
+		}()
+	}
+	return c, nil
+}
+
+func (c *connection) Send(msg []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *connection) Closed() bool {
+	select {
+	case <-c.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *connection) Close() {
+	c.conn.Close()
+}
+
+// Health reports whether connecting to the TAK endpoint or sending events is
+// failing.
+func (o *Output) Health() health.Status {
+	return o.health.Status()
+}
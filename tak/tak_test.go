@@ -0,0 +1,296 @@
+package tak
+
+import (
+	"bufio"
+	"encoding/xml"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/internal/testutil"
+	"meshstream/nodes"
+)
+
+// collector records CoT events received by a stand-in TAK endpoint.
+type collector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (c *collector) add(t *testing.T, msg []byte) {
+	var e Event
+	if err := xml.Unmarshal(msg, &e); err != nil {
+		t.Errorf("received invalid CoT XML: %v\n%s", err, msg)
+		return
+	}
+	c.mu.Lock()
+	c.events = append(c.events, e)
+	c.mu.Unlock()
+}
+
+func (c *collector) wait(t *testing.T, n int) []Event {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		if len(c.events) >= n {
+			events := append([]Event(nil), c.events...)
+			c.mu.Unlock()
+			return events
+		}
+		c.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d CoT events", n)
+	return nil
+}
+
+func listenUDP(t *testing.T) (string, *collector) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &collector{}
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			c.add(t, append([]byte(nil), buf[:n]...))
+		}
+	}()
+	return conn.LocalAddr().String(), c
+}
+
+func newTestOutput(t *testing.T, config Config) chan *meshtreampb.Packet {
+	t.Helper()
+	mesh := testutil.NewMesh(t)
+	mesh.Name(1, "Base Camp")
+
+	output, err := NewOutput(config, mesh.Broker, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatalf("failed to create TAK output: %v", err)
+	}
+	t.Cleanup(output.Close)
+	return mesh.Source
+}
+
+func position(id, from uint32) *meshtreampb.Packet {
+	return &meshtreampb.Packet{Data: &meshtreampb.Data{
+		Id:      id,
+		From:    from,
+		PortNum: pb.PortNum_POSITION_APP,
+		Payload: &meshtreampb.Data_Position{Position: &pb.Position{
+			LatitudeI:     proto.Int32(377749290),
+			LongitudeI:    proto.Int32(-1224194160),
+			Altitude:      proto.Int32(52),
+			PrecisionBits: 16,
+		}},
+	}}
+}
+
+func TestPositionAndChat(t *testing.T) {
+	addr, received := listenUDP(t)
+	source := newTestOutput(t, Config{Address: addr, Stale: time.Hour})
+
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    1,
+		PortNum: pb.PortNum_TELEMETRY_APP,
+		Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+			Variant: &pb.Telemetry_DeviceMetrics{DeviceMetrics: &pb.DeviceMetrics{BatteryLevel: proto.Uint32(101)}},
+		}},
+	}}
+	time.Sleep(20 * time.Millisecond)
+	source <- position(10, 1)
+	source <- position(10, 1) // second gateway
+	received.wait(t, 1)
+
+	source <- &meshtreampb.Packet{
+		Data: &meshtreampb.Data{
+			Id:      0x2a,
+			From:    1,
+			To:      nodes.BroadcastID,
+			PortNum: pb.PortNum_TEXT_MESSAGE_APP,
+			Payload: &meshtreampb.Data_TextMessage{TextMessage: "Rally at <CP1> & wait"},
+		},
+		Info: &meshtreampb.TopicInfo{Channel: "LongFast"},
+	}
+
+	events := received.wait(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	received.mu.Lock()
+	if len(received.events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(received.events))
+	}
+	received.mu.Unlock()
+
+	pli := events[0]
+	if pli.UID != "MESHTASTIC-!00000001" || pli.Type != TypeFriendlyGround || pli.How != "m-g" {
+		t.Errorf("unexpected PLI header: %+v", pli)
+	}
+	if pli.Point.Lat != "37.774929" || pli.Point.Lon != "-122.419416" || pli.Point.HAE != "52" || pli.Point.CE != "364.8" {
+		t.Errorf("unexpected PLI point: %+v", pli.Point)
+	}
+	if pli.Detail.Contact == nil || pli.Detail.Contact.Callsign != "Base Camp" {
+		t.Errorf("unexpected contact: %+v", pli.Detail.Contact)
+	}
+	if pli.Detail.Status == nil || pli.Detail.Status.Battery != 100 {
+		t.Errorf("expected battery 100 (101 means powered), got %+v", pli.Detail.Status)
+	}
+	start, _ := time.Parse(timeFormat, pli.Start)
+	stale, _ := time.Parse(timeFormat, pli.Stale)
+	if stale.Sub(start) != time.Hour {
+		t.Errorf("expected stale one hour after start, got %s and %s", pli.Start, pli.Stale)
+	}
+
+	chat := events[1]
+	if chat.Type != "b-t-f" || chat.UID != "GeoChat.MESHTASTIC-!00000001.All Chat Rooms.2a" {
+		t.Errorf("unexpected chat header: %+v", chat)
+	}
+	if chat.Detail.Chat == nil || chat.Detail.Chat.SenderCallsign != "Base Camp" || chat.Detail.Chat.Chatroom != "All Chat Rooms" {
+		t.Errorf("unexpected chat detail: %+v", chat.Detail.Chat)
+	}
+	if chat.Detail.Remarks == nil || chat.Detail.Remarks.Text != "Rally at <CP1> & wait" {
+		t.Errorf("unexpected chat text: %+v", chat.Detail.Remarks)
+	}
+	if chat.Point.Lat != "37.774929" {
+		t.Errorf("chat should be placed at the sender's last position, got %+v", chat.Point)
+	}
+}
+
+func TestTAKPacketOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := &collector{}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			// Events are written back to back; split on the closing tag.
+			var sb strings.Builder
+			for !strings.HasSuffix(sb.String(), "</event>") {
+				b, err := reader.ReadByte()
+				if err != nil {
+					return
+				}
+				sb.WriteByte(b)
+			}
+			received.add(t, []byte(sb.String()))
+		}
+	}()
+
+	source := newTestOutput(t, Config{Address: listener.Addr().String(), Protocol: "tcp"})
+
+	pli, _ := proto.Marshal(&pb.TAKPacket{
+		Contact: &pb.Contact{Callsign: "FALKE", DeviceCallsign: "ANDROID-1234"},
+		Group:   &pb.Group{Role: pb.MemberRole_TeamLead, Team: pb.Team_Dark_Blue},
+		Status:  &pb.Status{Battery: 88},
+		PayloadVariant: &pb.TAKPacket_Pli{Pli: &pb.PLI{
+			LatitudeI: 377749290, LongitudeI: -1224194160, Altitude: 10, Speed: 3, Course: 270,
+		}},
+	})
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{
+		Id: 1, From: 2, PortNum: pb.PortNum_ATAK_PLUGIN,
+		Payload: &meshtreampb.Data_AtakPlugin{AtakPlugin: pli},
+	}}
+	received.wait(t, 1)
+
+	chat, _ := proto.Marshal(&pb.TAKPacket{
+		Contact: &pb.Contact{Callsign: "FALKE", DeviceCallsign: "ANDROID-1234"},
+		PayloadVariant: &pb.TAKPacket_Chat{Chat: &pb.GeoChat{
+			Message: "copy", To: proto.String("ANDROID-5678"), ToCallsign: proto.String("HAWK"),
+		}},
+	})
+	source <- &meshtreampb.Packet{Data: &meshtreampb.Data{
+		Id: 2, From: 2, PortNum: pb.PortNum_ATAK_PLUGIN,
+		Payload: &meshtreampb.Data_AtakPlugin{AtakPlugin: chat},
+	}}
+
+	events := received.wait(t, 2)
+	e := events[0]
+	if e.UID != "ANDROID-1234" || e.Detail.Contact.Callsign != "FALKE" {
+		t.Errorf("unexpected PLI identity: %+v", e)
+	}
+	if e.Detail.Group == nil || e.Detail.Group.Name != "Dark Blue" || e.Detail.Group.Role != "Team Lead" {
+		t.Errorf("unexpected group: %+v", e.Detail.Group)
+	}
+	if e.Detail.Track == nil || e.Detail.Track.Speed != "3" || e.Detail.Track.Course != "270" {
+		t.Errorf("unexpected track: %+v", e.Detail.Track)
+	}
+
+	c := events[1]
+	if c.Detail.Chat == nil || c.Detail.Chat.Chatroom != "HAWK" || c.Detail.Chat.ID != "ANDROID-5678" {
+		t.Errorf("unexpected direct chat: %+v", c.Detail.Chat)
+	}
+	if c.Detail.Remarks.Text != "copy" || c.Point.Lat != "37.774929" {
+		t.Errorf("unexpected chat content: %+v %+v", c.Detail.Remarks, c.Point)
+	}
+}
+
+func TestOutputConfigValidation(t *testing.T) {
+	logger := logging.NewDevLogger().Named("test")
+	if _, err := NewOutput(Config{Protocol: "http"}, nil, nodes.NewDirectory(), logger); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+	if _, err := NewOutput(Config{Address: "localhost"}, nil, nodes.NewDirectory(), logger); err == nil {
+		t.Error("expected an error for an address without a port")
+	}
+}
+
+func TestCloseWhileEndpointFails(t *testing.T) {
+	address, received := listenUDP(t)
+
+	// A name this long makes the event too large for a UDP datagram, so
+	// every write fails.
+	mesh := testutil.NewMesh(t)
+	mesh.Name(1, strings.Repeat("x", 70000))
+	output, err := NewOutput(Config{Address: address}, mesh.Broker, mesh.Directory, mesh.Logger)
+	if err != nil {
+		t.Fatalf("failed to create TAK output: %v", err)
+	}
+
+	mesh.Source <- position(1, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for output.Health().FailingSince.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the write to fail")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		output.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close didn't return while the endpoint was failing")
+	}
+	received.mu.Lock()
+	defer received.mu.Unlock()
+	if len(received.events) != 0 {
+		t.Errorf("expected no events, got %d", len(received.events))
+	}
+}
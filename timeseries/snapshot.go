@@ -0,0 +1,91 @@
+package timeseries
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes.
+const snapshotVersion = 1
+
+// snapshot is the on-disk representation of the store.
+type snapshot struct {
+	Version int
+	Series  []snapshotSeries
+}
+
+type snapshotSeries struct {
+	Node    uint32
+	Metric  string
+	Raw     []sample
+	Rollups []aggregate
+}
+
+// save writes the store to its snapshot file, replacing it atomically.
+func (s *Store) save() error {
+	snap := snapshot{Version: snapshotVersion}
+
+	s.mu.RLock()
+	for key, ser := range s.series {
+		snap.Series = append(snap.Series, snapshotSeries{
+			Node:    key.node,
+			Metric:  key.metric,
+			Raw:     append([]sample(nil), ser.raw...),
+			Rollups: append([]aggregate(nil), ser.rollups...),
+		})
+	}
+	s.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.config.FilePath), filepath.Base(s.config.FilePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(&snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.config.FilePath)
+}
+
+// load restores the store from its snapshot file. A missing file is not an
+// error.
+func (s *Store) load() error {
+	f, err := os.Open(s.config.FilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	s.mu.Lock()
+	for _, ser := range snap.Series {
+		s.series[seriesKey{node: ser.Node, metric: ser.Metric}] = &series{
+			raw:     ser.Raw,
+			rollups: ser.Rollups,
+		}
+	}
+	s.mu.Unlock()
+
+	// Drop anything that expired while we were not running.
+	s.prune()
+
+	s.logger.Infow("Loaded time-series snapshot", "path", s.config.FilePath, "series", len(snap.Series))
+	return nil
+}
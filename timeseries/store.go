@@ -0,0 +1,417 @@
+package timeseries
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+
+	"meshstream/decoder"
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/health"
+)
+
+// Config holds configuration for the time-series store.
+type Config struct {
+	RawRetention    time.Duration // How long individual samples are kept (default: 24h)
+	RollupInterval  time.Duration // Width of the aggregates kept after raw samples expire (default: 1h)
+	RollupRetention time.Duration // How long aggregates are kept (default: 90 days)
+	FilePath        string        // Optional snapshot file used to persist history across restarts
+	SaveInterval    time.Duration // How often the snapshot file is written (default: 5m)
+}
+
+// kindPreference orders telemetry variants when a metric is requested by its
+// bare field name and more than one variant reports it, e.g. "voltage".
+var kindPreference = []string{"device", "environment", "power", "air_quality", "health", "local_stats"}
+
+// Bucket summarizes the samples of one metric within a time step.
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"`
+	Count int       `json:"count"`
+}
+
+// sample is a single raw reading.
+type sample struct {
+	T int64 // Unix seconds
+	V float64
+}
+
+// aggregate accumulates the readings that fall within one rollup interval or
+// query step.
+type aggregate struct {
+	Start int64 // Unix seconds
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count int
+	Last  float64
+	LastT int64
+}
+
+func (a *aggregate) add(t int64, v float64) {
+	if a.Count == 0 || v < a.Min {
+		a.Min = v
+	}
+	if a.Count == 0 || v > a.Max {
+		a.Max = v
+	}
+	a.Sum += v
+	a.Count++
+	if t >= a.LastT {
+		a.Last = v
+		a.LastT = t
+	}
+}
+
+func (a *aggregate) merge(o aggregate) {
+	if o.Count == 0 {
+		return
+	}
+	if a.Count == 0 || o.Min < a.Min {
+		a.Min = o.Min
+	}
+	if a.Count == 0 || o.Max > a.Max {
+		a.Max = o.Max
+	}
+	a.Sum += o.Sum
+	a.Count += o.Count
+	if o.LastT >= a.LastT {
+		a.Last = o.Last
+		a.LastT = o.LastT
+	}
+}
+
+func (a *aggregate) bucket() Bucket {
+	return Bucket{
+		Start: time.Unix(a.Start, 0).UTC(),
+		Min:   a.Min,
+		Max:   a.Max,
+		Avg:   a.Sum / float64(a.Count),
+		Last:  a.Last,
+		Count: a.Count,
+	}
+}
+
+// series holds the history of one metric from one node. Raw samples and
+// rollups are both kept sorted by time.
+type series struct {
+	raw     []sample
+	rollups []aggregate
+}
+
+// seriesKey identifies a series by node and qualified metric name, e.g.
+// "device.battery_level".
+type seriesKey struct {
+	node   uint32
+	metric string
+}
+
+// Store keeps per-node telemetry history in memory with tiered retention:
+// raw samples for a short window and fixed-interval aggregates for much
+// longer.
+type Store struct {
+	config Config
+	mu     sync.RWMutex
+	series map[seriesKey]*series
+	now    func() time.Time
+
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+	health health.Tracker
+	logger logging.Logger
+}
+
+// NewStore creates a time-series store. When a snapshot file is configured,
+// existing history is loaded from it and the store is saved periodically and
+// on Close.
+func NewStore(config Config, logger logging.Logger) *Store {
+	if config.RawRetention <= 0 {
+		config.RawRetention = 24 * time.Hour
+	}
+	if config.RollupInterval <= 0 {
+		config.RollupInterval = time.Hour
+	}
+	if config.RollupRetention <= 0 {
+		config.RollupRetention = 90 * 24 * time.Hour
+	}
+	if config.SaveInterval <= 0 {
+		config.SaveInterval = 5 * time.Minute
+	}
+
+	s := &Store{
+		config: config,
+		series: make(map[seriesKey]*series),
+		now:    time.Now,
+		done:   make(chan struct{}),
+		logger: logger.Named("timeseries"),
+	}
+
+	if config.FilePath != "" {
+		if err := s.load(); err != nil {
+			s.logger.Warnw("Failed to load time-series snapshot", "path", config.FilePath, "error", err)
+		}
+	}
+
+	s.wg.Add(1)
+	go s.maintain()
+
+	return s
+}
+
+// Record stores every metric carried by a telemetry packet. It is intended to
+// be used as a subscriber processor.
+func (s *Store) Record(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	if data.GetPortNum() != pb.PortNum_TELEMETRY_APP || data.GetFrom() == 0 {
+		return
+	}
+	kind, fields := decoder.TelemetryMetrics(data.GetTelemetry())
+	if len(fields) == 0 {
+		return
+	}
+
+	// Prefer reception time; device clocks are frequently unset.
+	t := time.Unix(int64(data.GetRxTime()), 0)
+	if data.GetRxTime() == 0 {
+		t = s.now()
+	}
+
+	for name, value := range fields {
+		s.Add(data.GetFrom(), kind+"."+name, t, value)
+	}
+}
+
+// Add records a single sample for a node's metric. NaN and infinite values
+// are ignored, since they would poison the rollups and can't be sent as JSON.
+func (s *Store) Add(node uint32, metric string, t time.Time, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	ts := t.Unix()
+	// Samples older than the rollup window would be pruned immediately.
+	if ts < s.now().Add(-s.config.RollupRetention).Unix() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := seriesKey{node: node, metric: metric}
+	ser, ok := s.series[key]
+	if !ok {
+		ser = &series{}
+		s.series[key] = ser
+	}
+
+	// Packets usually arrive in order, so appending is the common case.
+	i := len(ser.raw)
+	if i > 0 && ser.raw[i-1].T > ts {
+		i = sort.Search(len(ser.raw), func(j int) bool { return ser.raw[j].T > ts })
+	}
+	ser.raw = append(ser.raw, sample{})
+	copy(ser.raw[i+1:], ser.raw[i:])
+	ser.raw[i] = sample{T: ts, V: value}
+
+	start := truncate(ts, s.interval())
+	j := len(ser.rollups)
+	if j == 0 || ser.rollups[j-1].Start != start {
+		j = sort.Search(len(ser.rollups), func(k int) bool { return ser.rollups[k].Start >= start })
+		if j == len(ser.rollups) || ser.rollups[j].Start != start {
+			ser.rollups = append(ser.rollups, aggregate{})
+			copy(ser.rollups[j+1:], ser.rollups[j:])
+			ser.rollups[j] = aggregate{Start: start}
+		}
+	} else {
+		j--
+	}
+	ser.rollups[j].add(ts, value)
+}
+
+// Metrics returns the qualified names of the metrics recorded for a node.
+func (s *Store) Metrics(node uint32) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	for key := range s.series {
+		if key.node == node {
+			names = append(names, key.metric)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve maps a metric name to the qualified name stored for a node. Names
+// may be qualified ("environment.voltage") or bare ("battery_level"); bare
+// names that several telemetry variants report resolve in kindPreference
+// order. It returns false if the node has no such metric.
+func (s *Store) Resolve(node uint32, name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if strings.Contains(name, ".") {
+		_, ok := s.series[seriesKey{node: node, metric: name}]
+		return name, ok
+	}
+	for _, kind := range kindPreference {
+		metric := kind + "." + name
+		if _, ok := s.series[seriesKey{node: node, metric: metric}]; ok {
+			return metric, true
+		}
+	}
+	return "", false
+}
+
+// Query summarizes a metric between from and to in buckets of step. Only
+// buckets containing samples are returned.
+//
+// Raw samples are used while the whole range is within the raw retention
+// window. Older ranges are answered from rollups, in which case step is
+// rounded up to a multiple of the rollup interval.
+func (s *Store) Query(node uint32, metric string, from, to time.Time, step time.Duration) []Bucket {
+	if step < time.Second {
+		step = time.Second
+	}
+	fromTS, toTS := from.Unix(), to.Unix()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ser, ok := s.series[seriesKey{node: node, metric: metric}]
+	if !ok {
+		return nil
+	}
+
+	var buckets []aggregate
+	addTo := func(start int64) *aggregate {
+		if n := len(buckets); n == 0 || buckets[n-1].Start != start {
+			buckets = append(buckets, aggregate{Start: start})
+		}
+		return &buckets[len(buckets)-1]
+	}
+
+	if fromTS >= s.rawCutoff() {
+		stepSecs := int64(step / time.Second)
+		i := sort.Search(len(ser.raw), func(j int) bool { return ser.raw[j].T >= fromTS })
+		for ; i < len(ser.raw) && ser.raw[i].T <= toTS; i++ {
+			addTo(truncate(ser.raw[i].T, stepSecs)).add(ser.raw[i].T, ser.raw[i].V)
+		}
+	} else {
+		interval := s.interval()
+		stepSecs := max(int64(step/time.Second), interval)
+		stepSecs = (stepSecs + interval - 1) / interval * interval
+		i := sort.Search(len(ser.rollups), func(j int) bool { return ser.rollups[j].Start >= truncate(fromTS, interval) })
+		for ; i < len(ser.rollups) && ser.rollups[i].Start <= toTS; i++ {
+			addTo(truncate(ser.rollups[i].Start, stepSecs)).merge(ser.rollups[i])
+		}
+	}
+
+	result := make([]Bucket, len(buckets))
+	for i := range buckets {
+		result[i] = buckets[i].bucket()
+	}
+	return result
+}
+
+// Close stops background maintenance and writes a final snapshot.
+func (s *Store) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		if s.config.FilePath != "" {
+			if err := s.save(); err != nil {
+				s.logger.Errorw("Failed to save time-series snapshot", "path", s.config.FilePath, "error", err)
+			}
+		}
+	})
+}
+
+// Health reports whether saving the snapshot file is failing.
+func (s *Store) Health() health.Status {
+	return s.health.Status()
+}
+
+// maintain prunes expired data and periodically saves the snapshot.
+func (s *Store) maintain() {
+	defer s.wg.Done()
+
+	pruneTicker := time.NewTicker(time.Minute)
+	defer pruneTicker.Stop()
+	saveTicker := time.NewTicker(s.config.SaveInterval)
+	defer saveTicker.Stop()
+
+	for {
+		select {
+		case <-pruneTicker.C:
+			s.prune()
+		case <-saveTicker.C:
+			if s.config.FilePath == "" {
+				continue
+			}
+			if err := s.save(); err != nil {
+				s.health.Failure()
+				s.logger.Errorw("Failed to save time-series snapshot", "path", s.config.FilePath, "error", err)
+			} else {
+				s.health.Success()
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// prune drops raw samples and rollups that have aged out of their tier.
+func (s *Store) prune() {
+	rawCutoff := s.rawCutoff()
+	rollupCutoff := s.now().Add(-s.config.RollupRetention).Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, ser := range s.series {
+		// Copy rather than reslice so the expired prefix can be collected.
+		if i := sort.Search(len(ser.raw), func(j int) bool { return ser.raw[j].T >= rawCutoff }); i > 0 {
+			ser.raw = append([]sample(nil), ser.raw[i:]...)
+		}
+
+		// A rollup is kept until its whole interval has expired.
+		j := sort.Search(len(ser.rollups), func(k int) bool {
+			return ser.rollups[k].Start+s.interval() > rollupCutoff
+		})
+		if j > 0 {
+			ser.rollups = append([]aggregate(nil), ser.rollups[j:]...)
+		}
+
+		if len(ser.raw) == 0 && len(ser.rollups) == 0 {
+			delete(s.series, key)
+		}
+	}
+}
+
+func (s *Store) rawCutoff() int64 {
+	return s.now().Add(-s.config.RawRetention).Unix()
+}
+
+// interval returns the rollup interval in seconds.
+func (s *Store) interval() int64 {
+	return max(int64(s.config.RollupInterval/time.Second), 1)
+}
+
+// truncate rounds a Unix timestamp down to a multiple of step seconds.
+func truncate(ts, step int64) int64 {
+	r := ts % step
+	if r < 0 {
+		r += step
+	}
+	return ts - r
+}
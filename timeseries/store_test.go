@@ -0,0 +1,149 @@
+package timeseries
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dpup/prefab/logging"
+	"google.golang.org/protobuf/proto"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+)
+
+var testNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestStore(t *testing.T, config Config) *Store {
+	t.Helper()
+	s := NewStore(config, logging.NewDevLogger().Named("test"))
+	s.now = func() time.Time { return testNow }
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRecordTelemetry(t *testing.T) {
+	s := newTestStore(t, Config{})
+
+	s.Record(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    0xabcd1234,
+		PortNum: pb.PortNum_TELEMETRY_APP,
+		RxTime:  uint64(testNow.Add(-time.Minute).Unix()),
+		Payload: &meshtreampb.Data_Telemetry{Telemetry: &pb.Telemetry{
+			Variant: &pb.Telemetry_EnvironmentMetrics{EnvironmentMetrics: &pb.EnvironmentMetrics{
+				Temperature: proto.Float32(21.5),
+				Voltage:     proto.Float32(3.3),
+			}},
+		}},
+	}})
+
+	got := s.Metrics(0xabcd1234)
+	if len(got) != 2 || got[0] != "environment.temperature" || got[1] != "environment.voltage" {
+		t.Errorf("unexpected metrics: %v", got)
+	}
+
+	if metric, ok := s.Resolve(0xabcd1234, "temperature"); !ok || metric != "environment.temperature" {
+		t.Errorf("bare name resolved to %q, %t", metric, ok)
+	}
+	if _, ok := s.Resolve(0xabcd1234, "battery_level"); ok {
+		t.Error("unrecorded metric should not resolve")
+	}
+}
+
+func TestQueryRawBuckets(t *testing.T) {
+	s := newTestStore(t, Config{})
+	base := testNow.Add(-time.Hour).Truncate(5 * time.Minute)
+
+	// Two samples in the first bucket, recorded out of order, and one in the
+	// third.
+	s.Add(1, "device.battery_level", base.Add(2*time.Minute), 80)
+	s.Add(1, "device.battery_level", base.Add(time.Minute), 90)
+	s.Add(1, "device.battery_level", base.Add(11*time.Minute), 70)
+
+	buckets := s.Query(1, "device.battery_level", base, testNow, 5*time.Minute)
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 non-empty buckets, got %d: %+v", len(buckets), buckets)
+	}
+
+	first := buckets[0]
+	if !first.Start.Equal(base) || first.Min != 80 || first.Max != 90 || first.Avg != 85 || first.Last != 80 || first.Count != 2 {
+		t.Errorf("unexpected first bucket: %+v", first)
+	}
+	if !buckets[1].Start.Equal(base.Add(10*time.Minute)) || buckets[1].Last != 70 {
+		t.Errorf("unexpected second bucket: %+v", buckets[1])
+	}
+}
+
+func TestAddIgnoresNonFiniteValues(t *testing.T) {
+	s := newTestStore(t, Config{})
+	at := testNow.Add(-time.Minute)
+	s.Add(1, "device.voltage", at, 4.1)
+	s.Add(1, "device.voltage", at, math.NaN())
+	s.Add(1, "device.voltage", at, math.Inf(-1))
+
+	buckets := s.Query(1, "device.voltage", testNow.Add(-time.Hour), testNow, time.Hour)
+	if len(buckets) != 1 || buckets[0].Count != 1 || buckets[0].Avg != 4.1 {
+		t.Errorf("expected only the finite sample, got %+v", buckets)
+	}
+}
+
+func TestQueryUsesRollupsBeyondRawRetention(t *testing.T) {
+	s := newTestStore(t, Config{})
+
+	old := testNow.Add(-72 * time.Hour).Truncate(time.Hour)
+	s.Add(1, "device.voltage", old.Add(10*time.Minute), 4.0)
+	s.Add(1, "device.voltage", old.Add(20*time.Minute), 3.8)
+	s.Add(1, "device.voltage", old.Add(90*time.Minute), 3.6)
+	s.prune()
+
+	s.mu.RLock()
+	ser := s.series[seriesKey{node: 1, metric: "device.voltage"}]
+	rawLen := len(ser.raw)
+	s.mu.RUnlock()
+	if rawLen != 0 {
+		t.Errorf("expected raw samples older than 24h to be pruned, %d remain", rawLen)
+	}
+
+	// A 5m step is rounded up to the rollup interval.
+	buckets := s.Query(1, "device.voltage", old.Add(-time.Hour), testNow, 5*time.Minute)
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 hourly buckets, got %d: %+v", len(buckets), buckets)
+	}
+	if buckets[0].Min != 3.8 || buckets[0].Max != 4.0 || buckets[0].Last != 3.8 || buckets[0].Count != 2 {
+		t.Errorf("unexpected rollup bucket: %+v", buckets[0])
+	}
+
+	// Wider steps merge rollups.
+	buckets = s.Query(1, "device.voltage", old.Add(-time.Hour), testNow, 24*time.Hour)
+	if len(buckets) != 1 || buckets[0].Count != 3 || buckets[0].Last != 3.6 {
+		t.Errorf("unexpected daily bucket: %+v", buckets)
+	}
+}
+
+func TestPruneDropsExpiredRollups(t *testing.T) {
+	s := newTestStore(t, Config{RollupRetention: 48 * time.Hour})
+
+	s.Add(1, "device.voltage", testNow.Add(-47*time.Hour), 4.0)
+	s.now = func() time.Time { return testNow.Add(3 * time.Hour) }
+	s.prune()
+
+	if metrics := s.Metrics(1); len(metrics) != 0 {
+		t.Errorf("expected expired series to be removed, got %v", metrics)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.gob")
+
+	s := NewStore(Config{FilePath: path}, logging.NewDevLogger().Named("test"))
+	s.Add(1, "device.battery_level", time.Now().Add(-time.Minute), 55)
+	s.Close()
+
+	restored := newTestStore(t, Config{FilePath: path})
+	restored.now = time.Now
+	buckets := restored.Query(1, "device.battery_level", time.Now().Add(-time.Hour), time.Now(), time.Hour)
+	if len(buckets) != 1 || buckets[0].Last != 55 {
+		t.Errorf("expected history to survive a restart, got %+v", buckets)
+	}
+}
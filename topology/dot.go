@@ -0,0 +1,80 @@
+package topology
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sourceStyles sets the GraphViz line style for an edge based on its
+// strongest evidence, from measured hops down to inferred ones.
+var sourceStyles = []struct {
+	source Source
+	style  string
+}{
+	{SourceTraceroute, "solid"},
+	{SourceNeighborInfo, "solid"},
+	{SourceZeroHop, "solid"},
+	{SourceRelay, "dashed"},
+	{SourceNextHop, "dotted"},
+}
+
+// WriteDOT renders the topology as a GraphViz digraph. Nodes are labelled
+// with their short name (or ID) and edges with their latest SNR.
+func (t Topology) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph mesh {\n")
+	sb.WriteString("  node [shape=ellipse];\n")
+
+	for _, n := range t.Nodes {
+		label := n.ID
+		if n.ShortName != "" {
+			label = n.ShortName
+		}
+		tooltip := n.ID
+		if n.LongName != "" {
+			tooltip = n.LongName + " (" + n.ID + ")"
+		}
+		fmt.Fprintf(&sb, "  %s [label=%s, tooltip=%s];\n", quote(n.ID), quote(label), quote(tooltip))
+	}
+
+	for _, e := range t.Edges {
+		attrs := []string{"style=" + edgeStyle(e)}
+		if n := len(e.SNRHistory); n > 0 {
+			snr := strconv.FormatFloat(float64(e.SNRHistory[n-1].SNR), 'f', -1, 32)
+			attrs = append(attrs, "label="+quote(snr+" dB"))
+		}
+
+		sources := make([]string, 0, len(e.Sources))
+		for s := range e.Sources {
+			sources = append(sources, string(s))
+		}
+		sort.Strings(sources)
+		attrs = append(attrs, "tooltip="+quote(strings.Join(sources, ", ")))
+
+		if e.ViaMQTT {
+			attrs = append(attrs, "color=gray")
+		}
+		fmt.Fprintf(&sb, "  %s -> %s [%s];\n", quote(e.From), quote(e.To), strings.Join(attrs, ", "))
+	}
+
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func edgeStyle(e Edge) string {
+	for _, s := range sourceStyles {
+		if _, ok := e.Sources[s.source]; ok {
+			return s.style
+		}
+	}
+	return "dotted"
+}
+
+// quote returns s as a DOT quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
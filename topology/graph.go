@@ -0,0 +1,402 @@
+package topology
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
+)
+
+// Source describes the evidence an edge observation was inferred from.
+type Source string
+
+const (
+	SourceTraceroute   Source = "traceroute"    // Hop in a RouteDiscovery reply, with SNR per hop
+	SourceNeighborInfo Source = "neighbor_info" // Neighbor reported by a NeighborInfo broadcast
+	SourceZeroHop      Source = "zero_hop"      // Gateway received the sender's own transmission
+	SourceRelay        Source = "relay"         // Gateway received a relayed packet from relay_node
+	SourceNextHop      Source = "next_hop"      // Relaying node routed a packet towards next_hop
+)
+
+// Config holds configuration for the topology graph.
+type Config struct {
+	MaxAge      time.Duration // Edges not observed within this window are dropped (default: 24h)
+	MaxEdges    int           // Maximum number of edges; past it the oldest tenth are dropped (default: 5000)
+	HistorySize int           // SNR samples kept per edge (default: 20)
+}
+
+// SNRSample is one signal-to-noise measurement of an edge.
+type SNRSample struct {
+	Time   time.Time `json:"time"`
+	SNR    float32   `json:"snr"`
+	Source Source    `json:"source"`
+}
+
+// Edge is a directed radio link: To was observed receiving From.
+type Edge struct {
+	From       string               `json:"from"`
+	To         string               `json:"to"`
+	Sources    map[Source]time.Time `json:"sources"` // Last time each kind of evidence was seen
+	SNRHistory []SNRSample          `json:"snrHistory"`
+	LastRSSI   int32                `json:"lastRssi,omitempty"` // Gateway-measured RSSI from zero-hop packets
+	ViaMQTT    bool                 `json:"viaMqtt,omitempty"`  // Some evidence crossed an MQTT bridge
+	LastSeen   time.Time            `json:"lastSeen"`
+}
+
+// Node is a vertex of the topology graph.
+type Node struct {
+	ID        string    `json:"id"`
+	Num       uint32    `json:"num"`
+	LongName  string    `json:"longName,omitempty"`
+	ShortName string    `json:"shortName,omitempty"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Topology is a point-in-time copy of the graph.
+type Topology struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// unknownSNR is the quarter-dB value firmware reports for hops whose SNR was
+// not measured.
+const unknownSNR = -128
+
+// pruneInterval limits how often expired entries are swept.
+const pruneInterval = time.Minute
+
+type edgeKey struct {
+	from, to uint32
+}
+
+// packetKey deduplicates copies of a packet delivered by several gateways.
+type packetKey struct {
+	from, id uint32
+}
+
+// Graph infers links between mesh nodes from the packets passing through the
+// broker.
+type Graph struct {
+	config    Config
+	directory *nodes.Directory
+	now       func() time.Time
+
+	mu        sync.RWMutex
+	nodes     map[uint32]time.Time
+	edges     map[edgeKey]*Edge
+	processed map[packetKey]time.Time
+	lastPrune time.Time
+}
+
+// NewGraph creates an empty topology graph. The directory, which may be nil,
+// is used to label nodes.
+func NewGraph(config Config, directory *nodes.Directory) *Graph {
+	if config.MaxAge <= 0 {
+		config.MaxAge = 24 * time.Hour
+	}
+	if config.MaxEdges <= 0 {
+		config.MaxEdges = 5000
+	}
+	if config.HistorySize <= 0 {
+		config.HistorySize = 20
+	}
+
+	return &Graph{
+		config:    config,
+		directory: directory,
+		now:       time.Now,
+		nodes:     make(map[uint32]time.Time),
+		edges:     make(map[edgeKey]*Edge),
+		processed: make(map[packetKey]time.Time),
+	}
+}
+
+// Observe extracts link evidence from a packet. It can be used directly as a
+// subscriber processor.
+func (g *Graph) Observe(packet *meshtreampb.Packet) {
+	data := packet.GetData()
+	if data == nil || data.GetFrom() == 0 {
+		return
+	}
+	now := g.now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.lastPrune) >= pruneInterval {
+		g.prune(now)
+	}
+	g.nodes[data.GetFrom()] = now
+
+	gateway, err := nodes.ParseID(data.GetGatewayId())
+	hasGateway := err == nil && gateway != 0 && gateway != data.GetFrom()
+	if hasGateway {
+		g.nodes[gateway] = now
+	}
+
+	// hop_start is only set by firmware 2.3+, so hops taken is unknown for
+	// packets without it.
+	hopsKnown := data.GetHopStart() > 0 && data.GetHopStart() >= data.GetHopLimit()
+	hopsTaken := data.GetHopStart() - data.GetHopLimit()
+
+	// The gateway heard the sender directly.
+	if hasGateway && hopsKnown && hopsTaken == 0 {
+		e := g.observe(data.GetFrom(), gateway, SourceZeroHop, data.GetViaMqtt(), now)
+		g.addSNR(e, data.GetRxSnr(), SourceZeroHop, now)
+		if data.GetRxRssi() != 0 {
+			e.LastRSSI = data.GetRxRssi()
+		}
+	}
+
+	// relay_node carries only the low byte of the last relayer's node number.
+	var relay uint32
+	if hopsKnown && hopsTaken > 0 && data.GetRelayNode() != 0 {
+		relay, _ = g.resolveByte(data.GetRelayNode())
+	}
+	if relay != 0 && hasGateway && relay != gateway {
+		e := g.observe(relay, gateway, SourceRelay, data.GetViaMqtt(), now)
+		g.addSNR(e, data.GetRxSnr(), SourceRelay, now)
+		if hopsTaken == 1 && relay != data.GetFrom() {
+			g.observe(data.GetFrom(), relay, SourceRelay, data.GetViaMqtt(), now)
+		}
+	}
+
+	// next_hop is set when the transmitting node routed the packet to a
+	// specific neighbor, implying it believes it can reach that neighbor.
+	if data.GetNextHop() != 0 {
+		sender := relay
+		if hopsKnown && hopsTaken == 0 {
+			sender = data.GetFrom()
+		}
+		if next, ok := g.resolveByte(data.GetNextHop()); ok && sender != 0 && next != sender {
+			g.observe(sender, next, SourceNextHop, data.GetViaMqtt(), now)
+		}
+	}
+
+	switch data.GetPortNum() {
+	case pb.PortNum_TRACEROUTE_APP:
+		if route := data.GetRouteDiscovery(); route != nil && !data.GetWantResponse() && g.firstCopy(data, now) {
+			g.observeRoute(data, route, now)
+		}
+	case pb.PortNum_NEIGHBORINFO_APP:
+		if info := data.GetNeighborInfo(); info != nil && g.firstCopy(data, now) {
+			g.observeNeighbors(data, info, now)
+		}
+	}
+
+	g.enforceLimit()
+}
+
+// observeRoute records each hop of a traceroute reply. The reply travels from
+// the traced node back to the requester, so the forward path runs from
+// data.to to data.from and the return path the other way.
+func (g *Graph) observeRoute(data *meshtreampb.Data, route *pb.RouteDiscovery, now time.Time) {
+	forward := append(append([]uint32{data.GetTo()}, route.GetRoute()...), data.GetFrom())
+	g.observePath(forward, route.GetSnrTowards(), data.GetViaMqtt(), now)
+
+	// route_back is only populated by firmware that records the return path.
+	if len(route.GetRouteBack()) > 0 || len(route.GetSnrBack()) > 0 {
+		back := append(append([]uint32{data.GetFrom()}, route.GetRouteBack()...), data.GetTo())
+		g.observePath(back, route.GetSnrBack(), data.GetViaMqtt(), now)
+	}
+}
+
+func (g *Graph) observePath(path []uint32, snrs []int32, viaMQTT bool, now time.Time) {
+	for i := 0; i+1 < len(path); i++ {
+		from, to := path[i], path[i+1]
+		if from == 0 || to == 0 || from == to || from == nodes.BroadcastID || to == nodes.BroadcastID {
+			continue
+		}
+		g.nodes[from] = now
+		g.nodes[to] = now
+
+		// Traceroute SNR is reported in quarter-dB. A hop SNR of exactly zero
+		// marks a hop that crossed an MQTT bridge rather than the air.
+		hopViaMQTT := viaMQTT
+		var snr float32
+		hasSNR := i < len(snrs)
+		if hasSNR {
+			snr = float32(snrs[i]) / 4
+			hopViaMQTT = hopViaMQTT || snrs[i] == 0
+		}
+		e := g.observe(from, to, SourceTraceroute, hopViaMQTT, now)
+		if hasSNR && snrs[i] != 0 && snrs[i] != unknownSNR {
+			g.addSNR(e, snr, SourceTraceroute, now)
+		}
+	}
+}
+
+// observeNeighbors records the links reported by a NeighborInfo broadcast.
+// Each neighbor was heard by the broadcasting node.
+func (g *Graph) observeNeighbors(data *meshtreampb.Data, info *pb.NeighborInfo, now time.Time) {
+	receiver := info.GetNodeId()
+	if receiver == 0 {
+		receiver = data.GetFrom()
+	}
+	for _, neighbor := range info.GetNeighbors() {
+		sender := neighbor.GetNodeId()
+		if sender == 0 || sender == receiver {
+			continue
+		}
+		g.nodes[sender] = now
+		e := g.observe(sender, receiver, SourceNeighborInfo, data.GetViaMqtt(), now)
+		g.addSNR(e, neighbor.GetSnr(), SourceNeighborInfo, now)
+	}
+}
+
+// observe returns the edge from -> to, creating it if needed, and records
+// that the given evidence was seen.
+func (g *Graph) observe(from, to uint32, source Source, viaMQTT bool, now time.Time) *Edge {
+	key := edgeKey{from: from, to: to}
+	e, ok := g.edges[key]
+	if !ok {
+		e = &Edge{
+			From:    nodes.FormatID(from),
+			To:      nodes.FormatID(to),
+			Sources: make(map[Source]time.Time),
+		}
+		g.edges[key] = e
+	}
+	e.Sources[source] = now
+	e.LastSeen = now
+	e.ViaMQTT = e.ViaMQTT || viaMQTT
+	return e
+}
+
+func (g *Graph) addSNR(e *Edge, snr float32, source Source, now time.Time) {
+	if snr == 0 {
+		return
+	}
+	e.SNRHistory = append(e.SNRHistory, SNRSample{Time: now, SNR: snr, Source: source})
+	if n := len(e.SNRHistory); n > g.config.HistorySize {
+		e.SNRHistory = append([]SNRSample(nil), e.SNRHistory[n-g.config.HistorySize:]...)
+	}
+}
+
+// resolveByte maps the low byte used by relay_node and next_hop to a known
+// node. It only succeeds when exactly one known node matches.
+func (g *Graph) resolveByte(b uint32) (uint32, bool) {
+	b &= 0xff
+	var match uint32
+	for num := range g.nodes {
+		if num&0xff != b {
+			continue
+		}
+		if match != 0 {
+			return 0, false
+		}
+		match = num
+	}
+	return match, match != 0
+}
+
+// firstCopy reports whether this is the first time a packet has been seen,
+// so that traceroutes and neighbor reports heard by several gateways are only
+// counted once.
+func (g *Graph) firstCopy(data *meshtreampb.Data, now time.Time) bool {
+	if data.GetId() == 0 {
+		return true
+	}
+	key := packetKey{from: data.GetFrom(), id: data.GetId()}
+	if _, ok := g.processed[key]; ok {
+		return false
+	}
+	g.processed[key] = now
+	return true
+}
+
+// prune drops edges, nodes and dedup entries older than MaxAge.
+func (g *Graph) prune(now time.Time) {
+	g.lastPrune = now
+	cutoff := now.Add(-g.config.MaxAge)
+	for key, e := range g.edges {
+		if e.LastSeen.Before(cutoff) {
+			delete(g.edges, key)
+		}
+	}
+	for num, seen := range g.nodes {
+		if seen.Before(cutoff) {
+			delete(g.nodes, num)
+		}
+	}
+	for key, seen := range g.processed {
+		if seen.Before(cutoff) {
+			delete(g.processed, key)
+		}
+	}
+}
+
+// enforceLimit drops the least recently seen edges when over MaxEdges. It
+// drops them down to 90% of the limit, and keeps at least one, so the edges
+// are only sorted once every tenth of MaxEdges new ones rather than on every
+// packet.
+func (g *Graph) enforceLimit() {
+	if len(g.edges) <= g.config.MaxEdges {
+		return
+	}
+	excess := len(g.edges) - max(1, g.config.MaxEdges*9/10)
+	keys := make([]edgeKey, 0, len(g.edges))
+	for key := range g.edges {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return g.edges[keys[i]].LastSeen.Before(g.edges[keys[j]].LastSeen)
+	})
+	for _, key := range keys[:excess] {
+		delete(g.edges, key)
+	}
+}
+
+// Snapshot returns a copy of the current graph. Nodes are sorted by number
+// and edges by endpoints.
+func (g *Graph) Snapshot() Topology {
+	g.mu.Lock()
+	g.prune(g.now())
+
+	t := Topology{
+		Nodes: make([]Node, 0, len(g.nodes)),
+		Edges: make([]Edge, 0, len(g.edges)),
+	}
+	for _, e := range g.edges {
+		edge := *e
+		edge.Sources = make(map[Source]time.Time, len(e.Sources))
+		for s, seen := range e.Sources {
+			edge.Sources[s] = seen
+		}
+		edge.SNRHistory = append([]SNRSample{}, e.SNRHistory...)
+		t.Edges = append(t.Edges, edge)
+	}
+	nums := make([]uint32, 0, len(g.nodes))
+	lastSeen := make(map[uint32]time.Time, len(g.nodes))
+	for num, seen := range g.nodes {
+		nums = append(nums, num)
+		lastSeen[num] = seen
+	}
+	g.mu.Unlock()
+
+	// IDs are fixed-width hex, so string order matches numeric order.
+	sort.Slice(t.Edges, func(i, j int) bool {
+		if t.Edges[i].From != t.Edges[j].From {
+			return t.Edges[i].From < t.Edges[j].From
+		}
+		return t.Edges[i].To < t.Edges[j].To
+	})
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	for _, num := range nums {
+		node := Node{ID: nodes.FormatID(num), Num: num, LastSeen: lastSeen[num]}
+		if g.directory == nil {
+			t.Nodes = append(t.Nodes, node)
+			continue
+		}
+		if user := g.directory.User(num); user != nil {
+			node.LongName = user.GetLongName()
+			node.ShortName = user.GetShortName()
+		}
+		t.Nodes = append(t.Nodes, node)
+	}
+	return t
+}
@@ -0,0 +1,230 @@
+package topology
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	meshtreampb "meshstream/generated/meshstream"
+	pb "meshstream/generated/meshtastic"
+	"meshstream/nodes"
+)
+
+func findEdge(t Topology, from, to string) *Edge {
+	for i := range t.Edges {
+		if t.Edges[i].From == from && t.Edges[i].To == to {
+			return &t.Edges[i]
+		}
+	}
+	return nil
+}
+
+func TestZeroHopEvidence(t *testing.T) {
+	g := NewGraph(Config{}, nil)
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:      0x11111111,
+		GatewayId: "!22222222",
+		HopStart:  3,
+		HopLimit:  3,
+		RxSnr:     6.5,
+		RxRssi:    -90,
+		PortNum:   pb.PortNum_TEXT_MESSAGE_APP,
+	}})
+
+	topo := g.Snapshot()
+	e := findEdge(topo, "!11111111", "!22222222")
+	if e == nil {
+		t.Fatalf("expected zero-hop edge, got %+v", topo.Edges)
+	}
+	if _, ok := e.Sources[SourceZeroHop]; !ok || e.LastRSSI != -90 || len(e.SNRHistory) != 1 || e.SNRHistory[0].SNR != 6.5 {
+		t.Errorf("unexpected zero-hop edge: %+v", e)
+	}
+	if len(topo.Nodes) != 2 {
+		t.Errorf("expected both endpoints as nodes, got %+v", topo.Nodes)
+	}
+}
+
+func TestTracerouteHops(t *testing.T) {
+	g := NewGraph(Config{}, nil)
+	reply := &meshtreampb.Packet{Data: &meshtreampb.Data{
+		Id:      42,
+		From:    0xcccccccc, // traced node
+		To:      0xaaaaaaaa, // requester
+		PortNum: pb.PortNum_TRACEROUTE_APP,
+		Payload: &meshtreampb.Data_RouteDiscovery{RouteDiscovery: &pb.RouteDiscovery{
+			Route:      []uint32{0xbbbbbbbb},
+			SnrTowards: []int32{24, -10},
+			RouteBack:  []uint32{0xbbbbbbbb},
+			SnrBack:    []int32{8, 0},
+		}},
+	}}
+	g.Observe(reply)
+	// A second gateway delivering the same reply adds nothing.
+	g.Observe(reply)
+
+	topo := g.Snapshot()
+	cases := []struct {
+		from, to string
+		snr      float32
+	}{
+		{"!aaaaaaaa", "!bbbbbbbb", 6},
+		{"!bbbbbbbb", "!cccccccc", -2.5},
+		{"!cccccccc", "!bbbbbbbb", 2},
+	}
+	for _, c := range cases {
+		e := findEdge(topo, c.from, c.to)
+		if e == nil {
+			t.Fatalf("missing edge %s -> %s", c.from, c.to)
+		}
+		if len(e.SNRHistory) != 1 || e.SNRHistory[0].SNR != c.snr {
+			t.Errorf("edge %s -> %s: unexpected SNR history %+v", c.from, c.to, e.SNRHistory)
+		}
+	}
+
+	// A zero SNR marks an MQTT-bridged hop.
+	if e := findEdge(topo, "!bbbbbbbb", "!aaaaaaaa"); e == nil || !e.ViaMQTT || len(e.SNRHistory) != 0 {
+		t.Errorf("expected MQTT-bridged return hop without SNR, got %+v", e)
+	}
+}
+
+func TestNeighborInfo(t *testing.T) {
+	g := NewGraph(Config{}, nil)
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		Id:      7,
+		From:    0x11111111,
+		PortNum: pb.PortNum_NEIGHBORINFO_APP,
+		Payload: &meshtreampb.Data_NeighborInfo{NeighborInfo: &pb.NeighborInfo{
+			NodeId:    0x11111111,
+			Neighbors: []*pb.Neighbor{{NodeId: 0x33333333, Snr: -4.25}},
+		}},
+	}})
+
+	e := findEdge(g.Snapshot(), "!33333333", "!11111111")
+	if e == nil || len(e.SNRHistory) != 1 || e.SNRHistory[0].Source != SourceNeighborInfo {
+		t.Errorf("expected neighbor edge into the broadcaster, got %+v", e)
+	}
+}
+
+func TestRelayAndNextHopHints(t *testing.T) {
+	g := NewGraph(Config{}, nil)
+	// Make the relay known so its low byte can be resolved.
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{From: 0x445566ab}})
+
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:      0x11111111,
+		GatewayId: "!22222222",
+		HopStart:  3,
+		HopLimit:  2,
+		RelayNode: 0xab,
+		RxSnr:     -3,
+	}})
+
+	topo := g.Snapshot()
+	if e := findEdge(topo, "!445566ab", "!22222222"); e == nil || len(e.SNRHistory) != 1 {
+		t.Errorf("expected relay -> gateway edge with SNR, got %+v", e)
+	}
+	if e := findEdge(topo, "!11111111", "!445566ab"); e == nil {
+		t.Error("expected sender -> relay edge for a one-hop packet")
+	}
+
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:     0x11111111,
+		HopStart: 3,
+		HopLimit: 3,
+		NextHop:  0xab,
+	}})
+	if e := findEdge(g.Snapshot(), "!11111111", "!445566ab"); e == nil {
+		t.Error("expected next_hop edge")
+	} else if _, ok := e.Sources[SourceNextHop]; !ok {
+		t.Errorf("expected next_hop source, got %v", e.Sources)
+	}
+}
+
+func TestAmbiguousRelayIgnored(t *testing.T) {
+	g := NewGraph(Config{}, nil)
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{From: 0x000000ab}})
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{From: 0x000001ab}})
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:      0x11111111,
+		GatewayId: "!22222222",
+		HopStart:  3,
+		HopLimit:  1,
+		RelayNode: 0xab,
+	}})
+
+	if edges := g.Snapshot().Edges; len(edges) != 0 {
+		t.Errorf("ambiguous relay byte should not produce edges, got %+v", edges)
+	}
+}
+
+func TestPruneAndDOT(t *testing.T) {
+	directory := nodes.NewDirectory()
+	directory.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From:    0x11111111,
+		PortNum: pb.PortNum_NODEINFO_APP,
+		Payload: &meshtreampb.Data_NodeInfo{NodeInfo: &pb.User{LongName: `Hill "Top"`, ShortName: "HT"}},
+	}})
+
+	now := time.Now()
+	g := NewGraph(Config{MaxAge: time.Hour}, directory)
+	g.now = func() time.Time { return now }
+	g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+		From: 0x11111111, GatewayId: "!22222222", HopStart: 3, HopLimit: 3, RxSnr: 5,
+	}})
+
+	var sb strings.Builder
+	if err := g.Snapshot().WriteDOT(&sb); err != nil {
+		t.Fatal(err)
+	}
+	dot := sb.String()
+	for _, want := range []string{
+		`"!11111111" [label="HT", tooltip="Hill \"Top\" (!11111111)"];`,
+		`"!11111111" -> "!22222222" [style=solid, label="5 dB", tooltip="zero_hop"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	if topo := g.Snapshot(); len(topo.Edges) != 0 || len(topo.Nodes) != 0 {
+		t.Errorf("expected stale edges and nodes to be pruned, got %+v", topo)
+	}
+}
+
+func TestEdgeLimit(t *testing.T) {
+	now := time.Now()
+	g := NewGraph(Config{MaxEdges: 10}, nil)
+	g.now = func() time.Time { return now }
+	for i := uint32(1); i <= 11; i++ {
+		now = now.Add(time.Second)
+		g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+			From: i, GatewayId: "!0000ffff", HopStart: 3, HopLimit: 3,
+		}})
+	}
+
+	// Going over the limit drops the oldest edges down to 90% of it.
+	topo := g.Snapshot()
+	if len(topo.Edges) != 9 {
+		t.Fatalf("expected 9 edges, got %d", len(topo.Edges))
+	}
+	for i := uint32(1); i <= 11; i++ {
+		kept := findEdge(topo, nodes.FormatID(i), "!0000ffff") != nil
+		if kept != (i > 2) {
+			t.Errorf("edge from %d: expected kept=%v", i, i > 2)
+		}
+	}
+
+	// A small limit still keeps the latest edge.
+	g = NewGraph(Config{MaxEdges: 1}, nil)
+	g.now = func() time.Time { return now }
+	for i := uint32(1); i <= 2; i++ {
+		now = now.Add(time.Second)
+		g.Observe(&meshtreampb.Packet{Data: &meshtreampb.Data{
+			From: i, GatewayId: "!0000ffff", HopStart: 3, HopLimit: 3,
+		}})
+	}
+	if topo := g.Snapshot(); len(topo.Edges) != 1 || findEdge(topo, "!00000002", "!0000ffff") == nil {
+		t.Errorf("expected only the latest edge, got %+v", topo.Edges)
+	}
+}
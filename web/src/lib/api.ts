@@ -56,6 +56,7 @@ export function streamPackets(
   let reconnectTimer: number | null = null;
   let shouldReconnect = true;
   let reconnectAttempt = 0;
+  let lastEventId = ""; // ID of the last packet received, for resuming
   
   // Reconnection settings
   const INITIAL_RECONNECT_DELAY = 1000; // 1 second
@@ -94,6 +95,9 @@ export function streamPackets(
       source.removeEventListener("message", handleMessage as EventListener);
       source.removeEventListener("info", handleInfo as EventListener);
       source.removeEventListener("connection_info", handleConnectionInfo as EventListener);
+      source.removeEventListener("gap", handleGap as EventListener);
+      source.removeEventListener("snapshot", handleSnapshot as EventListener);
+      source.removeEventListener("replay_complete", handleReplayComplete as EventListener);
       source.onerror = null;
       
       // Close the connection
@@ -120,6 +124,38 @@ export function streamPackets(
     });
   }
 
+  /**
+   * Handle gap events, sent when packets missed while disconnected are no
+   * longer available and the server is replaying its whole cache
+   */
+  function handleGap(event: Event): void {
+    const evtData = (event as any).data;
+    try {
+      const parsedData = JSON.parse(String(evtData));
+      console.warn("[SSE] Missed packets are no longer available:", parsedData.lastEventId);
+      onEvent({
+        type: "info",
+        data: String(parsedData.message),
+      });
+    } catch (error) {
+      console.warn("[SSE] Failed to parse gap notice:", error);
+    }
+  }
+
+  /**
+   * Handle replay_complete events, sent once the cached packets have been
+   * replayed and live packets follow
+   */
+  function handleReplayComplete(event: Event): void {
+    const evtData = (event as any).data;
+    try {
+      const parsedData = JSON.parse(String(evtData));
+      console.log(`[SSE] Replayed ${parsedData.packets} cached packets`);
+    } catch (error) {
+      console.warn("[SSE] Failed to parse replay notice:", error);
+    }
+  }
+
   /**
    * Handle connection info events
    */
@@ -144,33 +180,12 @@ export function streamPackets(
    */
   function handleMessage(event: Event): void {
     const evtData = (event as any).data;
+    if ((event as any).lastEventId) {
+      lastEventId = String((event as any).lastEventId);
+    }
     try {
       // Parse the event data as JSON
-      const parsedData = JSON.parse(String(evtData)) as Packet;
-      
-      // Check if the packet has a timestamp and filter by age
-      if (parsedData.data && parsedData.data.rxTime) {
-        const currentTime = Math.floor(Date.now() / 1000); // Current time in seconds
-        const packetTime = parsedData.data.rxTime;
-        const ageInSeconds = currentTime - packetTime;
-        const maxAgeInSeconds = MAX_PACKET_AGE_HOURS * 60 * 60;
-        
-        // Skip packets older than our threshold
-        if (ageInSeconds > maxAgeInSeconds) {
-          console.debug(
-            `[SSE] Ignoring old packet: age=${Math.round(ageInSeconds / 3600)}h ` +
-            `(max=${MAX_PACKET_AGE_HOURS}h)`,
-            parsedData.data.id
-          );
-          return;
-        }
-      }
-      
-      // Forward the message to the caller
-      onEvent({
-        type: "message",
-        data: parsedData,
-      });
+      forwardPacket(JSON.parse(String(evtData)) as Packet);
     } catch (error) {
       console.warn("[SSE] Failed to parse message:", error);
       
@@ -181,6 +196,53 @@ export function streamPackets(
       });
     }
   }
+
+  /**
+   * Handle snapshot events, which carry the latest state of every node and
+   * recent chat in place of a replay of the server's cache
+   */
+  function handleSnapshot(event: Event): void {
+    const evtData = (event as any).data;
+    if ((event as any).lastEventId) {
+      lastEventId = String((event as any).lastEventId);
+    }
+    try {
+      const parsedData = JSON.parse(String(evtData)) as { seq: string; packets: Packet[] };
+      console.log(`[SSE] Received snapshot of ${parsedData.packets.length} packets`);
+      parsedData.packets.forEach(forwardPacket);
+    } catch (error) {
+      console.warn("[SSE] Failed to parse snapshot:", error);
+    }
+  }
+
+  /**
+   * Forward a packet to the caller unless it is too old
+   */
+  function forwardPacket(parsedData: Packet): void {
+    // Check if the packet has a timestamp and filter by age
+    if (parsedData.data && parsedData.data.rxTime) {
+      const currentTime = Math.floor(Date.now() / 1000); // Current time in seconds
+      const packetTime = parsedData.data.rxTime;
+      const ageInSeconds = currentTime - packetTime;
+      const maxAgeInSeconds = MAX_PACKET_AGE_HOURS * 60 * 60;
+      
+      // Skip packets older than our threshold
+      if (ageInSeconds > maxAgeInSeconds) {
+        console.debug(
+          `[SSE] Ignoring old packet: age=${Math.round(ageInSeconds / 3600)}h ` +
+          `(max=${MAX_PACKET_AGE_HOURS}h)`,
+          parsedData.data.id
+        );
+        return;
+      }
+    }
+    
+    // Forward the message to the caller
+    onEvent({
+      type: "message",
+      data: parsedData,
+    });
+  }
   
   /**
    * Handle connection errors and reconnection
@@ -225,8 +287,18 @@ export function streamPackets(
     }
     
     try {
-      // Create a new EventSource connection using dynamic endpoint
-      source = new EventSource(getStreamEndpoint());
+      // Create a new EventSource connection using dynamic endpoint. When
+      // reconnecting, resume after the last packet received.
+      // Otherwise ask for a snapshot of the current state rather than a
+      // replay of every cached packet.
+      let endpoint = getStreamEndpoint();
+      const separator = endpoint.includes("?") ? "&" : "?";
+      if (lastEventId) {
+        endpoint += separator + "lastEventId=" + encodeURIComponent(lastEventId);
+      } else {
+        endpoint += separator + "snapshot=true";
+      }
+      source = new EventSource(endpoint);
       
       // Log connection attempt
       if (reconnectAttempt === 0) {
@@ -239,6 +311,9 @@ export function streamPackets(
       source.addEventListener("info", handleInfo as EventListener);
       source.addEventListener("message", handleMessage as EventListener);
       source.addEventListener("connection_info", handleConnectionInfo as EventListener);
+      source.addEventListener("gap", handleGap as EventListener);
+      source.addEventListener("snapshot", handleSnapshot as EventListener);
+      source.addEventListener("replay_complete", handleReplayComplete as EventListener);
       source.onerror = handleError;
     } catch (error) {
       console.error("[SSE] Failed to create EventSource:", error);
@@ -328,6 +328,7 @@ export interface TopicInfo {
   format: string;
   channel: string;
   userId: string;
+  origin?: string; // MQTT v5 origin user property, if set
 }
 
 // Data provides a flattened structure for decoded Meshtastic packets
@@ -404,6 +405,7 @@ export interface Data {
 export interface Packet {
   info: TopicInfo;
   data: Data;
+  seq?: string; // Broker sequence number (uint64 as string)
 }
 
 /**